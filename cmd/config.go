@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ItsJimi/casa/config"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var showSecrets bool
+
+func init() {
+	configPrintCmd.Flags().BoolVar(&showSecrets, "show-secrets", false, "Print passwords instead of masking them")
+	configCmd.AddCommand(configPrintCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage casa configuration",
+	Long:  "Manage casa configuration.",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var configPrintCmd = &cobra.Command{
+	Use:   "print",
+	Short: "Print the effective configuration",
+	Long:  "Print the effective configuration after merging file, CASA_* environment variables and flags.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conf := config.Get()
+		if !showSecrets && conf.Database.Password != "" {
+			conf.Database.Password = "********"
+		}
+		if !showSecrets && conf.MQTT.Password != "" {
+			conf.MQTT.Password = "********"
+		}
+		if !showSecrets && conf.Gateway.MQTT.Password != "" {
+			conf.Gateway.MQTT.Password = "********"
+		}
+		if !showSecrets && conf.Notifications.SMTP.Password != "" {
+			conf.Notifications.SMTP.Password = "********"
+		}
+		if !showSecrets && conf.HomeKit.Pin != "" {
+			conf.HomeKit.Pin = "********"
+		}
+		for i := range conf.OAuth.Clients {
+			if !showSecrets && conf.OAuth.Clients[i].Secret != "" {
+				conf.OAuth.Clients[i].Secret = "********"
+			}
+		}
+
+		out, err := yaml.Marshal(conf)
+		if err != nil {
+			return err
+		}
+
+		if config.File() != "" {
+			fmt.Println("# " + config.File())
+		}
+		fmt.Print(string(out))
+		return nil
+	},
+}
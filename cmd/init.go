@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"github.com/ItsJimi/casa/config"
 	"github.com/ItsJimi/casa/server"
 	"github.com/spf13/cobra"
 )
@@ -14,6 +15,6 @@ var initCmd = &cobra.Command{
 	Short: "Init casa database",
 	Long:  "Init casa database.",
 	Run: func(cmd *cobra.Command, args []string) {
-		server.InitDB()
+		server.InitDB(config.Get().Database)
 	},
 }
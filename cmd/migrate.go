@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ItsJimi/casa/config"
+	"github.com/ItsJimi/casa/server"
+	"github.com/spf13/cobra"
+)
+
+var migrateTo int
+var migrateSteps int
+
+func init() {
+	migrateUpCmd.Flags().IntVar(&migrateTo, "to", 0, "Version to migrate to (default is latest)")
+	migrateDownCmd.Flags().IntVar(&migrateSteps, "steps", 1, "Number of migrations to revert")
+	migrateCmd.AddCommand(migrateUpCmd)
+	migrateCmd.AddCommand(migrateDownCmd)
+	migrateCmd.AddCommand(migrateStatusCmd)
+	rootCmd.AddCommand(migrateCmd)
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Manage casa database schema",
+	Long:  "Manage casa database schema with versioned migrations.",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply pending migrations",
+	Long:  "Apply pending migrations, each one in its own transaction.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store := server.StartDB(config.Get().Database)
+		return store.Migrate(migrateTo)
+	},
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Revert applied migrations",
+	Long:  "Revert the last applied migrations, each one in its own transaction.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store := server.StartDB(config.Get().Database)
+		return store.Rollback(migrateSteps)
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Print migrations state",
+	Long:  "Print every migration known by this binary and if it was applied.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store := server.StartDB(config.Get().Database)
+		states, err := store.SchemaStatus()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, state := range states {
+			appliedAt := "pending"
+			if state.Applied {
+				appliedAt = state.AppliedAt
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", state.Version, state.Name, appliedAt)
+		}
+		return w.Flush()
+	},
+}
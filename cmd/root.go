@@ -2,11 +2,23 @@ package cmd
 
 import (
 	"fmt"
+	"log"
 	"os"
 
+	"github.com/ItsJimi/casa/config"
+	"github.com/ItsJimi/casa/logger"
 	"github.com/spf13/cobra"
 )
 
+var configFile string
+
+func init() {
+	cobra.OnInitialize(initConfig)
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Configuration file (default is ./casa.yaml or /etc/casa/casa.yaml)")
+	rootCmd.PersistentFlags().String("log-level", "debug", "Console log level (debug, info, warn, error)")
+	config.BindFlag("log.console_level", rootCmd.PersistentFlags().Lookup("log-level"))
+}
+
 var rootCmd = &cobra.Command{
 	Use:   "casa",
 	Short: "Casa is a domotic software",
@@ -16,6 +28,29 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+// initConfig load configuration and instantiate logger
+func initConfig() {
+	if err := config.Load(configFile); err != nil {
+		log.Fatalf("Could not load configuration %s", err.Error())
+	}
+	conf := config.Get().Log
+
+	err := logger.NewLogger(logger.Configuration{
+		EnableConsole:     conf.ConsoleEnabled,
+		ConsoleLevel:      conf.ConsoleLevel,
+		ConsoleJSONFormat: conf.ConsoleJSON,
+		EnableFile:        conf.FileEnabled,
+		FileLevel:         conf.FileLevel,
+		FileJSONFormat:    conf.FileJSON,
+		FileLocation:      conf.FileLocation,
+	})
+	if err != nil {
+		log.Fatalf("Could not instantiate log %s", err.Error())
+	}
+
+	logger.WithFields(logger.Fields{}).Debugf("Start casa")
+}
+
 // Execute cli init
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ItsJimi/casa/config"
+	"github.com/ItsJimi/casa/logger"
+	"github.com/ItsJimi/casa/simulator"
+	"github.com/spf13/cobra"
+)
+
+var simulateServer string
+var simulateAddress string
+var simulateScenario string
+
+func init() {
+	simulateGatewayCmd.Flags().StringVar(&simulateServer, "server", "ws://localhost:4353/v1/ws", "Websocket route of casa server, or its MQTT broker (tcp://localhost:1883)")
+	simulateGatewayCmd.Flags().StringVar(&simulateAddress, "address", ":4354", "Address the simulated gateway listen on")
+	simulateGatewayCmd.Flags().StringVar(&simulateScenario, "scenario", "", "JSON scenario file (default is a lamp and a sensor)")
+	simulateGatewayCmd.Flags().String("topic-prefix", simulator.DefaultTopicPrefix, "Prefix of gateway topics on MQTT broker")
+	config.BindFlag("gateway.mqtt.topic_prefix", simulateGatewayCmd.Flags().Lookup("topic-prefix"))
+	rootCmd.AddCommand(simulateGatewayCmd)
+}
+
+var simulateGatewayCmd = &cobra.Command{
+	Use:   "simulate-gateway",
+	Short: "Connect a simulated gateway to casa server",
+	Long:  "Connect a simulated gateway to casa server, it serve fake plugins, send scenario datas and print called actions.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		scenario := simulator.DefaultScenario()
+		if simulateScenario != "" {
+			var err error
+			scenario, err = simulator.LoadScenario(simulateScenario)
+			if err != nil {
+				return err
+			}
+		}
+
+		gateway := simulator.New(simulateServer, simulateAddress, scenario)
+		gateway.TopicPrefix = config.Get().Gateway.MQTT.TopicPrefix
+		if err := gateway.Start(); err != nil {
+			return err
+		}
+		defer gateway.Close()
+		logger.WithFields(logger.Fields{}).Infof("Simulated gateway connected to %s, listening on %s", simulateServer, gateway.Addr())
+
+		go func() {
+			if err := gateway.Play(); err != nil {
+				logger.WithFields(logger.Fields{}).Errorf("%s", err.Error())
+			}
+		}()
+
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		<-interrupt
+
+		logger.WithFields(logger.Fields{}).Infof("%d actions received", len(gateway.Actions()))
+		return nil
+	},
+}
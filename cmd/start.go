@@ -1,25 +1,45 @@
 package cmd
 
 import (
+	"github.com/ItsJimi/casa/config"
 	"github.com/ItsJimi/casa/server"
 	"github.com/spf13/cobra"
 )
 
 func init() {
+	startCmd.Flags().String("address", ":4353", "Address the server listen on")
+	startCmd.Flags().String("tls-cert", "", "TLS certificate file, enable https with --tls-key")
+	startCmd.Flags().String("tls-key", "", "TLS key file, enable https with --tls-cert")
+	startCmd.Flags().Bool("device-metrics", false, "Expose latest numeric device values on /metrics")
+	config.BindFlag("server.address", startCmd.Flags().Lookup("address"))
+	config.BindFlag("server.tls.cert_file", startCmd.Flags().Lookup("tls-cert"))
+	config.BindFlag("server.tls.key_file", startCmd.Flags().Lookup("tls-key"))
+	config.BindFlag("metrics.device_values", startCmd.Flags().Lookup("device-metrics"))
 	rootCmd.AddCommand(startCmd)
 }
 
 var startCmd = &cobra.Command{
-	Use:   "start",
+	Use:   "start [port]",
 	Short: "Start casa server",
 	Long:  "Start casa server.",
 	Run: func(cmd *cobra.Command, args []string) {
-		port := "4353"
+		conf := config.Get()
 		if len(args) > 0 {
-			port = args[0]
+			conf.Server.Address = ":" + args[0]
 		}
-		server.StartDB()
-		go server.Automations()
-		server.Start(port)
+		if conf.Server.TLS.CertFile != "" && conf.Server.TLS.KeyFile != "" {
+			conf.Server.TLS.Enabled = true
+		}
+		store := server.StartDB(conf.Database)
+		server.CheckSchema(store)
+		transport := server.NewGatewayTransport()
+		if conf.Gateway.Transport == "mqtt" {
+			transport = server.NewMQTTGatewayTransport(conf.Gateway.MQTT)
+		}
+		s := server.NewServer(store, transport)
+		if conf.Automation.Enabled {
+			go s.Automations(conf.Automation)
+		}
+		s.Start(conf)
 	},
 }
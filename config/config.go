@@ -0,0 +1,288 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Configuration define all runtime settings of casa
+type Configuration struct {
+	Database   Database   `mapstructure:"database" yaml:"database"`
+	Server     Server     `mapstructure:"server" yaml:"server"`
+	Log        Log        `mapstructure:"log" yaml:"log"`
+	Automation Automation `mapstructure:"automation" yaml:"automation"`
+	Metrics    Metrics    `mapstructure:"metrics" yaml:"metrics"`
+	Export     Export     `mapstructure:"export" yaml:"export"`
+	MQTT       MQTT       `mapstructure:"mqtt" yaml:"mqtt"`
+	Gateway    Gateway    `mapstructure:"gateway" yaml:"gateway"`
+	OAuth      OAuth      `mapstructure:"oauth" yaml:"oauth"`
+	HomeKit    HomeKit    `mapstructure:"homekit" yaml:"homekit"`
+	Hue        Hue        `mapstructure:"hue" yaml:"hue"`
+	// Notifications define channels reaching members, in-app notifications are always enabled
+	Notifications Notifications `mapstructure:"notifications" yaml:"notifications"`
+}
+
+// Database define storage backend settings
+type Database struct {
+	Driver   string `mapstructure:"driver" yaml:"driver"` // postgres, sqlite
+	Path     string `mapstructure:"path" yaml:"path"`     // sqlite file
+	Host     string `mapstructure:"host" yaml:"host"`
+	Port     string `mapstructure:"port" yaml:"port"`
+	User     string `mapstructure:"user" yaml:"user"`
+	Password string `mapstructure:"password" yaml:"password"`
+	Name     string `mapstructure:"name" yaml:"name"`
+	SSLMode  string `mapstructure:"sslmode" yaml:"sslmode"`
+}
+
+// Server define http server settings
+type Server struct {
+	Address     string   `mapstructure:"address" yaml:"address"`
+	CORSOrigins []string `mapstructure:"cors_origins" yaml:"cors_origins"`
+	// TrustedProxies are addresses or CIDR networks of proxies whose X-Forwarded-For is trusted
+	TrustedProxies []string `mapstructure:"trusted_proxies" yaml:"trusted_proxies"`
+	TLS            TLS      `mapstructure:"tls" yaml:"tls"`
+}
+
+// TLS define certificate used by http server
+type TLS struct {
+	Enabled  bool   `mapstructure:"enabled" yaml:"enabled"`
+	CertFile string `mapstructure:"cert_file" yaml:"cert_file"`
+	KeyFile  string `mapstructure:"key_file" yaml:"key_file"`
+}
+
+// Log define console and file loggers settings
+type Log struct {
+	ConsoleEnabled bool   `mapstructure:"console_enabled" yaml:"console_enabled"`
+	ConsoleLevel   string `mapstructure:"console_level" yaml:"console_level"`
+	ConsoleJSON    bool   `mapstructure:"console_json" yaml:"console_json"`
+	FileEnabled    bool   `mapstructure:"file_enabled" yaml:"file_enabled"`
+	FileLevel      string `mapstructure:"file_level" yaml:"file_level"`
+	FileJSON       bool   `mapstructure:"file_json" yaml:"file_json"`
+	FileLocation   string `mapstructure:"file_location" yaml:"file_location"`
+}
+
+// Automation define automations engine tuning
+type Automation struct {
+	Enabled  bool          `mapstructure:"enabled" yaml:"enabled"`
+	Interval time.Duration `mapstructure:"interval" yaml:"interval"`
+}
+
+// Metrics define prometheus exporter settings
+type Metrics struct {
+	DeviceValues bool `mapstructure:"device_values" yaml:"device_values"`
+	// Token is the bearer token scrapers send, /metrics isn't served without it
+	Token string `mapstructure:"token" yaml:"token"`
+}
+
+// Export define datas exports settings
+type Export struct {
+	Dir string `mapstructure:"dir" yaml:"dir"`
+}
+
+// MQTT define bridge between devices and an MQTT broker
+type MQTT struct {
+	Enabled     bool   `mapstructure:"enabled" yaml:"enabled"`
+	Broker      string `mapstructure:"broker" yaml:"broker"` // tcp://host:1883, ssl://host:8883
+	ClientID    string `mapstructure:"client_id" yaml:"client_id"`
+	Username    string `mapstructure:"username" yaml:"username"`
+	Password    string `mapstructure:"password" yaml:"password"`
+	TopicPrefix string `mapstructure:"topic_prefix" yaml:"topic_prefix"`
+	QoS         byte   `mapstructure:"qos" yaml:"qos"`
+	Retain      bool   `mapstructure:"retain" yaml:"retain"`
+	// Discovery publish Home Assistant discovery configs of devices under DiscoveryPrefix
+	Discovery       bool   `mapstructure:"discovery" yaml:"discovery"`
+	DiscoveryPrefix string `mapstructure:"discovery_prefix" yaml:"discovery_prefix"`
+}
+
+// Gateway define how gateways connect to server
+type Gateway struct {
+	Transport string      `mapstructure:"transport" yaml:"transport"` // websocket, mqtt
+	MQTT      GatewayMQTT `mapstructure:"mqtt" yaml:"mqtt"`
+}
+
+// GatewayMQTT define embedded MQTT broker gateways connect to
+type GatewayMQTT struct {
+	Address     string `mapstructure:"address" yaml:"address"`
+	Username    string `mapstructure:"username" yaml:"username"`
+	Password    string `mapstructure:"password" yaml:"password"`
+	TopicPrefix string `mapstructure:"topic_prefix" yaml:"topic_prefix"`
+}
+
+// OAuth define clients allowed to link casa accounts, like voice assistants
+type OAuth struct {
+	Clients []OAuthClient `mapstructure:"clients" yaml:"clients"`
+}
+
+// OAuthClient define an OAuth client and where users can be redirected after linking
+type OAuthClient struct {
+	ID           string   `mapstructure:"id" yaml:"id"`
+	Secret       string   `mapstructure:"secret" yaml:"secret"`
+	RedirectURIs []string `mapstructure:"redirect_uris" yaml:"redirect_uris"`
+}
+
+// HomeKit define bridges exposing devices of each home to Apple Home
+type HomeKit struct {
+	Enabled bool   `mapstructure:"enabled" yaml:"enabled"`
+	Pin     string `mapstructure:"pin" yaml:"pin"` // 8 digits asked when pairing, bridges don't start without it
+}
+
+// Hue define emulated Philips Hue bridge exposing allowed devices as lights
+type Hue struct {
+	Enabled bool   `mapstructure:"enabled" yaml:"enabled"`
+	Address string `mapstructure:"address" yaml:"address"` // most Hue clients only use port 80
+	// AdvertiseIP is the address announced with SSDP, first private IPv4 of host when empty
+	AdvertiseIP string `mapstructure:"advertise_ip" yaml:"advertise_ip"`
+}
+
+// Notifications define how notifications leave the server
+type Notifications struct {
+	SMTP        SMTP          `mapstructure:"smtp" yaml:"smtp"`
+	HTTPTimeout time.Duration `mapstructure:"http_timeout" yaml:"http_timeout"`
+	// LowBattery is the battery percentage under which members of home are notified, 0 disables it
+	LowBattery float64 `mapstructure:"low_battery" yaml:"low_battery"`
+}
+
+// SMTP define mail server sending email notifications, disabled when host is empty
+type SMTP struct {
+	Host     string `mapstructure:"host" yaml:"host"`
+	Port     int    `mapstructure:"port" yaml:"port"`
+	Username string `mapstructure:"username" yaml:"username"`
+	Password string `mapstructure:"password" yaml:"password"`
+	From     string `mapstructure:"from" yaml:"from"`
+	// TLS connect with implicit TLS, like on port 465, else STARTTLS is used when server offers it
+	TLS bool `mapstructure:"tls" yaml:"tls"`
+}
+
+var v = viper.New()
+var current Configuration
+
+func init() {
+	v.SetDefault("database.driver", "postgres")
+	v.SetDefault("database.path", "casa.db")
+	v.SetDefault("database.host", "localhost")
+	v.SetDefault("database.port", "5432")
+	v.SetDefault("database.user", "postgres")
+	v.SetDefault("database.password", "password")
+	v.SetDefault("database.name", "casadb")
+	v.SetDefault("database.sslmode", "disable")
+
+	v.SetDefault("server.address", ":4353")
+	v.SetDefault("server.cors_origins", []string{"*"})
+	v.SetDefault("server.trusted_proxies", []string{})
+	v.SetDefault("server.tls.enabled", false)
+	v.SetDefault("server.tls.cert_file", "")
+	v.SetDefault("server.tls.key_file", "")
+
+	v.SetDefault("log.console_enabled", true)
+	v.SetDefault("log.console_level", "debug")
+	v.SetDefault("log.console_json", false)
+	v.SetDefault("log.file_enabled", true)
+	v.SetDefault("log.file_level", "info")
+	v.SetDefault("log.file_json", true)
+	v.SetDefault("log.file_location", "log.log")
+
+	v.SetDefault("automation.enabled", true)
+	v.SetDefault("automation.interval", 200*time.Millisecond)
+
+	v.SetDefault("metrics.device_values", false)
+	v.SetDefault("metrics.token", "")
+
+	v.SetDefault("export.dir", filepath.Join(os.TempDir(), "casa-exports"))
+
+	v.SetDefault("mqtt.enabled", false)
+	v.SetDefault("mqtt.broker", "tcp://localhost:1883")
+	v.SetDefault("mqtt.client_id", "casa")
+	v.SetDefault("mqtt.username", "")
+	v.SetDefault("mqtt.password", "")
+	v.SetDefault("mqtt.topic_prefix", "casa")
+	v.SetDefault("mqtt.qos", 1)
+	v.SetDefault("mqtt.retain", true)
+	v.SetDefault("mqtt.discovery", true)
+	v.SetDefault("mqtt.discovery_prefix", "homeassistant")
+
+	v.SetDefault("gateway.transport", "websocket")
+	v.SetDefault("gateway.mqtt.address", ":1883")
+	v.SetDefault("gateway.mqtt.username", "")
+	v.SetDefault("gateway.mqtt.password", "")
+	v.SetDefault("gateway.mqtt.topic_prefix", "casa/gateway")
+	v.SetDefault("homekit.enabled", false)
+	v.SetDefault("homekit.pin", "")
+	v.SetDefault("hue.enabled", false)
+	v.SetDefault("hue.address", ":80")
+	v.SetDefault("hue.advertise_ip", "")
+	v.SetDefault("notifications.smtp.host", "")
+	v.SetDefault("notifications.smtp.port", 587)
+	v.SetDefault("notifications.smtp.username", "")
+	v.SetDefault("notifications.smtp.password", "")
+	v.SetDefault("notifications.smtp.from", "casa@localhost")
+	v.SetDefault("notifications.smtp.tls", false)
+	v.SetDefault("notifications.http_timeout", 10*time.Second)
+	v.SetDefault("notifications.low_battery", 15)
+
+	// CASA_DATABASE_HOST override database.host
+	v.SetEnvPrefix("casa")
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+}
+
+// BindFlag override key with flag value when flag is set
+func BindFlag(key string, flag *pflag.Flag) error {
+	return v.BindPFlag(key, flag)
+}
+
+// Load read configuration file then apply environment variables and flags
+func Load(file string) error {
+	if file == "" {
+		file = findFile()
+	}
+
+	if file != "" {
+		v.SetConfigFile(file)
+		if err := v.ReadInConfig(); err != nil {
+			return err
+		}
+	}
+
+	return v.Unmarshal(&current)
+}
+
+// findFile search casa configuration file in default paths
+func findFile() string {
+	for _, dir := range []string{".", "/etc/casa"} {
+		for _, ext := range []string{"yaml", "yml", "toml", "json"} {
+			file := filepath.Join(dir, "casa."+ext)
+			if _, err := os.Stat(file); err == nil {
+				return file
+			}
+		}
+	}
+	return ""
+}
+
+// Get return the loaded configuration
+func Get() Configuration {
+	return current
+}
+
+// File return path of the configuration file used, empty if none
+func File() string {
+	return v.ConfigFileUsed()
+}
+
+// DSN return postgres connection string for database name
+func (d Database) DSN(name string) string {
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(d.User, d.Password),
+		Host:     d.Host + ":" + d.Port,
+		Path:     "/" + name,
+		RawQuery: "sslmode=" + url.QueryEscape(d.SSLMode),
+	}
+	return dsn.String()
+}
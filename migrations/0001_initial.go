@@ -1,3 +1,12 @@
+package migrations
+
+// Tables are created only when missing so installs made with database.sql can adopt migrations
+// Timestamps are stored with milliseconds on sqlite to keep datas ordered
+func init() {
+	register(Migration{
+		Version: 1,
+		Name:    "initial",
+		Up: `
 CREATE TABLE IF NOT EXISTS users (
   id TEXT PRIMARY KEY,
   firstname TEXT NOT NULL,
@@ -91,7 +100,6 @@ CREATE TABLE IF NOT EXISTS permissions (
   updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
-
 CREATE TABLE IF NOT EXISTS automations (
   id TEXT PRIMARY KEY,
   home_id TEXT NOT NULL REFERENCES homes (id) ON DELETE CASCADE,
@@ -127,13 +135,199 @@ CREATE TABLE IF NOT EXISTS logs (
   created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
-
-CREATE EXTENSION moddatetime;
+CREATE EXTENSION IF NOT EXISTS moddatetime;
+DROP TRIGGER IF EXISTS update_date_users ON users;
 CREATE TRIGGER update_date_users BEFORE UPDATE ON users FOR EACH ROW EXECUTE PROCEDURE moddatetime(updated_at);
+DROP TRIGGER IF EXISTS update_date_tokens ON tokens;
 CREATE TRIGGER update_date_tokens BEFORE UPDATE ON tokens FOR EACH ROW EXECUTE PROCEDURE moddatetime(updated_at);
+DROP TRIGGER IF EXISTS update_date_homes ON homes;
 CREATE TRIGGER update_date_homes BEFORE UPDATE ON homes FOR EACH ROW EXECUTE PROCEDURE moddatetime(updated_at);
+DROP TRIGGER IF EXISTS update_date_gateways ON gateways;
 CREATE TRIGGER update_date_gateways BEFORE UPDATE ON gateways FOR EACH ROW EXECUTE PROCEDURE moddatetime(updated_at);
+DROP TRIGGER IF EXISTS update_date_rooms ON rooms;
 CREATE TRIGGER update_date_rooms BEFORE UPDATE ON rooms FOR EACH ROW EXECUTE PROCEDURE moddatetime(updated_at);
+DROP TRIGGER IF EXISTS update_date_devices ON devices;
 CREATE TRIGGER update_date_devices BEFORE UPDATE ON devices FOR EACH ROW EXECUTE PROCEDURE moddatetime(updated_at);
+DROP TRIGGER IF EXISTS update_date_permissions ON permissions;
 CREATE TRIGGER update_date_permissions BEFORE UPDATE ON permissions FOR EACH ROW EXECUTE PROCEDURE moddatetime(updated_at);
-CREATE TRIGGER update_date_automations BEFORE UPDATE ON automations FOR EACH ROW EXECUTE PROCEDURE moddatetime(updated_at);
\ No newline at end of file
+DROP TRIGGER IF EXISTS update_date_automations ON automations;
+CREATE TRIGGER update_date_automations BEFORE UPDATE ON automations FOR EACH ROW EXECUTE PROCEDURE moddatetime(updated_at);
+`,
+		Down: `
+DROP TABLE IF EXISTS logs;
+DROP TABLE IF EXISTS datas;
+DROP TABLE IF EXISTS automations;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS devices;
+DROP TABLE IF EXISTS rooms;
+DROP TABLE IF EXISTS plugins;
+DROP TABLE IF EXISTS gateways;
+DROP TABLE IF EXISTS homes;
+DROP TABLE IF EXISTS tokens;
+DROP TABLE IF EXISTS users;
+`,
+		SQLiteUp: `
+CREATE TABLE IF NOT EXISTS users (
+  id TEXT PRIMARY KEY,
+  firstname TEXT NOT NULL,
+  lastname TEXT,
+  email TEXT NOT NULL UNIQUE,
+  password TEXT NOT NULL,
+  birthdate TIMESTAMP,
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS tokens (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  type TEXT NOT NULL,
+  ip TEXT NOT NULL,
+  user_agent TEXT NOT NULL,
+  read BOOLEAN NOT NULL DEFAULT true,
+  write BOOLEAN NOT NULL DEFAULT true,
+  manage BOOLEAN NOT NULL DEFAULT true,
+  admin BOOLEAN NOT NULL DEFAULT true,
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  expire_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now', '+1 months'))
+);
+
+CREATE TABLE IF NOT EXISTS homes (
+  id TEXT PRIMARY KEY,
+  name TEXT NOT NULL,
+  address TEXT,
+  wifi_ssid TEXT,
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  creator_id TEXT NOT NULL REFERENCES users (id)
+);
+
+CREATE TABLE IF NOT EXISTS gateways (
+  id TEXT PRIMARY KEY,
+  home_id TEXT REFERENCES homes (id) ON DELETE CASCADE,
+  name TEXT,
+  model TEXT,
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  creator_id TEXT REFERENCES users (id)
+);
+
+CREATE TABLE IF NOT EXISTS plugins (
+  id TEXT PRIMARY KEY,
+  gateway_id TEXT REFERENCES gateways (id) ON DELETE CASCADE,
+  name TEXT,
+  config TEXT,
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS rooms (
+  id TEXT PRIMARY KEY,
+  name TEXT,
+  icon TEXT,
+  home_id TEXT NOT NULL REFERENCES homes (id) ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  creator_id TEXT NOT NULL REFERENCES users (id)
+);
+
+CREATE TABLE IF NOT EXISTS devices (
+  id TEXT PRIMARY KEY,
+  gateway_id TEXT NOT NULL REFERENCES gateways (id) ON DELETE CASCADE,
+  name TEXT,
+  icon TEXT,
+  physical_id TEXT NOT NULL,
+  physical_name TEXT NOT NULL,
+  config TEXT NOT NULL,
+  plugin TEXT NOT NULL,
+  room_id TEXT NOT NULL REFERENCES rooms (id) ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  creator_id TEXT NOT NULL REFERENCES users (id)
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL REFERENCES users (id),
+  type TEXT NOT NULL,
+  type_id TEXT NOT NULL,
+  read BOOLEAN NOT NULL DEFAULT false,
+  write BOOLEAN NOT NULL DEFAULT false,
+  manage BOOLEAN NOT NULL DEFAULT false,
+  admin BOOLEAN NOT NULL DEFAULT false,
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS automations (
+  id TEXT PRIMARY KEY,
+  home_id TEXT NOT NULL REFERENCES homes (id) ON DELETE CASCADE,
+  name TEXT,
+  "trigger" TEXT,
+  trigger_key TEXT,
+  trigger_value TEXT,
+  trigger_operator TEXT,
+  action TEXT,
+  action_call TEXT,
+  action_value TEXT,
+  status BOOLEAN DEFAULT true,
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  creator_id TEXT NOT NULL REFERENCES users (id)
+);
+
+CREATE TABLE IF NOT EXISTS datas (
+  id TEXT PRIMARY KEY,
+  device_id TEXT NOT NULL REFERENCES devices (id) ON DELETE CASCADE,
+  field TEXT NOT NULL,
+  value_nbr REAL,
+  value_str TEXT,
+  value_bool BOOLEAN,
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS logs (
+  id TEXT PRIMARY KEY,
+  type TEXT NOT NULL,
+  type_id TEXT NOT NULL,
+  value TEXT,
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS datas_device_field ON datas (device_id, field, created_at);
+
+CREATE TRIGGER IF NOT EXISTS update_date_users AFTER UPDATE ON users FOR EACH ROW
+BEGIN UPDATE users SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE id = NEW.id; END;
+CREATE TRIGGER IF NOT EXISTS update_date_tokens AFTER UPDATE ON tokens FOR EACH ROW
+BEGIN UPDATE tokens SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE id = NEW.id; END;
+CREATE TRIGGER IF NOT EXISTS update_date_homes AFTER UPDATE ON homes FOR EACH ROW
+BEGIN UPDATE homes SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE id = NEW.id; END;
+CREATE TRIGGER IF NOT EXISTS update_date_gateways AFTER UPDATE ON gateways FOR EACH ROW
+BEGIN UPDATE gateways SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE id = NEW.id; END;
+CREATE TRIGGER IF NOT EXISTS update_date_plugins AFTER UPDATE ON plugins FOR EACH ROW
+BEGIN UPDATE plugins SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE id = NEW.id; END;
+CREATE TRIGGER IF NOT EXISTS update_date_rooms AFTER UPDATE ON rooms FOR EACH ROW
+BEGIN UPDATE rooms SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE id = NEW.id; END;
+CREATE TRIGGER IF NOT EXISTS update_date_devices AFTER UPDATE ON devices FOR EACH ROW
+BEGIN UPDATE devices SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE id = NEW.id; END;
+CREATE TRIGGER IF NOT EXISTS update_date_permissions AFTER UPDATE ON permissions FOR EACH ROW
+BEGIN UPDATE permissions SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE id = NEW.id; END;
+CREATE TRIGGER IF NOT EXISTS update_date_automations AFTER UPDATE ON automations FOR EACH ROW
+BEGIN UPDATE automations SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE id = NEW.id; END;
+`,
+		SQLiteDown: `
+DROP TABLE IF EXISTS logs;
+DROP TABLE IF EXISTS datas;
+DROP TABLE IF EXISTS automations;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS devices;
+DROP TABLE IF EXISTS rooms;
+DROP TABLE IF EXISTS plugins;
+DROP TABLE IF EXISTS gateways;
+DROP TABLE IF EXISTS homes;
+DROP TABLE IF EXISTS tokens;
+DROP TABLE IF EXISTS users;
+`,
+	})
+}
@@ -0,0 +1,48 @@
+package migrations
+
+func init() {
+	register(Migration{
+		Version: 3,
+		Name:    "automation_hooks",
+		Up: `
+CREATE TABLE IF NOT EXISTS automation_hooks (
+  id TEXT PRIMARY KEY,
+  home_id TEXT NOT NULL REFERENCES homes (id) ON DELETE CASCADE,
+  automation_id TEXT NOT NULL REFERENCES automations (id) ON DELETE CASCADE,
+  token TEXT NOT NULL UNIQUE,
+  last_fired_at TIMESTAMP WITH TIME ZONE,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  creator_id TEXT NOT NULL REFERENCES users (id)
+);
+
+CREATE INDEX IF NOT EXISTS automation_hooks_automation ON automation_hooks (automation_id);
+
+DROP TRIGGER IF EXISTS update_date_automation_hooks ON automation_hooks;
+CREATE TRIGGER update_date_automation_hooks BEFORE UPDATE ON automation_hooks FOR EACH ROW EXECUTE PROCEDURE moddatetime(updated_at);
+`,
+		Down: `
+DROP TABLE IF EXISTS automation_hooks;
+`,
+		SQLiteUp: `
+CREATE TABLE IF NOT EXISTS automation_hooks (
+  id TEXT PRIMARY KEY,
+  home_id TEXT NOT NULL REFERENCES homes (id) ON DELETE CASCADE,
+  automation_id TEXT NOT NULL REFERENCES automations (id) ON DELETE CASCADE,
+  token TEXT NOT NULL UNIQUE,
+  last_fired_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  creator_id TEXT NOT NULL REFERENCES users (id)
+);
+
+CREATE INDEX IF NOT EXISTS automation_hooks_automation ON automation_hooks (automation_id);
+
+CREATE TRIGGER IF NOT EXISTS update_date_automation_hooks AFTER UPDATE ON automation_hooks FOR EACH ROW
+BEGIN UPDATE automation_hooks SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE id = NEW.id; END;
+`,
+		SQLiteDown: `
+DROP TABLE IF EXISTS automation_hooks;
+`,
+	})
+}
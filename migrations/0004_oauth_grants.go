@@ -0,0 +1,48 @@
+package migrations
+
+func init() {
+	register(Migration{
+		Version: 4,
+		Name:    "oauth_grants",
+		Up: `
+CREATE TABLE IF NOT EXISTS oauth_grants (
+  id TEXT PRIMARY KEY,
+  type TEXT NOT NULL,
+  client_id TEXT NOT NULL,
+  user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  redirect_uri TEXT NOT NULL DEFAULT '',
+  expire_at TIMESTAMP WITH TIME ZONE,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS oauth_grants_user ON oauth_grants (user_id);
+
+DROP TRIGGER IF EXISTS update_date_oauth_grants ON oauth_grants;
+CREATE TRIGGER update_date_oauth_grants BEFORE UPDATE ON oauth_grants FOR EACH ROW EXECUTE PROCEDURE moddatetime(updated_at);
+`,
+		Down: `
+DROP TABLE IF EXISTS oauth_grants;
+`,
+		SQLiteUp: `
+CREATE TABLE IF NOT EXISTS oauth_grants (
+  id TEXT PRIMARY KEY,
+  type TEXT NOT NULL,
+  client_id TEXT NOT NULL,
+  user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  redirect_uri TEXT NOT NULL DEFAULT '',
+  expire_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS oauth_grants_user ON oauth_grants (user_id);
+
+CREATE TRIGGER IF NOT EXISTS update_date_oauth_grants AFTER UPDATE ON oauth_grants FOR EACH ROW
+BEGIN UPDATE oauth_grants SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE id = NEW.id; END;
+`,
+		SQLiteDown: `
+DROP TABLE IF EXISTS oauth_grants;
+`,
+	})
+}
@@ -0,0 +1,40 @@
+package migrations
+
+func init() {
+	register(Migration{
+		Version: 5,
+		Name:    "homekit",
+		Up: `
+CREATE TABLE IF NOT EXISTS homekit_store (
+  home_id TEXT NOT NULL REFERENCES homes (id) ON DELETE CASCADE,
+  key TEXT NOT NULL,
+  value BYTEA NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (home_id, key)
+);
+
+DROP TRIGGER IF EXISTS update_date_homekit_store ON homekit_store;
+CREATE TRIGGER update_date_homekit_store BEFORE UPDATE ON homekit_store FOR EACH ROW EXECUTE PROCEDURE moddatetime(updated_at);
+`,
+		Down: `
+DROP TABLE IF EXISTS homekit_store;
+`,
+		SQLiteUp: `
+CREATE TABLE IF NOT EXISTS homekit_store (
+  home_id TEXT NOT NULL REFERENCES homes (id) ON DELETE CASCADE,
+  key TEXT NOT NULL,
+  value BLOB NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  PRIMARY KEY (home_id, key)
+);
+
+CREATE TRIGGER IF NOT EXISTS update_date_homekit_store AFTER UPDATE ON homekit_store FOR EACH ROW
+BEGIN UPDATE homekit_store SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE home_id = NEW.home_id AND key = NEW.key; END;
+`,
+		SQLiteDown: `
+DROP TABLE IF EXISTS homekit_store;
+`,
+	})
+}
@@ -0,0 +1,50 @@
+package migrations
+
+func init() {
+	register(Migration{
+		Version: 6,
+		Name:    "hue_devices",
+		Up: `
+CREATE TABLE IF NOT EXISTS hue_devices (
+  device_id TEXT PRIMARY KEY REFERENCES devices (id) ON DELETE CASCADE,
+  home_id TEXT NOT NULL REFERENCES homes (id) ON DELETE CASCADE,
+  light_id INTEGER NOT NULL UNIQUE,
+  on_call TEXT NOT NULL DEFAULT '',
+  off_call TEXT NOT NULL DEFAULT '',
+  brightness_call TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  creator_id TEXT NOT NULL REFERENCES users (id)
+);
+
+CREATE INDEX IF NOT EXISTS hue_devices_home ON hue_devices (home_id);
+
+DROP TRIGGER IF EXISTS update_date_hue_devices ON hue_devices;
+CREATE TRIGGER update_date_hue_devices BEFORE UPDATE ON hue_devices FOR EACH ROW EXECUTE PROCEDURE moddatetime(updated_at);
+`,
+		Down: `
+DROP TABLE IF EXISTS hue_devices;
+`,
+		SQLiteUp: `
+CREATE TABLE IF NOT EXISTS hue_devices (
+  device_id TEXT PRIMARY KEY REFERENCES devices (id) ON DELETE CASCADE,
+  home_id TEXT NOT NULL REFERENCES homes (id) ON DELETE CASCADE,
+  light_id INTEGER NOT NULL UNIQUE,
+  on_call TEXT NOT NULL DEFAULT '',
+  off_call TEXT NOT NULL DEFAULT '',
+  brightness_call TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  creator_id TEXT NOT NULL REFERENCES users (id)
+);
+
+CREATE INDEX IF NOT EXISTS hue_devices_home ON hue_devices (home_id);
+
+CREATE TRIGGER IF NOT EXISTS update_date_hue_devices AFTER UPDATE ON hue_devices FOR EACH ROW
+BEGIN UPDATE hue_devices SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE device_id = NEW.device_id; END;
+`,
+		SQLiteDown: `
+DROP TABLE IF EXISTS hue_devices;
+`,
+	})
+}
@@ -0,0 +1,46 @@
+package migrations
+
+func init() {
+	register(Migration{
+		Version: 7,
+		Name:    "aliases",
+		Up: `
+CREATE TABLE IF NOT EXISTS aliases (
+  id TEXT PRIMARY KEY,
+  home_id TEXT NOT NULL REFERENCES homes (id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  type TEXT NOT NULL,
+  type_id TEXT NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  creator_id TEXT NOT NULL REFERENCES users (id),
+  UNIQUE (home_id, name)
+);
+
+DROP TRIGGER IF EXISTS update_date_aliases ON aliases;
+CREATE TRIGGER update_date_aliases BEFORE UPDATE ON aliases FOR EACH ROW EXECUTE PROCEDURE moddatetime(updated_at);
+`,
+		Down: `
+DROP TABLE IF EXISTS aliases;
+`,
+		SQLiteUp: `
+CREATE TABLE IF NOT EXISTS aliases (
+  id TEXT PRIMARY KEY,
+  home_id TEXT NOT NULL REFERENCES homes (id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  type TEXT NOT NULL,
+  type_id TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  creator_id TEXT NOT NULL REFERENCES users (id),
+  UNIQUE (home_id, name)
+);
+
+CREATE TRIGGER IF NOT EXISTS update_date_aliases AFTER UPDATE ON aliases FOR EACH ROW
+BEGIN UPDATE aliases SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE id = NEW.id; END;
+`,
+		SQLiteDown: `
+DROP TABLE IF EXISTS aliases;
+`,
+	})
+}
@@ -0,0 +1,52 @@
+package migrations
+
+func init() {
+	register(Migration{
+		Version: 8,
+		Name:    "notification_preferences",
+		Up: `
+CREATE TABLE IF NOT EXISTS notification_preferences (
+  user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  home_id TEXT NOT NULL REFERENCES homes (id) ON DELETE CASCADE,
+  app BOOLEAN NOT NULL DEFAULT true,
+  email BOOLEAN NOT NULL DEFAULT false,
+  http_url TEXT NOT NULL DEFAULT '',
+  http_format TEXT NOT NULL DEFAULT 'text',
+  quiet_start TEXT NOT NULL DEFAULT '',
+  quiet_end TEXT NOT NULL DEFAULT '',
+  timezone TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (user_id, home_id)
+);
+
+DROP TRIGGER IF EXISTS update_date_notification_preferences ON notification_preferences;
+CREATE TRIGGER update_date_notification_preferences BEFORE UPDATE ON notification_preferences FOR EACH ROW EXECUTE PROCEDURE moddatetime(updated_at);
+`,
+		Down: `
+DROP TABLE IF EXISTS notification_preferences;
+`,
+		SQLiteUp: `
+CREATE TABLE IF NOT EXISTS notification_preferences (
+  user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  home_id TEXT NOT NULL REFERENCES homes (id) ON DELETE CASCADE,
+  app BOOLEAN NOT NULL DEFAULT 1,
+  email BOOLEAN NOT NULL DEFAULT 0,
+  http_url TEXT NOT NULL DEFAULT '',
+  http_format TEXT NOT NULL DEFAULT 'text',
+  quiet_start TEXT NOT NULL DEFAULT '',
+  quiet_end TEXT NOT NULL DEFAULT '',
+  timezone TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  PRIMARY KEY (user_id, home_id)
+);
+
+CREATE TRIGGER IF NOT EXISTS update_date_notification_preferences AFTER UPDATE ON notification_preferences FOR EACH ROW
+BEGIN UPDATE notification_preferences SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE user_id = NEW.user_id AND home_id = NEW.home_id; END;
+`,
+		SQLiteDown: `
+DROP TABLE IF EXISTS notification_preferences;
+`,
+	})
+}
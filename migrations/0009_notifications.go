@@ -0,0 +1,42 @@
+package migrations
+
+func init() {
+	register(Migration{
+		Version: 9,
+		Name:    "notifications",
+		Up: `
+CREATE TABLE IF NOT EXISTS notifications (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  home_id TEXT NOT NULL REFERENCES homes (id) ON DELETE CASCADE,
+  title TEXT NOT NULL DEFAULT '',
+  message TEXT NOT NULL DEFAULT '',
+  source TEXT NOT NULL DEFAULT '',
+  read_at TIMESTAMP WITH TIME ZONE,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS notifications_user ON notifications (user_id);
+`,
+		Down: `
+DROP TABLE IF EXISTS notifications;
+`,
+		SQLiteUp: `
+CREATE TABLE IF NOT EXISTS notifications (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  home_id TEXT NOT NULL REFERENCES homes (id) ON DELETE CASCADE,
+  title TEXT NOT NULL DEFAULT '',
+  message TEXT NOT NULL DEFAULT '',
+  source TEXT NOT NULL DEFAULT '',
+  read_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS notifications_user ON notifications (user_id);
+`,
+		SQLiteDown: `
+DROP TABLE IF EXISTS notifications;
+`,
+	})
+}
@@ -0,0 +1,50 @@
+package migrations
+
+func init() {
+	register(Migration{
+		Version: 10,
+		Name:    "api_keys",
+		Up: `
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS name TEXT NOT NULL DEFAULT '';
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS scope_type TEXT NOT NULL DEFAULT '';
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS scope_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS secret_hash TEXT NOT NULL DEFAULT '';
+`,
+		Down: `
+DELETE FROM tokens WHERE type = 'apikey';
+ALTER TABLE tokens DROP COLUMN IF EXISTS name;
+ALTER TABLE tokens DROP COLUMN IF EXISTS scope_type;
+ALTER TABLE tokens DROP COLUMN IF EXISTS scope_id;
+ALTER TABLE tokens DROP COLUMN IF EXISTS secret_hash;
+`,
+		SQLiteUp: `
+ALTER TABLE tokens ADD COLUMN name TEXT NOT NULL DEFAULT '';
+ALTER TABLE tokens ADD COLUMN scope_type TEXT NOT NULL DEFAULT '';
+ALTER TABLE tokens ADD COLUMN scope_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE tokens ADD COLUMN secret_hash TEXT NOT NULL DEFAULT '';
+`,
+		// SQLite can't drop columns, tokens table is rebuilt without them
+		SQLiteDown: `
+CREATE TABLE tokens_down (
+  id TEXT PRIMARY KEY,
+  user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  type TEXT NOT NULL,
+  ip TEXT NOT NULL,
+  user_agent TEXT NOT NULL,
+  read BOOLEAN NOT NULL DEFAULT true,
+  write BOOLEAN NOT NULL DEFAULT true,
+  manage BOOLEAN NOT NULL DEFAULT true,
+  admin BOOLEAN NOT NULL DEFAULT true,
+  created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  expire_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now', '+1 months'))
+);
+INSERT INTO tokens_down SELECT id, user_id, type, ip, user_agent, read, write, manage, admin, created_at, updated_at, expire_at FROM tokens WHERE type != 'apikey';
+DROP TABLE tokens;
+ALTER TABLE tokens_down RENAME TO tokens;
+
+CREATE TRIGGER IF NOT EXISTS update_date_tokens AFTER UPDATE ON tokens FOR EACH ROW
+BEGIN UPDATE tokens SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE id = NEW.id; END;
+`,
+	})
+}
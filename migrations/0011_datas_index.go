@@ -0,0 +1,17 @@
+package migrations
+
+func init() {
+	register(Migration{
+		Version: 11,
+		Name:    "datas_index",
+		Up: `
+CREATE INDEX IF NOT EXISTS datas_device_field ON datas (device_id, field, created_at);
+`,
+		Down: `
+DROP INDEX IF EXISTS datas_device_field;
+`,
+		// SQLite got this index from initial migration, which keeps it when reverted
+		SQLiteUp:   ``,
+		SQLiteDown: ``,
+	})
+}
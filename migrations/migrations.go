@@ -0,0 +1,214 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ItsJimi/casa/logger"
+	"github.com/jmoiron/sqlx"
+)
+
+// Migration define a versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// SQLiteUp and SQLiteDown replace Up and Down on sqlite databases
+	SQLiteUp   string
+	SQLiteDown string
+}
+
+// noop tell if migration changes nothing on driver, it's only recorded
+func (m Migration) noop(driver string) bool {
+	up, down := m.queries(driver)
+	return up == "" && down == ""
+}
+
+// queries return up and down queries of migration for driver
+func (m Migration) queries(driver string) (string, string) {
+	if driver == "sqlite3" {
+		return m.SQLiteUp, m.SQLiteDown
+	}
+	return m.Up, m.Down
+}
+
+// State define a migration and if it was applied in database
+type State struct {
+	Version   int    `db:"version"`
+	Name      string `db:"name"`
+	Applied   bool   `db:"-"`
+	AppliedAt string `db:"applied_at"`
+}
+
+// lockID is the postgres advisory lock taken while migrating
+const lockID = 4353
+
+var list []Migration
+
+func register(migration Migration) {
+	list = append(list, migration)
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+}
+
+// All return every migration ordered by version
+func All() []Migration {
+	return list
+}
+
+// Latest return the version expected by this binary
+func Latest() int {
+	if len(list) == 0 {
+		return 0
+	}
+	return list[len(list)-1].Version
+}
+
+func createTable(db *sqlx.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	return err
+}
+
+// Current return the last applied version, 0 when no migration was applied
+func Current(db *sqlx.DB) (int, error) {
+	if err := createTable(db); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := db.Get(&version, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations")
+	return version, err
+}
+
+// Status return state of every known migration
+func Status(db *sqlx.DB) ([]State, error) {
+	if err := createTable(db); err != nil {
+		return nil, err
+	}
+
+	var applied []State
+	err := db.Select(&applied, "SELECT version, name, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+
+	states := []State{}
+	for _, migration := range list {
+		state := State{
+			Version: migration.Version,
+			Name:    migration.Name,
+		}
+		for _, a := range applied {
+			if a.Version == migration.Version {
+				state.Applied = true
+				state.AppliedAt = a.AppliedAt
+			}
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// apply run query of migration in a transaction and record it
+func apply(db *sqlx.DB, migration Migration, up bool) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// sqlite serialize writers itself
+	if db.DriverName() != "sqlite3" {
+		_, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", lockID)
+		if err != nil {
+			return err
+		}
+	}
+
+	var count int
+	err = tx.Get(&count, tx.Rebind("SELECT COUNT(*) FROM schema_migrations WHERE version=?"), migration.Version)
+	if err != nil {
+		return err
+	}
+	// Another process applied or reverted it while we were waiting for the lock
+	if (up && count > 0) || (!up && count == 0) {
+		return tx.Commit()
+	}
+
+	query, down := migration.queries(db.DriverName())
+	if !up {
+		query = down
+	}
+	if !migration.noop(db.DriverName()) {
+		if _, err = tx.Exec(query); err != nil {
+			return fmt.Errorf("migration %d %s: %s", migration.Version, migration.Name, err.Error())
+		}
+	}
+
+	if up {
+		_, err = tx.Exec(tx.Rebind("INSERT INTO schema_migrations (version, name) VALUES (?, ?)"), migration.Version, migration.Name)
+	} else {
+		_, err = tx.Exec(tx.Rebind("DELETE FROM schema_migrations WHERE version=?"), migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Up apply migrations until version target, 0 means latest
+func Up(db *sqlx.DB, target int) error {
+	if target == 0 {
+		target = Latest()
+	}
+
+	current, err := Current(db)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range list {
+		if migration.Version <= current || migration.Version > target {
+			continue
+		}
+		logger.WithFields(logger.Fields{"version": migration.Version}).Infof("Apply migration %s", migration.Name)
+		if err := apply(db, migration, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Down revert the last steps applied migrations
+func Down(db *sqlx.DB, steps int) error {
+	current, err := Current(db)
+	if err != nil {
+		return err
+	}
+
+	for i := len(list) - 1; i >= 0 && steps > 0; i-- {
+		migration := list[i]
+		if migration.Version > current {
+			continue
+		}
+		if _, down := migration.queries(db.DriverName()); down == "" && !migration.noop(db.DriverName()) {
+			return errors.New("Migration " + migration.Name + " can't be reverted")
+		}
+		logger.WithFields(logger.Fields{"version": migration.Version}).Infof("Revert migration %s", migration.Name)
+		if err := apply(db, migration, false); err != nil {
+			return err
+		}
+		steps--
+	}
+	return nil
+}
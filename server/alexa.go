@@ -0,0 +1,369 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ItsJimi/casa/logger"
+	"github.com/ItsJimi/casa/utils"
+	"github.com/labstack/echo"
+)
+
+// alexaVersion is the version of Alexa Smart Home interfaces
+const alexaVersion = "3"
+
+type alexaRequest struct {
+	Directive alexaDirective `json:"directive"`
+}
+
+type alexaDirective struct {
+	Header   alexaHeader    `json:"header"`
+	Endpoint *alexaEndpoint `json:"endpoint"`
+	Payload  alexaPayload   `json:"payload"`
+}
+
+type alexaHeader struct {
+	Namespace        string `json:"namespace"`
+	Name             string `json:"name"`
+	PayloadVersion   string `json:"payloadVersion"`
+	MessageID        string `json:"messageId"`
+	CorrelationToken string `json:"correlationToken,omitempty"`
+}
+
+type alexaEndpoint struct {
+	Scope      *alexaScope `json:"scope,omitempty"`
+	EndpointID string      `json:"endpointId"`
+}
+
+type alexaScope struct {
+	Type  string `json:"type"`
+	Token string `json:"token"`
+}
+
+type alexaPayload struct {
+	Scope           *alexaScope       `json:"scope"`
+	Grantee         *alexaScope       `json:"grantee"`
+	Brightness      *int              `json:"brightness"`
+	BrightnessDelta *int              `json:"brightnessDelta"`
+	TargetSetpoint  *alexaTemperature `json:"targetSetpoint"`
+}
+
+type alexaTemperature struct {
+	Value float64 `json:"value"`
+	Scale string  `json:"scale"`
+}
+
+type alexaResponse struct {
+	Event   alexaEvent    `json:"event"`
+	Context *alexaContext `json:"context,omitempty"`
+}
+
+type alexaEvent struct {
+	Header   alexaHeader    `json:"header"`
+	Endpoint *alexaEndpoint `json:"endpoint,omitempty"`
+	Payload  interface{}    `json:"payload"`
+}
+
+type alexaContext struct {
+	Properties []alexaProperty `json:"properties"`
+}
+
+type alexaProperty struct {
+	Namespace                 string      `json:"namespace"`
+	Name                      string      `json:"name"`
+	Value                     interface{} `json:"value"`
+	TimeOfSample              string      `json:"timeOfSample"`
+	UncertaintyInMilliseconds int         `json:"uncertaintyInMilliseconds"`
+}
+
+type alexaDiscoveredEndpoint struct {
+	EndpointID        string            `json:"endpointId"`
+	ManufacturerName  string            `json:"manufacturerName"`
+	FriendlyName      string            `json:"friendlyName"`
+	Description       string            `json:"description"`
+	DisplayCategories []string          `json:"displayCategories"`
+	Capabilities      []alexaCapability `json:"capabilities"`
+}
+
+type alexaCapability struct {
+	Type       string                     `json:"type"`
+	Interface  string                     `json:"interface"`
+	Version    string                     `json:"version"`
+	Properties *alexaCapabilityProperties `json:"properties,omitempty"`
+}
+
+type alexaCapabilityProperties struct {
+	Supported           []alexaPropertyName `json:"supported"`
+	ProactivelyReported bool                `json:"proactivelyReported"`
+	Retrievable         bool                `json:"retrievable"`
+}
+
+type alexaPropertyName struct {
+	Name string `json:"name"`
+}
+
+// token return access token of user sent with directive
+func (d alexaDirective) token() string {
+	switch {
+	case d.Endpoint != nil && d.Endpoint.Scope != nil:
+		return d.Endpoint.Scope.Token
+	case d.Payload.Scope != nil:
+		return d.Payload.Scope.Token
+	case d.Payload.Grantee != nil:
+		return d.Payload.Grantee.Token
+	}
+	return ""
+}
+
+// AlexaFulfillment route answer directives of Alexa Smart Home, authenticated by the token they carry
+func (s *Server) AlexaFulfillment(c echo.Context) error {
+	req := alexaRequest{}
+	if err := c.Bind(&req); err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAAF001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSAAF001",
+			Message: "Wrong parameters",
+		})
+	}
+
+	user, err := s.userFromToken(req.Directive.token())
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAAF002"}).Warnf("%s", err.Error())
+		return c.JSON(http.StatusOK, alexaError(req.Directive, "INVALID_AUTHORIZATION_CREDENTIAL", "Token is invalid or expired"))
+	}
+
+	devices, err := s.assistantDevices(user.ID)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAAF003"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusOK, alexaError(req.Directive, "INTERNAL_ERROR", "Devices can't be retrieved"))
+	}
+
+	return c.JSON(http.StatusOK, alexaFulfill(req, devices, s.assistantSender("alexa"), time.Now()))
+}
+
+// alexaFulfill answer directive for devices of user, controls are sent with send
+func alexaFulfill(req alexaRequest, devices []assistantDevice, send actionSender, now time.Time) alexaResponse {
+	directive := req.Directive
+	header := directive.Header
+
+	switch header.Namespace + "." + header.Name {
+	case "Alexa.Discovery.Discover":
+		endpoints := []alexaDiscoveredEndpoint{}
+		for _, device := range devices {
+			if endpoint, ok := alexaEndpointFrom(device); ok {
+				endpoints = append(endpoints, endpoint)
+			}
+		}
+		return alexaResponse{
+			Event: alexaEvent{
+				Header:  alexaResponseHeader(directive, "Alexa.Discovery", "Discover.Response"),
+				Payload: map[string]interface{}{"endpoints": endpoints},
+			},
+		}
+	case "Alexa.Authorization.AcceptGrant":
+		return alexaResponse{
+			Event: alexaEvent{
+				Header:  alexaResponseHeader(directive, "Alexa.Authorization", "AcceptGrant.Response"),
+				Payload: map[string]interface{}{},
+			},
+		}
+	}
+
+	if directive.Endpoint == nil {
+		return alexaError(directive, "INVALID_DIRECTIVE", "Directive has no endpoint")
+	}
+	device, ok := findAssistantDevice(devices, directive.Endpoint.EndpointID)
+	if !ok {
+		return alexaError(directive, "NO_SUCH_ENDPOINT", "Device can't be found")
+	}
+
+	name := "Response"
+	var err error
+	switch header.Namespace + "." + header.Name {
+	case "Alexa.ReportState":
+		name = "StateReport"
+	case "Alexa.PowerController.TurnOn":
+		err = device.setOn(true, send)
+	case "Alexa.PowerController.TurnOff":
+		err = device.setOn(false, send)
+	case "Alexa.BrightnessController.SetBrightness":
+		err = errAssistantValue
+		if directive.Payload.Brightness != nil {
+			err = device.setBrightness(*directive.Payload.Brightness, send)
+		}
+	case "Alexa.BrightnessController.AdjustBrightness":
+		err = errAssistantValue
+		if directive.Payload.BrightnessDelta != nil {
+			current, _ := device.brightnessPercent()
+			err = device.setBrightness(clampPercent(current+*directive.Payload.BrightnessDelta), send)
+		}
+	case "Alexa.ThermostatController.SetTargetTemperature":
+		err = errAssistantValue
+		if directive.Payload.TargetSetpoint != nil {
+			err = device.setTargetTemperature(directive.Payload.TargetSetpoint.celsius(), send)
+		}
+	default:
+		err = errAssistantNotSupported
+	}
+	if err != nil {
+		return alexaError(directive, alexaErrorType(err), err.Error())
+	}
+
+	return alexaResponse{
+		Event: alexaEvent{
+			Header:   alexaResponseHeader(directive, "Alexa", name),
+			Endpoint: &alexaEndpoint{EndpointID: device.ID},
+			Payload:  map[string]interface{}{},
+		},
+		Context: &alexaContext{
+			Properties: alexaProperties(device, now),
+		},
+	}
+}
+
+// alexaEndpointFrom describe device with Alexa capabilities, false when device has none
+func alexaEndpointFrom(device assistantDevice) (alexaDiscoveredEndpoint, bool) {
+	endpoint := alexaDiscoveredEndpoint{
+		EndpointID:       device.ID,
+		ManufacturerName: device.Manufacturer,
+		FriendlyName:     device.Name,
+		Description:      device.PhysicalName + " in " + device.RoomName,
+		Capabilities: []alexaCapability{
+			{Type: "AlexaInterface", Interface: "Alexa", Version: alexaVersion},
+		},
+	}
+	if endpoint.ManufacturerName == "" {
+		endpoint.ManufacturerName = "Casa"
+	}
+
+	category := ""
+	if device.onOff() {
+		category = "SWITCH"
+		endpoint.Capabilities = append(endpoint.Capabilities, alexaInterface("Alexa.PowerController", "powerState"))
+	}
+	if _, ok := device.brightness(); ok {
+		category = "LIGHT"
+		endpoint.Capabilities = append(endpoint.Capabilities, alexaInterface("Alexa.BrightnessController", "brightness"))
+	}
+	if device.thermometer() {
+		if category == "" {
+			category = "TEMPERATURE_SENSOR"
+		}
+		endpoint.Capabilities = append(endpoint.Capabilities, alexaInterface("Alexa.TemperatureSensor", "temperature"))
+	}
+	if device.thermostat() {
+		category = "THERMOSTAT"
+		endpoint.Capabilities = append(endpoint.Capabilities, alexaInterface("Alexa.ThermostatController", "targetSetpoint"))
+	}
+	endpoint.DisplayCategories = []string{category}
+	return endpoint, category != ""
+}
+
+func alexaInterface(name string, property string) alexaCapability {
+	return alexaCapability{
+		Type:      "AlexaInterface",
+		Interface: name,
+		Version:   alexaVersion,
+		Properties: &alexaCapabilityProperties{
+			Supported:   []alexaPropertyName{{Name: property}},
+			Retrievable: true,
+		},
+	}
+}
+
+// alexaProperties return known states of device capabilities
+func alexaProperties(device assistantDevice, now time.Time) []alexaProperty {
+	sample := now.UTC().Format(time.RFC3339)
+	property := func(namespace string, name string, value interface{}) alexaProperty {
+		return alexaProperty{
+			Namespace:                 namespace,
+			Name:                      name,
+			Value:                     value,
+			TimeOfSample:              sample,
+			UncertaintyInMilliseconds: 500,
+		}
+	}
+
+	properties := []alexaProperty{}
+	if on, ok := device.isOn(); ok && device.onOff() {
+		value := "OFF"
+		if on {
+			value = "ON"
+		}
+		properties = append(properties, property("Alexa.PowerController", "powerState", value))
+	}
+	if brightness, ok := device.brightnessPercent(); ok {
+		properties = append(properties, property("Alexa.BrightnessController", "brightness", brightness))
+	}
+	if ambient, ok := device.ambientTemperature(); ok && device.thermometer() {
+		properties = append(properties, property("Alexa.TemperatureSensor", "temperature", alexaTemperature{Value: ambient, Scale: "CELSIUS"}))
+	}
+	if target, ok := device.targetTemperature(); ok && device.thermostat() {
+		properties = append(properties, property("Alexa.ThermostatController", "targetSetpoint", alexaTemperature{Value: target, Scale: "CELSIUS"}))
+	}
+	return properties
+}
+
+func alexaResponseHeader(directive alexaDirective, namespace string, name string) alexaHeader {
+	return alexaHeader{
+		Namespace:        namespace,
+		Name:             name,
+		PayloadVersion:   alexaVersion,
+		MessageID:        utils.NewULID(),
+		CorrelationToken: directive.Header.CorrelationToken,
+	}
+}
+
+// alexaError return an Alexa error response to directive
+func alexaError(directive alexaDirective, errorType string, message string) alexaResponse {
+	res := alexaResponse{
+		Event: alexaEvent{
+			Header: alexaResponseHeader(directive, "Alexa", "ErrorResponse"),
+			Payload: map[string]interface{}{
+				"type":    errorType,
+				"message": message,
+			},
+		},
+	}
+	if directive.Endpoint != nil {
+		res.Event.Endpoint = &alexaEndpoint{EndpointID: directive.Endpoint.EndpointID}
+	}
+	return res
+}
+
+func alexaErrorType(err error) string {
+	switch err {
+	case errAssistantNotSupported:
+		return "INVALID_DIRECTIVE"
+	case errAssistantCantTurnOn, errAssistantCantTurnOff:
+		return "NOT_SUPPORTED_IN_CURRENT_MODE"
+	case errAssistantForbidden:
+		return "INSUFFICIENT_PERMISSIONS"
+	case errAssistantValue:
+		return "INVALID_VALUE"
+	case errGatewayOffline:
+		return "BRIDGE_UNREACHABLE"
+	}
+	return "ENDPOINT_UNREACHABLE"
+}
+
+// celsius convert temperature to celsius
+func (t alexaTemperature) celsius() float64 {
+	switch t.Scale {
+	case "FAHRENHEIT":
+		return (t.Value - 32) * 5 / 9
+	case "KELVIN":
+		return t.Value - 273.15
+	}
+	return t.Value
+}
+
+func clampPercent(percent int) int {
+	if percent < 0 {
+		return 0
+	}
+	if percent > 100 {
+		return 100
+	}
+	return percent
+}
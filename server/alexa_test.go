@@ -0,0 +1,31 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+func TestAlexaFulfill(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	runGoldens(t, "alexa", func(request []byte, send actionSender) interface{} {
+		var req alexaRequest
+		if err := json.Unmarshal(request, &req); err != nil {
+			t.Fatal(err)
+		}
+		devices, err := newAssistantFixture().assistantDevices("user")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res := alexaFulfill(req, devices, send, now)
+		// message ids are random ULIDs, so they are checked here instead of in golden files
+		if _, err := ulid.ParseStrict(res.Event.Header.MessageID); err != nil {
+			t.Errorf("messageId %s isn't a ULID", res.Event.Header.MessageID)
+		}
+		res.Event.Header.MessageID = "ulid"
+		return res
+	})
+}
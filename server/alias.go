@@ -0,0 +1,131 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/ItsJimi/casa/logger"
+	"github.com/ItsJimi/casa/utils"
+	"github.com/labstack/echo"
+)
+
+type aliasReq struct {
+	Name   string
+	Type   string
+	TypeID string
+}
+
+// aliasTargetHome return home of room or device named by alias
+func (s *Server) aliasTargetHome(aliasType string, typeID string) (string, error) {
+	roomID := typeID
+	switch aliasType {
+	case "room":
+	case "device":
+		device, err := s.db.Devices().ByID(typeID)
+		if err != nil {
+			return "", err
+		}
+		roomID = device.RoomID
+	default:
+		return "", errors.New("Type must be room or device")
+	}
+	room, err := s.db.Rooms().ByID(roomID)
+	if err != nil {
+		return "", err
+	}
+	return room.HomeID, nil
+}
+
+// AddAlias route give another name to a room or a device of home
+func (s *Server) AddAlias(c echo.Context) error {
+	req := new(aliasReq)
+	if err := c.Bind(req); err != nil {
+		logger.WithFields(logger.Fields{"code": "CSALAA001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSALAA001",
+			Message: "Wrong parameters",
+		})
+	}
+
+	if err := utils.MissingFields(c, reflect.ValueOf(req).Elem(), []string{"Name", "Type", "TypeID"}); err != nil {
+		logger.WithFields(logger.Fields{"code": "CSALAA002"}).Warnf("%s", err.Error())
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSALAA002",
+			Message: err.Error(),
+		})
+	}
+
+	homeID, err := s.aliasTargetHome(req.Type, req.TypeID)
+	if err != nil || homeID != c.Param("homeId") {
+		logger.WithFields(logger.Fields{"code": "CSALAA003"}).Warnf("Alias target %s %s not found in home", req.Type, req.TypeID)
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    "CSALAA003",
+			Message: "Room or device not found",
+		})
+	}
+
+	user := c.Get("user").(User)
+	alias := Alias{
+		ID:        utils.NewULID(),
+		HomeID:    c.Param("homeId"),
+		Name:      strings.TrimSpace(req.Name),
+		Type:      req.Type,
+		TypeID:    req.TypeID,
+		CreatorID: user.ID,
+	}
+	err = s.db.Aliases().Create(alias)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSALAA004"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSALAA004",
+			Message: "Alias can't be created, names are unique in a home",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, MessageResponse{
+		Message: alias.ID,
+	})
+}
+
+// DeleteAlias route delete alias of home
+func (s *Server) DeleteAlias(c echo.Context) error {
+	_, err := s.db.Aliases().GetForHome(c.Param("homeId"), c.Param("aliasId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSALDA001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    "CSALDA001",
+			Message: "Alias not found",
+		})
+	}
+
+	err = s.db.Aliases().Delete(c.Param("homeId"), c.Param("aliasId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSALDA002"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSALDA002",
+			Message: "Alias can't be deleted",
+		})
+	}
+
+	return c.JSON(http.StatusOK, MessageResponse{
+		Message: "Alias deleted",
+	})
+}
+
+// GetAliases route get aliases of home
+func (s *Server) GetAliases(c echo.Context) error {
+	aliases, err := s.db.Aliases().ListForHome(c.Param("homeId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSALGAS001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSALGAS001",
+			Message: "Aliases can't be retrieved",
+		})
+	}
+
+	return c.JSON(http.StatusOK, DataReponse{
+		Data: aliases,
+	})
+}
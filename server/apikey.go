@@ -0,0 +1,222 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ItsJimi/casa/logger"
+	"github.com/ItsJimi/casa/utils"
+	"github.com/labstack/echo"
+)
+
+// apiKeyType is the type of tokens created through the API for scripts and services
+const apiKeyType = "apikey"
+
+// apiKeyDefaultLifetime is used when no expiry is given
+const apiKeyDefaultLifetime = 365 * 24 * time.Hour
+
+type apiKeyReq struct {
+	Name     string
+	Type     string
+	TypeID   string
+	Read     bool
+	Write    bool
+	Manage   bool
+	Admin    bool
+	ExpireAt string // RFC3339, in a year when empty
+}
+
+// allows tell if rights of token cover rights asked by a route, like permissions do
+func (t Token) allows(read, write, manage, admin bool) bool {
+	return !((read && !t.Read && !t.Admin) ||
+		(write && !t.Write && !t.Admin) ||
+		(manage && !t.Manage && !t.Admin) ||
+		(admin && !t.Admin))
+}
+
+// inTokenScope tell if elements of route belong to scope of token
+func (s *Server) inTokenScope(token Token, c echo.Context) bool {
+	if token.ScopeType == "" {
+		return true
+	}
+	if token.ScopeType == "device" {
+		return c.Param("deviceId") == token.ScopeID
+	}
+
+	// handlers may look devices and rooms up by id only, so their parents are checked here
+	roomID := c.Param("roomId")
+	if c.Param("deviceId") != "" {
+		device, err := s.db.Devices().ByID(c.Param("deviceId"))
+		if err != nil || (roomID != "" && device.RoomID != roomID) {
+			return false
+		}
+		roomID = device.RoomID
+	}
+	if token.ScopeType == "room" {
+		return roomID == token.ScopeID
+	}
+	if roomID != "" {
+		room, err := s.db.Rooms().ByID(roomID)
+		if err != nil || room.HomeID != token.ScopeID {
+			return false
+		}
+	}
+	return c.Param("homeId") == token.ScopeID
+}
+
+func hashAPIKeySecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func validAPIKeySecret(token Token, secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashAPIKeySecret(secret)), []byte(token.SecretHash)) == 1
+}
+
+// validAPIKey check scope and rights asked for an API key, rights can't exceed those of user on scope
+func (s *Server) validAPIKey(userID string, req *apiKeyReq) error {
+	if req.Type != "home" && req.Type != "room" && req.Type != "device" {
+		return errors.New("Type must be home, room or device")
+	}
+	if !req.Read && !req.Write && !req.Manage && !req.Admin {
+		return errors.New("API key needs at least one right")
+	}
+	permission, err := s.db.Permissions().Get(userID, req.Type, req.TypeID)
+	if err != nil {
+		return errors.New(strings.Title(req.Type) + " not found")
+	}
+	rights := Token{Read: permission.Read, Write: permission.Write, Manage: permission.Manage, Admin: permission.Admin}
+	if !rights.allows(req.Read, req.Write, req.Manage, req.Admin) {
+		return errors.New("API key can't have more rights than you")
+	}
+	return nil
+}
+
+// AddAPIKey route create an API key scoped to a home, room or device, its key is only given once
+func (s *Server) AddAPIKey(c echo.Context) error {
+	req := new(apiKeyReq)
+	if err := c.Bind(req); err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAKAAK001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSAKAAK001",
+			Message: "Wrong parameters",
+		})
+	}
+
+	if err := utils.MissingFields(c, reflect.ValueOf(req).Elem(), []string{"Name", "Type", "TypeID"}); err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAKAAK002"}).Warnf("%s", err.Error())
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSAKAAK002",
+			Message: err.Error(),
+		})
+	}
+
+	expireAt := time.Now().Add(apiKeyDefaultLifetime)
+	if req.ExpireAt != "" {
+		var err error
+		expireAt, err = time.Parse(time.RFC3339, req.ExpireAt)
+		if err != nil || !expireAt.After(time.Now()) {
+			logger.WithFields(logger.Fields{"code": "CSAKAAK003"}).Warnf("Wrong expiry %s", req.ExpireAt)
+			return c.JSON(http.StatusBadRequest, ErrorResponse{
+				Code:    "CSAKAAK003",
+				Message: "expireAt must be a future RFC3339 date",
+			})
+		}
+	}
+
+	user := c.Get("user").(User)
+	if err := s.validAPIKey(user.ID, req); err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAKAAK004"}).Warnf("%s", err.Error())
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSAKAAK004",
+			Message: err.Error(),
+		})
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAKAAK005"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSAKAAK005",
+			Message: "API key can't be created",
+		})
+	}
+
+	token := Token{
+		ID:         utils.NewULID(),
+		UserID:     user.ID,
+		Type:       apiKeyType,
+		IP:         c.RealIP(),
+		UserAgent:  c.Request().UserAgent(),
+		Read:       req.Read,
+		Write:      req.Write,
+		Manage:     req.Manage,
+		Admin:      req.Admin,
+		Name:       req.Name,
+		ScopeType:  req.Type,
+		ScopeID:    req.TypeID,
+		SecretHash: hashAPIKeySecret(secret),
+	}
+	err = s.db.Tokens().CreateAPIKey(token, expireAt)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAKAAK006"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSAKAAK006",
+			Message: "API key can't be created",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, MessageResponse{
+		Message: token.ID + "." + secret,
+	})
+}
+
+// GetAPIKeys route get API keys of user, without their secret
+func (s *Server) GetAPIKeys(c echo.Context) error {
+	user := c.Get("user").(User)
+	tokens, err := s.db.Tokens().ListForUser(user.ID, apiKeyType)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAKGAK001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSAKGAK001",
+			Message: "API keys can't be retrieved",
+		})
+	}
+
+	return c.JSON(http.StatusOK, DataReponse{
+		Data: tokens,
+	})
+}
+
+// DeleteAPIKey route revoke an API key of user
+func (s *Server) DeleteAPIKey(c echo.Context) error {
+	user := c.Get("user").(User)
+	token, _, err := s.db.Tokens().ByIDWithUser(c.Param("tokenId"))
+	if err != nil || token.UserID != user.ID || token.Type != apiKeyType {
+		logger.WithFields(logger.Fields{"code": "CSAKDAK001"}).Warnf("API key %s not found", c.Param("tokenId"))
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    "CSAKDAK001",
+			Message: "API key not found",
+		})
+	}
+
+	err = s.db.Tokens().Delete(token.ID)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAKDAK002"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSAKDAK002",
+			Message: "API key can't be deleted",
+		})
+	}
+	s.dropClientsWithToken(token.ID)
+
+	return c.JSON(http.StatusOK, MessageResponse{
+		Message: "API key deleted",
+	})
+}
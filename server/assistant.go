@@ -0,0 +1,279 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/getcasa/sdk"
+)
+
+const (
+	// setpointAction is the action setting target temperature of a thermostat, in celsius
+	setpointAction = "setpoint"
+	// temperatureTrigger is the trigger giving ambient temperature, in celsius
+	temperatureTrigger = "temperature"
+)
+
+var (
+	errAssistantNotSupported = errors.New("Device doesn't support this command")
+	errAssistantForbidden    = errors.New("User can't control this device")
+	errAssistantValue        = errors.New("Wrong command value")
+	// errAssistantCantTurnOn and errAssistantCantTurnOff are returned for devices which only toggle when their state is unknown
+	errAssistantCantTurnOn  = errors.New("Device can't turn on, it only toggles and its state is unknown")
+	errAssistantCantTurnOff = errors.New("Device can't turn off, it only toggles and its state is unknown")
+)
+
+// actionSender send call with params to device
+type actionSender func(device Device, call string, params string) error
+
+// assistantDevice is a device with what voice assistants need to describe and control it
+type assistantDevice struct {
+	Device
+	RoomName     string
+	Manufacturer string
+	Write        bool
+	Plugin       sdk.Device
+	Actions      []sdk.Action
+	// States hold latest data of each trigger
+	States map[string]Datas
+}
+
+// assistantDevices return devices user can read, with their latest datas
+func (s *Server) assistantDevices(userID string) ([]assistantDevice, error) {
+	permissions, err := s.db.Devices().ListAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := map[string]string{}
+	devices := []assistantDevice{}
+	for _, permission := range permissions {
+		roomName, ok := rooms[permission.DeviceRoomID]
+		if !ok {
+			if room, err := s.db.Rooms().ByID(permission.DeviceRoomID); err == nil {
+				roomName = room.Name
+			}
+			rooms[permission.DeviceRoomID] = roomName
+		}
+
+		device, ok := s.newAssistantDevice(Device{
+			ID:           permission.DeviceID,
+			GatewayID:    permission.DeviceGatewayID,
+			Name:         permission.DeviceName,
+			Icon:         permission.DeviceIcon,
+			PhysicalID:   permission.DevicePhysicalID,
+			PhysicalName: permission.DevicePhysicalName,
+			Config:       permission.DeviceConfig,
+			Plugin:       permission.DevicePlugin,
+			RoomID:       permission.DeviceRoomID,
+		}, roomName, permission.Write || permission.Admin)
+		if ok {
+			devices = append(devices, device)
+		}
+	}
+	return devices, nil
+}
+
+// newAssistantDevice return device with metadata of its plugin and its latest datas, false when its plugin isn't loaded
+func (s *Server) newAssistantDevice(device Device, roomName string, write bool) (assistantDevice, bool) {
+	plugin := configFromPlugin(s.configs, device.Plugin)
+	pluginDevice := sdk.FindDevicesFromName(plugin.Devices, device.PhysicalName)
+	if pluginDevice.Name == "" {
+		return assistantDevice{}, false
+	}
+
+	aDevice := assistantDevice{
+		Device:       device,
+		RoomName:     roomName,
+		Manufacturer: plugin.Author,
+		Write:        write,
+		Plugin:       pluginDevice,
+		Actions:      plugin.Actions,
+		States:       map[string]Datas{},
+	}
+	for _, trigger := range pluginDevice.Triggers {
+		if data, err := s.db.Datas().Latest(device.ID, trigger.Name); err == nil {
+			aDevice.States[trigger.Name] = data
+		}
+	}
+	return aDevice, true
+}
+
+// assistantSender send actions asked by a voice assistant through gateway
+func (s *Server) assistantSender(source string) actionSender {
+	return func(device Device, call string, params string) error {
+		return s.sendDeviceAction(device, call, params, source)
+	}
+}
+
+// findAssistantDevice find device with ID
+func findAssistantDevice(devices []assistantDevice, ID string) (assistantDevice, bool) {
+	for _, device := range devices {
+		if device.ID == ID {
+			return device, true
+		}
+	}
+	return assistantDevice{}, false
+}
+
+// onOff tell if device can be turned on and off, with its default action and a bool default trigger
+func (d assistantDevice) onOff() bool {
+	return d.Plugin.DefaultAction != "" && FindFieldFromName(d.Plugin.Triggers, d.Plugin.DefaultTrigger).Type == "bool"
+}
+
+// brightness tell if device can be dimmed and return max level of its brightness action
+func (d assistantDevice) brightness() (int, bool) {
+	if !hasAction(d.Plugin, brightnessAction) {
+		return 0, false
+	}
+	for _, field := range findActionFromName(d.Actions, brightnessAction).Fields {
+		if field.Max > 0 {
+			return field.Max, true
+		}
+	}
+	return 100, true
+}
+
+// thermostat tell if target temperature of device can be set
+func (d assistantDevice) thermostat() bool {
+	return hasAction(d.Plugin, setpointAction)
+}
+
+// thermometer tell if device report ambient temperature
+func (d assistantDevice) thermometer() bool {
+	trigger := FindFieldFromName(d.Plugin.Triggers, temperatureTrigger)
+	return trigger.Name != "" && trigger.Type != "bool" && trigger.Type != "string"
+}
+
+func (d assistantDevice) isOn() (bool, bool) {
+	data, ok := d.States[d.Plugin.DefaultTrigger]
+	return data.ValueBool, ok
+}
+
+func (d assistantDevice) brightnessPercent() (int, bool) {
+	max, ok := d.brightness()
+	data, known := d.States[brightnessAction]
+	if !ok || !known {
+		return 0, false
+	}
+	return int(math.Round(data.ValueNbr * 100 / float64(max))), true
+}
+
+func (d assistantDevice) ambientTemperature() (float64, bool) {
+	data, ok := d.States[temperatureTrigger]
+	return data.ValueNbr, ok
+}
+
+func (d assistantDevice) targetTemperature() (float64, bool) {
+	data, ok := d.States[setpointAction]
+	return data.ValueNbr, ok
+}
+
+// defaultTakesState tell if default action of device sets the state it's given instead of toggling device
+func (d assistantDevice) defaultTakesState() bool {
+	fields := findActionFromName(d.Actions, d.Plugin.DefaultAction).Fields
+	return len(fields) == 1 && fields[0].Type == "bool"
+}
+
+// powerCall return action and params turning device on or off: its on or off action, else its default action
+// when it takes the state, else its default action toggling device when it's known to be in the other state
+func (d assistantDevice) powerCall(on bool) (string, string, error) {
+	calls, cant := commandOffActions, errAssistantCantTurnOff
+	if on {
+		calls, cant = commandOnActions, errAssistantCantTurnOn
+	}
+	if call := findCommandAction(d, calls); call != "" {
+		return call, "", nil
+	}
+	if !d.onOff() {
+		return "", "", errAssistantNotSupported
+	}
+	if d.defaultTakesState() {
+		return d.Plugin.DefaultAction, strconv.FormatBool(on), nil
+	}
+	if current, ok := d.isOn(); ok && current != on {
+		return d.Plugin.DefaultAction, "", nil
+	}
+	return "", "", cant
+}
+
+// setOn turn device on or off unless it's already in asked state
+func (d assistantDevice) setOn(on bool, send actionSender) error {
+	if !d.onOff() && findCommandAction(d, commandOnActions) == "" && findCommandAction(d, commandOffActions) == "" {
+		return errAssistantNotSupported
+	}
+	if !d.Write {
+		return errAssistantForbidden
+	}
+	if current, ok := d.isOn(); ok && current == on {
+		return nil
+	}
+	call, params, err := d.powerCall(on)
+	if err != nil {
+		return err
+	}
+	if err := send(d.Device, call, params); err != nil {
+		return err
+	}
+	if d.Plugin.DefaultTrigger != "" {
+		d.States[d.Plugin.DefaultTrigger] = Datas{DeviceID: d.ID, Field: d.Plugin.DefaultTrigger, ValueBool: on}
+	}
+	return nil
+}
+
+// toggle invert state of device, calling its default action when it toggles device
+func (d assistantDevice) toggle(send actionSender) error {
+	if !d.onOff() {
+		return errAssistantNotSupported
+	}
+	current, known := d.isOn()
+	if d.defaultTakesState() {
+		return d.setOn(!current, send)
+	}
+	if !d.Write {
+		return errAssistantForbidden
+	}
+	if err := send(d.Device, d.Plugin.DefaultAction, ""); err != nil {
+		return err
+	}
+	if known {
+		d.States[d.Plugin.DefaultTrigger] = Datas{DeviceID: d.ID, Field: d.Plugin.DefaultTrigger, ValueBool: !current}
+	}
+	return nil
+}
+
+// setBrightness call brightness action with percent scaled to its max level
+func (d assistantDevice) setBrightness(percent int, send actionSender) error {
+	max, ok := d.brightness()
+	if !ok {
+		return errAssistantNotSupported
+	}
+	if !d.Write {
+		return errAssistantForbidden
+	}
+	if percent < 0 || percent > 100 {
+		return errAssistantValue
+	}
+	level := int(math.Round(float64(percent*max) / 100))
+	if err := send(d.Device, brightnessAction, strconv.Itoa(level)); err != nil {
+		return err
+	}
+	d.States[brightnessAction] = Datas{DeviceID: d.ID, Field: brightnessAction, ValueNbr: float64(level)}
+	return nil
+}
+
+// setTargetTemperature call setpoint action with celsius
+func (d assistantDevice) setTargetTemperature(celsius float64, send actionSender) error {
+	if !d.thermostat() {
+		return errAssistantNotSupported
+	}
+	if !d.Write {
+		return errAssistantForbidden
+	}
+	if err := send(d.Device, setpointAction, strconv.FormatFloat(celsius, 'f', -1, 64)); err != nil {
+		return err
+	}
+	d.States[setpointAction] = Datas{DeviceID: d.ID, Field: setpointAction, ValueNbr: celsius}
+	return nil
+}
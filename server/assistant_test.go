@@ -0,0 +1,178 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/getcasa/sdk"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files of testdata with actual results")
+
+// sentAction is an action sent to a device by a fake actionSender
+type sentAction struct {
+	Device string `json:"device"`
+	Call   string `json:"call"`
+	Params string `json:"params"`
+}
+
+// recordSender return an actionSender keeping actions in sent, failing as gateway offline when offline is true
+func recordSender(sent *[]sentAction, offline bool) actionSender {
+	return func(device Device, call string, params string) error {
+		if offline {
+			return errGatewayOffline
+		}
+		*sent = append(*sent, sentAction{Device: device.ID, Call: call, Params: params})
+		return nil
+	}
+}
+
+// golden is a request to an assistant with actions it must send and its expected response
+type golden struct {
+	Request        json.RawMessage `json:"request"`
+	GatewayOffline bool            `json:"gatewayOffline,omitempty"`
+	Sent           []sentAction    `json:"sent"`
+	Response       json.RawMessage `json:"response"`
+}
+
+// runGoldens call fulfill with request of each golden file of dir and compare what it sends and answers
+func runGoldens(t *testing.T, dir string, fulfill func(request []byte, send actionSender) interface{}) {
+	files, err := filepath.Glob(filepath.Join("testdata", dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no golden files in testdata/%s", dir)
+	}
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want golden
+			if err := json.Unmarshal(content, &want); err != nil {
+				t.Fatal(err)
+			}
+
+			sent := []sentAction{}
+			response, err := json.Marshal(fulfill(want.Request, recordSender(&sent, want.GatewayOffline)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if *update {
+				got := want
+				got.Sent = sent
+				got.Response = response
+				content, err := json.MarshalIndent(got, "", "  ")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(file, append(content, '\n'), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			if !reflect.DeepEqual(sent, want.Sent) {
+				t.Errorf("sent %+v, want %+v", sent, want.Sent)
+			}
+			var got, expected interface{}
+			json.Unmarshal(response, &got)
+			json.Unmarshal(want.Response, &expected)
+			if !reflect.DeepEqual(got, expected) {
+				var indented bytes.Buffer
+				json.Indent(&indented, response, "", "  ")
+				t.Errorf("response is\n%s", indented.String())
+			}
+		})
+	}
+}
+
+// newAssistantFixture return a server knowing plugins of a lamp, a plug, a remote, a thermostat and a weather station,
+// user can control all of them but the plug
+func newAssistantFixture() *Server {
+	store := &fakeStore{
+		users: []User{{ID: "user", Firstname: "User"}},
+		rooms: []Room{{ID: "living", Name: "Living room", HomeID: "home"}},
+		devices: []Device{
+			{ID: "lamp", Name: "Lamp", RoomID: "living", Plugin: "hue", PhysicalID: "1", PhysicalName: "bulb"},
+			{ID: "plug", Name: "Plug", RoomID: "living", Plugin: "hue", PhysicalID: "2", PhysicalName: "plug"},
+			{ID: "remote", Name: "Remote", RoomID: "living", Plugin: "hue", PhysicalID: "3", PhysicalName: "remote"},
+			{ID: "heater", Name: "Heater", RoomID: "living", Plugin: "netatmo", PhysicalID: "4", PhysicalName: "thermostat"},
+			{ID: "station", Name: "Station", RoomID: "living", Plugin: "netatmo", PhysicalID: "5", PhysicalName: "station"},
+		},
+		permissions: []Permission{
+			{UserID: "user", Type: "device", TypeID: "lamp", Read: true, Write: true},
+			{UserID: "user", Type: "device", TypeID: "plug", Read: true},
+			{UserID: "user", Type: "device", TypeID: "remote", Read: true, Write: true},
+			{UserID: "user", Type: "device", TypeID: "heater", Read: true, Admin: true},
+			{UserID: "user", Type: "device", TypeID: "station", Read: true},
+		},
+		datas: []Datas{
+			{DeviceID: "lamp", Field: "on", ValueBool: true},
+			{DeviceID: "lamp", Field: "brightness", ValueNbr: 127},
+			{DeviceID: "plug", Field: "on", ValueBool: false},
+			{DeviceID: "heater", Field: "temperature", ValueNbr: 18.5},
+			{DeviceID: "heater", Field: "setpoint", ValueNbr: 19},
+			{DeviceID: "station", Field: "temperature", ValueNbr: 21.3},
+		},
+	}
+
+	s := NewServer(store, nil)
+	s.configs = []sdk.Configuration{
+		{
+			Name:   "hue",
+			Author: "Philips",
+			Devices: []sdk.Device{
+				{
+					Name:           "bulb",
+					DefaultTrigger: "on",
+					DefaultAction:  "switch",
+					Triggers:       []sdk.Trigger{{Name: "on", Type: "bool"}, {Name: "brightness", Type: "int"}},
+					Actions:        []string{"switch", "brightness"},
+				},
+				{
+					Name:           "plug",
+					DefaultTrigger: "on",
+					DefaultAction:  "switch",
+					Triggers:       []sdk.Trigger{{Name: "on", Type: "bool"}},
+					Actions:        []string{"switch"},
+				},
+				{
+					Name:     "remote",
+					Triggers: []sdk.Trigger{{Name: "button", Type: "string"}},
+				},
+			},
+			Actions: []sdk.Action{
+				{Name: "switch", Fields: []sdk.Field{{Name: "state", Type: "bool"}}},
+				{Name: "brightness", Fields: []sdk.Field{{Name: "level", Type: "int", Max: 254}}},
+			},
+		},
+		{
+			Name: "netatmo",
+			Devices: []sdk.Device{
+				{
+					Name:     "thermostat",
+					Triggers: []sdk.Trigger{{Name: "temperature", Type: "int"}, {Name: "setpoint", Type: "int"}},
+					Actions:  []string{"setpoint"},
+				},
+				{
+					Name:     "station",
+					Triggers: []sdk.Trigger{{Name: "temperature", Type: "int"}},
+				},
+			},
+			Actions: []sdk.Action{
+				{Name: "setpoint", Fields: []sdk.Field{{Name: "celsius", Type: "int", Min: 5, Max: 30}}},
+			},
+		},
+	}
+	return s
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"errors"
 	"net/http"
 	"reflect"
 	"strings"
@@ -29,7 +30,7 @@ type signinReq struct {
 }
 
 // SignUp route create an user
-func SignUp(c echo.Context) error {
+func (s *Server) SignUp(c echo.Context) error {
 	req := new(signupReq)
 	if err := c.Bind(req); err != nil {
 		logger.WithFields(logger.Fields{"code": "CSASU001"}).Errorf("%s", err.Error())
@@ -75,13 +76,14 @@ func SignUp(c echo.Context) error {
 	}
 
 	newUser := User{
+		ID:        utils.NewULID(),
 		Email:     req.Email,
 		Password:  string(hashedPassword),
 		Firstname: firstname,
 		Lastname:  req.Lastname,
 		Birthdate: birthdate.Format("2006-01-02 00:00:00"),
 	}
-	_, err = DB.NamedExec("INSERT INTO users (id, email, password, firstname, lastname, birthdate) VALUES (generate_ulid(), :email, :password, :firstname, :lastname, :birthdate)", newUser)
+	err = s.db.Users().Create(newUser)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSASU005"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -96,7 +98,7 @@ func SignUp(c echo.Context) error {
 }
 
 // SignIn route log an user by giving token
-func SignIn(c echo.Context) error {
+func (s *Server) SignIn(c echo.Context) error {
 	req := new(signinReq)
 	if err := c.Bind(req); err != nil {
 		logger.WithFields(logger.Fields{"code": "CSASI001"}).Errorf("%s", err.Error())
@@ -114,8 +116,7 @@ func SignIn(c echo.Context) error {
 		})
 	}
 
-	var user User
-	err := DB.Get(&user, "SELECT id, password FROM users WHERE email=$1", req.Email)
+	user, err := s.db.Users().ByEmail(req.Email)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSASI003"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusBadRequest, ErrorResponse{
@@ -132,8 +133,14 @@ func SignIn(c echo.Context) error {
 		})
 	}
 
-	row, err := DB.Query("INSERT INTO tokens (id, user_id, type, ip, user_agent) VALUES (generate_ulid(), $1, $2, $3, $4) RETURNING id;",
-		user.ID, "signin", c.RealIP(), c.Request().UserAgent())
+	newToken := Token{
+		ID:        utils.NewULID(),
+		UserID:    user.ID,
+		Type:      "signin",
+		IP:        c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+	}
+	err = s.db.Tokens().Create(newToken)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSASI005"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusBadRequest, ErrorResponse{
@@ -141,29 +148,16 @@ func SignIn(c echo.Context) error {
 			Message: "Token can't be created",
 		})
 	}
-	var id string
-	row.Next()
-	err = row.Scan(&id)
-	if err != nil {
-		logger.WithFields(logger.Fields{"code": "CSASI006"}).Errorf("%s", err.Error())
-		return c.JSON(http.StatusBadRequest, ErrorResponse{
-			Code:    "CSASI006",
-			Message: "Token can't be created",
-		})
-	}
 
 	return c.JSON(http.StatusOK, MessageResponse{
-		Message: id,
+		Message: newToken.ID,
 	})
 }
 
 // SignOut route logout user and delete his token
-func SignOut(c echo.Context) error {
+func (s *Server) SignOut(c echo.Context) error {
 	token := strings.Split(c.Request().Header.Get("Authorization"), " ")[1]
-	_, err := DB.Exec(`
-		DELETE FROM tokens
-		WHERE id=$1
-	`, token)
+	err := s.db.Tokens().Delete(token)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSASO001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -171,37 +165,82 @@ func SignOut(c echo.Context) error {
 			Message: "Token can't be delete",
 		})
 	}
+	s.dropClientsWithToken(token)
 
 	return c.JSON(http.StatusOK, MessageResponse{
 		Message: "You've been disconnected and your token has been deleted",
 	})
 }
 
-type tokenUser struct {
-	Token
-	User
-}
-
 // IsAuthenticated verify validity of token
-func IsAuthenticated(key string, c echo.Context) (bool, error) {
-	var token tokenUser
-	err := DB.Get(&token, "SELECT users.*, tokens.expire_at FROM tokens JOIN users ON tokens.user_id = users.id WHERE tokens.id=$1", key)
+func (s *Server) IsAuthenticated(key string, c echo.Context) (bool, error) {
+	token, user, err := s.tokenFromKey(key)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSAIA001"}).Errorf("%s", err.Error())
 		return false, nil
 	}
+	// scope of API keys is checked by hasPermission, which protects every route of homes
+	if token.Type == apiKeyType && !strings.HasPrefix(c.Path(), "/v1/homes/:homeId") {
+		logger.WithFields(logger.Fields{"code": "CSAIA002", "tokenId": token.ID}).Warnf("API key used on %s", c.Path())
+		return false, nil
+	}
+
+	c.Set("user", user)
+	c.Set("token", token)
+
+	return true, nil
+}
 
-	expireAt, err := time.Parse(time.RFC3339, token.Token.ExpireAt)
+// userFromToken return owner of token key when token isn't expired, API keys are refused
+func (s *Server) userFromToken(key string) (User, error) {
+	token, user, err := s.tokenFromKey(key)
 	if err != nil {
-		logger.WithFields(logger.Fields{"code": "CSAIA002"}).Errorf("%s", err.Error())
-		return false, nil
+		return User{}, err
 	}
-	if expireAt.Sub(time.Now()) <= 0 {
-		logger.WithFields(logger.Fields{"code": "CSAIA003"}).Warnf("Expired tokens")
-		return false, nil
+	if token.Type == apiKeyType {
+		return User{}, errors.New("API keys can't be used here")
+	}
+
+	return user, nil
+}
+
+// tokenFromKey return token and its owner when token isn't expired, key of API keys is <id>.<secret>
+func (s *Server) tokenFromKey(key string) (Token, User, error) {
+	parts := strings.SplitN(key, ".", 2)
+	token, user, err := s.db.Tokens().ByIDWithUser(parts[0])
+	if err != nil {
+		return Token{}, User{}, err
+	}
+	if token.Type == apiKeyType && (len(parts) != 2 || !validAPIKeySecret(token, parts[1])) {
+		return Token{}, User{}, errors.New("Wrong API key secret")
+	}
+	if token.Type != apiKeyType && len(parts) != 1 {
+		return Token{}, User{}, errors.New("Token isn't an API key")
 	}
 
-	c.Set("user", token.User)
+	if err := tokenExpired(token); err != nil {
+		return Token{}, User{}, err
+	}
 
-	return true, nil
+	return token, user, nil
+}
+
+// tokenValid check that token authenticating a long lived connection still exists and isn't expired
+func (s *Server) tokenValid(id string) error {
+	token, _, err := s.db.Tokens().ByIDWithUser(id)
+	if err != nil {
+		return err
+	}
+	return tokenExpired(token)
+}
+
+func tokenExpired(token Token) error {
+	expireAt, err := time.Parse(time.RFC3339, token.ExpireAt)
+	if err != nil {
+		return err
+	}
+	if expireAt.Sub(time.Now()) <= 0 {
+		return errors.New("Expired token")
+	}
+	return nil
 }
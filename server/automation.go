@@ -1,15 +1,12 @@
 package server
 
 import (
-	"encoding/json"
-	"fmt"
 	"net/http"
 	"reflect"
 
 	"github.com/ItsJimi/casa/logger"
 	"github.com/ItsJimi/casa/utils"
 	"github.com/labstack/echo"
-	"github.com/lib/pq"
 )
 
 type addAutomationReq struct {
@@ -25,7 +22,7 @@ type addAutomationReq struct {
 }
 
 // AddAutomation route create and add user to an automation
-func AddAutomation(c echo.Context) error {
+func (s *Server) AddAutomation(c echo.Context) error {
 	req := new(addAutomationReq)
 	if err := c.Bind(req); err != nil {
 		logger.WithFields(logger.Fields{"code": "CSAAA001"}).Errorf("%s", err.Error())
@@ -54,6 +51,7 @@ func AddAutomation(c echo.Context) error {
 	user := c.Get("user").(User)
 
 	newAutomation := Automation{
+		ID:              utils.NewULID(),
 		Name:            req.Name,
 		Trigger:         req.Trigger,
 		TriggerKey:      req.TriggerKey,
@@ -67,9 +65,11 @@ func AddAutomation(c echo.Context) error {
 		CreatorID:       user.ID,
 	}
 
-	var device Device
 	for _, trigg := range req.Trigger {
-		err := DB.Get(&device, `SELECT * FROM devices WHERE id = $1`, trigg)
+		if trigg == webhookTrigger {
+			continue
+		}
+		_, err := s.db.Devices().ByID(trigg)
 		if err != nil {
 			logger.WithFields(logger.Fields{"code": "CSAAA004"}).Errorf("%s", err.Error())
 			return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -80,7 +80,10 @@ func AddAutomation(c echo.Context) error {
 	}
 
 	for _, act := range req.Action {
-		err := DB.Get(&device, `SELECT * FROM devices WHERE id = $1`, act)
+		if act == notificationAction {
+			continue
+		}
+		_, err := s.db.Devices().ByID(act)
 		if err != nil {
 			logger.WithFields(logger.Fields{"code": "CSAAA005"}).Errorf("%s", err.Error())
 			return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -90,8 +93,7 @@ func AddAutomation(c echo.Context) error {
 		}
 	}
 
-	row, err := DB.Query("INSERT INTO automations (id, name, trigger, trigger_key, trigger_operator, trigger_value, action, action_call, action_value, status, creator_id, home_id) VALUES (generate_ulid(), $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id",
-		newAutomation.Name, pq.Array(newAutomation.Trigger), pq.Array(newAutomation.TriggerKey), pq.Array(newAutomation.TriggerOperator), pq.Array(newAutomation.TriggerValue), pq.Array(newAutomation.Action), pq.Array(newAutomation.ActionCall), pq.Array(newAutomation.ActionValue), newAutomation.Status, newAutomation.CreatorID, newAutomation.HomeID)
+	err := s.db.Automations().Create(newAutomation)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSAAA005"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusBadRequest, ErrorResponse{
@@ -100,24 +102,13 @@ func AddAutomation(c echo.Context) error {
 		})
 	}
 
-	var automationID string
-	row.Next()
-	err = row.Scan(&automationID)
-	if err != nil {
-		logger.WithFields(logger.Fields{"code": "CSAAA006"}).Errorf("%s", err.Error())
-		return c.JSON(http.StatusBadRequest, ErrorResponse{
-			Code:    "CSAAA006",
-			Message: "Automation can't be created",
-		})
-	}
-
 	return c.JSON(http.StatusCreated, MessageResponse{
-		Message: automationID,
+		Message: newAutomation.ID,
 	})
 }
 
 // UpdateAutomation route update automation
-func UpdateAutomation(c echo.Context) error {
+func (s *Server) UpdateAutomation(c echo.Context) error {
 	req := new(addAutomationReq)
 	if err := c.Bind(req); err != nil {
 		logger.WithFields(logger.Fields{"code": "CSAUA001"}).Errorf("%s", err.Error())
@@ -127,22 +118,17 @@ func UpdateAutomation(c echo.Context) error {
 		})
 	}
 
-	request := `UPDATE automations
-	SET name = COALESCE($1, name),
-
-	trigger = COALESCE($2, trigger),
-	trigger_key = COALESCE($3, trigger_key),
-	trigger_operator = COALESCE($4, trigger_operator),
-	trigger_value = COALESCE($5, trigger_value),
-	action = COALESCE($6, action),
-	action_call = COALESCE($7, action_call),
-	action_value = COALESCE($8, action_value)
-
-	WHERE id=$9`
-
-	fmt.Println(req.Trigger)
-
-	_, err := DB.Exec(request, utils.NewNullString(req.Name), pq.Array(req.Trigger), pq.Array(req.TriggerKey), pq.Array(req.TriggerOperator), pq.Array(req.TriggerValue), pq.Array(req.Action), pq.Array(req.ActionCall), pq.Array(req.ActionValue), c.Param("automationId"))
+	err := s.db.Automations().Update(Automation{
+		ID:              c.Param("automationId"),
+		Name:            req.Name,
+		Trigger:         req.Trigger,
+		TriggerKey:      req.TriggerKey,
+		TriggerOperator: req.TriggerOperator,
+		TriggerValue:    req.TriggerValue,
+		Action:          req.Action,
+		ActionCall:      req.ActionCall,
+		ActionValue:     req.ActionValue,
+	})
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSAUA002"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -157,10 +143,10 @@ func UpdateAutomation(c echo.Context) error {
 }
 
 // DeleteAutomation route delete automation
-func DeleteAutomation(c echo.Context) error {
+func (s *Server) DeleteAutomation(c echo.Context) error {
 	user := c.Get("user").(User)
 
-	_, err := DB.Exec("DELETE FROM automations WHERE creator_id=$1 AND id=$2", user.ID, c.Param("automationId"))
+	err := s.db.Automations().Delete(user.ID, c.Param("automationId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSADA001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -174,25 +160,6 @@ func DeleteAutomation(c echo.Context) error {
 	})
 }
 
-type permissionAutomations struct {
-	User            User
-	ID              string   `db:"a_id"`
-	Name            string   `db:"a_name"`
-	HomeID          string   `db:"a_homeid"`
-	Trigger         []string `db:"a_trigger"`
-	TriggerKey      []string `db:"a_triggerkey"`
-	TriggerOperator []string `db:"a_triggeroperator"`
-	TriggerValue    []string `db:"a_triggervalue"`
-	Action          []string `db:"a_action"`
-	ActionCall      []string `db:"a_actioncall"`
-	ActionValue     []string `db:"a_actionvalue"`
-	Status          bool     `db:"a_status"`
-	CreatedAt       string   `db:"a_createdat"`
-	UpdatedAt       string   `db:"a_updatedat"`
-	Triggers        string
-	Actions         string
-}
-
 type automationStruct struct {
 	ID              string   `json:"id"`
 	HomeID          string   `db:"home_id" json:"homeId"`
@@ -211,15 +178,8 @@ type automationStruct struct {
 }
 
 // GetAutomations route get list of user automations
-func GetAutomations(c echo.Context) error {
-	rows, err := DB.Queryx(`
-		SELECT t.*,
-		array(SELECT `+DeviceJSONSelect+` FROM devices WHERE devices.id = ANY(a_trigger)) AS triggers,
-		array(SELECT `+DeviceJSONSelect+` FROM devices WHERE devices.id = ANY(a_action)) AS actions
-		FROM(SELECT users.*, automations.id as a_id,	automations.name AS a_name, automations.home_id AS a_homeid, automations.trigger AS a_trigger, automations.trigger_key AS a_triggerkey, automations.trigger_operator AS a_triggeroperator, automations.trigger_value AS a_triggervalue, automations.action AS a_action, automations.action_call AS a_actioncall, automations.action_value AS a_actionvalue, automations.status AS a_status, automations.created_at AS a_createdat, automations.updated_at AS a_updatedat FROM automations
-		JOIN users ON automations.creator_id = users.id
-		WHERE automations.home_id=$1) AS t
-	`, c.Param("homeId"))
+func (s *Server) GetAutomations(c echo.Context) error {
+	details, err := s.db.Automations().ListForHome(c.Param("homeId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSAGAS001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -227,36 +187,24 @@ func GetAutomations(c echo.Context) error {
 			Message: "Automations can't be retrieved",
 		})
 	}
-	defer rows.Close()
 
 	automations := []automationStruct{}
-	for rows.Next() {
-
-		var auto permissionAutomations
-		err := rows.Scan(&auto.User.ID, &auto.User.Firstname, &auto.User.Lastname, &auto.User.Email, &auto.User.Password, &auto.User.Birthdate, &auto.User.CreatedAt, &auto.User.UpdatedAt, &auto.ID, &auto.Name, &auto.HomeID, pq.Array(&auto.Trigger), pq.Array(&auto.TriggerKey), pq.Array(&auto.TriggerOperator), pq.Array(&auto.TriggerValue), pq.Array(&auto.Action), pq.Array(&auto.ActionCall), pq.Array(&auto.ActionValue), &auto.Status, &auto.CreatedAt, &auto.UpdatedAt, &auto.Triggers, &auto.Actions)
-		if err != nil {
-			logger.WithFields(logger.Fields{"code": "CSAGAS002"}).Errorf("%s", err.Error())
-			return c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Code:    "CSAGAS002",
-				Message: "Automations can't be retrieved",
-			})
-		}
-
+	for _, auto := range details {
 		automations = append(automations, automationStruct{
 			ID:              auto.ID,
 			HomeID:          auto.HomeID,
 			Name:            auto.Name,
-			Trigger:         deviceJSONToStruct(auto.Triggers),
+			Trigger:         auto.Triggers,
 			TriggerKey:      auto.TriggerKey,
 			TriggerOperator: auto.TriggerOperator,
 			TriggerValue:    auto.TriggerValue,
-			Action:          deviceJSONToStruct(auto.Actions),
+			Action:          auto.Actions,
 			ActionCall:      auto.ActionCall,
 			ActionValue:     auto.ActionValue,
 			Status:          auto.Status,
 			CreatedAt:       auto.CreatedAt,
 			UpdatedAt:       auto.UpdatedAt,
-			Creator:         auto.User,
+			Creator:         auto.Creator,
 		})
 	}
 
@@ -264,18 +212,8 @@ func GetAutomations(c echo.Context) error {
 }
 
 // GetAutomation route get specific automation with id
-func GetAutomation(c echo.Context) error {
-	row := DB.QueryRowx(`
-	SELECT t.*,
-	array(SELECT `+DeviceJSONSelect+` FROM devices WHERE devices.id = ANY(a_trigger)) AS triggers,
-	array(SELECT `+DeviceJSONSelect+` FROM devices WHERE devices.id = ANY(a_action)) AS actions
-	FROM(SELECT users.*, automations.id as a_id,	automations.name AS a_name, automations.home_id AS a_homeid, automations.trigger AS a_trigger, automations.trigger_key AS a_triggerkey, automations.trigger_operator AS a_triggeroperator, automations.trigger_value AS a_triggervalue, automations.action AS a_action, automations.action_call AS a_actioncall, automations.action_value AS a_actionvalue, automations.status AS a_status, automations.created_at AS a_createdat, automations.updated_at AS a_updatedat FROM automations
-	JOIN users ON automations.creator_id = users.id
-	WHERE automations.home_id=$1 AND automations.id=$2) AS t
-`, c.Param("homeId"), c.Param("automationId"))
-
-	var auto permissionAutomations
-	err := row.Scan(&auto.User.ID, &auto.User.Firstname, &auto.User.Lastname, &auto.User.Email, &auto.User.Password, &auto.User.Birthdate, &auto.User.CreatedAt, &auto.User.UpdatedAt, &auto.ID, &auto.HomeID, &auto.Name, pq.Array(&auto.Trigger), pq.Array(&auto.TriggerKey), pq.Array(&auto.TriggerOperator), pq.Array(&auto.TriggerValue), pq.Array(&auto.Action), pq.Array(&auto.ActionCall), pq.Array(&auto.ActionValue), &auto.Status, &auto.CreatedAt, &auto.UpdatedAt, &auto.Triggers, &auto.Actions)
+func (s *Server) GetAutomation(c echo.Context) error {
+	auto, err := s.db.Automations().GetForHome(c.Param("homeId"), c.Param("automationId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSAGA001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -288,28 +226,23 @@ func GetAutomation(c echo.Context) error {
 		ID:              auto.ID,
 		HomeID:          auto.HomeID,
 		Name:            auto.Name,
-		Trigger:         deviceJSONToStruct(auto.Triggers),
+		Trigger:         auto.Triggers,
 		TriggerKey:      auto.TriggerKey,
 		TriggerOperator: auto.TriggerOperator,
 		TriggerValue:    auto.TriggerValue,
-		Action:          deviceJSONToStruct(auto.Actions),
+		Action:          auto.Actions,
 		ActionCall:      auto.ActionCall,
 		ActionValue:     auto.ActionValue,
 		Status:          auto.Status,
 		CreatedAt:       auto.CreatedAt,
 		UpdatedAt:       auto.UpdatedAt,
-		Creator:         auto.User,
+		Creator:         auto.Creator,
 	})
 }
 
 // GetLogsAutomation return list of log for an automation
-func GetLogsAutomation(c echo.Context) error {
-	rows, err := DB.Queryx(`
-	SELECT logs.* FROM logs
-	JOIN automations ON logs.type_id = automations.id
-	WHERE automations.home_id=$1 AND type_id=$2 AND type = 'automation'
-	ORDER BY created_at DESC
-	`, c.Param("homeId"), c.Param("automationId"))
+func (s *Server) GetLogsAutomation(c echo.Context) error {
+	logs, err := s.db.Logs().ListForAutomation(c.Param("homeId"), c.Param("automationId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSAGLA002"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -318,45 +251,5 @@ func GetLogsAutomation(c echo.Context) error {
 		})
 	}
 
-	defer rows.Close()
-
-	logs := []Logs{}
-	for rows.Next() {
-		var log Logs
-		err := rows.StructScan(&log)
-		if err != nil {
-			logger.WithFields(logger.Fields{"code": "CSAGLA003"}).Errorf("%s", err.Error())
-			return c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Code:    "CSAGLA003",
-				Message: "Logs can't be found",
-			})
-		}
-
-		logs = append(logs, log)
-	}
-
 	return c.JSON(http.StatusOK, logs)
 }
-
-func deviceJSONToStruct(str string) []Device {
-	str = "[" + str[1:len(str)-1] + "]"
-	var arrayJSON []string
-	err := json.Unmarshal([]byte(str), &arrayJSON)
-	if err != nil {
-		logger.WithFields(logger.Fields{"code": "CSADJTS001"}).Errorf("%s", err.Error())
-		return []Device{}
-	}
-
-	var listTrigger []Device
-	for _, elem := range arrayJSON {
-		var trigger Device
-		err = json.Unmarshal([]byte(elem), &trigger)
-		if err != nil {
-			logger.WithFields(logger.Fields{"code": "CSADJTS002"}).Errorf("%s", err.Error())
-			return []Device{}
-		}
-		listTrigger = append(listTrigger, trigger)
-	}
-
-	return listTrigger
-}
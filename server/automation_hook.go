@@ -0,0 +1,386 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ItsJimi/casa/logger"
+	"github.com/ItsJimi/casa/utils"
+	"github.com/getcasa/sdk"
+	"github.com/labstack/echo"
+)
+
+// webhookTrigger is the trigger of an automation matching a variable of an incoming webhook body
+const webhookTrigger = "webhook"
+
+const (
+	// hookRateLimit define how many calls of a hook are accepted per hookRateWindow
+	hookRateLimit  = 10
+	hookRateWindow = time.Minute
+	// hookMaxBodySize define maximum size of a body posted to a hook
+	hookMaxBodySize = 64 * 1024
+)
+
+var hookVariable = regexp.MustCompile(`{{\s*([^{}\s]+)\s*}}`)
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// rateLimiter allow limit calls per window for each key
+type rateLimiter struct {
+	mutex   sync.Mutex
+	limit   int
+	window  time.Duration
+	windows map[string]*rateWindow
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		window:  window,
+		windows: map[string]*rateWindow{},
+	}
+}
+
+// allow count a call of key and tell if it's under limit
+func (l *rateLimiter) allow(key string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+	w.count++
+	return w.count <= l.limit
+}
+
+// evict remove windows ended before now
+func (l *rateLimiter) evict(now time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= l.window {
+			delete(l.windows, key)
+		}
+	}
+}
+
+// EvictWindows remove ended windows every interval, so keys called once don't stay in memory
+func (l *rateLimiter) EvictWindows(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		l.evict(now)
+	}
+}
+
+// clientIP return address of client, or the one given by a trusted proxy in X-Forwarded-For or X-Real-IP
+func (s *Server) clientIP(c echo.Context) string {
+	host, _, err := net.SplitHostPort(c.Request().RemoteAddr)
+	if err != nil {
+		host = c.Request().RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	for _, proxy := range s.trustedProxies {
+		if proxy == host {
+			return c.RealIP()
+		}
+		if _, network, err := net.ParseCIDR(proxy); err == nil && ip != nil && network.Contains(ip) {
+			return c.RealIP()
+		}
+	}
+	return host
+}
+
+type automationHookRes struct {
+	AutomationHook
+	URL string `json:"url"`
+}
+
+// AddAutomationHook route create an incoming webhook firing automation
+func (s *Server) AddAutomationHook(c echo.Context) error {
+	auto, err := s.db.Automations().GetForHome(c.Param("homeId"), c.Param("automationId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAHAAH001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    "CSAHAAH001",
+			Message: "Automation not found",
+		})
+	}
+
+	token, err := newSecret()
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAHAAH002"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSAHAAH002",
+			Message: "Hook can't be created",
+		})
+	}
+
+	user := c.Get("user").(User)
+	hook := AutomationHook{
+		ID:           utils.NewULID(),
+		HomeID:       auto.HomeID,
+		AutomationID: auto.ID,
+		Token:        token,
+		CreatorID:    user.ID,
+	}
+	err = s.db.AutomationHooks().Create(hook)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAHAAH003"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSAHAAH003",
+			Message: "Hook can't be created",
+		})
+	}
+
+	hook, err = s.db.AutomationHooks().ByToken(hook.Token)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAHAAH004"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSAHAAH004",
+			Message: "Hook can't be retrieved",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, DataReponse{
+		Data: automationHookRes{
+			AutomationHook: hook,
+			URL:            "/v1/hooks/" + hook.Token,
+		},
+	})
+}
+
+// GetAutomationHooks route get incoming webhooks of automation
+func (s *Server) GetAutomationHooks(c echo.Context) error {
+	auto, err := s.db.Automations().GetForHome(c.Param("homeId"), c.Param("automationId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAHGAH001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    "CSAHGAH001",
+			Message: "Automation not found",
+		})
+	}
+
+	hooks, err := s.db.AutomationHooks().ListForAutomation(auto.ID)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAHGAH002"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSAHGAH002",
+			Message: "Hooks can't be retrieved",
+		})
+	}
+
+	res := []automationHookRes{}
+	for _, hook := range hooks {
+		res = append(res, automationHookRes{
+			AutomationHook: hook,
+			URL:            "/v1/hooks/" + hook.Token,
+		})
+	}
+
+	return c.JSON(http.StatusOK, DataReponse{
+		Data: res,
+	})
+}
+
+// DeleteAutomationHook route delete incoming webhook of automation
+func (s *Server) DeleteAutomationHook(c echo.Context) error {
+	auto, err := s.db.Automations().GetForHome(c.Param("homeId"), c.Param("automationId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAHDAH001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    "CSAHDAH001",
+			Message: "Automation not found",
+		})
+	}
+
+	err = s.db.AutomationHooks().Delete(auto.ID, c.Param("hookId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAHDAH002"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSAHDAH002",
+			Message: "Hook can't be deleted",
+		})
+	}
+
+	return c.JSON(http.StatusOK, MessageResponse{
+		Message: "Hook deleted",
+	})
+}
+
+// FireAutomationHook route run automation of hook when its conditions match posted body
+func (s *Server) FireAutomationHook(c echo.Context) error {
+	token := c.Param("token")
+	// address is limited first, so a client trying random tokens can't fill limiter with them
+	ip := s.clientIP(c)
+	if !s.hookLimiter.allow(ip) || !s.hookLimiter.allow(token) {
+		logger.WithFields(logger.Fields{"code": "CSAHFAH001", "ip": ip}).Warnf("Hook rate limit exceeded")
+		return c.JSON(http.StatusTooManyRequests, ErrorResponse{
+			Code:    "CSAHFAH001",
+			Message: "Too many requests",
+		})
+	}
+
+	hook, err := s.db.AutomationHooks().ByToken(token)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAHFAH002"}).Warnf("%s", err.Error())
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    "CSAHFAH002",
+			Message: "Hook not found",
+		})
+	}
+
+	vars := map[string]string{}
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request().Body, hookMaxBodySize))
+	if err == nil && len(strings.TrimSpace(string(body))) > 0 {
+		var parsed interface{}
+		err = json.Unmarshal(body, &parsed)
+		if err == nil {
+			flattenVariables("", parsed, vars)
+		}
+	}
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAHFAH003"}).Warnf("%s", err.Error())
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSAHFAH003",
+			Message: "Body must be JSON",
+		})
+	}
+
+	auto, err := s.db.Automations().ByID(hook.AutomationID)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAHFAH004"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    "CSAHFAH004",
+			Message: "Automation not found",
+		})
+	}
+
+	err = s.db.AutomationHooks().Fired(hook.ID)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSAHFAH005"}).Errorf("%s", err.Error())
+	}
+
+	if !s.hookConditions(auto, vars) {
+		return c.JSON(http.StatusOK, MessageResponse{
+			Message: "Conditions not met",
+		})
+	}
+
+	s.runAutomation(auto, vars)
+	return c.JSON(http.StatusAccepted, MessageResponse{
+		Message: "Automation fired",
+	})
+}
+
+// hookConditions evaluate triggers of automation, webhook triggers against vars and device triggers against their latest data
+func (s *Server) hookConditions(auto Automation, vars map[string]string) bool {
+	var conditions []string
+	for i := 0; i < len(auto.Trigger); i++ {
+		match := false
+		if auto.Trigger[i] == webhookTrigger {
+			// a webhook trigger without key match every call
+			match = auto.TriggerKey[i] == "" || matchVariable(vars[auto.TriggerKey[i]], auto.TriggerValue[i])
+		} else {
+			device, err := s.db.Devices().ByID(auto.Trigger[i])
+			if err == nil {
+				field := FindFieldFromName(sdk.FindDevicesFromName(configFromPlugin(s.configs, device.Plugin).Devices, device.PhysicalName).Triggers, auto.TriggerKey[i])
+				data, _ := s.db.Datas().Latest(device.ID, auto.TriggerKey[i])
+				match = matchData(field.Type, data, auto.TriggerValue[i])
+			}
+		}
+
+		if match {
+			conditions = append(conditions, "1")
+		} else {
+			conditions = append(conditions, "0")
+		}
+		if len(auto.TriggerOperator) > i {
+			conditions = append(conditions, auto.TriggerOperator[i])
+		}
+	}
+	return checkConditionOperator(conditions)
+}
+
+// matchVariable compare value of a variable with trigger, numbers can be compared with >, >=, <, <=, = and !=
+func matchVariable(value string, trigger string) bool {
+	for _, operator := range []string{">=", "<=", "!=", ">", "<", "="} {
+		if !strings.HasPrefix(trigger, operator) {
+			continue
+		}
+		expected, err := strconv.ParseFloat(trigger[len(operator):], 64)
+		if err != nil {
+			break
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false
+		}
+		switch operator {
+		case ">=":
+			return number >= expected
+		case "<=":
+			return number <= expected
+		case "!=":
+			return number != expected
+		case ">":
+			return number > expected
+		case "<":
+			return number < expected
+		default:
+			return number == expected
+		}
+	}
+	return value == trigger
+}
+
+// flattenVariables add values of JSON body to vars, nested keys are joined with dots
+func flattenVariables(prefix string, value interface{}, vars map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flattenVariables(key, child, vars)
+		}
+	case []interface{}:
+		for i, child := range v {
+			key := strconv.Itoa(i)
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flattenVariables(key, child, vars)
+		}
+	case nil:
+		vars[prefix] = ""
+	case string:
+		vars[prefix] = v
+	default:
+		vars[prefix] = fmt.Sprint(v)
+	}
+}
+
+// replaceVariables replace {{name}} in params with value of variable name
+func replaceVariables(params string, vars map[string]string) string {
+	if len(vars) == 0 {
+		return params
+	}
+	return hookVariable.ReplaceAllStringFunc(params, func(match string) string {
+		return vars[hookVariable.FindStringSubmatch(match)[1]]
+	})
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxies []string
+		remote  string
+		ip      string
+	}{
+		{"no trusted proxy", nil, "10.0.0.1:1234", "10.0.0.1"},
+		{"untrusted proxy", []string{"10.0.0.2"}, "10.0.0.1:1234", "10.0.0.1"},
+		{"trusted proxy", []string{"10.0.0.1"}, "10.0.0.1:1234", "203.0.113.7"},
+		{"trusted network", []string{"10.0.0.0/8"}, "10.0.0.1:1234", "203.0.113.7"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &Server{trustedProxies: test.proxies}
+			req := httptest.NewRequest("POST", "/v1/hooks/token", nil)
+			req.RemoteAddr = test.remote
+			req.Header.Set(echo.HeaderXForwardedFor, "203.0.113.7")
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+
+			if ip := s.clientIP(c); ip != test.ip {
+				t.Errorf("clientIP = %s, want %s", ip, test.ip)
+			}
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, time.Minute)
+	for i, want := range []bool{true, true, false} {
+		if allowed := l.allow("key"); allowed != want {
+			t.Errorf("call %d allowed = %t, want %t", i, allowed, want)
+		}
+	}
+	l.allow("other")
+
+	l.evict(time.Now())
+	if len(l.windows) != 2 {
+		t.Errorf("%d windows after evicting none, want 2", len(l.windows))
+	}
+	l.evict(time.Now().Add(time.Minute))
+	if len(l.windows) != 0 {
+		t.Errorf("%d windows after evicting ended ones, want 0", len(l.windows))
+	}
+}
@@ -0,0 +1,684 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ItsJimi/casa/logger"
+	"github.com/labstack/echo"
+)
+
+// Intents understood by text commands
+const (
+	commandOn         = "on"
+	commandOff        = "off"
+	commandToggle     = "toggle"
+	commandBrightness = "brightness"
+	commandSetpoint   = "setpoint"
+	// commandSet set brightness or temperature, guessed from targeted devices
+	commandSet = "set"
+	// commandCall call the action named like verb
+	commandCall = "call"
+)
+
+// Units of command values
+const (
+	unitNone = iota
+	unitPercent
+	unitDegrees
+)
+
+// commandPolite define words skipped before verb
+var commandPolite = map[string]bool{
+	"please": true, "can": true, "could": true, "would": true, "will": true, "you": true, "hey": true, "casa": true, "ok": true,
+}
+
+// commandStopwords define words which never name a room or a device
+var commandStopwords = map[string]bool{
+	"the": true, "a": true, "an": true, "in": true, "of": true, "to": true, "at": true, "please": true, "my": true,
+	"all": true, "and": true, "is": true, "it": true, "on": true, "off": true, "for": true, "by": true, "up": true,
+	"down": true, "level": true, "brightness": true, "temperature": true, "this": true, "that": true, "now": true,
+	"with": true, "from": true, "room": true, "percent": true, "degree": true, "degrees": true, "celsius": true,
+}
+
+// commandGroups define words naming a kind of devices
+var commandGroups = map[string]string{
+	"light":      "light",
+	"lamp":       "light",
+	"bulb":       "light",
+	"switch":     "switch",
+	"plug":       "switch",
+	"outlet":     "switch",
+	"socket":     "switch",
+	"thermostat": "thermostat",
+	"heater":     "thermostat",
+	"heating":    "thermostat",
+	"radiator":   "thermostat",
+	"everything": "all",
+	"device":     "all",
+}
+
+// commandOnActions and commandOffActions define actions called by on and off rather than default action of device
+var (
+	commandOnActions  = []string{"on", "turnon", "turn_on", "poweron", "power_on"}
+	commandOffActions = []string{"off", "turnoff", "turn_off", "poweroff", "power_off"}
+)
+
+var (
+	commandCleaner = regexp.MustCompile(`[^a-z0-9.°%\s]+`)
+	commandValue   = regexp.MustCompile(`^(\d+(?:\.\d+)?)(%|°c|°|c|percent|degrees?)?$`)
+)
+
+type commandReq struct {
+	Text string
+}
+
+// commandRes tell what was done, or what is missing when clarification is true
+type commandRes struct {
+	Text          string          `json:"text"`
+	Intent        string          `json:"intent,omitempty"`
+	Message       string          `json:"message"`
+	Clarification bool            `json:"clarification"`
+	Actions       []commandAction `json:"actions"`
+	Candidates    []commandTarget `json:"candidates,omitempty"`
+}
+
+type commandAction struct {
+	DeviceID   string `json:"deviceId"`
+	DeviceName string `json:"deviceName"`
+	RoomName   string `json:"roomName"`
+	Call       string `json:"call,omitempty"`
+	Params     string `json:"params,omitempty"`
+	Status     string `json:"status"` // sent, unchanged, forbidden or failed
+	Error      string `json:"error,omitempty"`
+}
+
+type commandTarget struct {
+	Type     string `json:"type"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	RoomName string `json:"roomName,omitempty"`
+}
+
+// command is text parsed into an intent, its value and words naming targets
+type command struct {
+	intent   string
+	action   string
+	value    float64
+	hasValue bool
+	unit     int
+	words    []string
+}
+
+// commandHome hold rooms, devices and aliases text can name
+type commandHome struct {
+	rooms   []Room
+	devices []assistantDevice
+	aliases []Alias
+}
+
+// Command route parse text like "turn off the kitchen lights" and call actions of devices it names
+func (s *Server) Command(c echo.Context) error {
+	req := new(commandReq)
+	if err := c.Bind(req); err != nil || strings.TrimSpace(req.Text) == "" {
+		logger.WithFields(logger.Fields{"code": "CSCOC001"}).Warnf("Wrong parameters")
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSCOC001",
+			Message: "Wrong parameters",
+		})
+	}
+
+	user := c.Get("user").(User)
+	home, err := s.commandHome(user.ID, c.Param("homeId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSCOC002"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSCOC002",
+			Message: "Devices can't be retrieved",
+		})
+	}
+
+	return c.JSON(http.StatusOK, DataReponse{
+		Data: runCommand(req.Text, home, s.assistantSender("command")),
+	})
+}
+
+// commandHome return rooms and devices of home readable by user, with aliases of home
+func (s *Server) commandHome(userID string, homeID string) (commandHome, error) {
+	home := commandHome{}
+	permissionRooms, err := s.db.Rooms().ListForUser(userID, homeID)
+	if err != nil {
+		return home, err
+	}
+	roomIDs := map[string]bool{}
+	for _, room := range permissionRooms {
+		roomIDs[room.RoomID] = true
+		home.rooms = append(home.rooms, Room{ID: room.RoomID, Name: room.RoomName, HomeID: room.RoomHomeID})
+	}
+
+	devices, err := s.assistantDevices(userID)
+	if err != nil {
+		return home, err
+	}
+	for _, device := range devices {
+		if roomIDs[device.RoomID] {
+			home.devices = append(home.devices, device)
+		}
+	}
+
+	home.aliases, err = s.db.Aliases().ListForHome(homeID)
+	return home, err
+}
+
+// runCommand parse text, resolve devices it names and send their actions with send
+func runCommand(text string, home commandHome, send actionSender) commandRes {
+	res := commandRes{
+		Text:    text,
+		Actions: []commandAction{},
+	}
+	cmd, ok := parseCommand(text)
+	if ok && cmd.intent == commandCall {
+		ok = len(cmd.capable(home.devices)) > 0
+	}
+	if !ok {
+		res.Clarification = true
+		res.Message = `What should I do? Try "turn on the kitchen light" or "set bedroom to 19 degrees"`
+		return res
+	}
+	res.Intent = cmd.intent
+
+	devices, candidates, ok := home.resolve(cmd)
+	if !ok {
+		res.Clarification = true
+		res.Candidates = candidates
+		if len(candidates) == 0 {
+			res.Message = "Which room or device?"
+			for _, room := range home.rooms {
+				res.Candidates = append(res.Candidates, commandTarget{Type: "room", ID: room.ID, Name: room.Name})
+			}
+		} else {
+			res.Message = "Which one: " + joinTargetNames(candidates) + "?"
+		}
+		return res
+	}
+
+	devices = cmd.capable(devices)
+	if cmd.intent == commandSet {
+		if !cmd.hasValue {
+			res.Clarification = true
+			res.Message = "To which value?"
+			return res
+		}
+		cmd.intent = cmd.guessSetIntent(devices)
+		if cmd.intent == commandSet {
+			res.Clarification = true
+			res.Message = "Brightness or temperature? Say percent or degrees"
+			return res
+		}
+		res.Intent = cmd.intent
+		devices = cmd.capable(devices)
+	}
+	if len(devices) == 0 {
+		res.Message = "No device can do that"
+		return res
+	}
+	if (cmd.intent == commandBrightness || cmd.intent == commandSetpoint) && !cmd.hasValue {
+		res.Clarification = true
+		res.Message = "To which value?"
+		return res
+	}
+
+	done := []commandTarget{}
+	for _, device := range devices {
+		action := cmd.run(device, send)
+		res.Actions = append(res.Actions, action)
+		if action.Status == "sent" || action.Status == "unchanged" {
+			done = append(done, commandTarget{Name: device.Name})
+		}
+	}
+	res.Message = cmd.describe(done)
+	return res
+}
+
+// parseCommand turn text into a command, false when no verb is understood
+func parseCommand(text string) (command, bool) {
+	cmd := command{}
+	words := []string{}
+	for _, word := range strings.Fields(commandCleaner.ReplaceAllString(strings.ToLower(text), " ")) {
+		word = strings.Trim(word, ".")
+		if word == "" {
+			continue
+		}
+		if match := commandValue.FindStringSubmatch(word); match != nil {
+			if !cmd.hasValue {
+				cmd.value, _ = strconv.ParseFloat(match[1], 64)
+				cmd.hasValue = true
+				cmd.unit = commandUnit(match[2])
+			}
+			continue
+		}
+		if unit := commandUnit(word); unit != unitNone && cmd.hasValue && cmd.unit == unitNone {
+			cmd.unit = unit
+			continue
+		}
+		words = append(words, word)
+	}
+	for len(words) > 0 && commandPolite[words[0]] {
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		return cmd, false
+	}
+
+	verb, rest := words[0], words[1:]
+	switch verb {
+	case "turn", "switch", "power", "shut", "put":
+		switch {
+		case containsWord(rest, "on"):
+			cmd.intent = commandOn
+		case containsWord(rest, "off"):
+			cmd.intent = commandOff
+		case verb == "put" || verb == "turn":
+			cmd.intent = commandSet
+		default:
+			return cmd, false
+		}
+	case "enable", "activate":
+		cmd.intent = commandOn
+	case "disable", "deactivate":
+		cmd.intent = commandOff
+	case "toggle":
+		cmd.intent = commandToggle
+	case "dim", "brighten":
+		cmd.intent = commandBrightness
+	case "heat", "warm", "cool":
+		cmd.intent = commandSetpoint
+	case "set", "make", "change", "adjust":
+		switch {
+		case cmd.unit == unitDegrees || containsWord(rest, "temperature"):
+			cmd.intent = commandSetpoint
+		case cmd.unit == unitPercent || containsWord(rest, "brightness"):
+			cmd.intent = commandBrightness
+		case !cmd.hasValue && containsWord(rest, "on"):
+			cmd.intent = commandOn
+		case !cmd.hasValue && containsWord(rest, "off"):
+			cmd.intent = commandOff
+		default:
+			cmd.intent = commandSet
+		}
+	default:
+		// "kitchen light off" has no verb
+		switch words[len(words)-1] {
+		case "on":
+			cmd.intent, rest = commandOn, words
+		case "off":
+			cmd.intent, rest = commandOff, words
+		default:
+			cmd.intent, cmd.action = commandCall, verb
+		}
+	}
+	if cmd.intent == commandSet && cmd.unit == unitDegrees {
+		cmd.intent = commandSetpoint
+	}
+	if cmd.intent == commandSet && cmd.unit == unitPercent {
+		cmd.intent = commandBrightness
+	}
+
+	for _, word := range rest {
+		if !commandStopwords[word] {
+			cmd.words = append(cmd.words, word)
+		}
+	}
+	return cmd, true
+}
+
+func commandUnit(word string) int {
+	switch word {
+	case "%", "percent":
+		return unitPercent
+	case "°", "°c", "c", "degree", "degrees", "celsius":
+		return unitDegrees
+	}
+	return unitNone
+}
+
+// resolve return devices named by words of command, or candidates to choose from when they are ambiguous
+func (h commandHome) resolve(cmd command) ([]assistantDevice, []commandTarget, bool) {
+	group, plural := "", false
+	for _, word := range cmd.words {
+		if g, ok := commandGroups[singular(word)]; ok {
+			group, plural = g, plural || word != singular(word)
+		}
+	}
+
+	// rooms and devices score the number of words of their longest name fully found in text
+	rooms := map[string]bool{}
+	best := 0
+	for _, room := range h.rooms {
+		score := namesScore(cmd.words, h.names("room", room.ID, room.Name))
+		if score > best {
+			rooms, best = map[string]bool{}, score
+		}
+		if score > 0 && score == best {
+			rooms[room.ID] = true
+		}
+	}
+	named := []assistantDevice{}
+	best = 0
+	for _, device := range h.devices {
+		score := namesScore(cmd.words, h.names("device", device.ID, device.Name))
+		if score > best {
+			named, best = []assistantDevice{}, score
+		}
+		if score > 0 && score == best {
+			named = append(named, device)
+		}
+	}
+
+	// "lights" of a room, or a device named in another room than the one in text, are resolved by room
+	if len(rooms) > 0 && (plural && group != "" || len(devicesInRooms(named, rooms)) == 0) {
+		named = nil
+	}
+	switch {
+	case len(named) > 0:
+		named = devicesInRooms(named, rooms)
+		if capable := cmd.capable(named); len(capable) > 1 && !plural {
+			candidates := []commandTarget{}
+			for _, device := range capable {
+				candidates = append(candidates, commandTarget{Type: "device", ID: device.ID, Name: device.Name, RoomName: device.RoomName})
+			}
+			return nil, candidates, false
+		} else if len(capable) == 1 {
+			return capable, nil, true
+		}
+		return named, nil, true
+	case len(rooms) > 0 || group != "":
+		selected := []assistantDevice{}
+		for _, device := range devicesInRooms(h.devices, rooms) {
+			if group == "" || deviceInGroup(device, group) {
+				selected = append(selected, device)
+			}
+		}
+		return selected, nil, true
+	}
+	return nil, nil, false
+}
+
+// names return name and aliases of room or device
+func (h commandHome) names(aliasType string, ID string, name string) []string {
+	names := []string{name}
+	for _, alias := range h.aliases {
+		if alias.Type == aliasType && alias.TypeID == ID {
+			names = append(names, alias.Name)
+		}
+	}
+	return names
+}
+
+// devicesInRooms return devices in rooms, all devices when rooms is empty
+func devicesInRooms(devices []assistantDevice, rooms map[string]bool) []assistantDevice {
+	selected := []assistantDevice{}
+	for _, device := range devices {
+		if len(rooms) == 0 || rooms[device.RoomID] {
+			selected = append(selected, device)
+		}
+	}
+	return selected
+}
+
+// deviceInGroup tell if device is of kind of devices named by group
+func deviceInGroup(device assistantDevice, group string) bool {
+	switch group {
+	case "light":
+		if _, ok := device.brightness(); ok {
+			return true
+		}
+		for _, word := range strings.Fields(strings.ToLower(device.Name + " " + device.PhysicalName)) {
+			if commandGroups[singular(word)] == "light" {
+				return true
+			}
+		}
+		return false
+	case "switch":
+		return device.onOff()
+	case "thermostat":
+		return device.thermostat()
+	}
+	return true
+}
+
+// capable return devices able to run command
+func (cmd command) capable(devices []assistantDevice) []assistantDevice {
+	selected := []assistantDevice{}
+	for _, device := range devices {
+		ok := false
+		switch cmd.intent {
+		case commandOn:
+			ok = device.onOff() || findCommandAction(device, commandOnActions) != ""
+		case commandOff:
+			ok = device.onOff() || findCommandAction(device, commandOffActions) != ""
+		case commandToggle:
+			ok = device.onOff()
+		case commandBrightness:
+			_, ok = device.brightness()
+		case commandSetpoint:
+			ok = device.thermostat()
+		case commandSet:
+			_, ok = device.brightness()
+			ok = ok || device.thermostat()
+		case commandCall:
+			ok = findCommandAction(device, []string{cmd.action}) != ""
+		}
+		if ok {
+			selected = append(selected, device)
+		}
+	}
+	return selected
+}
+
+// guessSetIntent choose between brightness and setpoint from what devices support
+func (cmd command) guessSetIntent(devices []assistantDevice) string {
+	dimmable, thermostat := false, false
+	for _, device := range devices {
+		_, ok := device.brightness()
+		dimmable = dimmable || ok
+		thermostat = thermostat || device.thermostat()
+	}
+	switch {
+	case dimmable && !thermostat:
+		return commandBrightness
+	case thermostat && !dimmable:
+		return commandSetpoint
+	}
+	return commandSet
+}
+
+// run send actions of command to device and return what was done
+func (cmd command) run(device assistantDevice, send actionSender) commandAction {
+	action := commandAction{
+		DeviceID:   device.ID,
+		DeviceName: device.Name,
+		RoomName:   device.RoomName,
+		Status:     "unchanged",
+	}
+	record := func(d Device, call string, params string) error {
+		action.Call, action.Params, action.Status = call, params, "sent"
+		return send(d, call, params)
+	}
+
+	var err error
+	switch cmd.intent {
+	case commandOn, commandOff:
+		err = device.setOn(cmd.intent == commandOn, record)
+	case commandToggle:
+		err = device.toggle(record)
+	case commandBrightness:
+		err = device.setBrightness(int(cmd.value), record)
+	case commandSetpoint:
+		err = device.setTargetTemperature(cmd.value, record)
+	case commandCall:
+		if !device.Write {
+			err = errAssistantForbidden
+			break
+		}
+		params := ""
+		if cmd.hasValue {
+			params = strconv.FormatFloat(cmd.value, 'f', -1, 64)
+		}
+		err = record(device.Device, findCommandAction(device, []string{cmd.action}), params)
+	}
+
+	switch {
+	case err == errAssistantForbidden:
+		action.Status = "forbidden"
+	case err != nil:
+		action.Status, action.Error = "failed", err.Error()
+	}
+	return action
+}
+
+// describe tell what command did on devices
+func (cmd command) describe(devices []commandTarget) string {
+	if len(devices) == 0 {
+		return "Nothing was done"
+	}
+	names := joinTargetNames(devices)
+	switch cmd.intent {
+	case commandOn:
+		return "Turned on " + names
+	case commandOff:
+		return "Turned off " + names
+	case commandToggle:
+		return "Toggled " + names
+	case commandBrightness:
+		return fmt.Sprintf("Set brightness of %s to %g%%", names, cmd.value)
+	case commandSetpoint:
+		return fmt.Sprintf("Set %s to %g°C", names, cmd.value)
+	}
+	return fmt.Sprintf("Called %s on %s", cmd.action, names)
+}
+
+// findCommandAction return first action of device fuzzy matching one of calls, empty when there is none
+func findCommandAction(device assistantDevice, calls []string) string {
+	for _, call := range calls {
+		for _, name := range device.Plugin.Actions {
+			if fuzzyEqual(call, strings.ToLower(name)) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// namesScore return number of words of longest name whose words are all found in words, 0 when none is
+func namesScore(words []string, names []string) int {
+	best := 0
+	for _, name := range names {
+		nameWords := strings.Fields(commandCleaner.ReplaceAllString(strings.ToLower(name), " "))
+		if len(nameWords) == 0 || len(nameWords) <= best {
+			continue
+		}
+		used := make([]bool, len(words))
+		found := true
+		for _, nameWord := range nameWords {
+			matched := false
+			for i, word := range words {
+				if !used[i] && fuzzyEqual(nameWord, word) {
+					used[i], matched = true, true
+					break
+				}
+			}
+			if !matched {
+				found = false
+				break
+			}
+		}
+		if found {
+			best = len(nameWords)
+		}
+	}
+	return best
+}
+
+// fuzzyEqual compare words ignoring plurals and small typos of speech to text
+func fuzzyEqual(a string, b string) bool {
+	a, b = singular(a), singular(b)
+	if a == b {
+		return true
+	}
+	max := 0
+	switch {
+	case len(a) >= 8:
+		max = 2
+	case len(a) >= 4:
+		max = 1
+	}
+	return max > 0 && levenshtein(a, b) <= max
+}
+
+// singular remove plural mark of english words
+func singular(word string) string {
+	switch {
+	case strings.HasSuffix(word, "ches") || strings.HasSuffix(word, "shes") || strings.HasSuffix(word, "xes"):
+		return strings.TrimSuffix(word, "es")
+	case strings.HasSuffix(word, "ss") || len(word) <= 3:
+		return word
+	}
+	return strings.TrimSuffix(word, "s")
+}
+
+func levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current := make([]int, len(rb)+1)
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+		}
+		previous = current
+	}
+	return previous[len(rb)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func containsWord(words []string, word string) bool {
+	for _, w := range words {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
+
+// joinTargetNames return "a, b and c"
+func joinTargetNames(targets []commandTarget) string {
+	names := []string{}
+	for _, target := range targets {
+		name := target.Name
+		if target.RoomName != "" {
+			name += " (" + target.RoomName + ")"
+		}
+		names = append(names, name)
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
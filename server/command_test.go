@@ -0,0 +1,142 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/getcasa/sdk"
+)
+
+// newCommandHome return a home of a kitchen, a bedroom and a living room with lamps of the same name, a thermostat
+// and devices which only toggle, user can control all of them
+func newCommandHome(t *testing.T) commandHome {
+	t.Helper()
+	devices := []Device{
+		{ID: "ceiling", Name: "Ceiling light", RoomID: "kitchen", Plugin: "hue", PhysicalName: "bulb"},
+		{ID: "kettle", Name: "Kettle", RoomID: "kitchen", Plugin: "sonoff", PhysicalName: "relay"},
+		{ID: "bedroom-lamp", Name: "Lamp", RoomID: "bedroom", Plugin: "hue", PhysicalName: "bulb"},
+		{ID: "heater", Name: "Heater", RoomID: "bedroom", Plugin: "netatmo", PhysicalName: "thermostat"},
+		{ID: "fan", Name: "Fan", RoomID: "bedroom", Plugin: "sonoff", PhysicalName: "fan"},
+		{ID: "living-lamp", Name: "Lamp", RoomID: "living", Plugin: "hue", PhysicalName: "bulb"},
+		{ID: "radio", Name: "Radio", RoomID: "living", Plugin: "sonoff", PhysicalName: "relay"},
+	}
+	permissions := []Permission{}
+	for _, device := range devices {
+		permissions = append(permissions, Permission{UserID: "user", Type: "device", TypeID: device.ID, Read: true, Write: true})
+	}
+	rooms := []Room{
+		{ID: "kitchen", Name: "Kitchen", HomeID: "home"},
+		{ID: "bedroom", Name: "Bedroom", HomeID: "home"},
+		{ID: "living", Name: "Living room", HomeID: "home"},
+	}
+
+	s := NewServer(&fakeStore{
+		users:       []User{{ID: "user"}},
+		rooms:       rooms,
+		devices:     devices,
+		permissions: permissions,
+		datas: []Datas{
+			{DeviceID: "ceiling", Field: "on", ValueBool: false},
+			{DeviceID: "kettle", Field: "on", ValueBool: true},
+			{DeviceID: "bedroom-lamp", Field: "on", ValueBool: true},
+			{DeviceID: "heater", Field: "setpoint", ValueNbr: 17},
+			{DeviceID: "living-lamp", Field: "on", ValueBool: true},
+		},
+	}, nil)
+	s.configs = append(newAssistantFixture().configs, sdk.Configuration{
+		Name: "sonoff",
+		Devices: []sdk.Device{
+			{
+				Name:           "relay",
+				DefaultTrigger: "on",
+				DefaultAction:  "toggle",
+				Triggers:       []sdk.Trigger{{Name: "on", Type: "bool"}},
+				Actions:        []string{"toggle"},
+			},
+			{
+				Name:           "fan",
+				DefaultTrigger: "on",
+				DefaultAction:  "toggle",
+				Triggers:       []sdk.Trigger{{Name: "on", Type: "bool"}},
+				Actions:        []string{"toggle", "on", "off"},
+			},
+		},
+		Actions: []sdk.Action{{Name: "toggle"}, {Name: "on"}, {Name: "off"}},
+	})
+
+	assistantDevices, err := s.assistantDevices("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return commandHome{
+		rooms:   rooms,
+		devices: assistantDevices,
+		aliases: []Alias{
+			{HomeID: "home", Name: "Lounge", Type: "room", TypeID: "living"},
+			{HomeID: "home", Name: "Big light", Type: "device", TypeID: "ceiling"},
+		},
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	// action is what a command did on a device: room, device, call, params and status
+	type action [5]string
+
+	tests := []struct {
+		text          string
+		clarification bool
+		message       string
+		actions       []action
+	}{
+		{"turn on the kitchen light", false, "", []action{{"Kitchen", "Ceiling light", "switch", "true", "sent"}}},
+		{"turn off the bedroom lamp", false, "", []action{{"Bedroom", "Lamp", "switch", "false", "sent"}}},
+		{"switch on the big light", false, "", []action{{"Kitchen", "Ceiling light", "switch", "true", "sent"}}},
+		{"turn off the lounge lights", false, "", []action{{"Living room", "Lamp", "switch", "false", "sent"}}},
+		{"turn off the lamp", true, "Which one: Lamp (Bedroom) and Lamp (Living room)?", []action{}},
+		{"turn off the lamps", false, "", []action{
+			{"Bedroom", "Lamp", "switch", "false", "sent"},
+			{"Living room", "Lamp", "switch", "false", "sent"},
+		}},
+		{"set bedroom to 19 degrees", false, "", []action{{"Bedroom", "Heater", "setpoint", "19", "sent"}}},
+		{"set the kitchen to 19 degrees", false, "No device can do that", []action{}},
+		{"set the big light to 40%", false, "", []action{{"Kitchen", "Ceiling light", "brightness", "102", "sent"}}},
+		// kettle only toggles: it's toggled when it's known to be on, and left alone when it's already on
+		{"turn off the kettle", false, "", []action{{"Kitchen", "Kettle", "toggle", "", "sent"}}},
+		{"turn on the kettle", false, "", []action{{"Kitchen", "Kettle", "", "", "unchanged"}}},
+		// radio only toggles and its state is unknown, so it can't be turned off, only toggled
+		{"turn off the radio", false, "", []action{{"Living room", "Radio", "", "", "failed"}}},
+		{"toggle the radio", false, "", []action{{"Living room", "Radio", "toggle", "", "sent"}}},
+		// fan has actions of its own to turn it on and off
+		{"turn off the fan", false, "", []action{{"Bedroom", "Fan", "off", "", "sent"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			sent := []sentAction{}
+			res := runCommand(test.text, newCommandHome(t), recordSender(&sent, false))
+
+			if res.Clarification != test.clarification {
+				t.Errorf("clarification = %t, want %t (%s)", res.Clarification, test.clarification, res.Message)
+			}
+			if test.message != "" && res.Message != test.message {
+				t.Errorf("message = %q, want %q", res.Message, test.message)
+			}
+			actions := []action{}
+			for _, a := range res.Actions {
+				call, params := a.Call, a.Params
+				if a.Status == "failed" {
+					call, params = "", ""
+				}
+				actions = append(actions, action{a.RoomName, a.DeviceName, call, params, a.Status})
+			}
+			if !reflect.DeepEqual(actions, test.actions) {
+				t.Errorf("actions %v, want %v", actions, test.actions)
+			}
+			for _, a := range res.Actions {
+				if a.Status == "failed" && a.Error != errAssistantCantTurnOff.Error() {
+					t.Errorf("%s failed with %q, want %q", a.DeviceName, a.Error, errAssistantCantTurnOff)
+				}
+			}
+		})
+	}
+}
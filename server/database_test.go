@@ -0,0 +1,62 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ItsJimi/casa/config"
+	"github.com/ItsJimi/casa/migrations"
+)
+
+func TestInitDBSQLite(t *testing.T) {
+	conf := config.Database{
+		Driver: "sqlite",
+		Path:   filepath.Join(t.TempDir(), "casa.db"),
+	}
+
+	// init must not need network and must be idempotent
+	InitDB(conf)
+	InitDB(conf)
+
+	store, err := OpenStore(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	version, err := store.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != migrations.Latest() {
+		t.Errorf("schema version is %d, want %d", version, migrations.Latest())
+	}
+
+	states, err := store.SchemaStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range states {
+		if !state.Applied {
+			t.Errorf("migration %d %s isn't applied", state.Version, state.Name)
+		}
+	}
+}
+
+func TestRollbackSQLite(t *testing.T) {
+	store := newSQLiteStore(t)
+
+	// datas_index, version 11, only changes postgres, it's recorded and reverted without a query on sqlite
+	if err := store.Rollback(migrations.Latest() - 10); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := store.SchemaVersion(); err != nil || version != 10 {
+		t.Errorf("schema version is %d (%v), want 10", version, err)
+	}
+	if err := store.Migrate(0); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := store.SchemaVersion(); err != nil || version != migrations.Latest() {
+		t.Errorf("schema version is %d (%v), want %d", version, err, migrations.Latest())
+	}
+}
@@ -27,7 +27,7 @@ type getDatasDeviceReq struct {
 }
 
 // AddDevice route create a device
-func AddDevice(c echo.Context) error {
+func (s *Server) AddDevice(c echo.Context) error {
 	req := new(addDeviceReq)
 	if err := c.Bind(req); err != nil {
 		logger.WithFields(logger.Fields{"code": "CSDAD001"}).Errorf("%s", err.Error())
@@ -47,8 +47,7 @@ func AddDevice(c echo.Context) error {
 
 	user := c.Get("user").(User)
 
-	var device Device
-	err := DB.Get(&device, "SELECT * FROM devices WHERE physical_id=$1 AND gateway_id=$2", req.PhysicalID, req.GatewayID)
+	_, err := s.db.Devices().ByPhysicalID(req.GatewayID, req.PhysicalID)
 	if err == nil {
 		logger.WithFields(logger.Fields{"code": "CSDAD003"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -57,8 +56,19 @@ func AddDevice(c echo.Context) error {
 		})
 	}
 
-	row, err := DB.Query("INSERT INTO devices (id, name, icon, room_id, gateway_id, physical_id, physical_name, config, plugin, creator_id) VALUES (generate_ulid(), $1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id;",
-		req.Name, req.Icon, c.Param("roomId"), req.GatewayID, req.PhysicalID, req.PhysicalName, req.Config, req.Plugin, user.ID)
+	newDevice := Device{
+		ID:           utils.NewULID(),
+		Name:         req.Name,
+		Icon:         req.Icon,
+		RoomID:       c.Param("roomId"),
+		GatewayID:    req.GatewayID,
+		PhysicalID:   req.PhysicalID,
+		PhysicalName: req.PhysicalName,
+		Config:       req.Config,
+		Plugin:       req.Plugin,
+		CreatorID:    user.ID,
+	}
+	err = s.db.Devices().Create(newDevice)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSDAD004"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusBadRequest, ErrorResponse{
@@ -66,27 +76,18 @@ func AddDevice(c echo.Context) error {
 			Message: "Device can't be created",
 		})
 	}
-	var deviceID string
-	row.Next()
-	err = row.Scan(&deviceID)
-	if err != nil {
-		logger.WithFields(logger.Fields{"code": "CSDAD005"}).Errorf("%s", err.Error())
-		return c.JSON(http.StatusBadRequest, ErrorResponse{
-			Code:    "CSDAD005",
-			Message: "Device can't be created",
-		})
-	}
 
 	newPermission := Permission{
+		ID:     utils.NewULID(),
 		UserID: user.ID,
 		Type:   "device",
-		TypeID: deviceID,
+		TypeID: newDevice.ID,
 		Read:   true,
 		Write:  true,
 		Manage: true,
 		Admin:  true,
 	}
-	_, err = DB.NamedExec("INSERT INTO permissions (id, user_id, type, type_id, read, write, manage, admin) VALUES (generate_ulid(), :user_id, :type, :type_id, :read, :write, :manage, :admin)", newPermission)
+	err = s.db.Permissions().Create(newPermission)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSDAD006"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -95,13 +96,16 @@ func AddDevice(c echo.Context) error {
 		})
 	}
 
+	s.publishDeviceDiscovery(newDevice, c.Param("homeId"))
+	go s.reloadHomeKit(c.Param("homeId"))
+
 	return c.JSON(http.StatusCreated, MessageResponse{
-		Message: deviceID,
+		Message: newDevice.ID,
 	})
 }
 
 // UpdateDevice route update device
-func UpdateDevice(c echo.Context) error {
+func (s *Server) UpdateDevice(c echo.Context) error {
 	req := new(addDeviceReq)
 	if err := c.Bind(req); err != nil {
 		logger.WithFields(logger.Fields{"code": "CSDUD001"}).Errorf("%s", err.Error())
@@ -111,12 +115,12 @@ func UpdateDevice(c echo.Context) error {
 		})
 	}
 
-	request := `UPDATE devices SET
-	name = COALESCE($1, name),
-	room_id = COALESCE($2, room_id),
-	icon = COALESCE($3, icon)
-	WHERE id = $4 RETURNING *`
-	rows, err := DB.Queryx(request, utils.NewNullString(req.Name), utils.NewNullString(req.RoomID), utils.NewNullString(req.Icon), c.Param("deviceId"))
+	device, err := s.db.Devices().Update(Device{
+		ID:     c.Param("deviceId"),
+		Name:   req.Name,
+		RoomID: req.RoomID,
+		Icon:   req.Icon,
+	})
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSDUD005"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -125,28 +129,17 @@ func UpdateDevice(c echo.Context) error {
 		})
 	}
 
-	defer rows.Close()
-
-	rows.Next()
-	var device Device
-	err = rows.StructScan(&device)
-	if err != nil {
-		logger.WithFields(logger.Fields{"code": "CSDUD006"}).Errorf("%s", err.Error())
-		return c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Code:    "CSDUD006",
-			Message: "Device can't be updated",
-		})
-	}
+	s.publishDeviceDiscovery(device, c.Param("homeId"))
+	go s.reloadHomeKit(c.Param("homeId"))
 
 	return c.JSON(http.StatusOK, device)
 }
 
 // DeleteDevice route delete device
-func DeleteDevice(c echo.Context) error {
+func (s *Server) DeleteDevice(c echo.Context) error {
 	user := c.Get("user").(User)
 
-	var permission Permission
-	err := DB.Get(&permission, "SELECT * FROM permissions WHERE user_id=$1 AND type=$2 AND type_id=$3", user.ID, "device", c.Param("deviceId"))
+	permission, err := s.db.Permissions().Get(user.ID, "device", c.Param("deviceId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSDDD001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusNotFound, ErrorResponse{
@@ -163,7 +156,16 @@ func DeleteDevice(c echo.Context) error {
 		})
 	}
 
-	_, err = DB.Exec("DELETE FROM devices WHERE id=$1", c.Param("deviceId"))
+	device, err := s.db.Devices().ByID(c.Param("deviceId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSDDD005"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    "CSDDD005",
+			Message: "Device can't be found",
+		})
+	}
+
+	err = s.db.Devices().Delete(c.Param("deviceId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSDDD003"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -171,7 +173,7 @@ func DeleteDevice(c echo.Context) error {
 			Message: "Device can't be deleted",
 		})
 	}
-	_, err = DB.Exec("DELETE FROM permissions WHERE type=$1 AND type_id=$2", "device", c.Param("deviceId"))
+	err = s.db.Permissions().DeleteAll("device", c.Param("deviceId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSDDD004"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -180,27 +182,14 @@ func DeleteDevice(c echo.Context) error {
 		})
 	}
 
+	s.removeDeviceDiscovery(device)
+	go s.reloadHomeKit(c.Param("homeId"))
+
 	return c.JSON(http.StatusOK, MessageResponse{
 		Message: "Device deleted",
 	})
 }
 
-type permissionDevice struct {
-	Permission
-	User
-	DeviceID           string `db:"d_id"`
-	DeviceName         string `db:"d_name"`
-	DeviceIcon         string `db:"d_icon"`
-	DeviceRoomID       string `db:"d_roomid"`
-	DevicePlugin       string `db:"d_plugin"`
-	DeviceGatewayID    string `db:"d_gatewayid"`
-	DevicePhysicalID   string `db:"d_physicalid"`
-	DevicePhysicalName string `db:"d_physicalname"`
-	DeviceConfig       string `db:"d_config"`
-	DeviceCreatedAt    string `db:"d_createdat"`
-	DeviceUpdatedAt    string `db:"d_updatedat"`
-}
-
 type deviceRes struct {
 	ID            string       `json:"id"`
 	Name          string       `json:"name"`
@@ -223,16 +212,10 @@ type deviceRes struct {
 }
 
 // GetDevices route get list of user devices
-func GetDevices(c echo.Context) error {
+func (s *Server) GetDevices(c echo.Context) error {
 	user := c.Get("user").(User)
 
-	rows, err := DB.Queryx(`
-		SELECT permissions.*, users.*,
-		devices.id as d_id,	devices.name AS d_name, devices.icon AS d_icon, devices.room_id AS d_roomid, devices.gateway_id AS d_gatewayid, devices.physical_id AS d_physicalid, devices.physical_name AS d_physicalname, devices.config AS d_config, devices.plugin AS d_plugin, devices.plugin AS d_plugin, devices.created_at AS d_createdat FROM permissions
-		JOIN devices ON permissions.type_id = devices.id
-		JOIN users ON devices.creator_id = users.id
-		WHERE permissions.type=$1 AND permissions.user_id=$2 AND devices.room_id=$3 AND (permissions.read=true OR permissions.admin=true)
-	`, "device", user.ID, c.Param("roomId"))
+	permissions, err := s.db.Devices().ListForUser(user.ID, c.Param("roomId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSDGDS001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -240,23 +223,12 @@ func GetDevices(c echo.Context) error {
 			Message: "Devices can't be found",
 		})
 	}
-	defer rows.Close()
 
 	devices := []deviceRes{}
-	for rows.Next() {
-		var permission permissionDevice
-		err := rows.StructScan(&permission)
-		if err != nil {
-			logger.WithFields(logger.Fields{"code": "CSDGDS002"}).Errorf("%s", err.Error())
-			return c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Code:    "CSDGDS002",
-				Message: "Devices can't be found",
-			})
-		}
-
+	for _, permission := range permissions {
 		var pluginDevice sdk.Device
 		pluginActions := []sdk.Action{}
-		for _, config := range Configs {
+		for _, config := range s.configs {
 			if config.Name != permission.DevicePlugin {
 				continue
 			}
@@ -302,38 +274,21 @@ func GetDevices(c echo.Context) error {
 }
 
 // GetDevice route get specific device with id
-func GetDevice(c echo.Context) error {
+func (s *Server) GetDevice(c echo.Context) error {
 	user := c.Get("user").(User)
 
-	row := DB.QueryRowx(`
-		SELECT permissions.*, users.*,
-		devices.id as d_id,	devices.name AS d_name, devices.room_id AS d_roomid, devices.gateway_id AS d_gatewayid, devices.physical_id AS d_physicalid, devices.physical_name AS d_physicalname, devices.config AS d_config, devices.plugin AS d_plugin, devices.created_at AS d_createdat FROM permissions
-		JOIN devices ON permissions.type_id = devices.id
-		JOIN users ON devices.creator_id = users.id
-		WHERE permissions.type=$1 AND permissions.type_id=$2 AND permissions.user_id=$3 AND devices.room_id=$4
-	`, "device", c.Param("deviceId"), user.ID, c.Param("roomId"))
-
-	if row == nil {
-		logger.WithFields(logger.Fields{"code": "CSDGD001"}).Errorf("QueryRowx: Select permissions")
+	permission, err := s.db.Devices().GetForUser(user.ID, c.Param("roomId"), c.Param("deviceId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSDGD001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusNotFound, ErrorResponse{
 			Code:    "CSDGD001",
 			Message: "Device not found",
 		})
 	}
 
-	var permission permissionDevice
-	err := row.StructScan(&permission)
-	if err != nil {
-		logger.WithFields(logger.Fields{"code": "CSDGD002"}).Errorf("%s", err.Error())
-		return c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Code:    "CSDGD002",
-			Message: "Error 4: Can't retrieve devices",
-		})
-	}
-
 	var pluginDevice sdk.Device
 	pluginActions := []sdk.Action{}
-	for _, config := range Configs {
+	for _, config := range s.configs {
 		if config.Name != permission.DevicePlugin {
 			continue
 		}
@@ -374,14 +329,8 @@ func GetDevice(c echo.Context) error {
 }
 
 // GetLogsDevice return list of log for a device
-func GetLogsDevice(c echo.Context) error {
-	rows, err := DB.Queryx(`
-	SELECT logs.* FROM logs
-	JOIN devices ON logs.type_id = devices.id
-	JOIN rooms ON devices.room_id = rooms.id
-	WHERE rooms.home_id = $1 AND devices.room_id=$2 AND type_id=$3 AND type = 'device'
-	ORDER BY created_at DESC
-	`, c.Param("homeId"), c.Param("roomId"), c.Param("deviceId"))
+func (s *Server) GetLogsDevice(c echo.Context) error {
+	logs, err := s.db.Logs().ListForDevice(c.Param("homeId"), c.Param("roomId"), c.Param("deviceId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSDGLD002"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -390,28 +339,11 @@ func GetLogsDevice(c echo.Context) error {
 		})
 	}
 
-	defer rows.Close()
-
-	logs := []Logs{}
-	for rows.Next() {
-		var log Logs
-		err := rows.StructScan(&log)
-		if err != nil {
-			logger.WithFields(logger.Fields{"code": "CSDGLD003"}).Errorf("%s", err.Error())
-			return c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Code:    "CSDGLD003",
-				Message: "Logs can't be found",
-			})
-		}
-
-		logs = append(logs, log)
-	}
-
 	return c.JSON(http.StatusOK, logs)
 }
 
 // GetDatasDevice return list of datas for a device
-func GetDatasDevice(c echo.Context) error {
+func (s *Server) GetDatasDevice(c echo.Context) error {
 	req := new(getDatasDeviceReq)
 	if err := c.Bind(req); err != nil {
 		logger.WithFields(logger.Fields{"code": "CSAGLA001"}).Errorf("%s", err.Error())
@@ -429,13 +361,7 @@ func GetDatasDevice(c echo.Context) error {
 		})
 	}
 
-	rows, err := DB.Queryx(`
-	SELECT datas.* FROM datas
-	JOIN devices ON datas.device_id = devices.id
-	JOIN rooms ON devices.room_id = rooms.id
-	WHERE rooms.home_id = $1 AND devices.room_id=$2 AND device_id=$3 AND field = $4
-	ORDER BY created_at DESC
-	`, c.Param("homeId"), c.Param("roomId"), c.Param("deviceId"), req.Field)
+	datas, err := s.db.Datas().ListForDevice(c.Param("homeId"), c.Param("roomId"), c.Param("deviceId"), req.Field)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSDGLD003"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -444,33 +370,12 @@ func GetDatasDevice(c echo.Context) error {
 		})
 	}
 
-	defer rows.Close()
-
-	datas := []Datas{}
-	for rows.Next() {
-		var data Datas
-		err := rows.StructScan(&data)
-		if err != nil {
-			logger.WithFields(logger.Fields{"code": "CSDGLD004"}).Errorf("%s", err.Error())
-			return c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Code:    "CSDGLD004",
-				Message: "Datas can't be found",
-			})
-		}
-
-		datas = append(datas, data)
-	}
-
 	return c.JSON(http.StatusOK, datas)
 }
 
 // GetDeviceMembers route get list of device users
-func GetDeviceMembers(c echo.Context) error {
-	rows, err := DB.Queryx(`
-		SELECT * FROM permissions
-		JOIN users ON permissions.user_id = users.id
-		WHERE (permissions.type=$1 AND permissions.type_id=$2) OR (permissions.type=$3 AND permissions.type_id=$4)
-	`, "room", c.Param("roomId"), "device", c.Param("deviceId"))
+func (s *Server) GetDeviceMembers(c echo.Context) error {
+	roomPermissions, err := s.db.Permissions().Members("room", c.Param("roomId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSDGDM001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -478,22 +383,15 @@ func GetDeviceMembers(c echo.Context) error {
 			Message: "Members can't be retrieved",
 		})
 	}
-	defer rows.Close()
-
-	var permissions []permissionDevice
-	for rows.Next() {
-		var permission permissionDevice
-		err := rows.StructScan(&permission)
-		if err != nil {
-			logger.WithFields(logger.Fields{"code": "CSDGDM002"}).Errorf("%s", err.Error())
-			return c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Code:    "CSDGDM002",
-				Message: "Members can't be retrieved",
-			})
-		}
-
-		permissions = append(permissions, permission)
+	devicePermissions, err := s.db.Permissions().Members("device", c.Param("deviceId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSDGDM002"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSDGDM002",
+			Message: "Members can't be retrieved",
+		})
 	}
+	permissions := append(roomPermissions, devicePermissions...)
 
 	var members []memberRes
 	for _, permission := range permissions {
@@ -532,7 +430,7 @@ func GetDeviceMembers(c echo.Context) error {
 }
 
 // EditDeviceMember route create a new permission to authorize an useron a device
-func EditDeviceMember(c echo.Context) error {
+func (s *Server) EditDeviceMember(c echo.Context) error {
 	req := new(editMemberReq)
 	if err := c.Bind(req); err != nil {
 		logger.WithFields(logger.Fields{"code": "CSDEDM001"}).Errorf("%s", err.Error())
@@ -550,17 +448,19 @@ func EditDeviceMember(c echo.Context) error {
 		})
 	}
 
-	var permission Permission
-	err := DB.QueryRowx(`
-		SELECT * FROM permissions
-		WHERE user_id=$1 AND type=$2 AND type_id=$3
-	 `, c.Param("userId"), "device", c.Param("deviceId")).StructScan(&permission)
-
+	permission := Permission{
+		ID:     utils.NewULID(),
+		UserID: c.Param("userId"),
+		Type:   "device",
+		TypeID: c.Param("deviceId"),
+		Read:   req.Read,
+		Write:  req.Write,
+		Manage: req.Manage,
+		Admin:  req.Admin,
+	}
+	_, err := s.db.Permissions().Get(permission.UserID, permission.Type, permission.TypeID)
 	if err != nil {
-		_, err = DB.Exec(`
-			INSERT INTO permissions (id, user_id, type, type_id, read, write, manage, admin) 
-			VALUES (generate_ulid(), $1, $2, $3, $4, $5, $6, $7)
-		`, c.Param("userId"), "device", c.Param("deviceId"), req.Read, req.Write, req.Manage, req.Admin)
+		err = s.db.Permissions().Create(permission)
 		if err != nil {
 			logger.WithFields(logger.Fields{"code": "CSDEDM003"}).Errorf("%s", err.Error())
 			return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -568,17 +468,14 @@ func EditDeviceMember(c echo.Context) error {
 				Message: "Member can't be updated",
 			})
 		}
+		s.publishMemberEvent(c, "added", permission)
 
 		return c.JSON(http.StatusOK, MessageResponse{
 			Message: "Member has been updated",
 		})
 	}
 
-	_, err = DB.Exec(`
-		UPDATE permissions
-		SET read=$1, write=$2, manage=$3, admin=$4
-		WHERE user_id=$5 AND type=$6 AND type_id=$7
-	`, req.Read, req.Write, req.Manage, req.Admin, c.Param("userId"), "device", c.Param("deviceId"))
+	err = s.db.Permissions().Update(permission)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSDEDM004"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -586,6 +483,7 @@ func EditDeviceMember(c echo.Context) error {
 			Message: "Member can't be updated",
 		})
 	}
+	s.publishMemberEvent(c, "updated", permission)
 
 	return c.JSON(http.StatusOK, MessageResponse{
 		Message: "Member has been updated",
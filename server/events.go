@@ -0,0 +1,236 @@
+package server
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/ItsJimi/casa/logger"
+	"github.com/ItsJimi/casa/utils"
+	"github.com/labstack/echo"
+)
+
+// Event define a change pushed to subscribed clients
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"` // data, action, automation, gateway
+	HomeID    string      `json:"homeId,omitempty"`
+	RoomID    string      `json:"roomId,omitempty"`
+	DeviceID  string      `json:"deviceId,omitempty"`
+	Data      interface{} `json:"data"`
+	CreatedAt string      `json:"createdAt"`
+}
+
+// ActionEvent define data of an action event
+type ActionEvent struct {
+	Call         string `json:"call"`
+	Params       string `json:"params"`
+	Status       string `json:"status"` // sent, failed
+	Source       string `json:"source"` // api, automation
+	AutomationID string `json:"automationId,omitempty"`
+}
+
+// AutomationEvent define data of an automation event
+type AutomationEvent struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// MemberEvent define data of a member event
+type MemberEvent struct {
+	UserID string `json:"userId"`
+	Type   string `json:"type"`   // home, room, device
+	Action string `json:"action"` // added, updated, removed
+	Read   bool   `json:"read"`
+	Write  bool   `json:"write"`
+	Manage bool   `json:"manage"`
+	Admin  bool   `json:"admin"`
+}
+
+// GatewayEvent define data of a gateway event
+type GatewayEvent struct {
+	Online bool `json:"online"`
+}
+
+// Subscription define an element watched by client
+type Subscription struct {
+	Type string `json:"type"` // home, room, device
+	ID   string `json:"id"`
+}
+
+// permissionCacheDuration define how long a client read right is trusted before asking database again
+const permissionCacheDuration = time.Minute
+
+type cachedPermission struct {
+	read     bool
+	expireAt time.Time
+}
+
+// permissionCache keep read rights of an user on elements for permissionCacheDuration
+type permissionCache struct {
+	mutex   sync.Mutex
+	entries map[string]cachedPermission
+}
+
+func newPermissionCache() *permissionCache {
+	return &permissionCache{
+		entries: map[string]cachedPermission{},
+	}
+}
+
+func (client *wsClient) subscribe(sub Subscription) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	for _, existing := range client.subscriptions {
+		if existing == sub {
+			return
+		}
+	}
+	client.subscriptions = append(client.subscriptions, sub)
+}
+
+func (client *wsClient) unsubscribe(sub Subscription) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	for i, existing := range client.subscriptions {
+		if existing == sub {
+			client.subscriptions = append(client.subscriptions[:i], client.subscriptions[i+1:]...)
+			return
+		}
+	}
+}
+
+// watch tell if client subscribed to an element concerned by event
+func (client *wsClient) watch(event Event) bool {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	for _, sub := range client.subscriptions {
+		switch sub.Type {
+		case "home":
+			if sub.ID == event.HomeID {
+				return true
+			}
+		case "room":
+			if sub.ID == event.RoomID {
+				return true
+			}
+		case "device":
+			if sub.ID == event.DeviceID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// canRead tell if user has read right on typ typeID
+func (s *Server) canRead(userID string, cache *permissionCache, typ string, typeID string) bool {
+	key := typ + ":" + typeID
+	cache.mutex.Lock()
+	cached, ok := cache.entries[key]
+	cache.mutex.Unlock()
+	if ok && time.Now().Before(cached.expireAt) {
+		return cached.read
+	}
+
+	read := false
+	permission, err := s.db.Permissions().Get(userID, typ, typeID)
+	if err == nil {
+		read = missingRight(permission, true, false, false, false) == ""
+	}
+
+	cache.mutex.Lock()
+	cache.entries[key] = cachedPermission{
+		read:     read,
+		expireAt: time.Now().Add(permissionCacheDuration),
+	}
+	cache.mutex.Unlock()
+	return read
+}
+
+// allowed tell if user can receive event
+func (s *Server) allowed(userID string, cache *permissionCache, event Event) bool {
+	if event.DeviceID != "" {
+		return s.canRead(userID, cache, "device", event.DeviceID)
+	}
+	if event.HomeID != "" {
+		return s.canRead(userID, cache, "home", event.HomeID)
+	}
+	// events outside homes would reach every user
+	return false
+}
+
+// publish push event to clients watching it with read right and to home webhooks
+func (s *Server) publish(event Event) {
+	if event.ID == "" {
+		event.ID = utils.NewULID()
+	}
+	if event.CreatedAt == "" {
+		event.CreatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSEP001"}).Errorf("%s", err.Error())
+		return
+	}
+	message := WebsocketMessage{
+		Action: "event",
+		Body:   body,
+	}
+
+	s.broker.add(event)
+	go s.queueWebhooks(event)
+
+	for _, client := range s.hub.list() {
+		if !client.watch(event) || !s.allowed(client.user.ID, client.permissions, event) {
+			continue
+		}
+		err := client.send(message)
+		if err == errSlowClient {
+			logger.WithFields(logger.Fields{"code": "CSEP002", "userId": client.user.ID}).Warnf("%s", err.Error())
+			wsClientsEvicted.Inc()
+			s.hub.unregister(client)
+		}
+	}
+}
+
+// publishDeviceEvent push event about device, looking for its home, and return it
+func (s *Server) publishDeviceEvent(typ string, device Device, data interface{}) Event {
+	event := Event{
+		Type:     typ,
+		RoomID:   device.RoomID,
+		DeviceID: device.ID,
+		Data:     data,
+	}
+
+	room, err := s.db.Rooms().ByID(device.RoomID)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSEPDE001"}).Errorf("%s", err.Error())
+	} else {
+		event.HomeID = room.HomeID
+	}
+
+	s.publish(event)
+	return event
+}
+
+// publishMemberEvent push change of permission on element of route and notify its user
+func (s *Server) publishMemberEvent(c echo.Context, action string, permission Permission) {
+	go s.notifyMember(c.Param("homeId"), action, permission)
+	s.publish(Event{
+		Type:     "member",
+		HomeID:   c.Param("homeId"),
+		RoomID:   c.Param("roomId"),
+		DeviceID: c.Param("deviceId"),
+		Data: MemberEvent{
+			UserID: permission.UserID,
+			Type:   permission.Type,
+			Action: action,
+			Read:   permission.Read,
+			Write:  permission.Write,
+			Manage: permission.Manage,
+			Admin:  permission.Admin,
+		},
+	})
+}
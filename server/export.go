@@ -0,0 +1,438 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ItsJimi/casa/logger"
+	"github.com/ItsJimi/casa/utils"
+	"github.com/labstack/echo"
+	parquetsource "github.com/xitongsys/parquet-go-source/writer"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// ExportDir define the folder where home exports are written
+var ExportDir = filepath.Join(os.TempDir(), "casa-exports")
+
+// exportJobTTL define how long a finished export job and its file can be downloaded
+const exportJobTTL = 24 * time.Hour
+
+var exportHeader = []string{"id", "device_id", "field", "value_nbr", "value_str", "value_bool", "created_at"}
+
+// exportData define a datas row as read from database
+type exportData struct {
+	ID        string          `db:"id"`
+	DeviceID  string          `db:"device_id"`
+	Field     string          `db:"field"`
+	ValueNbr  sql.NullFloat64 `db:"value_nbr"`
+	ValueStr  sql.NullString  `db:"value_str"`
+	ValueBool sql.NullBool    `db:"value_bool"`
+	CreatedAt time.Time       `db:"created_at"`
+}
+
+// exportRow define a datas row as written in parquet files
+type exportRow struct {
+	ID        string   `parquet:"name=id, type=UTF8"`
+	DeviceID  string   `parquet:"name=device_id, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	Field     string   `parquet:"name=field, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	ValueNbr  *float64 `parquet:"name=value_nbr, type=DOUBLE"`
+	ValueStr  *string  `parquet:"name=value_str, type=UTF8"`
+	ValueBool *bool    `parquet:"name=value_bool, type=BOOLEAN"`
+	CreatedAt int64    `parquet:"name=created_at, type=TIMESTAMP_MILLIS"`
+}
+
+type datasExporter interface {
+	Write(data exportData) error
+	Close() error
+}
+
+type csvExporter struct {
+	writer *csv.Writer
+}
+
+func (e *csvExporter) Write(data exportData) error {
+	record := []string{data.ID, data.DeviceID, data.Field, "", "", "", data.CreatedAt.Format(time.RFC3339Nano)}
+	if data.ValueNbr.Valid {
+		record[3] = strconv.FormatFloat(data.ValueNbr.Float64, 'f', -1, 64)
+	}
+	if data.ValueStr.Valid {
+		record[4] = data.ValueStr.String
+	}
+	if data.ValueBool.Valid {
+		record[5] = strconv.FormatBool(data.ValueBool.Bool)
+	}
+	return e.writer.Write(record)
+}
+
+func (e *csvExporter) Close() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+type parquetExporter struct {
+	writer *writer.ParquetWriter
+}
+
+func (e *parquetExporter) Write(data exportData) error {
+	row := exportRow{
+		ID:        data.ID,
+		DeviceID:  data.DeviceID,
+		Field:     data.Field,
+		CreatedAt: data.CreatedAt.UnixNano() / int64(time.Millisecond),
+	}
+	if data.ValueNbr.Valid {
+		row.ValueNbr = &data.ValueNbr.Float64
+	}
+	if data.ValueStr.Valid {
+		row.ValueStr = &data.ValueStr.String
+	}
+	if data.ValueBool.Valid {
+		row.ValueBool = &data.ValueBool.Bool
+	}
+	return e.writer.Write(row)
+}
+
+func (e *parquetExporter) Close() error {
+	return e.writer.WriteStop()
+}
+
+func newDatasExporter(w io.Writer, format string) (datasExporter, error) {
+	switch format {
+	case "csv":
+		csvWriter := csv.NewWriter(w)
+		if err := csvWriter.Write(exportHeader); err != nil {
+			return nil, err
+		}
+		return &csvExporter{writer: csvWriter}, nil
+	case "parquet":
+		parquetWriter, err := writer.NewParquetWriter(parquetsource.NewWriterFile(w), new(exportRow), 1)
+		if err != nil {
+			return nil, err
+		}
+		// Row groups of 8MB instead of default 128MB bound memory buffered before datas are flushed
+		parquetWriter.RowGroupSize = 8 * 1024 * 1024
+		parquetWriter.CompressionType = parquet.CompressionCodec_SNAPPY
+		return &parquetExporter{writer: parquetWriter}, nil
+	default:
+		return nil, errors.New("Unknown export format: " + format)
+	}
+}
+
+func exportContentType(format string) string {
+	if format == "csv" {
+		return "text/csv"
+	}
+	return "application/octet-stream"
+}
+
+// writeDatasExport stream rows into w with format and return number of rows written
+func writeDatasExport(w io.Writer, format string, rows Rows, flush func()) (int64, error) {
+	exporter, err := newDatasExporter(w, format)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	for rows.Next() {
+		var data exportData
+		if err := rows.StructScan(&data); err != nil {
+			return count, err
+		}
+		if err := exporter.Write(data); err != nil {
+			return count, err
+		}
+		count++
+		if flush != nil && count%1000 == 0 {
+			flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+
+	return count, exporter.Close()
+}
+
+// ExportDatasDevice stream all datas of a device as csv or parquet
+func (s *Server) ExportDatasDevice(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "parquet" {
+		logger.WithFields(logger.Fields{"code": "CSEEDD001"}).Warnf("Unknown format %s", format)
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSEEDD001",
+			Message: "Format must be csv or parquet",
+		})
+	}
+
+	rows, err := s.db.Datas().ExportDevice(c.Param("homeId"), c.Param("roomId"), c.Param("deviceId"), c.QueryParam("field"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSEEDD002"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSEEDD002",
+			Message: "Datas can't be exported",
+		})
+	}
+	defer rows.Close()
+
+	c.Response().Header().Set(echo.HeaderContentType, exportContentType(format))
+	c.Response().Header().Set(echo.HeaderContentDisposition, "attachment; filename=\""+c.Param("deviceId")+"."+format+"\"")
+	c.Response().WriteHeader(http.StatusOK)
+
+	_, err = writeDatasExport(c.Response(), format, rows, c.Response().Flush)
+	if err != nil {
+		// Headers are already sent, the truncated body is the only signal left for the client
+		logger.WithFields(logger.Fields{"code": "CSEEDD003"}).Errorf("%s", err.Error())
+	}
+
+	return nil
+}
+
+// ExportJob define a home-wide export running in background
+type ExportJob struct {
+	ID        string `json:"id"`
+	HomeID    string `json:"homeId"`
+	Format    string `json:"format"`
+	Field     string `json:"field"`
+	Status    string `json:"status"` // pending, running, done, failed
+	Rows      int64  `json:"rows"`
+	Error     string `json:"error"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+	path      string
+	// userID started job, only datas of devices this user can read are exported and only this user can get them
+	userID string
+	// expireAt is set when job is done or failed
+	expireAt time.Time
+}
+
+var exportJobs = map[string]*ExportJob{}
+var exportJobsMutex sync.RWMutex
+
+func setExportJob(id string, update func(job *ExportJob)) {
+	exportJobsMutex.Lock()
+	defer exportJobsMutex.Unlock()
+
+	job, ok := exportJobs[id]
+	if !ok {
+		return
+	}
+	update(job)
+	job.UpdatedAt = time.Now().Format(time.RFC3339)
+}
+
+// getExportJob return job of home started by user
+func getExportJob(homeID string, userID string, id string) (ExportJob, bool) {
+	job, ok := getExportJobByID(id)
+	if !ok || job.HomeID != homeID || job.userID != userID {
+		return ExportJob{}, false
+	}
+	return job, true
+}
+
+func getExportJobByID(id string) (ExportJob, bool) {
+	exportJobsMutex.RLock()
+	defer exportJobsMutex.RUnlock()
+
+	job, ok := exportJobs[id]
+	if !ok {
+		return ExportJob{}, false
+	}
+	return *job, true
+}
+
+// runHomeExport write all datas of an home in export file
+func (s *Server) runHomeExport(id string) {
+	job, ok := getExportJobByID(id)
+	if !ok {
+		return
+	}
+
+	fail := func(code string, err error) {
+		logger.WithFields(logger.Fields{"code": code, "exportId": id}).Errorf("%s", err.Error())
+		setExportJob(id, func(job *ExportJob) {
+			job.Status = "failed"
+			job.Error = err.Error()
+			job.expireAt = time.Now().Add(exportJobTTL)
+		})
+	}
+
+	setExportJob(id, func(job *ExportJob) {
+		job.Status = "running"
+	})
+
+	err := os.MkdirAll(ExportDir, 0700)
+	if err != nil {
+		fail("CSERHE001", err)
+		return
+	}
+
+	file, err := os.Create(job.path)
+	if err != nil {
+		fail("CSERHE002", err)
+		return
+	}
+	defer file.Close()
+
+	rows, err := s.db.Datas().ExportHome(job.HomeID, job.userID, job.Field)
+	if err != nil {
+		fail("CSERHE003", err)
+		return
+	}
+	defer rows.Close()
+
+	count, err := writeDatasExport(file, job.Format, rows, nil)
+	if err != nil {
+		fail("CSERHE004", err)
+		return
+	}
+
+	setExportJob(id, func(job *ExportJob) {
+		job.Status = "done"
+		job.Rows = count
+		job.expireAt = time.Now().Add(exportJobTTL)
+	})
+}
+
+// purgeExportJobs remove jobs expired at now, then files older than exportJobTTL which no job can download,
+// like files of expired jobs or of jobs of a previous run
+func purgeExportJobs(now time.Time) {
+	exportJobsMutex.Lock()
+	kept := map[string]bool{}
+	for id, job := range exportJobs {
+		if !job.expireAt.IsZero() && !now.Before(job.expireAt) {
+			delete(exportJobs, id)
+			continue
+		}
+		kept[job.path] = true
+	}
+	exportJobsMutex.Unlock()
+
+	files, err := ioutil.ReadDir(ExportDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.WithFields(logger.Fields{"code": "CSEPEJ001"}).Errorf("%s", err.Error())
+		}
+		return
+	}
+	for _, file := range files {
+		path := filepath.Join(ExportDir, file.Name())
+		if kept[path] || now.Sub(file.ModTime()) < exportJobTTL {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			logger.WithFields(logger.Fields{"code": "CSEPEJ002"}).Errorf("%s", err.Error())
+		}
+	}
+}
+
+// PurgeExportJobs remove expired export jobs and their files every interval
+func PurgeExportJobs(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		purgeExportJobs(now)
+	}
+}
+
+type addHomeExportReq struct {
+	Format string
+	Field  string
+}
+
+// AddHomeExport route start an export job of all home datas
+func (s *Server) AddHomeExport(c echo.Context) error {
+	req := new(addHomeExportReq)
+	if err := c.Bind(req); err != nil {
+		logger.WithFields(logger.Fields{"code": "CSEAHE001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSEAHE001",
+			Message: "Wrong parameters",
+		})
+	}
+
+	if req.Format == "" {
+		req.Format = "csv"
+	}
+	if req.Format != "csv" && req.Format != "parquet" {
+		logger.WithFields(logger.Fields{"code": "CSEAHE002"}).Warnf("Unknown format %s", req.Format)
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSEAHE002",
+			Message: "Format must be csv or parquet",
+		})
+	}
+
+	user := c.Get("user").(User)
+	id := utils.NewULID()
+	now := time.Now().Format(time.RFC3339)
+	exportJobsMutex.Lock()
+	exportJobs[id] = &ExportJob{
+		ID:        id,
+		HomeID:    c.Param("homeId"),
+		Format:    req.Format,
+		Field:     req.Field,
+		Status:    "pending",
+		CreatedAt: now,
+		UpdatedAt: now,
+		path:      filepath.Join(ExportDir, id+"."+req.Format),
+		userID:    user.ID,
+	}
+	exportJobsMutex.Unlock()
+
+	go s.runHomeExport(id)
+
+	return c.JSON(http.StatusAccepted, MessageResponse{
+		Message: id,
+	})
+}
+
+// GetHomeExport route get status of an export job
+func (s *Server) GetHomeExport(c echo.Context) error {
+	user := c.Get("user").(User)
+	job, ok := getExportJob(c.Param("homeId"), user.ID, c.Param("exportId"))
+	if !ok {
+		logger.WithFields(logger.Fields{"code": "CSEGHE001"}).Warnf("Export %s not found", c.Param("exportId"))
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    "CSEGHE001",
+			Message: "Export can't be found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, DataReponse{
+		Data: job,
+	})
+}
+
+// DownloadHomeExport route download file of a finished export job
+func (s *Server) DownloadHomeExport(c echo.Context) error {
+	user := c.Get("user").(User)
+	job, ok := getExportJob(c.Param("homeId"), user.ID, c.Param("exportId"))
+	if !ok {
+		logger.WithFields(logger.Fields{"code": "CSEDHE001"}).Warnf("Export %s not found", c.Param("exportId"))
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    "CSEDHE001",
+			Message: "Export can't be found",
+		})
+	}
+
+	if job.Status != "done" {
+		logger.WithFields(logger.Fields{"code": "CSEDHE002"}).Warnf("Export %s is %s", job.ID, job.Status)
+		return c.JSON(http.StatusConflict, ErrorResponse{
+			Code:    "CSEDHE002",
+			Message: "Export is " + job.Status,
+		})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, exportContentType(job.Format))
+	return c.Attachment(job.path, job.HomeID+"-"+job.ID+"."+job.Format)
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// exportRows is a cursor over datas, like the ones of ExportDevice and ExportHome
+type exportRows struct {
+	datas []exportData
+	next  int
+}
+
+func (r *exportRows) Next() bool {
+	r.next++
+	return r.next <= len(r.datas)
+}
+
+func (r *exportRows) StructScan(dest interface{}) error {
+	*dest.(*exportData) = r.datas[r.next-1]
+	return nil
+}
+
+func (r *exportRows) Err() error   { return nil }
+func (r *exportRows) Close() error { return nil }
+
+func TestWriteDatasExport(t *testing.T) {
+	at := time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	datas := []exportData{
+		{ID: "1", DeviceID: "sensor", Field: "temperature", ValueNbr: sql.NullFloat64{Float64: 21.5, Valid: true}, CreatedAt: at},
+		{ID: "2", DeviceID: "lamp", Field: "state", ValueStr: sql.NullString{String: "on, dimmed", Valid: true}, CreatedAt: at},
+		{ID: "3", DeviceID: "lamp", Field: "on", ValueBool: sql.NullBool{Bool: false, Valid: true}, CreatedAt: at},
+	}
+	nbr, str, boolean := 21.5, "on, dimmed", false
+	// values a reader gets back, empty columns are the values datas don't have
+	want := []exportRow{
+		{ID: "1", DeviceID: "sensor", Field: "temperature", ValueNbr: &nbr, CreatedAt: at.UnixNano() / int64(time.Millisecond)},
+		{ID: "2", DeviceID: "lamp", Field: "state", ValueStr: &str, CreatedAt: at.UnixNano() / int64(time.Millisecond)},
+		{ID: "3", DeviceID: "lamp", Field: "on", ValueBool: &boolean, CreatedAt: at.UnixNano() / int64(time.Millisecond)},
+	}
+
+	tests := []struct {
+		format string
+		read   func(t *testing.T, content []byte) []exportRow
+	}{
+		{"csv", func(t *testing.T, content []byte) []exportRow {
+			records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(records[0], exportHeader) {
+				t.Errorf("header = %v, want %v", records[0], exportHeader)
+			}
+			wantRecords := [][]string{
+				{"1", "sensor", "temperature", "21.5", "", "", "2020-01-02T03:04:05.006Z"},
+				{"2", "lamp", "state", "", "on, dimmed", "", "2020-01-02T03:04:05.006Z"},
+				{"3", "lamp", "on", "", "", "false", "2020-01-02T03:04:05.006Z"},
+			}
+			if !reflect.DeepEqual(records[1:], wantRecords) {
+				t.Errorf("records = %q, want %q", records[1:], wantRecords)
+			}
+			return want
+		}},
+		{"parquet", func(t *testing.T, content []byte) []exportRow {
+			file, err := buffer.NewBufferFile(content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pr, err := reader.NewParquetReader(file, new(exportRow), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pr.ReadStop()
+			rows := make([]exportRow, pr.GetNumRows())
+			if err := pr.Read(&rows); err != nil {
+				t.Fatal(err)
+			}
+			return rows
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			var content bytes.Buffer
+			count, err := writeDatasExport(&content, test.format, &exportRows{datas: datas}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != int64(len(datas)) {
+				t.Errorf("count = %d, want %d", count, len(datas))
+			}
+			if rows := test.read(t, content.Bytes()); !reflect.DeepEqual(rows, want) {
+				t.Errorf("rows = %+v, want %+v", rows, want)
+			}
+		})
+	}
+
+	if _, err := writeDatasExport(&bytes.Buffer{}, "xml", &exportRows{}, nil); err == nil {
+		t.Error("unknown format is exported")
+	}
+}
+
+func TestPurgeExportJobs(t *testing.T) {
+	dir := ExportDir
+	ExportDir = t.TempDir()
+	defer func() { ExportDir = dir }()
+
+	now := time.Now()
+	old := now.Add(-2 * exportJobTTL)
+	file := func(name string, modTime time.Time) string {
+		path := filepath.Join(ExportDir, name)
+		if err := os.WriteFile(path, []byte("id\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	exportJobsMutex.Lock()
+	exportJobs = map[string]*ExportJob{
+		"expired": {ID: "expired", Status: "done", path: file("expired.csv", old), expireAt: now.Add(-time.Minute)},
+		"done":    {ID: "done", Status: "done", path: file("done.csv", now), expireAt: now.Add(time.Minute)},
+		// a running job has no expiry, even when it's writing for longer than ttl
+		"running": {ID: "running", Status: "running", path: file("running.csv", old)},
+	}
+	exportJobsMutex.Unlock()
+	orphan := file("orphan.csv", old)
+	recent := file("recent.csv", now)
+
+	purgeExportJobs(now)
+
+	for _, test := range []struct {
+		id   string
+		kept bool
+	}{{"expired", false}, {"done", true}, {"running", true}} {
+		if _, ok := getExportJobByID(test.id); ok != test.kept {
+			t.Errorf("job %s kept = %t, want %t", test.id, ok, test.kept)
+		}
+		_, err := os.Stat(filepath.Join(ExportDir, test.id+".csv"))
+		if kept := err == nil; kept != test.kept {
+			t.Errorf("file of %s kept = %t, want %t", test.id, kept, test.kept)
+		}
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Error("old file of no job is kept")
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Error("recent file of no job is removed")
+	}
+}
+
+func TestGetExportJob(t *testing.T) {
+	exportJobsMutex.Lock()
+	exportJobs = map[string]*ExportJob{"job": {ID: "job", HomeID: "home", Status: "done", userID: "owner"}}
+	exportJobsMutex.Unlock()
+
+	tests := []struct {
+		homeID string
+		userID string
+		found  bool
+	}{
+		{"home", "owner", true},
+		// other members of home can't get export of owner, it may have datas they can't read
+		{"home", "member", false},
+		{"other", "owner", false},
+	}
+	for _, test := range tests {
+		if _, ok := getExportJob(test.homeID, test.userID, "job"); ok != test.found {
+			t.Errorf("getExportJob(%s, %s) found = %t, want %t", test.homeID, test.userID, ok, test.found)
+		}
+	}
+}
@@ -34,7 +34,7 @@ func checkUlid(id string) error {
 }
 
 // AddGateway route add new gateway in system
-func AddGateway(c echo.Context) error {
+func (s *Server) AddGateway(c echo.Context) error {
 	req := new(addGatewayReq)
 	err := c.Bind(req)
 	if err != nil {
@@ -61,7 +61,7 @@ func AddGateway(c echo.Context) error {
 		ID:    req.ID,
 		Model: req.Model,
 	}
-	_, err = DB.NamedExec("INSERT INTO gateways (id, model) VALUES (:id, :model)", newGateway)
+	err = s.db.Gateways().Create(newGateway)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSGAG004"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -76,7 +76,7 @@ func AddGateway(c echo.Context) error {
 }
 
 // UpdateGateway route update gateway
-func UpdateGateway(c echo.Context) error {
+func (s *Server) UpdateGateway(c echo.Context) error {
 	id := c.Param("gatewayId")
 	req := new(updateGatewayReq)
 	if err := c.Bind(req); err != nil {
@@ -94,8 +94,7 @@ func UpdateGateway(c echo.Context) error {
 		})
 	}
 
-	var gateway Gateway
-	err := DB.Get(&gateway, "SELECT * FROM gateways WHERE id=$1", id)
+	gateway, err := s.db.Gateways().ByID(id)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSGUG003"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusNotFound, ErrorResponse{
@@ -106,8 +105,7 @@ func UpdateGateway(c echo.Context) error {
 
 	user := c.Get("user").(User)
 
-	var permission Permission
-	err = DB.Get(&permission, "SELECT * FROM permissions WHERE user_id=$1 AND type=$2 AND type_id=$3", user.ID, "home", gateway.HomeID)
+	permission, err := s.db.Permissions().Get(user.ID, "home", gateway.HomeID)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSGUG004"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusNotFound, ErrorResponse{
@@ -124,7 +122,10 @@ func UpdateGateway(c echo.Context) error {
 		})
 	}
 
-	_, err = DB.Exec("UPDATE gateways SET Name=COALESCE($1, name) WHERE id=$2", utils.NewNullString(req.Name), gateway.ID)
+	err = s.db.Gateways().Update(Gateway{
+		ID:   gateway.ID,
+		Name: req.Name,
+	})
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSGUG006"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -139,12 +140,11 @@ func UpdateGateway(c echo.Context) error {
 }
 
 // DeleteGateway route delete gateway
-func DeleteGateway(c echo.Context) error {
+func (s *Server) DeleteGateway(c echo.Context) error {
 	id := c.Param("gatewayId")
 	user := c.Get("user").(User)
 
-	var gateway Gateway
-	err := DB.Get(&gateway, "SELECT * FROM gateways WHERE id=$1", id)
+	gateway, err := s.db.Gateways().ByID(id)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSGDG001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusNotFound, ErrorResponse{
@@ -153,8 +153,7 @@ func DeleteGateway(c echo.Context) error {
 		})
 	}
 
-	var permission Permission
-	err = DB.Get(&permission, "SELECT * FROM permissions WHERE user_id=$1 AND type=$2 AND type_id=$3", user.ID, "home", gateway.HomeID)
+	permission, err := s.db.Permissions().Get(user.ID, "home", gateway.HomeID)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSGDG002"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusNotFound, ErrorResponse{
@@ -171,7 +170,7 @@ func DeleteGateway(c echo.Context) error {
 		})
 	}
 
-	_, err = DB.Exec("DELETE FROM gateways WHERE id=$1", id)
+	err = s.db.Gateways().Delete(id)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSGDG004"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -186,7 +185,7 @@ func DeleteGateway(c echo.Context) error {
 }
 
 // LinkGateway route link gateway with user & home
-func LinkGateway(c echo.Context) error {
+func (s *Server) LinkGateway(c echo.Context) error {
 	req := new(linkGatewayReq)
 	err := c.Bind(req)
 	if err != nil {
@@ -204,17 +203,16 @@ func LinkGateway(c echo.Context) error {
 		})
 	}
 
-	var gateway Gateway
-	err = DB.Get(&gateway, "SELECT * FROM gateways WHERE id=$1 AND creator_id = '' AND home_id = ''", req.ID)
-	if err == nil {
-		logger.WithFields(logger.Fields{"code": "CSGLG003"}).Errorf("%s", err.Error())
+	gateway, err := s.db.Gateways().ByID(req.ID)
+	if err == nil && gateway.HomeID != "" {
+		logger.WithFields(logger.Fields{"code": "CSGLG003"}).Warnf("Gateway %s is already linked", gateway.ID)
 		return c.JSON(http.StatusBadRequest, ErrorResponse{
 			Code:    "CSGLG003",
 			Message: "Gateway is already linked",
 		})
 	}
 
-	_, err = DB.Exec("UPDATE gateways SET creator_id=$1, home_id=$2 WHERE id=$3", req.User, c.Param("homeId"), req.ID)
+	err = s.db.Gateways().Link(req.ID, req.User, c.Param("homeId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSGLG004"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -228,17 +226,6 @@ func LinkGateway(c echo.Context) error {
 	})
 }
 
-type permissionGateway struct {
-	Permission
-	User
-	GatewayID        string         `db:"g_id"`
-	GatewayName      sql.NullString `db:"g_name"`
-	GatewayHomeID    sql.NullString `db:"g_homeid"`
-	GatewayModel     string         `db:"g_model"`
-	GatewayCreatedAt string         `db:"g_createdat"`
-	GatewayUpdatedAt string         `db:"g_updatedat"`
-}
-
 type gatewayRes struct {
 	ID        string         `json:"id"`
 	HomeID    sql.NullString `json:"homeId"`
@@ -254,58 +241,18 @@ type gatewayRes struct {
 }
 
 // GetGateway route get specific gateway with id
-func GetGateway(c echo.Context) error {
+func (s *Server) GetGateway(c echo.Context) error {
 	user := c.Get("user").(User)
 
-	row := DB.QueryRowx(`
-		SELECT * FROM gateways
-		WHERE id=$1
-	 `, c.Param("gatewayId"))
-
-	if row == nil {
-		logger.WithFields(logger.Fields{"code": "CSGGG001"}).Errorf("QueryRowx: Select gateways")
+	permission, err := s.db.Gateways().GetForUser(user.ID, c.Param("gatewayId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSGGG001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusNotFound, ErrorResponse{
 			Code:    "CSGGG001",
 			Message: "Gateway not found",
 		})
 	}
 
-	var gateway Gateway
-	err := row.StructScan(&gateway)
-	if err != nil {
-		logger.WithFields(logger.Fields{"code": "CSGSG002"}).Errorf("%s", err.Error())
-		return c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Code:    "CSGSG002",
-			Message: "Gateway can't be found",
-		})
-	}
-
-	row = DB.QueryRowx(`
-		SELECT permissions.*, users.*,
-		gateways.id as g_id,	gateways.name AS g_name, gateways.home_id AS g_homeid, gateways.model AS g_model, gateways.created_at AS g_createdat, gateways.updated_at AS g_updatedat FROM permissions
-		JOIN gateways ON permissions.type_id = gateways.home_id
-		JOIN users ON gateways.creator_id = users.id
-		WHERE type=$1 AND type_id=$2 AND user_id=$3
-	`, "home", gateway.HomeID, user.ID)
-
-	if row == nil {
-		logger.WithFields(logger.Fields{"code": "CSGSG003"}).Errorf("QueryRowx: Select gateways")
-		return c.JSON(http.StatusNotFound, ErrorResponse{
-			Code:    "CSGSG003",
-			Message: "Gateway can't be found",
-		})
-	}
-
-	var permission permissionGateway
-	err = row.StructScan(&permission)
-	if err != nil {
-		logger.WithFields(logger.Fields{"code": "CSGSG04"}).Errorf("%s", err.Error())
-		return c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Code:    "CSGSG04",
-			Message: "Gateway can't be found",
-		})
-	}
-
 	return c.JSON(http.StatusOK, DataReponse{
 		Data: gatewayRes{
 			ID:        permission.GatewayID,
@@ -329,7 +276,7 @@ type addPluginReq struct {
 }
 
 // AddPlugin add a plugin configuration for gateway
-func AddPlugin(c echo.Context) error {
+func (s *Server) AddPlugin(c echo.Context) error {
 	req := new(addPluginReq)
 	if err := c.Bind(req); err != nil {
 		logger.WithFields(logger.Fields{"code": "CSGAP001"}).Errorf("%s", err.Error())
@@ -347,11 +294,12 @@ func AddPlugin(c echo.Context) error {
 		})
 	}
 
-	_, err := DB.Exec(`
-	INSERT INTO plugins (id, gateway_id, name, config)
-	SELECT COALESCE((SELECT id FROM plugins WHERE plugins.gateway_id = $1 AND name = $2), generate_ulid()), $1, $2, $3
-	ON CONFLICT (id) DO
-	UPDATE SET config = $3 WHERE plugins.gateway_id = $1 AND plugins.name = $2`, c.Param("gatewayId"), req.Name, req.Config)
+	err := s.db.Plugins().Save(Plugin{
+		ID:        utils.NewULID(),
+		GatewayID: c.Param("gatewayId"),
+		Name:      req.Name,
+		Config:    req.Config,
+	})
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSGAP003"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusBadRequest, ErrorResponse{
@@ -366,13 +314,8 @@ func AddPlugin(c echo.Context) error {
 }
 
 // GetPlugin route get a gateway plugin
-func GetPlugin(c echo.Context) error {
-	var plugin Plugin
-	err := DB.QueryRowx(`
-		SELECT * FROM plugins
-		WHERE gateway_id=$1 AND name=$2
-	 `, c.Param("gatewayId"), c.Param("pluginName")).StructScan(&plugin)
-
+func (s *Server) GetPlugin(c echo.Context) error {
+	plugin, err := s.db.Plugins().ByName(c.Param("gatewayId"), c.Param("pluginName"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSSGGP001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusNotFound, ErrorResponse{
@@ -390,7 +333,7 @@ type callActionReq struct {
 }
 
 // CallAction call an action on selected gateway
-func CallAction(c echo.Context) error {
+func (s *Server) CallAction(c echo.Context) error {
 	req := new(callActionReq)
 	if err := c.Bind(req); err != nil {
 		logger.WithFields(logger.Fields{"code": "CSSGCA001"}).Errorf("%s", err.Error())
@@ -408,9 +351,7 @@ func CallAction(c echo.Context) error {
 		})
 	}
 
-	var device Device
-	err := DB.QueryRowx("SELECT * FROM devices WHERE id=$1", c.Param("deviceId")).StructScan(&device)
-
+	device, err := s.db.Devices().ByID(c.Param("deviceId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSSGCA003"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusNotFound, ErrorResponse{
@@ -419,12 +360,27 @@ func CallAction(c echo.Context) error {
 		})
 	}
 
+	err = s.sendDeviceAction(device, req.Action, req.Params, "api")
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSSGCA004"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, MessageResponse{
+			Message: "Action can't be sent",
+		})
+	}
+
+	return c.JSON(http.StatusOK, MessageResponse{
+		Message: "Action sent to gateway",
+	})
+}
+
+// sendDeviceAction send call with params to device through gateway, source tell who asked it (api, mqtt...)
+func (s *Server) sendDeviceAction(device Device, call string, params string, source string) error {
 	action := ActionMessage{
 		PhysicalID: device.PhysicalID,
 		Plugin:     device.Plugin,
-		Call:       req.Action,
+		Call:       call,
 		Config:     device.Config,
-		Params:     req.Params,
+		Params:     params,
 	}
 
 	byteAction, _ := json.Marshal(action)
@@ -434,21 +390,31 @@ func CallAction(c echo.Context) error {
 		Body:   byteAction,
 	}
 
+	actionEvent := ActionEvent{
+		Call:   action.Call,
+		Params: action.Params,
+		Status: "sent",
+		Source: source,
+	}
 	marshMessage, _ := json.Marshal(message)
-	err = WebsocketWriteMessage(GatewayConn, marshMessage)
+	err := s.gateway.Send(marshMessage)
 	if err != nil {
-		logger.WithFields(logger.Fields{"code": "CSSGCA004"}).Errorf("%s", err.Error())
-		return c.JSON(http.StatusInternalServerError, MessageResponse{
-			Message: "Action can't be sent",
-		})
-	}
-
-	_, err = DB.Exec("INSERT INTO logs (id, type, type_id, value) VALUES (generate_ulid(), $1, $2, $3)", "device", device.ID, string(byteAction))
+		actionSendFailures.WithLabelValues(source).Inc()
+		actionEvent.Status = "failed"
+		s.publishDeviceEvent("action", device, actionEvent)
+		return err
+	}
+	actionsSent.WithLabelValues(source).Inc()
+	s.publishDeviceEvent("action", device, actionEvent)
+
+	err = s.db.Logs().Create(Logs{
+		ID:     utils.NewULID(),
+		Type:   "device",
+		TypeID: device.ID,
+		Value:  string(byteAction),
+	})
 	if err != nil {
-		logger.WithFields(logger.Fields{"code": "CSSGCA005"}).Errorf("%s", err.Error())
+		logger.WithFields(logger.Fields{"code": "CSSGSDA001"}).Errorf("%s", err.Error())
 	}
-
-	return c.JSON(http.StatusOK, MessageResponse{
-		Message: "Action sent to gateway",
-	})
+	return nil
 }
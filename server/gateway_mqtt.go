@@ -0,0 +1,203 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/ItsJimi/casa/config"
+	"github.com/ItsJimi/casa/logger"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	broker "github.com/mochi-co/mqtt/server"
+	"github.com/mochi-co/mqtt/server/listeners"
+)
+
+// gatewayTopics define actions gateways can publish, on <prefix>/<action>
+var gatewayTopics = []string{"newConnection", "newData", "configs", "status"}
+
+// serverTopics define actions server can publish to gateways, on <prefix>/<action>
+var serverTopics = []string{"callAction"}
+
+// mqttServerUsername is the user of the internal client of server, its password is generated at each start
+const mqttServerUsername = "casa-server"
+
+var errGatewayMQTTCredentials = errors.New("gateway.mqtt.username and gateway.mqtt.password must be set to serve gateways over MQTT")
+var errGatewayMQTTUsername = errors.New("gateway.mqtt.username can't be " + mqttServerUsername)
+
+// mqttGateway run an embedded MQTT broker gateways connect to
+type mqttGateway struct {
+	gatewayHTTP
+	conf   config.GatewayMQTT
+	broker *broker.Server
+	client mqtt.Client
+
+	mutex  sync.Mutex
+	online bool
+}
+
+// NewMQTTGatewayTransport return transport to a gateway connected on embedded MQTT broker
+func NewMQTTGatewayTransport(conf config.GatewayMQTT) GatewayTransport {
+	return &mqttGateway{
+		conf: conf,
+	}
+}
+
+// gatewayAuth allow the gateway with configured username and password, and the internal client of server
+type gatewayAuth struct {
+	username       string
+	password       string
+	serverPassword string
+	prefix         string
+}
+
+func (a gatewayAuth) Authenticate(user, password []byte) bool {
+	if a.username == "" || a.password == "" || a.serverPassword == "" {
+		return false
+	}
+	if string(user) == mqttServerUsername {
+		return subtle.ConstantTimeCompare(password, []byte(a.serverPassword)) == 1
+	}
+	return subtle.ConstantTimeCompare(user, []byte(a.username)) == 1 &&
+		subtle.ConstantTimeCompare(password, []byte(a.password)) == 1
+}
+
+// ACL let only gateway publish gateway topics and server subscribe to them, and the other way for server topics
+func (a gatewayAuth) ACL(user []byte, topic string, write bool) bool {
+	var publish []string
+	var subscribe []string
+	switch string(user) {
+	case mqttServerUsername:
+		publish, subscribe = serverTopics, gatewayTopics
+	case a.username:
+		publish, subscribe = gatewayTopics, serverTopics
+	default:
+		return false
+	}
+	allowed := subscribe
+	if write {
+		allowed = publish
+	}
+	for _, action := range allowed {
+		if topic == a.prefix+"/"+action {
+			return true
+		}
+	}
+	return false
+}
+
+// Serve start broker and listen messages of gateways with an internal client
+func (g *mqttGateway) Serve(s *Server) error {
+	if g.conf.Username == "" || g.conf.Password == "" {
+		return errGatewayMQTTCredentials
+	}
+	if g.conf.Username == mqttServerUsername {
+		return errGatewayMQTTUsername
+	}
+	serverPassword, err := newSecret()
+	if err != nil {
+		return err
+	}
+
+	g.broker = broker.New()
+	err = g.broker.AddListener(listeners.NewTCP("gateway", g.conf.Address), &listeners.Config{
+		Auth: gatewayAuth{
+			username:       g.conf.Username,
+			password:       g.conf.Password,
+			serverPassword: serverPassword,
+			prefix:         g.conf.TopicPrefix,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	err = g.broker.Serve()
+	if err != nil {
+		return err
+	}
+	logger.WithFields(logger.Fields{}).Infof("Gateway MQTT broker listening on %s", g.conf.Address)
+
+	opts := mqtt.NewClientOptions().
+		AddBroker("tcp://" + localAddress(g.conf.Address)).
+		SetClientID("casa-server").
+		SetUsername(mqttServerUsername).
+		SetPassword(serverPassword).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			filters := map[string]byte{}
+			for _, action := range gatewayTopics {
+				filters[g.topic(action)] = 1
+			}
+			token := client.SubscribeMultiple(filters, g.handler(s))
+			if token.Wait() && token.Error() != nil {
+				logger.WithFields(logger.Fields{"code": "CSGMS001"}).Errorf("%s", token.Error().Error())
+			}
+		})
+	g.client = mqtt.NewClient(opts)
+	token := g.client.Connect()
+	token.Wait()
+	return token.Error()
+}
+
+// handler turn messages of gateways into gateway actions
+func (g *mqttGateway) handler(s *Server) mqtt.MessageHandler {
+	return func(client mqtt.Client, message mqtt.Message) {
+		action := strings.TrimPrefix(message.Topic(), g.conf.TopicPrefix+"/")
+		logger.WithFields(logger.Fields{}).Debugf("recv %s: %s", action, message.Payload())
+
+		if action != "status" {
+			s.handleGatewayMessage(WebsocketMessage{
+				Action: action,
+				Body:   message.Payload(),
+			})
+			return
+		}
+
+		online := string(message.Payload()) == "online"
+		g.mutex.Lock()
+		changed := g.online != online
+		g.online = online
+		g.mutex.Unlock()
+		if changed {
+			s.gatewayConnected(online)
+		}
+	}
+}
+
+// Send publish body of message on <prefix>/<action>
+func (g *mqttGateway) Send(message []byte) error {
+	g.mutex.Lock()
+	online := g.online
+	g.mutex.Unlock()
+	if !online || g.client == nil {
+		return errGatewayOffline
+	}
+
+	var wm WebsocketMessage
+	err := json.Unmarshal(message, &wm)
+	if err != nil {
+		return err
+	}
+
+	token := g.client.Publish(g.topic(wm.Action), 1, false, wm.Body)
+	token.Wait()
+	return token.Error()
+}
+
+func (g *mqttGateway) topic(action string) string {
+	return g.conf.TopicPrefix + "/" + action
+}
+
+// localAddress return address to reach a listener on address from this host
+func localAddress(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ItsJimi/casa/config"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestGatewayAuth(t *testing.T) {
+	auth := gatewayAuth{username: "gateway", password: "secret", serverPassword: "internal", prefix: "casa/gateway"}
+
+	logins := []struct {
+		user     string
+		password string
+		allowed  bool
+	}{
+		{"gateway", "secret", true},
+		{mqttServerUsername, "internal", true},
+		{"gateway", "internal", false},
+		{mqttServerUsername, "secret", false},
+		{"", "", false},
+	}
+	for _, login := range logins {
+		if allowed := auth.Authenticate([]byte(login.user), []byte(login.password)); allowed != login.allowed {
+			t.Errorf("Authenticate(%q, %q) = %t, want %t", login.user, login.password, allowed, login.allowed)
+		}
+	}
+	// without credentials, no one connects
+	if (gatewayAuth{serverPassword: "internal"}).Authenticate([]byte(""), []byte("")) {
+		t.Error("Authenticate allowed empty credentials")
+	}
+
+	acls := []struct {
+		user    string
+		topic   string
+		write   bool
+		allowed bool
+	}{
+		{"gateway", "casa/gateway/newData", true, true},
+		{"gateway", "casa/gateway/status", true, true},
+		{"gateway", "casa/gateway/callAction", false, true},
+		{"gateway", "casa/gateway/callAction", true, false},
+		{"gateway", "casa/gateway/newData", false, false},
+		{"gateway", "casa/gateway/#", false, false},
+		{mqttServerUsername, "casa/gateway/newData", false, true},
+		{mqttServerUsername, "casa/gateway/callAction", true, true},
+		{mqttServerUsername, "casa/gateway/newData", true, false},
+		{mqttServerUsername, "casa/other", true, false},
+		{"stranger", "casa/gateway/newData", true, false},
+	}
+	for _, acl := range acls {
+		if allowed := auth.ACL([]byte(acl.user), acl.topic, acl.write); allowed != acl.allowed {
+			t.Errorf("ACL(%q, %q, write %t) = %t, want %t", acl.user, acl.topic, acl.write, allowed, acl.allowed)
+		}
+	}
+}
+
+func TestMQTTGatewayServeWithoutCredentials(t *testing.T) {
+	for _, conf := range []config.GatewayMQTT{
+		{Address: freeAddress(t), TopicPrefix: "casa/gateway"},
+		{Address: freeAddress(t), Username: "gateway", TopicPrefix: "casa/gateway"},
+		{Address: freeAddress(t), Username: mqttServerUsername, Password: "secret", TopicPrefix: "casa/gateway"},
+	} {
+		if err := NewMQTTGatewayTransport(conf).(*mqttGateway).Serve(NewServer(&fakeStore{}, nil)); err == nil {
+			t.Errorf("Serve started broker for %q:%q", conf.Username, conf.Password)
+		}
+	}
+}
+
+// connectGatewayTest connect a client to broker with credentials, it's closed at the end of test
+func connectGatewayTest(t *testing.T, address string, user string, password string) mqtt.Client {
+	t.Helper()
+	client := mqtt.NewClient(mqtt.NewClientOptions().
+		AddBroker("tcp://" + address).
+		SetClientID("gateway-" + user).
+		SetUsername(user).
+		SetPassword(password))
+	token := client.Connect()
+	token.Wait()
+	if token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	t.Cleanup(func() { client.Disconnect(0) })
+	return client
+}
+
+func TestMQTTGatewayTransport(t *testing.T) {
+	conf := config.GatewayMQTT{Address: freeAddress(t), Username: "gateway", Password: "secret", TopicPrefix: "casa/gateway"}
+	store := &fakeStore{
+		rooms:   []Room{{ID: "kitchen", HomeID: "home"}},
+		devices: []Device{{ID: "lamp", RoomID: "kitchen", PhysicalID: "0x01", Plugin: "zigbee", Config: "{}"}},
+	}
+	transport := NewMQTTGatewayTransport(conf).(*mqttGateway)
+	s := NewServer(store, transport)
+	if err := transport.Serve(s); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		transport.client.Disconnect(0)
+		transport.broker.Close()
+		setGatewayOnline(false)
+	})
+
+	intruder := mqtt.NewClient(mqtt.NewClientOptions().AddBroker("tcp://" + conf.Address).SetClientID("intruder").SetUsername("gateway").SetPassword("wrong"))
+	if token := intruder.Connect(); token.Wait() && token.Error() == nil {
+		intruder.Disconnect(0)
+		t.Error("gateway connected with a wrong password")
+	}
+
+	gateway := connectGatewayTest(t, conf.Address, "gateway", "secret")
+	actions := make(chan mqtt.Message, 10)
+	if token := gateway.Subscribe("casa/gateway/callAction", 1, func(client mqtt.Client, message mqtt.Message) {
+		actions <- message
+	}); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+
+	// waitFor poll condition, messages of gateway are handled asynchronously
+	waitFor := func(t *testing.T, what string, condition func() bool) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for !condition() {
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-timeout:
+				t.Fatalf("%s timed out", what)
+			}
+		}
+	}
+
+	t.Run("status", func(t *testing.T) {
+		gateway.Publish("casa/gateway/status", 1, true, "online").Wait()
+		waitFor(t, "online", isGatewayOnline)
+
+		gateway.Publish("casa/gateway/status", 1, true, "offline").Wait()
+		waitFor(t, "offline", func() bool { return !isGatewayOnline() })
+
+		gateway.Publish("casa/gateway/status", 1, true, "online").Wait()
+		waitFor(t, "online again", isGatewayOnline)
+	})
+
+	t.Run("newData", func(t *testing.T) {
+		datas, _ := json.Marshal([]Datas{{DeviceID: "0x01", Field: "temperature", ValueNbr: 21.5}})
+		gateway.Publish("casa/gateway/newData", 1, false, datas).Wait()
+
+		waitFor(t, "SaveNewDatas", func() bool {
+			data, err := store.Datas().Latest("lamp", "temperature")
+			return err == nil && data.ValueNbr == 21.5
+		})
+	})
+
+	t.Run("send", func(t *testing.T) {
+		body, _ := json.Marshal(ActionMessage{PhysicalID: "0x01", Plugin: "zigbee", Call: "toggle"})
+		message, _ := json.Marshal(WebsocketMessage{Action: "callAction", Body: body})
+		if err := transport.Send(message); err != nil {
+			t.Fatal(err)
+		}
+
+		action := waitMessage(t, actions, "casa/gateway/callAction")
+		if string(action.Payload()) != string(body) {
+			t.Errorf("callAction payload = %s, want %s", action.Payload(), body)
+		}
+	})
+
+	t.Run("acl", func(t *testing.T) {
+		// gateway can't impersonate server, its callAction never reaches subscribers,
+		// broker drops it without acknowledging, so it's published at most once
+		gateway.Publish("casa/gateway/callAction", 0, false, "{}").Wait()
+		select {
+		case message := <-actions:
+			t.Errorf("gateway published %s on %s", message.Payload(), message.Topic())
+		case <-time.After(200 * time.Millisecond):
+		}
+	})
+}
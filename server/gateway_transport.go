@@ -0,0 +1,89 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// GatewayTransport define how server reach the connected gateway
+type GatewayTransport interface {
+	// SetAddr define http address announced by gateway
+	SetAddr(addr string)
+	// Send write message to gateway
+	Send(message []byte) error
+	// Fetch return body of gateway http route path
+	Fetch(path string) ([]byte, error)
+}
+
+// gatewayServer is a transport accepting gateways itself instead of websocket route
+type gatewayServer interface {
+	// Serve start accepting gateways, their messages are handled by s
+	Serve(s *Server) error
+}
+
+// errGatewayOffline is returned when no gateway is connected
+var errGatewayOffline = errors.New("No gateway connected")
+
+// gatewayHTTP reach http routes of gateway at its announced address
+type gatewayHTTP struct {
+	addrMutex sync.Mutex
+	addr      string
+}
+
+func (g *gatewayHTTP) SetAddr(addr string) {
+	g.addrMutex.Lock()
+	defer g.addrMutex.Unlock()
+	g.addr = addr
+}
+
+func (g *gatewayHTTP) Fetch(path string) ([]byte, error) {
+	g.addrMutex.Lock()
+	addr := g.addr
+	g.addrMutex.Unlock()
+	if addr == "" {
+		return nil, errGatewayOffline
+	}
+
+	resp, err := http.Get("http://" + addr + path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Gateway answered %s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+type websocketGateway struct {
+	gatewayHTTP
+	mutex sync.Mutex
+	conn  *websocket.Conn
+}
+
+// NewGatewayTransport return transport to a gateway connected on websocket route
+func NewGatewayTransport() GatewayTransport {
+	return &websocketGateway{}
+}
+
+// Attach use conn as gateway websocket
+func (g *websocketGateway) Attach(conn *websocket.Conn) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.conn = conn
+}
+
+func (g *websocketGateway) Send(message []byte) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.conn == nil {
+		return errGatewayOffline
+	}
+	return WebsocketWriteMessage(g.conn, message)
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/ItsJimi/casa/logger"
+	"github.com/labstack/echo"
+)
+
+// Google Smart Home intents, device types, traits and commands
+const (
+	googleSync       = "action.devices.SYNC"
+	googleQuery      = "action.devices.QUERY"
+	googleExecute    = "action.devices.EXECUTE"
+	googleDisconnect = "action.devices.DISCONNECT"
+
+	googleTypeSwitch     = "action.devices.types.SWITCH"
+	googleTypeLight      = "action.devices.types.LIGHT"
+	googleTypeThermostat = "action.devices.types.THERMOSTAT"
+
+	googleTraitOnOff              = "action.devices.traits.OnOff"
+	googleTraitBrightness         = "action.devices.traits.Brightness"
+	googleTraitTemperatureSetting = "action.devices.traits.TemperatureSetting"
+
+	googleCommandOnOff              = "action.devices.commands.OnOff"
+	googleCommandBrightnessAbsolute = "action.devices.commands.BrightnessAbsolute"
+	googleCommandSetpoint           = "action.devices.commands.ThermostatTemperatureSetpoint"
+)
+
+type googleRequest struct {
+	RequestID string        `json:"requestId"`
+	Inputs    []googleInput `json:"inputs"`
+}
+
+type googleInput struct {
+	Intent  string `json:"intent"`
+	Payload struct {
+		Devices  []googleDeviceRef `json:"devices"`
+		Commands []googleCommand   `json:"commands"`
+	} `json:"payload"`
+}
+
+type googleDeviceRef struct {
+	ID string `json:"id"`
+}
+
+type googleCommand struct {
+	Devices   []googleDeviceRef `json:"devices"`
+	Execution []googleExecution `json:"execution"`
+}
+
+type googleExecution struct {
+	Command string                 `json:"command"`
+	Params  map[string]interface{} `json:"params"`
+}
+
+type googleResponse struct {
+	RequestID string      `json:"requestId"`
+	Payload   interface{} `json:"payload,omitempty"`
+}
+
+type googleDevice struct {
+	ID              string                 `json:"id"`
+	Type            string                 `json:"type"`
+	Traits          []string               `json:"traits"`
+	Name            googleDeviceName       `json:"name"`
+	WillReportState bool                   `json:"willReportState"`
+	RoomHint        string                 `json:"roomHint,omitempty"`
+	Attributes      map[string]interface{} `json:"attributes,omitempty"`
+	DeviceInfo      googleDeviceInfo       `json:"deviceInfo"`
+}
+
+type googleDeviceName struct {
+	Name string `json:"name"`
+}
+
+type googleDeviceInfo struct {
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Model        string `json:"model"`
+}
+
+type googleCommandResult struct {
+	IDs       []string               `json:"ids"`
+	Status    string                 `json:"status"`
+	States    map[string]interface{} `json:"states,omitempty"`
+	ErrorCode string                 `json:"errorCode,omitempty"`
+}
+
+// GoogleFulfillment route answer intents of Google Smart Home for devices of user
+func (s *Server) GoogleFulfillment(c echo.Context) error {
+	req := googleRequest{}
+	if err := c.Bind(&req); err != nil {
+		logger.WithFields(logger.Fields{"code": "CSGGF001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSGGF001",
+			Message: "Wrong parameters",
+		})
+	}
+
+	user := c.Get("user").(User)
+	devices, err := s.assistantDevices(user.ID)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSGGF002"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSGGF002",
+			Message: "Devices can't be retrieved",
+		})
+	}
+
+	return c.JSON(http.StatusOK, googleFulfill(req, user.ID, devices, s.assistantSender("google")))
+}
+
+// googleFulfill answer request for devices of user, executed commands are sent with send
+func googleFulfill(req googleRequest, userID string, devices []assistantDevice, send actionSender) googleResponse {
+	res := googleResponse{
+		RequestID: req.RequestID,
+	}
+	if len(req.Inputs) == 0 {
+		res.Payload = map[string]interface{}{"errorCode": "protocolError"}
+		return res
+	}
+
+	input := req.Inputs[0]
+	switch input.Intent {
+	case googleSync:
+		synced := []googleDevice{}
+		for _, device := range devices {
+			if gDevice, ok := googleDeviceFrom(device); ok {
+				synced = append(synced, gDevice)
+			}
+		}
+		res.Payload = map[string]interface{}{
+			"agentUserId": userID,
+			"devices":     synced,
+		}
+	case googleQuery:
+		states := map[string]interface{}{}
+		for _, ref := range input.Payload.Devices {
+			device, ok := findAssistantDevice(devices, ref.ID)
+			if !ok {
+				states[ref.ID] = map[string]interface{}{"status": "ERROR", "errorCode": "deviceNotFound"}
+				continue
+			}
+			states[ref.ID] = googleStates(device)
+		}
+		res.Payload = map[string]interface{}{"devices": states}
+	case googleExecute:
+		results := []googleCommandResult{}
+		for _, command := range input.Payload.Commands {
+			for _, ref := range command.Devices {
+				results = append(results, googleRun(devices, ref.ID, command.Execution, send))
+			}
+		}
+		res.Payload = map[string]interface{}{"commands": results}
+	case googleDisconnect:
+	default:
+		res.Payload = map[string]interface{}{"errorCode": "notSupported"}
+	}
+	return res
+}
+
+// googleDeviceFrom describe device with Google traits, false when device has none
+func googleDeviceFrom(device assistantDevice) (googleDevice, bool) {
+	gDevice := googleDevice{
+		ID:         device.ID,
+		Name:       googleDeviceName{Name: device.Name},
+		RoomHint:   device.RoomName,
+		Traits:     []string{},
+		Attributes: map[string]interface{}{},
+		DeviceInfo: googleDeviceInfo{
+			Manufacturer: device.Manufacturer,
+			Model:        device.PhysicalName,
+		},
+	}
+	if device.onOff() {
+		gDevice.Type = googleTypeSwitch
+		gDevice.Traits = append(gDevice.Traits, googleTraitOnOff)
+	}
+	if _, ok := device.brightness(); ok {
+		gDevice.Type = googleTypeLight
+		gDevice.Traits = append(gDevice.Traits, googleTraitBrightness)
+	}
+	if device.thermostat() || device.thermometer() {
+		gDevice.Type = googleTypeThermostat
+		gDevice.Traits = append(gDevice.Traits, googleTraitTemperatureSetting)
+		gDevice.Attributes["thermostatTemperatureUnit"] = "C"
+		if device.thermostat() {
+			gDevice.Attributes["availableThermostatModes"] = []string{"heat"}
+		} else {
+			gDevice.Attributes["availableThermostatModes"] = []string{"off"}
+			gDevice.Attributes["queryOnlyTemperatureSetting"] = true
+		}
+	}
+	return gDevice, len(gDevice.Traits) > 0
+}
+
+// googleStates return known states of device traits
+func googleStates(device assistantDevice) map[string]interface{} {
+	states := map[string]interface{}{
+		"online": true,
+		"status": "SUCCESS",
+	}
+	if on, ok := device.isOn(); ok && device.onOff() {
+		states["on"] = on
+	}
+	if brightness, ok := device.brightnessPercent(); ok {
+		states["brightness"] = brightness
+	}
+	if device.thermostat() || device.thermometer() {
+		states["thermostatMode"] = "off"
+		if device.thermostat() {
+			states["thermostatMode"] = "heat"
+		}
+		if ambient, ok := device.ambientTemperature(); ok {
+			states["thermostatTemperatureAmbient"] = ambient
+		}
+		if target, ok := device.targetTemperature(); ok {
+			states["thermostatTemperatureSetpoint"] = target
+		}
+	}
+	return states
+}
+
+// googleRun execute commands on device and return result with its new states
+func googleRun(devices []assistantDevice, ID string, executions []googleExecution, send actionSender) googleCommandResult {
+	device, ok := findAssistantDevice(devices, ID)
+	if !ok {
+		return googleCommandResult{IDs: []string{ID}, Status: "ERROR", ErrorCode: "deviceNotFound"}
+	}
+
+	for _, execution := range executions {
+		var err error
+		switch execution.Command {
+		case googleCommandOnOff:
+			on, ok := execution.Params["on"].(bool)
+			err = errAssistantValue
+			if ok {
+				err = device.setOn(on, send)
+			}
+		case googleCommandBrightnessAbsolute:
+			brightness, ok := execution.Params["brightness"].(float64)
+			err = errAssistantValue
+			if ok {
+				err = device.setBrightness(int(brightness), send)
+			}
+		case googleCommandSetpoint:
+			setpoint, ok := execution.Params["thermostatTemperatureSetpoint"].(float64)
+			err = errAssistantValue
+			if ok {
+				err = device.setTargetTemperature(setpoint, send)
+			}
+		default:
+			err = errAssistantNotSupported
+		}
+		if err != nil {
+			return googleCommandResult{IDs: []string{ID}, Status: "ERROR", ErrorCode: googleErrorCode(err)}
+		}
+	}
+
+	return googleCommandResult{IDs: []string{ID}, Status: "SUCCESS", States: googleStates(device)}
+}
+
+func googleErrorCode(err error) string {
+	switch err {
+	case errAssistantNotSupported, errAssistantCantTurnOn, errAssistantCantTurnOff:
+		return "functionNotSupported"
+	case errAssistantForbidden:
+		return "authFailure"
+	case errAssistantValue:
+		return "valueOutOfRange"
+	case errGatewayOffline:
+		return "deviceOffline"
+	}
+	return "transientError"
+}
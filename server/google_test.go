@@ -0,0 +1,21 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestGoogleFulfill(t *testing.T) {
+	runGoldens(t, "google", func(request []byte, send actionSender) interface{} {
+		var req googleRequest
+		if err := json.Unmarshal(request, &req); err != nil {
+			t.Fatal(err)
+		}
+		// devices are read for each request, like the route does, so commands don't change the next golden
+		devices, err := newAssistantFixture().assistantDevices("user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return googleFulfill(req, "user", devices, send)
+	})
+}
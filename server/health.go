@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/ItsJimi/casa/migrations"
+	"github.com/labstack/echo"
+)
+
+// automationsHeartbeat store the unix nano time of the last automations loop
+var automationsHeartbeat int64
+
+// gatewayOnline is 1 while a gateway websocket is connected
+var gatewayOnline int32
+
+// automationsInterval store the interval of the running automations engine
+var automationsInterval time.Duration
+
+// automationsMinDelay define minimal delay after which automations engine is considered dead
+const automationsMinDelay = 10 * time.Second
+
+// CheckResult define the result of a readiness check
+type CheckResult struct {
+	Status    string  `json:"status"` // ok, warn, fail
+	LatencyMs float64 `json:"latencyMs"`
+	Message   string  `json:"message,omitempty"`
+}
+
+// ReadinessResponse define json response of readiness route
+type ReadinessResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+type readinessCheck struct {
+	Name     string
+	Critical bool
+	Check    func(s *Server, ctx context.Context) error
+}
+
+var readinessChecks = []readinessCheck{
+	{Name: "database", Critical: true, Check: (*Server).checkDatabase},
+	{Name: "schema", Critical: true, Check: (*Server).checkSchema},
+	{Name: "automations", Critical: true, Check: (*Server).checkAutomations},
+	{Name: "gateway", Critical: false, Check: (*Server).checkGateway},
+}
+
+// disableReadinessCheck remove check named name from readiness checks
+func disableReadinessCheck(name string) {
+	checks := []readinessCheck{}
+	for _, check := range readinessChecks {
+		if check.Name != name {
+			checks = append(checks, check)
+		}
+	}
+	readinessChecks = checks
+}
+
+func setGatewayOnline(online bool) {
+	if online {
+		atomic.StoreInt32(&gatewayOnline, 1)
+		gatewayConnected.Set(1)
+		return
+	}
+	atomic.StoreInt32(&gatewayOnline, 0)
+	gatewayConnected.Set(0)
+}
+
+func isGatewayOnline() bool {
+	return atomic.LoadInt32(&gatewayOnline) == 1
+}
+
+func (s *Server) checkDatabase(ctx context.Context) error {
+	if s.db == nil {
+		return errors.New("Database isn't started")
+	}
+	return s.db.Ping(ctx)
+}
+
+func (s *Server) checkSchema(ctx context.Context) error {
+	if s.db == nil {
+		return errors.New("Database isn't started")
+	}
+	current, err := s.db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current < migrations.Latest() {
+		return errors.New("Database schema is behind, run casa migrate up")
+	}
+	return nil
+}
+
+func (s *Server) checkAutomations(ctx context.Context) error {
+	last := atomic.LoadInt64(&automationsHeartbeat)
+	if last == 0 {
+		return errors.New("Automations engine isn't started")
+	}
+	maxDelay := automationsMinDelay
+	if 5*automationsInterval > maxDelay {
+		maxDelay = 5 * automationsInterval
+	}
+	if time.Since(time.Unix(0, last)) > maxDelay {
+		return errors.New("Automations engine is stalled since " + time.Unix(0, last).Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (s *Server) checkGateway(ctx context.Context) error {
+	if !isGatewayOnline() {
+		return errors.New("No gateway connected")
+	}
+	return nil
+}
+
+// Healthz route tell if process is alive
+func (s *Server) Healthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, MessageResponse{
+		Message: "ok",
+	})
+}
+
+// Readyz route check dependencies of casa server
+func (s *Server) Readyz(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), 2*time.Second)
+	defer cancel()
+
+	res := ReadinessResponse{
+		Status: "ok",
+		Checks: map[string]CheckResult{},
+	}
+	for _, check := range readinessChecks {
+		start := time.Now()
+		err := check.Check(s, ctx)
+		result := CheckResult{
+			Status:    "ok",
+			LatencyMs: float64(time.Since(start)) / float64(time.Millisecond),
+		}
+		if err != nil {
+			result.Message = err.Error()
+			result.Status = "warn"
+			if check.Critical {
+				result.Status = "fail"
+				res.Status = "fail"
+			}
+		}
+		res.Checks[check.Name] = result
+	}
+
+	if res.Status != "ok" {
+		return c.JSON(http.StatusServiceUnavailable, res)
+	}
+	return c.JSON(http.StatusOK, res)
+}
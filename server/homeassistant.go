@@ -0,0 +1,178 @@
+package server
+
+import (
+	"encoding/json"
+	"regexp"
+
+	"github.com/ItsJimi/casa/logger"
+	"github.com/getcasa/sdk"
+)
+
+// brightnessAction is the action making a device with a default action a dimmable light
+const brightnessAction = "brightness"
+
+var discoveryInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// discoveryEntity is an entity announced to Home Assistant
+type discoveryEntity struct {
+	component string
+	objectID  string
+	config    map[string]interface{}
+}
+
+// discoveryEntities describe device with Home Assistant entities from triggers and actions of its plugin
+func (s *Server) discoveryEntities(bridge *mqttBridge, device Device, homeID string) []discoveryEntity {
+	plugin := configFromPlugin(s.configs, device.Plugin)
+	pluginDevice := sdk.FindDevicesFromName(plugin.Devices, device.PhysicalName)
+	if pluginDevice.Name == "" {
+		return nil
+	}
+
+	base := func(name string, objectID string) map[string]interface{} {
+		return map[string]interface{}{
+			"name":               name,
+			"unique_id":          "casa_" + device.ID + "_" + objectID,
+			"availability_topic": bridge.topic("status"),
+			"device": map[string]interface{}{
+				"identifiers":  []string{"casa_" + device.ID},
+				"name":         device.Name,
+				"model":        device.PhysicalName,
+				"manufacturer": plugin.Author,
+			},
+		}
+	}
+	topic := func(levels ...string) string {
+		return bridge.topic(append([]string{homeID, device.RoomID, device.ID}, levels...)...)
+	}
+
+	entities := []discoveryEntity{}
+	used := ""
+	defaultTrigger := FindFieldFromName(pluginDevice.Triggers, pluginDevice.DefaultTrigger)
+	if pluginDevice.DefaultAction != "" && defaultTrigger.Type == "bool" {
+		component := "switch"
+		config := base(device.Name, pluginDevice.DefaultAction)
+		config["command_topic"] = topic(pluginDevice.DefaultAction, "set")
+		config["payload_on"] = "true"
+		config["payload_off"] = "false"
+		config["state_topic"] = topic(defaultTrigger.Name)
+		config["state_on"] = "true"
+		config["state_off"] = "false"
+		used = defaultTrigger.Name
+		if hasAction(pluginDevice, brightnessAction) {
+			component = "light"
+			config["brightness_command_topic"] = topic(brightnessAction, "set")
+			for _, field := range findActionFromName(plugin.Actions, brightnessAction).Fields {
+				if field.Max > 0 {
+					config["brightness_scale"] = field.Max
+					break
+				}
+			}
+		}
+		entities = append(entities, discoveryEntity{
+			component: component,
+			objectID:  pluginDevice.DefaultAction,
+			config:    config,
+		})
+	}
+
+	for _, trigger := range pluginDevice.Triggers {
+		if trigger.Name == used {
+			continue
+		}
+		config := base(device.Name+" "+trigger.Name, trigger.Name)
+		config["state_topic"] = topic(trigger.Name)
+		component := "sensor"
+		if trigger.Type == "bool" {
+			component = "binary_sensor"
+			config["payload_on"] = "true"
+			config["payload_off"] = "false"
+		}
+		entities = append(entities, discoveryEntity{
+			component: component,
+			objectID:  trigger.Name,
+			config:    config,
+		})
+	}
+
+	return entities
+}
+
+// discoveryTopic return topic of entity config, <discovery_prefix>/<component>/casa_<device>/<object>/config
+func discoveryTopic(bridge *mqttBridge, component string, deviceID string, objectID string) string {
+	return bridge.conf.DiscoveryPrefix + "/" + component + "/casa_" + deviceID + "/" + discoveryInvalidChars.ReplaceAllString(objectID, "_") + "/config"
+}
+
+// publishDiscovery announce every device to Home Assistant
+func (s *Server) publishDiscovery() {
+	if s.mqtt == nil || !s.mqtt.conf.Discovery || !s.mqtt.client.IsConnected() {
+		return
+	}
+
+	devices, err := s.db.Devices().All()
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHPD001"}).Errorf("%s", err.Error())
+		return
+	}
+
+	homes := map[string]string{}
+	for _, device := range devices {
+		homeID, ok := homes[device.RoomID]
+		if !ok {
+			room, err := s.db.Rooms().ByID(device.RoomID)
+			if err != nil {
+				logger.WithFields(logger.Fields{"code": "CSHPD002"}).Errorf("%s", err.Error())
+				continue
+			}
+			homeID = room.HomeID
+			homes[device.RoomID] = homeID
+		}
+		s.publishDeviceDiscovery(device, homeID)
+	}
+}
+
+// publishDeviceDiscovery announce entities of device to Home Assistant
+func (s *Server) publishDeviceDiscovery(device Device, homeID string) {
+	if s.mqtt == nil || !s.mqtt.conf.Discovery {
+		return
+	}
+
+	for _, entity := range s.discoveryEntities(s.mqtt, device, homeID) {
+		payload, err := json.Marshal(entity.config)
+		if err != nil {
+			logger.WithFields(logger.Fields{"code": "CSHPDD001"}).Errorf("%s", err.Error())
+			continue
+		}
+		s.mqtt.client.Publish(discoveryTopic(s.mqtt, entity.component, device.ID, entity.objectID), s.mqtt.conf.QoS, true, payload)
+	}
+}
+
+// removeDeviceDiscovery remove entities of device from Home Assistant with empty retained configs
+func (s *Server) removeDeviceDiscovery(device Device) {
+	if s.mqtt == nil || !s.mqtt.conf.Discovery {
+		return
+	}
+
+	for _, entity := range s.discoveryEntities(s.mqtt, device, "") {
+		s.mqtt.client.Publish(discoveryTopic(s.mqtt, entity.component, device.ID, entity.objectID), s.mqtt.conf.QoS, true, "")
+	}
+}
+
+// hasAction tell if device of plugin can be called with action
+func hasAction(device sdk.Device, action string) bool {
+	for _, name := range device.Actions {
+		if name == action {
+			return true
+		}
+	}
+	return false
+}
+
+// findActionFromName find action with name in plugin actions
+func findActionFromName(actions []sdk.Action, name string) sdk.Action {
+	for _, action := range actions {
+		if action.Name == name {
+			return action
+		}
+	}
+	return sdk.Action{}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ItsJimi/casa/config"
+	"github.com/getcasa/sdk"
+)
+
+func TestDiscoveryEntities(t *testing.T) {
+	s := NewServer(&fakeStore{}, nil)
+	s.configs = []sdk.Configuration{{
+		Name:   "zigbee",
+		Author: "Casa",
+		Devices: []sdk.Device{
+			{
+				Name:           "bulb",
+				DefaultTrigger: "on",
+				DefaultAction:  "switch",
+				Triggers:       []sdk.Trigger{{Name: "on", Type: "bool"}, {Name: "brightness", Type: "int"}},
+				Actions:        []string{"switch", "brightness"},
+			},
+			{
+				Name:           "plug",
+				DefaultTrigger: "on",
+				DefaultAction:  "switch",
+				Triggers:       []sdk.Trigger{{Name: "on", Type: "bool"}, {Name: "power", Type: "float"}},
+				Actions:        []string{"switch"},
+			},
+			{
+				// default trigger isn't a bool, brightness alone doesn't make it a light
+				Name:           "dimmer",
+				DefaultTrigger: "level",
+				DefaultAction:  "switch",
+				Triggers:       []sdk.Trigger{{Name: "level", Type: "int"}},
+				Actions:        []string{"switch", "brightness"},
+			},
+			{
+				Name:     "door",
+				Triggers: []sdk.Trigger{{Name: "open", Type: "bool"}, {Name: "battery", Type: "int"}},
+			},
+			{
+				Name: "empty",
+			},
+		},
+		Actions: []sdk.Action{
+			{Name: "switch", Fields: []sdk.Field{{Name: "state", Type: "bool"}}},
+			{Name: "brightness", Fields: []sdk.Field{{Name: "level", Type: "int", Max: 254}}},
+		},
+	}}
+	bridge := &mqttBridge{conf: config.MQTT{TopicPrefix: "casa", DiscoveryPrefix: "homeassistant"}}
+
+	// entity is the component and object of an entity with the topics it uses
+	type entity struct {
+		component  string
+		objectID   string
+		state      interface{}
+		command    interface{}
+		brightness interface{}
+	}
+	tests := []struct {
+		physicalName string
+		want         []entity
+	}{
+		{"bulb", []entity{
+			{"light", "switch", "casa/home/kitchen/device/on", "casa/home/kitchen/device/switch/set", "casa/home/kitchen/device/brightness/set"},
+			{"sensor", "brightness", "casa/home/kitchen/device/brightness", nil, nil},
+		}},
+		{"plug", []entity{
+			{"switch", "switch", "casa/home/kitchen/device/on", "casa/home/kitchen/device/switch/set", nil},
+			{"sensor", "power", "casa/home/kitchen/device/power", nil, nil},
+		}},
+		{"dimmer", []entity{
+			{"sensor", "level", "casa/home/kitchen/device/level", nil, nil},
+		}},
+		{"door", []entity{
+			{"binary_sensor", "open", "casa/home/kitchen/device/open", nil, nil},
+			{"sensor", "battery", "casa/home/kitchen/device/battery", nil, nil},
+		}},
+		{"empty", []entity{}},
+		{"unknown", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.physicalName, func(t *testing.T) {
+			device := Device{ID: "device", Name: "Device", RoomID: "kitchen", Plugin: "zigbee", PhysicalName: test.physicalName}
+			entities := s.discoveryEntities(bridge, device, "home")
+
+			var got []entity
+			if entities != nil {
+				got = []entity{}
+			}
+			for _, e := range entities {
+				got = append(got, entity{e.component, e.objectID, e.config["state_topic"], e.config["command_topic"], e.config["brightness_command_topic"]})
+				if e.config["unique_id"] != "casa_device_"+e.objectID || e.config["availability_topic"] != "casa/status" {
+					t.Errorf("%s config = %v", e.objectID, e.config)
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("entities = %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	t.Run("brightness scale", func(t *testing.T) {
+		entities := s.discoveryEntities(bridge, Device{ID: "device", RoomID: "kitchen", Plugin: "zigbee", PhysicalName: "bulb"}, "home")
+		if scale := entities[0].config["brightness_scale"]; scale != 254 {
+			t.Errorf("brightness_scale = %v, want 254", scale)
+		}
+	})
+}
@@ -0,0 +1,344 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"strings"
+	"sync"
+
+	"github.com/ItsJimi/casa/config"
+	"github.com/ItsJimi/casa/logger"
+	"github.com/brutella/hap"
+	"github.com/brutella/hap/accessory"
+	"github.com/brutella/hap/characteristic"
+	"github.com/brutella/hap/service"
+)
+
+// homekitBridges run a HomeKit bridge for each home with devices
+type homekitBridges struct {
+	conf config.HomeKit
+	// reload serialize rebuilds of bridges
+	reload  sync.Mutex
+	mutex   sync.Mutex
+	bridges map[string]*homekitBridge
+}
+
+type homekitBridge struct {
+	cancel      context.CancelFunc
+	done        chan struct{}
+	accessories map[string]*homekitAccessory
+}
+
+// homekitAccessory is a device exposed to HomeKit with characteristics updated from its datas
+type homekitAccessory struct {
+	*accessory.A
+	device     assistantDevice
+	on         *characteristic.On
+	brightness *characteristic.Brightness
+	current    *characteristic.CurrentTemperature
+	target     *characteristic.TargetTemperature
+	contacts   map[string]*characteristic.ContactSensorState
+}
+
+// homekitPairings persist pairings and keys of a home bridge in database
+type homekitPairings struct {
+	db     Store
+	homeID string
+}
+
+func (st homekitPairings) Set(key string, value []byte) error {
+	return st.db.HomeKit().Set(st.homeID, key, value)
+}
+
+func (st homekitPairings) Get(key string) ([]byte, error) {
+	return st.db.HomeKit().Get(st.homeID, key)
+}
+
+func (st homekitPairings) Delete(key string) error {
+	return st.db.HomeKit().Delete(st.homeID, key)
+}
+
+func (st homekitPairings) KeysWithSuffix(suffix string) ([]string, error) {
+	keys, err := st.db.HomeKit().Keys(st.homeID)
+	if err != nil {
+		return nil, err
+	}
+	matching := []string{}
+	for _, key := range keys {
+		if strings.HasSuffix(key, suffix) {
+			matching = append(matching, key)
+		}
+	}
+	return matching, nil
+}
+
+// StartHomeKit expose devices of each home as a HomeKit bridge on local network, bridges don't start without a pin
+func (s *Server) StartHomeKit(conf config.HomeKit) {
+	if err := checkHomeKitPin(conf.Pin); err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHKSHK001"}).Errorf("HomeKit bridges aren't started: %s", err.Error())
+		return
+	}
+	s.homekit = &homekitBridges{
+		conf:    conf,
+		bridges: map[string]*homekitBridge{},
+	}
+	go s.reloadHomeKit("")
+}
+
+// reloadHomeKit rebuild bridge of home, or of every home when homeID is empty, after devices or plugins changed
+func (s *Server) reloadHomeKit(homeID string) {
+	if s.homekit == nil {
+		return
+	}
+	s.homekit.reload.Lock()
+	defer s.homekit.reload.Unlock()
+
+	devices, err := s.db.Devices().All()
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHKRHK001"}).Errorf("%s", err.Error())
+		return
+	}
+
+	rooms := map[string]Room{}
+	homes := map[string][]assistantDevice{}
+	for _, device := range devices {
+		room, ok := rooms[device.RoomID]
+		if !ok {
+			room, err = s.db.Rooms().ByID(device.RoomID)
+			if err != nil {
+				logger.WithFields(logger.Fields{"code": "CSHKRHK002"}).Errorf("%s", err.Error())
+				continue
+			}
+			rooms[device.RoomID] = room
+		}
+		if homeID != "" && room.HomeID != homeID {
+			continue
+		}
+		if aDevice, ok := s.newAssistantDevice(device, room.Name, true); ok {
+			homes[room.HomeID] = append(homes[room.HomeID], aDevice)
+		}
+	}
+
+	stopped := []*homekitBridge{}
+	s.homekit.mutex.Lock()
+	for id, bridge := range s.homekit.bridges {
+		if homeID == "" || id == homeID {
+			stopped = append(stopped, bridge)
+			delete(s.homekit.bridges, id)
+		}
+	}
+	s.homekit.mutex.Unlock()
+	for _, bridge := range stopped {
+		bridge.cancel()
+		<-bridge.done
+	}
+
+	for id, homeDevices := range homes {
+		s.startHomeKitBridge(id, homeDevices)
+	}
+}
+
+// startHomeKitBridge serve devices of home as accessories of a bridge
+func (s *Server) startHomeKitBridge(homeID string, devices []assistantDevice) {
+	home, err := s.db.Homes().ByID(homeID)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHKSHKB001"}).Errorf("%s", err.Error())
+		return
+	}
+
+	bridge := &homekitBridge{
+		done:        make(chan struct{}),
+		accessories: map[string]*homekitAccessory{},
+	}
+	hapBridge := accessory.NewBridge(accessory.Info{
+		Name:         home.Name,
+		Manufacturer: "Casa",
+		SerialNumber: home.ID,
+	})
+	hapBridge.Id = 1
+
+	accessories := []*accessory.A{}
+	for _, device := range devices {
+		a, ok := s.homekitAccessoryFrom(device)
+		if !ok {
+			continue
+		}
+		bridge.accessories[device.ID] = a
+		accessories = append(accessories, a.A)
+	}
+	if len(accessories) == 0 {
+		return
+	}
+
+	server, err := hap.NewServer(homekitPairings{db: s.db, homeID: homeID}, hapBridge.A, accessories...)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHKSHKB002"}).Errorf("%s", err.Error())
+		return
+	}
+	server.Pin = s.homekit.conf.Pin
+
+	ctx, cancel := context.WithCancel(context.Background())
+	bridge.cancel = cancel
+	s.homekit.mutex.Lock()
+	s.homekit.bridges[homeID] = bridge
+	s.homekit.mutex.Unlock()
+
+	go func() {
+		defer close(bridge.done)
+		logger.WithFields(logger.Fields{}).Infof("HomeKit bridge %s serves %d devices", home.Name, len(accessories))
+		if err := server.ListenAndServe(ctx); err != nil && ctx.Err() == nil {
+			logger.WithFields(logger.Fields{"code": "CSHKSHKB003"}).Errorf("%s", err.Error())
+		}
+	}()
+}
+
+// homekitAccessoryFrom describe device with HomeKit services, false when device has none
+func (s *Server) homekitAccessoryFrom(device assistantDevice) (*homekitAccessory, bool) {
+	info := accessory.Info{
+		Name:         device.Name,
+		Manufacturer: device.Manufacturer,
+		Model:        device.PhysicalName,
+		SerialNumber: device.PhysicalID,
+	}
+	a := &homekitAccessory{
+		device:   device,
+		contacts: map[string]*characteristic.ContactSensorState{},
+	}
+
+	_, dimmable := device.brightness()
+	switch {
+	case device.onOff() && dimmable:
+		light := accessory.NewLightbulb(info)
+		a.A, a.on = light.A, light.Lightbulb.On
+		a.brightness = characteristic.NewBrightness()
+		light.Lightbulb.AddC(a.brightness.C)
+	case device.onOff():
+		switcher := accessory.NewSwitch(info)
+		a.A, a.on = switcher.A, switcher.Switch.On
+	case device.thermostat():
+		thermostat := accessory.NewThermostat(info)
+		a.A = thermostat.A
+		a.current, a.target = thermostat.Thermostat.CurrentTemperature, thermostat.Thermostat.TargetTemperature
+		thermostat.Thermostat.CurrentHeatingCoolingState.SetValue(characteristic.CurrentHeatingCoolingStateHeat)
+		thermostat.Thermostat.TargetHeatingCoolingState.SetValue(characteristic.TargetHeatingCoolingStateHeat)
+	case device.thermometer():
+		thermometer := accessory.NewTemperatureSensor(info)
+		a.A, a.current = thermometer.A, thermometer.TempSensor.CurrentTemperature
+	default:
+		a.A = accessory.New(info, accessory.TypeSensor)
+	}
+
+	// a switch or a light can also report temperature
+	if a.current == nil && device.thermometer() {
+		thermometer := service.NewTemperatureSensor()
+		a.AddS(thermometer.S)
+		a.current = thermometer.CurrentTemperature
+	}
+	if a.current != nil {
+		a.current.SetMinValue(-50)
+	}
+	// other bool triggers are contact sensors named like trigger
+	for _, trigger := range device.Plugin.Triggers {
+		if trigger.Type != "bool" || (a.on != nil && trigger.Name == device.Plugin.DefaultTrigger) {
+			continue
+		}
+		contact := service.NewContactSensor()
+		name := characteristic.NewName()
+		name.SetValue(trigger.Name)
+		contact.AddC(name.C)
+		a.AddS(contact.S)
+		a.contacts[trigger.Name] = contact.ContactSensorState
+	}
+	// first service is accessory information
+	if len(a.Ss) < 2 {
+		return nil, false
+	}
+	a.Id = homekitID(device.ID)
+
+	for _, data := range device.States {
+		a.update(data)
+	}
+
+	send := s.assistantSender("homekit")
+	if a.on != nil {
+		a.on.OnSetRemoteValue(func(on bool) error {
+			s.homekit.mutex.Lock()
+			defer s.homekit.mutex.Unlock()
+			return a.device.setOn(on, send)
+		})
+	}
+	if a.brightness != nil {
+		a.brightness.OnSetRemoteValue(func(percent int) error {
+			s.homekit.mutex.Lock()
+			defer s.homekit.mutex.Unlock()
+			return a.device.setBrightness(percent, send)
+		})
+	}
+	if a.target != nil {
+		a.target.OnSetRemoteValue(func(celsius float64) error {
+			s.homekit.mutex.Lock()
+			defer s.homekit.mutex.Unlock()
+			return a.device.setTargetTemperature(celsius, send)
+		})
+	}
+	return a, true
+}
+
+// update set characteristics of accessory from a data of its device
+func (a *homekitAccessory) update(data Datas) {
+	a.device.States[data.Field] = data
+	switch {
+	case a.on != nil && data.Field == a.device.Plugin.DefaultTrigger:
+		a.on.SetValue(data.ValueBool)
+	case a.brightness != nil && data.Field == brightnessAction:
+		if percent, ok := a.device.brightnessPercent(); ok {
+			a.brightness.SetValue(percent)
+		}
+	case a.current != nil && data.Field == temperatureTrigger:
+		a.current.SetValue(data.ValueNbr)
+	case a.target != nil && data.Field == setpointAction:
+		a.target.SetValue(data.ValueNbr)
+	case a.contacts[data.Field] != nil:
+		a.contacts[data.Field].SetValue(contactState(data.ValueBool))
+	}
+}
+
+// updateHomeKit update accessory of device with data received from gateway
+func (s *Server) updateHomeKit(data Datas) {
+	s.homekit.mutex.Lock()
+	defer s.homekit.mutex.Unlock()
+	for _, bridge := range s.homekit.bridges {
+		if a, ok := bridge.accessories[data.DeviceID]; ok {
+			a.update(data)
+		}
+	}
+}
+
+// checkHomeKitPin refuse pins HomeKit doesn't accept, anyone on local network could pair with a known pin
+func checkHomeKitPin(pin string) error {
+	if pin == "" {
+		return errors.New("homekit.pin isn't set")
+	}
+	if len(pin) != 8 || strings.Trim(pin, "0123456789") != "" {
+		return errors.New("homekit.pin must be 8 digits")
+	}
+	if hap.InvalidPins[pin] {
+		return errors.New("homekit.pin is too easy to guess")
+	}
+	return nil
+}
+
+func contactState(detected bool) int {
+	if detected {
+		return characteristic.ContactSensorStateContactDetected
+	}
+	return characteristic.ContactSensorStateContactNotDetected
+}
+
+// homekitID return a stable accessory id of device, 1 is the bridge
+func homekitID(deviceID string) uint64 {
+	h := fnv.New32a()
+	h.Write([]byte(deviceID))
+	return uint64(h.Sum32()) + 2
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/ItsJimi/casa/utils"
+	"github.com/brutella/hap/accessory"
+	"github.com/brutella/hap/characteristic"
+	"github.com/brutella/hap/service"
+	"github.com/getcasa/sdk"
+)
+
+func TestCheckHomeKitPin(t *testing.T) {
+	tests := []struct {
+		pin   string
+		valid bool
+	}{
+		{"", false},
+		{"1234", false},
+		{"0314515a", false},
+		{"12345678", false},
+		{"00000000", false},
+		{"03145154", true},
+	}
+	for _, test := range tests {
+		if err := checkHomeKitPin(test.pin); (err == nil) != test.valid {
+			t.Errorf("checkHomeKitPin(%q) = %v, want valid %t", test.pin, err, test.valid)
+		}
+	}
+}
+
+func TestHomeKitAccessoryFrom(t *testing.T) {
+	s := newAssistantFixture()
+	s.homekit = &homekitBridges{bridges: map[string]*homekitBridge{}}
+	s.configs = append(s.configs, sdk.Configuration{
+		Name: "aqara",
+		Devices: []sdk.Device{
+			{
+				Name:     "door",
+				Triggers: []sdk.Trigger{{Name: "open", Type: "bool"}, {Name: "battery", Type: "int"}},
+			},
+			{
+				Name:           "plug",
+				DefaultTrigger: "on",
+				DefaultAction:  "switch",
+				Triggers:       []sdk.Trigger{{Name: "on", Type: "bool"}, {Name: "overload", Type: "bool"}, {Name: "temperature", Type: "int"}},
+				Actions:        []string{"switch"},
+			},
+		},
+		Actions: []sdk.Action{{Name: "switch", Fields: []sdk.Field{{Name: "state", Type: "bool"}}}},
+	})
+
+	// services of accessory but its information, with the values they show, devices without datas show HomeKit defaults
+	type expected struct {
+		services   []string
+		on         interface{}
+		brightness interface{}
+		current    interface{}
+		target     interface{}
+		contacts   map[string]int
+	}
+	tests := []struct {
+		device  Device
+		exposed bool
+		want    expected
+	}{
+		{
+			device:  Device{ID: "lamp", Plugin: "hue", PhysicalName: "bulb"},
+			exposed: true,
+			want:    expected{services: []string{service.TypeLightbulb}, on: true, brightness: 50},
+		},
+		{
+			device:  Device{ID: "plug", Plugin: "hue", PhysicalName: "plug"},
+			exposed: true,
+			want:    expected{services: []string{service.TypeSwitch}, on: false},
+		},
+		{
+			// a button only trigger has no HomeKit service
+			device: Device{ID: "remote", Plugin: "hue", PhysicalName: "remote"},
+		},
+		{
+			device:  Device{ID: "heater", Plugin: "netatmo", PhysicalName: "thermostat"},
+			exposed: true,
+			want:    expected{services: []string{service.TypeThermostat}, current: 18.5, target: 19.0},
+		},
+		{
+			device:  Device{ID: "station", Plugin: "netatmo", PhysicalName: "station"},
+			exposed: true,
+			want:    expected{services: []string{service.TypeTemperatureSensor}, current: 21.3},
+		},
+		{
+			device:  Device{ID: "door", Plugin: "aqara", PhysicalName: "door"},
+			exposed: true,
+			want: expected{
+				services: []string{service.TypeContactSensor},
+				contacts: map[string]int{"open": characteristic.ContactSensorStateContactDetected},
+			},
+		},
+		{
+			// default trigger is the switch, other bool triggers are contacts, temperature is a sensor of the switch
+			device:  Device{ID: "outlet", Plugin: "aqara", PhysicalName: "plug"},
+			exposed: true,
+			want: expected{
+				services: []string{service.TypeSwitch, service.TypeTemperatureSensor, service.TypeContactSensor},
+				on:       false,
+				current:  0.0,
+				contacts: map[string]int{"overload": characteristic.ContactSensorStateContactDetected},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.device.ID, func(t *testing.T) {
+			device, ok := s.newAssistantDevice(test.device, "Living room", true)
+			if !ok {
+				t.Fatal("device has no plugin")
+			}
+			a, exposed := s.homekitAccessoryFrom(device)
+			if exposed != test.exposed {
+				t.Fatalf("exposed = %t, want %t", exposed, test.exposed)
+			}
+			if !exposed {
+				return
+			}
+			if a.Id != homekitID(test.device.ID) {
+				t.Errorf("id = %d, want %d", a.Id, homekitID(test.device.ID))
+			}
+
+			got := expected{services: []string{}}
+			for _, s := range a.Ss {
+				if s.Type != service.TypeAccessoryInformation {
+					got.services = append(got.services, s.Type)
+				}
+			}
+			if a.on != nil {
+				got.on = a.on.Value()
+			}
+			if a.brightness != nil {
+				got.brightness = a.brightness.Value()
+			}
+			if a.current != nil {
+				got.current = a.current.Value()
+			}
+			if a.target != nil {
+				got.target = a.target.Value()
+			}
+			if len(a.contacts) > 0 {
+				got.contacts = map[string]int{}
+				for name, contact := range a.contacts {
+					got.contacts[name] = contact.Value()
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("accessory = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+
+	t.Run("update", func(t *testing.T) {
+		device, _ := s.newAssistantDevice(Device{ID: "door", Plugin: "aqara", PhysicalName: "door"}, "Living room", true)
+		a, _ := s.homekitAccessoryFrom(device)
+		a.update(Datas{DeviceID: "door", Field: "open", ValueBool: false})
+		if state := a.contacts["open"].Value(); state != characteristic.ContactSensorStateContactNotDetected {
+			t.Errorf("contact state = %d, want not detected", state)
+		}
+	})
+
+	// a lightbulb is an accessory of type lightbulb, not a bare sensor
+	device, _ := s.newAssistantDevice(Device{ID: "lamp", Plugin: "hue", PhysicalName: "bulb"}, "Living room", true)
+	if a, _ := s.homekitAccessoryFrom(device); a.Type != accessory.TypeLightbulb {
+		t.Errorf("lamp type = %d, want lightbulb", a.Type)
+	}
+}
+
+func TestHomeKitPairings(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		f := newStoreFixture(t, store)
+		other := Home{ID: utils.NewULID(), Name: "Other", Address: "2 street", CreatorID: f.owner.ID}
+		if err := store.Homes().Create(other); err != nil {
+			t.Fatal(err)
+		}
+		pairings := homekitPairings{db: store, homeID: f.home.ID}
+		otherPairings := homekitPairings{db: store, homeID: other.ID}
+
+		for key, value := range map[string]string{"uuid": "bridge", "alice.pairing": "key-a", "bob.pairing": "key-b"} {
+			if err := pairings.Set(key, []byte(value)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := otherPairings.Set("carol.pairing", []byte("key-c")); err != nil {
+			t.Fatal(err)
+		}
+		// a pairing is updated when a controller pairs again
+		if err := pairings.Set("bob.pairing", []byte("key-b2")); err != nil {
+			t.Fatal(err)
+		}
+
+		if value, err := pairings.Get("bob.pairing"); err != nil || string(value) != "key-b2" {
+			t.Errorf("Get returned %q, %v", value, err)
+		}
+		keys, err := pairings.KeysWithSuffix(".pairing")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"alice.pairing", "bob.pairing"}; !reflect.DeepEqual(keys, want) {
+			t.Errorf("KeysWithSuffix = %v, want %v", keys, want)
+		}
+
+		if err := pairings.Delete("alice.pairing"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pairings.Get("alice.pairing"); err != sql.ErrNoRows {
+			t.Errorf("Get of deleted pairing returned %v, want %v", err, sql.ErrNoRows)
+		}
+		// pairings of other homes are kept apart
+		if _, err := pairings.Get("carol.pairing"); err != sql.ErrNoRows {
+			t.Errorf("Get of other home pairing returned %v, want %v", err, sql.ErrNoRows)
+		}
+		if keys, err := otherPairings.KeysWithSuffix(".pairing"); err != nil || !reflect.DeepEqual(keys, []string{"carol.pairing"}) {
+			t.Errorf("KeysWithSuffix of other home = %v, %v", keys, err)
+		}
+	})
+}
@@ -16,7 +16,7 @@ type addHomeReq struct {
 }
 
 // AddHome route create and add user to an home
-func AddHome(c echo.Context) error {
+func (s *Server) AddHome(c echo.Context) error {
 	req := new(addHomeReq)
 	if err := c.Bind(req); err != nil {
 		logger.WithFields(logger.Fields{"code": "CSHAH001"}).Errorf("%s", err.Error())
@@ -36,7 +36,13 @@ func AddHome(c echo.Context) error {
 
 	user := c.Get("user").(User)
 
-	row, err := DB.Query("INSERT INTO homes (id, name, address, creator_id) VALUES (generate_ulid(), $1, $2, $3) RETURNING id;", req.Name, req.Address, user.ID)
+	newHome := Home{
+		ID:        utils.NewULID(),
+		Name:      req.Name,
+		Address:   req.Address,
+		CreatorID: user.ID,
+	}
+	err := s.db.Homes().Create(newHome)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSHAH003"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusBadRequest, ErrorResponse{
@@ -44,27 +50,18 @@ func AddHome(c echo.Context) error {
 			Message: "Home can't be added",
 		})
 	}
-	var homeID string
-	row.Next()
-	err = row.Scan(&homeID)
-	if err != nil {
-		logger.WithFields(logger.Fields{"code": "CSHAH004"}).Errorf("%s", err.Error())
-		return c.JSON(http.StatusBadRequest, ErrorResponse{
-			Code:    "CSHAH004",
-			Message: "Home can't be added",
-		})
-	}
 
 	newPermission := Permission{
+		ID:     utils.NewULID(),
 		UserID: user.ID,
 		Type:   "home",
-		TypeID: homeID,
+		TypeID: newHome.ID,
 		Read:   true,
 		Write:  true,
 		Manage: true,
 		Admin:  true,
 	}
-	_, err = DB.NamedExec("INSERT INTO permissions (id, user_id, type, type_id, read, write, manage, admin) VALUES (generate_ulid(), :user_id, :type, :type_id, :read, :write, :manage, :admin)", newPermission)
+	err = s.db.Permissions().Create(newPermission)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSHAH005"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -74,12 +71,12 @@ func AddHome(c echo.Context) error {
 	}
 
 	return c.JSON(http.StatusCreated, MessageResponse{
-		Message: homeID,
+		Message: newHome.ID,
 	})
 }
 
 // UpdateHome route update home
-func UpdateHome(c echo.Context) error {
+func (s *Server) UpdateHome(c echo.Context) error {
 	req := new(addHomeReq)
 	if err := c.Bind(req); err != nil {
 		logger.WithFields(logger.Fields{"code": "CSHUH001"}).Errorf("%s", err.Error())
@@ -89,7 +86,12 @@ func UpdateHome(c echo.Context) error {
 		})
 	}
 
-	_, err := DB.Exec("UPDATE homes SET name=COALESCE($1, name), address=COALESCE($2, address), wifi_ssid=COALESCE($3, wifi_ssid) WHERE id=$4", utils.NewNullString(req.Name), utils.NewNullString(req.Address), utils.NewNullString(req.WifiSSID), c.Param("homeId"))
+	err := s.db.Homes().Update(Home{
+		ID:       c.Param("homeId"),
+		Name:     req.Name,
+		Address:  req.Address,
+		WifiSSID: req.WifiSSID,
+	})
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSHUH005"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -104,8 +106,8 @@ func UpdateHome(c echo.Context) error {
 }
 
 // DeleteHome route delete home
-func DeleteHome(c echo.Context) error {
-	_, err := DB.Exec("DELETE FROM homes WHERE id=$1", c.Param("homeId"))
+func (s *Server) DeleteHome(c echo.Context) error {
+	err := s.db.Homes().Delete(c.Param("homeId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSHDH001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -113,7 +115,7 @@ func DeleteHome(c echo.Context) error {
 			Message: "Home can't be deleted",
 		})
 	}
-	_, err = DB.Exec("DELETE FROM permissions WHERE type=$1 AND type_id=$2", "home", c.Param("homeId"))
+	err = s.db.Permissions().DeleteAll("home", c.Param("homeId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSHDH002"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -122,20 +124,13 @@ func DeleteHome(c echo.Context) error {
 		})
 	}
 
+	go s.reloadHomeKit(c.Param("homeId"))
+
 	return c.JSON(http.StatusOK, MessageResponse{
 		Message: "Home deleted",
 	})
 }
 
-type permissionHome struct {
-	Permission
-	User
-	HomeID        string `db:"h_id"`
-	HomeName      string `db:"h_name"`
-	HomeAddress   string `db:"h_address"`
-	HomeCreatedAt string `db:"h_createdat"`
-}
-
 type homeRes struct {
 	ID        string `json:"id"`
 	Name      string `json:"name"`
@@ -149,15 +144,10 @@ type homeRes struct {
 }
 
 // GetHomes route get list of user homes
-func GetHomes(c echo.Context) error {
+func (s *Server) GetHomes(c echo.Context) error {
 	user := c.Get("user").(User)
 
-	rows, err := DB.Queryx(`
-		SELECT permissions.*, users.*, homes.id as h_id, homes.name AS h_name, homes.address AS h_address, homes.created_at AS h_createdat FROM permissions
-		JOIN homes ON permissions.type_id = homes.id
-		JOIN users ON homes.creator_id = users.id
-		WHERE permissions.type=$1 AND permissions.user_id=$2
-	`, "home", user.ID)
+	permissions, err := s.db.Homes().ListForUser(user.ID)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSHGHS001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -165,19 +155,9 @@ func GetHomes(c echo.Context) error {
 			Message: "Homes can't be retrieved",
 		})
 	}
-	defer rows.Close()
 
 	var homes []homeRes
-	for rows.Next() {
-		var permission permissionHome
-		err := rows.StructScan(&permission)
-		if err != nil {
-			logger.WithFields(logger.Fields{"code": "CSHGHS002"}).Errorf("%s", err.Error())
-			return c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Code:    "CSHGHS002",
-				Message: "Homes can't be retrieved",
-			})
-		}
+	for _, permission := range permissions {
 		homes = append(homes, homeRes{
 			ID:        permission.HomeID,
 			Name:      permission.HomeName,
@@ -197,34 +177,18 @@ func GetHomes(c echo.Context) error {
 }
 
 // GetHome route get specific home with id
-func GetHome(c echo.Context) error {
+func (s *Server) GetHome(c echo.Context) error {
 	user := c.Get("user").(User)
 
-	row := DB.QueryRowx(`
-		SELECT permissions.*, users.*, homes.id as h_id, homes.name AS h_name, homes.address AS h_address, homes.created_at AS h_createdat FROM permissions
-		JOIN homes ON permissions.type_id = homes.id
-		JOIN users ON homes.creator_id = users.id
-		WHERE type=$1 AND type_id=$2 AND user_id=$3
-	`, "home", c.Param("homeId"), user.ID)
-
-	if row == nil {
-		logger.WithFields(logger.Fields{"code": "CSHGH001"}).Errorf("QueryRowx: Select error")
+	permission, err := s.db.Homes().GetForUser(user.ID, c.Param("homeId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHGH001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusNotFound, ErrorResponse{
 			Code:    "CSHGH001",
 			Message: "Home can't be found",
 		})
 	}
 
-	var permission permissionHome
-	err := row.StructScan(&permission)
-	if err != nil {
-		logger.WithFields(logger.Fields{"code": "CSHGH002"}).Errorf("QueryRowx: Select error")
-		return c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Code:    "CSHGH002",
-			Message: "Home can't be found",
-		})
-	}
-
 	return c.JSON(http.StatusOK, DataReponse{
 		Data: homeRes{
 			ID:        permission.HomeID,
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ItsJimi/casa/logger"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// clientWriteWait define time allowed to write a message to client
+	clientWriteWait = 10 * time.Second
+	// clientPongWait define time allowed to read next pong from client
+	clientPongWait = 60 * time.Second
+	// clientPingPeriod must be less than clientPongWait
+	clientPingPeriod = (clientPongWait * 9) / 10
+	// clientMaxMessageSize define maximum size of a message sent by client
+	clientMaxMessageSize = 64 * 1024
+	// clientSendBuffer define how many messages can wait for a client before it's evicted
+	clientSendBuffer = 64
+)
+
+var errClientClosed = errors.New("Client connection is closed")
+var errSlowClient = errors.New("Client send buffer is full")
+
+type outgoingMessage struct {
+	data []byte
+	// close send a close frame after data
+	close bool
+}
+
+type wsClient struct {
+	conn  *websocket.Conn
+	user  User
+	token string
+
+	queue     chan outgoingMessage
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mutex         sync.Mutex
+	subscriptions []Subscription
+	permissions   *permissionCache
+}
+
+func newWSClient(conn *websocket.Conn, user User, token string) *wsClient {
+	return &wsClient{
+		conn:        conn,
+		user:        user,
+		token:       token,
+		queue:       make(chan outgoingMessage, clientSendBuffer),
+		done:        make(chan struct{}),
+		permissions: newPermissionCache(),
+	}
+}
+
+// send queue message for client without blocking
+func (client *wsClient) send(message WebsocketMessage) error {
+	return client.enqueue(message, false)
+}
+
+func (client *wsClient) enqueue(message WebsocketMessage, close bool) error {
+	marshMessage, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-client.done:
+		return errClientClosed
+	default:
+	}
+
+	select {
+	case client.queue <- outgoingMessage{data: marshMessage, close: close}:
+		return nil
+	default:
+		return errSlowClient
+	}
+}
+
+// writePump write queued messages and pings to client until it's closed
+func (client *wsClient) writePump(h *hub) {
+	ticker := time.NewTicker(clientPingPeriod)
+	defer func() {
+		ticker.Stop()
+		h.unregister(client)
+	}()
+
+	for {
+		select {
+		case <-client.done:
+			return
+		case message := <-client.queue:
+			client.conn.SetWriteDeadline(time.Now().Add(clientWriteWait))
+			err := WebsocketWriteMessage(client.conn, message.data)
+			if err != nil {
+				logger.WithFields(logger.Fields{"code": "CSHWP001", "userId": client.user.ID}).Errorf("%s", err.Error())
+				return
+			}
+			if message.close {
+				client.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, ""))
+				return
+			}
+		case <-ticker.C:
+			client.conn.SetWriteDeadline(time.Now().Add(clientWriteWait))
+			err := client.conn.WriteMessage(websocket.PingMessage, nil)
+			if err != nil {
+				logger.WithFields(logger.Fields{"code": "CSHWP002", "userId": client.user.ID}).Errorf("%s", err.Error())
+				return
+			}
+		}
+	}
+}
+
+// hub keep connected clients
+type hub struct {
+	mutex   sync.Mutex
+	clients map[*wsClient]bool
+}
+
+func newHub() *hub {
+	return &hub{
+		clients: map[*wsClient]bool{},
+	}
+}
+
+// register add client and start writing its messages
+func (h *hub) register(client *wsClient) {
+	client.conn.SetReadLimit(clientMaxMessageSize)
+	client.conn.SetReadDeadline(time.Now().Add(clientPongWait))
+	client.conn.SetPongHandler(func(string) error {
+		client.conn.SetReadDeadline(time.Now().Add(clientPongWait))
+		return nil
+	})
+
+	h.mutex.Lock()
+	h.clients[client] = true
+	h.mutex.Unlock()
+	wsClientsConnected.Inc()
+
+	go client.writePump(h)
+}
+
+// unregister remove client and close its connection, it can be called many times
+func (h *hub) unregister(client *wsClient) {
+	client.closeOnce.Do(func() {
+		h.mutex.Lock()
+		delete(h.clients, client)
+		h.mutex.Unlock()
+		wsClientsConnected.Dec()
+
+		close(client.done)
+		client.conn.Close()
+	})
+}
+
+// list return connected clients
+func (h *hub) list() []*wsClient {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	clients := make([]*wsClient, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// dropClient send an error to client then disconnect it
+func (s *Server) dropClient(client *wsClient, code string, message string) {
+	body, _ := json.Marshal(ErrorResponse{
+		Code:    code,
+		Message: message,
+	})
+	err := client.enqueue(WebsocketMessage{
+		Action: "error",
+		Body:   body,
+	}, true)
+	if err != nil {
+		s.hub.unregister(client)
+	}
+}
+
+// dropClientsWithToken disconnect clients and close event streams authenticated with token id
+func (s *Server) dropClientsWithToken(token string) {
+	for _, client := range s.hub.list() {
+		// clients keep the key they sent, which is <id>.<secret> for API keys
+		if strings.SplitN(client.token, ".", 2)[0] == token {
+			s.dropClient(client, "CSHDCWT001", "Token revoked")
+		}
+	}
+	s.broker.dropToken(token)
+}
+
+// CheckClientsTokens disconnect clients which token expired or was deleted, every interval
+func (s *Server) CheckClientsTokens(interval time.Duration) {
+	for range time.Tick(interval) {
+		for _, client := range s.hub.list() {
+			if _, err := s.userFromToken(client.token); err != nil {
+				logger.WithFields(logger.Fields{"code": "CSHCCT001"}).Warnf("%s", err.Error())
+				s.dropClient(client, "CSHCCT001", "Token expired or revoked")
+			}
+		}
+	}
+}
@@ -0,0 +1,516 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/ItsJimi/casa/config"
+	"github.com/ItsJimi/casa/logger"
+	"github.com/labstack/echo"
+)
+
+// Hue v1 error types
+const (
+	hueErrorInvalidJSON   = 2
+	hueErrorNotAvailable  = 3
+	hueErrorParameter     = 6
+	hueErrorInvalidValue  = 7
+	hueErrorNotModifiable = 201
+	hueErrorInternal      = 901
+)
+
+// hueBridge serve allowed devices as lights of an emulated Philips Hue bridge
+type hueBridge struct {
+	conf config.Hue
+	// ip and port are announced with SSDP and in description.xml
+	ip   string
+	port string
+	// id is the 16 hex digits bridge id, derived from ip
+	id string
+}
+
+type hueDeviceReq struct {
+	OnCall         string
+	OffCall        string
+	BrightnessCall string
+}
+
+type hueStateReq struct {
+	On  *bool `json:"on"`
+	Bri *int  `json:"bri"`
+}
+
+type hueLight struct {
+	State            hueLightState `json:"state"`
+	Type             string        `json:"type"`
+	Name             string        `json:"name"`
+	ModelID          string        `json:"modelid"`
+	ManufacturerName string        `json:"manufacturername"`
+	UniqueID         string        `json:"uniqueid"`
+	SWVersion        string        `json:"swversion"`
+}
+
+type hueLightState struct {
+	On        bool   `json:"on"`
+	Bri       int    `json:"bri,omitempty"`
+	Alert     string `json:"alert"`
+	Mode      string `json:"mode"`
+	Reachable bool   `json:"reachable"`
+}
+
+type hueError struct {
+	Type        int    `json:"type"`
+	Address     string `json:"address"`
+	Description string `json:"description"`
+}
+
+// StartHue serve Hue v1 API on its own address and answer SSDP discovery
+func (s *Server) StartHue(conf config.Hue) {
+	_, port, err := net.SplitHostPort(conf.Address)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHUSH001"}).Errorf("%s", err.Error())
+		return
+	}
+	ip := conf.AdvertiseIP
+	if ip == "" {
+		ip = privateIPv4()
+	}
+	if ip == "" {
+		logger.WithFields(logger.Fields{"code": "CSHUSH002"}).Errorf("No private IPv4 to advertise Hue bridge, set hue.advertise_ip")
+		return
+	}
+	s.hue = &hueBridge{
+		conf: conf,
+		ip:   ip,
+		port: port,
+		id:   hueBridgeID(ip),
+	}
+
+	e := echo.New()
+	e.HideBanner = true
+	e.GET("/description.xml", s.HueDescription)
+	e.POST("/api", s.HueCreateUser)
+	e.GET("/api/:user", s.HueGetAll)
+	e.GET("/api/:user/config", s.HueGetConfig)
+	e.GET("/api/:user/lights", s.HueGetLights)
+	e.GET("/api/:user/lights/:lightId", s.HueGetLight)
+	e.PUT("/api/:user/lights/:lightId/state", s.HueSetLightState)
+
+	go func() {
+		if err := e.Start(conf.Address); err != nil {
+			logger.WithFields(logger.Fields{"code": "CSHUSH003"}).Errorf("%s", err.Error())
+		}
+	}()
+	go s.hue.serveSSDP()
+}
+
+// HueDescription route describe bridge to UPnP clients which found it with SSDP
+func (s *Server) HueDescription(c echo.Context) error {
+	return c.Blob(http.StatusOK, "text/xml", []byte(fmt.Sprintf(hueDescription, s.hue.ip, s.hue.port, s.hue.ip, s.hue.id, s.hue.uuid())))
+}
+
+// HueCreateUser route answer pairing of a Hue client, any username is accepted afterward
+func (s *Server) HueCreateUser(c echo.Context) error {
+	username := make([]byte, 16)
+	if _, err := rand.Read(username); err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHUHCU001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusOK, hueErrorRes(hueErrorInternal, "/", "internal error"))
+	}
+	return c.JSON(http.StatusOK, []map[string]interface{}{
+		{"success": map[string]string{"username": hex.EncodeToString(username)}},
+	})
+}
+
+// HueGetAll route return full state of bridge
+func (s *Server) HueGetAll(c echo.Context) error {
+	lights, err := s.hueLights()
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHUHGA001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusOK, hueErrorRes(hueErrorInternal, "/", "internal error"))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"lights":    lights,
+		"groups":    map[string]interface{}{},
+		"config":    s.hue.config(),
+		"schedules": map[string]interface{}{},
+		"scenes":    map[string]interface{}{},
+		"rules":     map[string]interface{}{},
+		"sensors":   map[string]interface{}{},
+	})
+}
+
+// HueGetConfig route return config of bridge
+func (s *Server) HueGetConfig(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.hue.config())
+}
+
+// HueGetLights route return all exposed devices as lights
+func (s *Server) HueGetLights(c echo.Context) error {
+	lights, err := s.hueLights()
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHUHGLS001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusOK, hueErrorRes(hueErrorInternal, "/lights", "internal error"))
+	}
+	return c.JSON(http.StatusOK, lights)
+}
+
+// HueGetLight route return one exposed device as light
+func (s *Server) HueGetLight(c echo.Context) error {
+	address := "/lights/" + c.Param("lightId")
+	hueDevice, device, ok := s.hueDevice(c.Param("lightId"))
+	if !ok {
+		return c.JSON(http.StatusOK, hueErrorRes(hueErrorNotAvailable, address, "resource, "+address+", not available"))
+	}
+	return c.JSON(http.StatusOK, s.hue.light(hueDevice, device))
+}
+
+// HueSetLightState route turn on or off and dim device, through its configured calls
+func (s *Server) HueSetLightState(c echo.Context) error {
+	address := "/lights/" + c.Param("lightId")
+	// Hue clients don't always send a json content type
+	req := hueStateReq{}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHUHSLS001"}).Warnf("%s", err.Error())
+		return c.JSON(http.StatusOK, hueErrorRes(hueErrorInvalidJSON, address, "body contains invalid json"))
+	}
+	hueDevice, device, ok := s.hueDevice(c.Param("lightId"))
+	if !ok {
+		return c.JSON(http.StatusOK, hueErrorRes(hueErrorNotAvailable, address, "resource, "+address+", not available"))
+	}
+
+	send := s.assistantSender("hue")
+	results := []map[string]interface{}{}
+	if req.On != nil {
+		attribute := address + "/state/on"
+		var err error
+		switch {
+		case *req.On && hueDevice.OnCall != "":
+			err = send(device.Device, hueDevice.OnCall, "true")
+		case !*req.On && hueDevice.OffCall != "":
+			err = send(device.Device, hueDevice.OffCall, "false")
+		default:
+			err = device.setOn(*req.On, send)
+		}
+		if err != nil {
+			logger.WithFields(logger.Fields{"code": "CSHUHSLS002"}).Errorf("%s", err.Error())
+			return c.JSON(http.StatusOK, hueCommandError(err, attribute, "on"))
+		}
+		results = append(results, map[string]interface{}{"success": map[string]bool{attribute: *req.On}})
+	}
+	if req.Bri != nil {
+		attribute := address + "/state/bri"
+		if *req.Bri < 1 || *req.Bri > 254 {
+			return c.JSON(http.StatusOK, hueErrorRes(hueErrorInvalidValue, attribute, fmt.Sprintf("invalid value, %d, for parameter, bri", *req.Bri)))
+		}
+		call := hueBrightnessCall(hueDevice)
+		if !hasAction(device.Plugin, call) {
+			return c.JSON(http.StatusOK, hueErrorRes(hueErrorParameter, attribute, "parameter, bri, not available"))
+		}
+		level := int(math.Round(float64(*req.Bri*hueActionMax(device, call)) / 254))
+		if err := send(device.Device, call, strconv.Itoa(level)); err != nil {
+			logger.WithFields(logger.Fields{"code": "CSHUHSLS003"}).Errorf("%s", err.Error())
+			return c.JSON(http.StatusOK, hueCommandError(err, attribute, "bri"))
+		}
+		results = append(results, map[string]interface{}{"success": map[string]int{attribute: *req.Bri}})
+	}
+	return c.JSON(http.StatusOK, results)
+}
+
+// SetHueDevice route expose device of home to Hue clients, or change its calls
+func (s *Server) SetHueDevice(c echo.Context) error {
+	req := new(hueDeviceReq)
+	if err := c.Bind(req); err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHUSHD001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSHUSHD001",
+			Message: "Wrong parameters",
+		})
+	}
+
+	device, err := s.db.Devices().ByID(c.Param("deviceId"))
+	if err == nil {
+		var room Room
+		room, err = s.db.Rooms().ByID(device.RoomID)
+		if err == nil && room.HomeID != c.Param("homeId") {
+			err = fmt.Errorf("Device %s isn't in home %s", device.ID, c.Param("homeId"))
+		}
+	}
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHUSHD002"}).Warnf("%s", err.Error())
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    "CSHUSHD002",
+			Message: "Device not found",
+		})
+	}
+
+	user := c.Get("user").(User)
+	err = s.db.HueDevices().Set(HueDevice{
+		DeviceID:       device.ID,
+		HomeID:         c.Param("homeId"),
+		OnCall:         req.OnCall,
+		OffCall:        req.OffCall,
+		BrightnessCall: req.BrightnessCall,
+		CreatorID:      user.ID,
+	})
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHUSHD003"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSHUSHD003",
+			Message: "Device can't be exposed",
+		})
+	}
+
+	hueDevice, err := s.db.HueDevices().GetForHome(c.Param("homeId"), device.ID)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHUSHD004"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSHUSHD004",
+			Message: "Device can't be retrieved",
+		})
+	}
+
+	return c.JSON(http.StatusOK, DataReponse{
+		Data: hueDevice,
+	})
+}
+
+// DeleteHueDevice route stop exposing device of home to Hue clients
+func (s *Server) DeleteHueDevice(c echo.Context) error {
+	_, err := s.db.HueDevices().GetForHome(c.Param("homeId"), c.Param("deviceId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHUDHD001"}).Warnf("%s", err.Error())
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    "CSHUDHD001",
+			Message: "Device isn't exposed",
+		})
+	}
+
+	err = s.db.HueDevices().Delete(c.Param("homeId"), c.Param("deviceId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHUDHD002"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSHUDHD002",
+			Message: "Device can't be hidden",
+		})
+	}
+
+	return c.JSON(http.StatusOK, MessageResponse{
+		Message: "Device hidden from Hue clients",
+	})
+}
+
+// GetHueDevices route get devices of home exposed to Hue clients
+func (s *Server) GetHueDevices(c echo.Context) error {
+	devices, err := s.db.HueDevices().ListForHome(c.Param("homeId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHUGHD001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSHUGHD001",
+			Message: "Devices can't be retrieved",
+		})
+	}
+
+	return c.JSON(http.StatusOK, DataReponse{
+		Data: devices,
+	})
+}
+
+// hueLights return exposed devices as lights by their light id
+func (s *Server) hueLights() (map[string]hueLight, error) {
+	hueDevices, err := s.db.HueDevices().All()
+	if err != nil {
+		return nil, err
+	}
+	lights := map[string]hueLight{}
+	for _, hueDevice := range hueDevices {
+		device, ok := s.hueAssistantDevice(hueDevice)
+		if !ok {
+			continue
+		}
+		lights[strconv.Itoa(hueDevice.LightID)] = s.hue.light(hueDevice, device)
+	}
+	return lights, nil
+}
+
+// hueDevice return exposed device with light id, false when it doesn't exist or its plugin isn't loaded
+func (s *Server) hueDevice(lightID string) (HueDevice, assistantDevice, bool) {
+	ID, err := strconv.Atoi(lightID)
+	if err != nil {
+		return HueDevice{}, assistantDevice{}, false
+	}
+	hueDevice, err := s.db.HueDevices().ByLightID(ID)
+	if err != nil {
+		return HueDevice{}, assistantDevice{}, false
+	}
+	device, ok := s.hueAssistantDevice(hueDevice)
+	return hueDevice, device, ok
+}
+
+// hueAssistantDevice return device with its latest datas, exposed devices can always be controlled
+func (s *Server) hueAssistantDevice(hueDevice HueDevice) (assistantDevice, bool) {
+	device, err := s.db.Devices().ByID(hueDevice.DeviceID)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHUHAD001"}).Errorf("%s", err.Error())
+		return assistantDevice{}, false
+	}
+	return s.newAssistantDevice(device, "", true)
+}
+
+// light describe device as a Hue light, dimmable when it has a brightness call
+func (b *hueBridge) light(hueDevice HueDevice, device assistantDevice) hueLight {
+	light := hueLight{
+		State: hueLightState{
+			Alert:     "none",
+			Mode:      "homeautomation",
+			Reachable: true,
+		},
+		Type:             "On/Off plug-in unit",
+		Name:             device.Name,
+		ModelID:          "LOM001",
+		ManufacturerName: "Casa",
+		UniqueID:         hueUniqueID(device.ID),
+		SWVersion:        "1.0.0",
+	}
+	light.State.On, _ = device.isOn()
+
+	call := hueBrightnessCall(hueDevice)
+	if hasAction(device.Plugin, call) {
+		light.Type = "Dimmable light"
+		light.ModelID = "LWB010"
+		light.State.Bri = 254
+		if data, ok := device.States[call]; ok {
+			light.State.Bri = int(math.Round(data.ValueNbr * 254 / float64(hueActionMax(device, call))))
+			if light.State.Bri < 1 {
+				light.State.Bri = 1
+			}
+		}
+	}
+	return light
+}
+
+// config return bridge config, enough for clients to identify it
+func (b *hueBridge) config() map[string]interface{} {
+	return map[string]interface{}{
+		"name":             "Casa",
+		"bridgeid":         b.id,
+		"mac":              b.mac(),
+		"ipaddress":        b.ip,
+		"modelid":          "BSB002",
+		"swversion":        "1935144040",
+		"apiversion":       "1.35.0",
+		"datastoreversion": "98",
+		"dhcp":             true,
+		"linkbutton":       true,
+		"factorynew":       false,
+		"replacesbridgeid": nil,
+		"whitelist":        map[string]interface{}{},
+	}
+}
+
+// mac return a fake mac address made from bridge id
+func (b *hueBridge) mac() string {
+	return fmt.Sprintf("%s:%s:%s:%s:%s:%s", b.id[0:2], b.id[2:4], b.id[4:6], b.id[10:12], b.id[12:14], b.id[14:16])
+}
+
+func (b *hueBridge) uuid() string {
+	return "2f402f80-da50-11e1-9b23-" + b.id[0:6] + b.id[10:16]
+}
+
+// hueBrightnessCall return call dimming device
+func hueBrightnessCall(hueDevice HueDevice) string {
+	if hueDevice.BrightnessCall != "" {
+		return hueDevice.BrightnessCall
+	}
+	return brightnessAction
+}
+
+// hueActionMax return max level of call, 100 when its fields have none
+func hueActionMax(device assistantDevice, call string) int {
+	for _, field := range findActionFromName(device.Actions, call).Fields {
+		if field.Max > 0 {
+			return field.Max
+		}
+	}
+	return 100
+}
+
+func hueErrorRes(errorType int, address string, description string) []map[string]hueError {
+	return []map[string]hueError{
+		{"error": {Type: errorType, Address: address, Description: description}},
+	}
+}
+
+// hueCommandError translate errors of assistant commands to Hue errors
+func hueCommandError(err error, address string, parameter string) []map[string]hueError {
+	switch err {
+	case errAssistantNotSupported:
+		return hueErrorRes(hueErrorParameter, address, "parameter, "+parameter+", not available")
+	case errGatewayOffline:
+		return hueErrorRes(hueErrorNotModifiable, address, "device is not reachable")
+	}
+	return hueErrorRes(hueErrorInternal, address, "internal error")
+}
+
+// hueBridgeID return a stable bridge id from ip, prefixed like real bridges
+func hueBridgeID(ip string) string {
+	h := fnv.New32a()
+	h.Write([]byte(ip))
+	return fmt.Sprintf("001788FFFE%06X", h.Sum32()&0xffffff)
+}
+
+// hueUniqueID return a stable light unique id from device id
+func hueUniqueID(deviceID string) string {
+	h := fnv.New32a()
+	h.Write([]byte(deviceID))
+	sum := h.Sum32()
+	return fmt.Sprintf("00:17:88:01:%02x:%02x:%02x:%02x-0b", byte(sum>>24), byte(sum>>16), byte(sum>>8), byte(sum))
+}
+
+// privateIPv4 return first private IPv4 of host, empty when there is none
+func privateIPv4() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP.To4()
+		if ip == nil {
+			continue
+		}
+		if ip[0] == 10 || (ip[0] == 172 && ip[1]&0xf0 == 16) || (ip[0] == 192 && ip[1] == 168) {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+const hueDescription = `<?xml version="1.0" encoding="UTF-8" ?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+<specVersion><major>1</major><minor>0</minor></specVersion>
+<URLBase>http://%s:%s/</URLBase>
+<device>
+<deviceType>urn:schemas-upnp-org:device:Basic:1</deviceType>
+<friendlyName>Casa (%s)</friendlyName>
+<manufacturer>Royal Philips Electronics</manufacturer>
+<manufacturerURL>http://www.philips.com</manufacturerURL>
+<modelDescription>Philips hue Personal Wireless Lighting</modelDescription>
+<modelName>Philips hue bridge 2015</modelName>
+<modelNumber>BSB002</modelNumber>
+<modelURL>http://www.meethue.com</modelURL>
+<serialNumber>%s</serialNumber>
+<UDN>uuid:%s</UDN>
+<presentationURL>index.html</presentationURL>
+</device>
+</root>
+`
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/ItsJimi/casa/logger"
+)
+
+// ssdpAddress is the multicast group of SSDP discovery
+const ssdpAddress = "239.255.255.250:1900"
+
+// hueSearchTargets define M-SEARCH targets answered by bridge
+var hueSearchTargets = map[string]bool{
+	"ssdp:all":                            true,
+	"upnp:rootdevice":                     true,
+	"urn:schemas-upnp-org:device:basic:1": true,
+}
+
+// serveSSDP answer M-SEARCH requests of Hue clients looking for a bridge
+func (b *hueBridge) serveSSDP() {
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHSSS001"}).Errorf("%s", err.Error())
+		return
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHSSS002"}).Errorf("%s", err.Error())
+		return
+	}
+	defer conn.Close()
+	logger.WithFields(logger.Fields{}).Infof("Hue bridge advertised at http://%s:%s", b.ip, b.port)
+
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			logger.WithFields(logger.Fields{"code": "CSHSSS003"}).Errorf("%s", err.Error())
+			return
+		}
+		target, ok := ssdpSearchTarget(buf[:n])
+		if !ok {
+			continue
+		}
+		go b.answerSSDP(from, target)
+	}
+}
+
+// answerSSDP send search response to client from its own socket, clients ignore answers from the multicast port
+func (b *hueBridge) answerSSDP(to *net.UDPAddr, target string) {
+	conn, err := net.DialUDP("udp4", nil, to)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHSAS001"}).Errorf("%s", err.Error())
+		return
+	}
+	defer conn.Close()
+
+	if target == "ssdp:all" {
+		target = "upnp:rootdevice"
+	}
+	usn := "uuid:" + b.uuid() + "::" + target
+	response := "HTTP/1.1 200 OK\r\n" +
+		"CACHE-CONTROL: max-age=100\r\n" +
+		"EXT:\r\n" +
+		fmt.Sprintf("LOCATION: http://%s:%s/description.xml\r\n", b.ip, b.port) +
+		"SERVER: Linux/3.14.0 UPnP/1.0 IpBridge/1.35.0\r\n" +
+		"hue-bridgeid: " + b.id + "\r\n" +
+		"ST: " + target + "\r\n" +
+		"USN: " + usn + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		logger.WithFields(logger.Fields{"code": "CSHSAS002"}).Errorf("%s", err.Error())
+	}
+}
+
+// ssdpSearchTarget return target of an M-SEARCH request, false when packet isn't a search for a bridge
+func ssdpSearchTarget(packet []byte) (string, bool) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(packet)))
+	if err != nil || req.Method != "M-SEARCH" {
+		return "", false
+	}
+	target := req.Header.Get("ST")
+	return target, hueSearchTargets[strings.ToLower(target)]
+}
@@ -0,0 +1,20 @@
+package server
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ItsJimi/casa/logger"
+)
+
+func TestMain(m *testing.M) {
+	// routes log expected errors, only fatal ones are shown
+	err := logger.NewLogger(logger.Configuration{
+		EnableConsole: true,
+		ConsoleLevel:  logger.Fatal,
+	})
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
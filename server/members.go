@@ -4,18 +4,12 @@ import (
 	"net/http"
 	"reflect"
 	"strconv"
-	"time"
 
 	"github.com/ItsJimi/casa/logger"
 	"github.com/ItsJimi/casa/utils"
 	"github.com/labstack/echo"
 )
 
-type permissionMember struct {
-	Permission
-	User
-}
-
 type memberRes struct {
 	ID        string `json:"id"`
 	Firstname string `json:"firstname"`
@@ -30,12 +24,8 @@ type memberRes struct {
 }
 
 // GetMembers route get list of home members
-func GetMembers(c echo.Context) error {
-	rows, err := DB.Queryx(`
-		SELECT * FROM permissions
-		JOIN users ON permissions.user_id = users.id
-		WHERE permissions.type=$1 AND permissions.type_id=$2
-	`, "home", c.Param("homeId"))
+func (s *Server) GetMembers(c echo.Context) error {
+	permissions, err := s.db.Permissions().Members("home", c.Param("homeId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSMGM001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -43,19 +33,9 @@ func GetMembers(c echo.Context) error {
 			Message: "Members can't be retrieved",
 		})
 	}
-	defer rows.Close()
 
 	var members []memberRes
-	for rows.Next() {
-		var permission permissionMember
-		err := rows.StructScan(&permission)
-		if err != nil {
-			logger.WithFields(logger.Fields{"code": "CSMGM002"}).Errorf("%s", err.Error())
-			return c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Code:    "CSMGM002",
-				Message: "Members can't be retrieved",
-			})
-		}
+	for _, permission := range permissions {
 		members = append(members, memberRes{
 			ID:        permission.User.ID,
 			Firstname: permission.User.Firstname,
@@ -80,7 +60,7 @@ type addMemberReq struct {
 }
 
 // AddMember route create a new permission to authorize an user
-func AddMember(c echo.Context) error {
+func (s *Server) AddMember(c echo.Context) error {
 	req := new(addMemberReq)
 	if err := c.Bind(req); err != nil {
 		logger.WithFields(logger.Fields{"code": "CSMAM001"}).Errorf("%s", err.Error())
@@ -98,9 +78,7 @@ func AddMember(c echo.Context) error {
 		})
 	}
 
-	var reqUser User
-	err := DB.QueryRowx("SELECT * FROM users WHERE email=$1", req.Email).StructScan(&reqUser)
-
+	reqUser, err := s.db.Users().ByEmail(req.Email)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSMAM003"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusNotFound, ErrorResponse{
@@ -109,9 +87,7 @@ func AddMember(c echo.Context) error {
 		})
 	}
 
-	var permission Permission
-	err = DB.QueryRowx("SELECT * FROM permissions WHERE user_id=$1 AND type_id=$2", reqUser.ID, c.Param("homeId")).StructScan(&permission)
-
+	_, err = s.db.Permissions().Get(reqUser.ID, "home", c.Param("homeId"))
 	if err == nil {
 		logger.WithFields(logger.Fields{"code": "CSMAM004"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusBadRequest, ErrorResponse{
@@ -120,10 +96,14 @@ func AddMember(c echo.Context) error {
 		})
 	}
 
-	_, err = DB.Exec(`
-		INSERT INTO permissions (id, user_id, type, type_id, read, write, manage, admin, updated_at) 
-		VALUES (generate_ulid(), $1, $2, $3, $4, $5, $6, $7, $8)
-	`, reqUser.ID, "home", c.Param("homeId"), 1, 0, 0, 0, time.Now().Format(time.RFC1123))
+	permission := Permission{
+		ID:     utils.NewULID(),
+		UserID: reqUser.ID,
+		Type:   "home",
+		TypeID: c.Param("homeId"),
+		Read:   true,
+	}
+	err = s.db.Permissions().Create(permission)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSMAM005"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -131,6 +111,7 @@ func AddMember(c echo.Context) error {
 			Message: "User can't be added to your home",
 		})
 	}
+	s.publishMemberEvent(c, "added", permission)
 
 	return c.JSON(http.StatusCreated, MessageResponse{
 		Message: reqUser.Firstname + " has been added to your home",
@@ -138,10 +119,8 @@ func AddMember(c echo.Context) error {
 }
 
 // RemoveMember route remove a member to an home
-func RemoveMember(c echo.Context) error {
-	var reqHome Home
-	err := DB.QueryRowx("SELECT * FROM homes WHERE id=$1", c.Param("homeId")).StructScan(&reqHome)
-
+func (s *Server) RemoveMember(c echo.Context) error {
+	reqHome, err := s.db.Homes().ByID(c.Param("homeId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSMRM001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusNotFound, ErrorResponse{
@@ -149,9 +128,7 @@ func RemoveMember(c echo.Context) error {
 		})
 	}
 
-	var reqUser User
-	err = DB.QueryRowx("SELECT * FROM users WHERE id=$1", c.Param("userId")).StructScan(&reqUser)
-
+	reqUser, err := s.db.Users().ByID(c.Param("userId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSMRM002"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusNotFound, ErrorResponse{
@@ -166,16 +143,18 @@ func RemoveMember(c echo.Context) error {
 		})
 	}
 
-	_, err = DB.Exec(`
-		DELETE FROM permissions WHERE user_id=$1 AND type='home' AND type_id=$2
-	`, c.Param("userId"), c.Param("homeId"))
-
+	err = s.db.Permissions().Delete(c.Param("userId"), "home", c.Param("homeId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSMRM004"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusNotFound, ErrorResponse{
 			Message: "Member can't be deleted",
 		})
 	}
+	s.publishMemberEvent(c, "removed", Permission{
+		UserID: reqUser.ID,
+		Type:   "home",
+		TypeID: c.Param("homeId"),
+	})
 
 	return c.JSON(http.StatusOK, MessageResponse{
 		Message: reqUser.Firstname + " has been removed from your home",
@@ -190,7 +169,7 @@ type editMemberReq struct {
 }
 
 // EditMember route create a new permission to authorize an user
-func EditMember(c echo.Context) error {
+func (s *Server) EditMember(c echo.Context) error {
 	req := new(editMemberReq)
 	if err := c.Bind(req); err != nil {
 		logger.WithFields(logger.Fields{"code": "CSMEM001"}).Errorf("%s", err.Error())
@@ -208,11 +187,16 @@ func EditMember(c echo.Context) error {
 		})
 	}
 
-	_, err := DB.Exec(`
-		UPDATE permissions
-		SET read=$1, write=$2, manage=$3, admin=$4
-		WHERE user_id=$5 AND type=$6 AND type_id=$7
-	`, req.Read, req.Write, req.Manage, req.Admin, c.Param("userId"), "home", c.Param("homeId"))
+	permission := Permission{
+		UserID: c.Param("userId"),
+		Type:   "home",
+		TypeID: c.Param("homeId"),
+		Read:   req.Read,
+		Write:  req.Write,
+		Manage: req.Manage,
+		Admin:  req.Admin,
+	}
+	err := s.db.Permissions().Update(permission)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSMEM007"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -220,6 +204,7 @@ func EditMember(c echo.Context) error {
 			Message: "Member can't be updated",
 		})
 	}
+	s.publishMemberEvent(c, "updated", permission)
 
 	return c.JSON(http.StatusOK, MessageResponse{
 		Message: "Member has been updated",
@@ -227,12 +212,8 @@ func EditMember(c echo.Context) error {
 }
 
 // GetRoomMembers route get list of home members
-func GetRoomMembers(c echo.Context) error {
-	rows, err := DB.Queryx(`
-		SELECT * FROM permissions
-		JOIN users ON permissions.user_id = users.id
-		WHERE (permissions.type=$1 AND permissions.type_id=$2) OR (permissions.type=$3 AND permissions.type_id=$4)
-	`, "home", c.Param("homeId"), "room", c.Param("roomId"))
+func (s *Server) GetRoomMembers(c echo.Context) error {
+	homePermissions, err := s.db.Permissions().Members("home", c.Param("homeId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSSMGRM001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -240,22 +221,15 @@ func GetRoomMembers(c echo.Context) error {
 			Message: "Members can't be retrieved",
 		})
 	}
-	defer rows.Close()
-
-	var permissions []permissionMember
-	for rows.Next() {
-		var permission permissionMember
-		err := rows.StructScan(&permission)
-		if err != nil {
-			logger.WithFields(logger.Fields{"code": "CSSMGRM002"}).Errorf("%s", err.Error())
-			return c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Code:    "CSSMGRM002",
-				Message: "Members can't be retrieved",
-			})
-		}
-
-		permissions = append(permissions, permission)
+	roomPermissions, err := s.db.Permissions().Members("room", c.Param("roomId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSSMGRM002"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSSMGRM002",
+			Message: "Members can't be retrieved",
+		})
 	}
+	permissions := append(homePermissions, roomPermissions...)
 
 	var members []memberRes
 	for _, permission := range permissions {
@@ -294,7 +268,7 @@ func GetRoomMembers(c echo.Context) error {
 }
 
 // EditRoomMember route create a new permission to authorize an user in a room
-func EditRoomMember(c echo.Context) error {
+func (s *Server) EditRoomMember(c echo.Context) error {
 	req := new(editMemberReq)
 	if err := c.Bind(req); err != nil {
 		logger.WithFields(logger.Fields{"code": "CSSMERM001"}).Errorf("%s", err.Error())
@@ -312,17 +286,19 @@ func EditRoomMember(c echo.Context) error {
 		})
 	}
 
-	var permission Permission
-	err := DB.QueryRowx(`
-		SELECT * FROM permissions
-		WHERE user_id=$1 AND type=$2 AND type_id=$3
-	 `, c.Param("userId"), "room", c.Param("roomId")).StructScan(&permission)
-
+	permission := Permission{
+		ID:     utils.NewULID(),
+		UserID: c.Param("userId"),
+		Type:   "room",
+		TypeID: c.Param("roomId"),
+		Read:   req.Read,
+		Write:  req.Write,
+		Manage: req.Manage,
+		Admin:  req.Admin,
+	}
+	_, err := s.db.Permissions().Get(permission.UserID, permission.Type, permission.TypeID)
 	if err != nil {
-		_, err = DB.Exec(`
-			INSERT INTO permissions (id, user_id, type, type_id, read, write, manage, admin) 
-			VALUES (generate_ulid(), $1, $2, $3, $4, $5, $6, $7)
-		`, c.Param("userId"), "room", c.Param("roomId"), req.Read, req.Write, req.Manage, req.Admin)
+		err = s.db.Permissions().Create(permission)
 		if err != nil {
 			logger.WithFields(logger.Fields{"code": "CSSMERM007"}).Errorf("%s", err.Error())
 			return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -330,17 +306,14 @@ func EditRoomMember(c echo.Context) error {
 				Message: "Member can't be updated",
 			})
 		}
+		s.publishMemberEvent(c, "added", permission)
 
 		return c.JSON(http.StatusOK, MessageResponse{
 			Message: "Member has been updated",
 		})
 	}
 
-	_, err = DB.Exec(`
-		UPDATE permissions
-		SET read=$1, write=$2, manage=$3, admin=$4
-		WHERE user_id=$5 AND type=$6 AND type_id=$7
-	`, req.Read, req.Write, req.Manage, req.Admin, c.Param("userId"), "room", c.Param("roomId"))
+	err = s.db.Permissions().Update(permission)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSSMERM008"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -348,6 +321,7 @@ func EditRoomMember(c echo.Context) error {
 			Message: "Member can't be updated",
 		})
 	}
+	s.publishMemberEvent(c, "updated", permission)
 
 	return c.JSON(http.StatusOK, MessageResponse{
 		Message: "Member has been updated",
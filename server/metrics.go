@@ -0,0 +1,182 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/ItsJimi/casa/logger"
+	"github.com/labstack/echo"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "casa",
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	dbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "casa",
+		Name:      "db_query_duration_seconds",
+		Help:      "Duration of database queries by statement type.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "status"})
+
+	gatewayConnected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "casa",
+		Name:      "gateway_connected",
+		Help:      "1 if a gateway is connected to the websocket, 0 otherwise.",
+	})
+
+	datasReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "casa",
+		Name:      "datas_received_total",
+		Help:      "Number of datas received from gateways.",
+	})
+
+	datasSaved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "casa",
+		Name:      "datas_saved_total",
+		Help:      "Number of datas saved in database by status.",
+	}, []string{"status"})
+
+	datasSaveDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "casa",
+		Name:      "datas_save_duration_seconds",
+		Help:      "Duration of a SaveNewDatas call.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	automationEvaluations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "casa",
+		Name:      "automation_evaluations_total",
+		Help:      "Number of automation evaluations.",
+	})
+
+	automationEvaluationDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "casa",
+		Name:      "automation_evaluation_duration_seconds",
+		Help:      "Duration of a single automation evaluation.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	automationRuns = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "casa",
+		Name:      "automation_runs_total",
+		Help:      "Number of automations whose conditions matched and actions were sent.",
+	})
+
+	actionsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "casa",
+		Name:      "actions_sent_total",
+		Help:      "Number of actions sent to gateways by source.",
+	}, []string{"source"})
+
+	actionSendFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "casa",
+		Name:      "action_send_failures_total",
+		Help:      "Number of actions which can't be sent to gateways by source.",
+	}, []string{"source"})
+
+	notificationsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "casa",
+		Name:      "notifications_sent_total",
+		Help:      "Number of notifications delivered by channel and status.",
+	}, []string{"channel", "status"})
+
+	wsClientsConnected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "casa",
+		Name:      "websocket_clients_connected",
+		Help:      "Number of clients connected to the client websocket.",
+	})
+
+	wsClientsEvicted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "casa",
+		Name:      "websocket_clients_evicted_total",
+		Help:      "Number of clients disconnected because they didn't read their messages fast enough.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		httpRequestDuration,
+		dbQueryDuration,
+		gatewayConnected,
+		datasReceived,
+		datasSaved,
+		datasSaveDuration,
+		automationEvaluations,
+		automationEvaluationDuration,
+		automationRuns,
+		actionsSent,
+		actionSendFailures,
+		notificationsSent,
+		wsClientsConnected,
+		wsClientsEvicted,
+	)
+}
+
+// metricsMiddleware observe duration of each request with the matched route
+func metricsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+
+		status := c.Response().Status
+		if err != nil {
+			status = 500
+			if httpErr, ok := err.(*echo.HTTPError); ok {
+				status = httpErr.Code
+			}
+		}
+
+		route := c.Path()
+		if route == "" {
+			route = "unknown"
+		}
+
+		httpRequestDuration.WithLabelValues(c.Request().Method, route, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// deviceCollector expose latest numeric datas of each device as gauges
+type deviceCollector struct {
+	desc  *prometheus.Desc
+	store Store
+}
+
+func newDeviceCollector(store Store) *deviceCollector {
+	return &deviceCollector{
+		store: store,
+		desc: prometheus.NewDesc(
+			"casa_device_value",
+			"Latest numeric value received for a device field.",
+			[]string{"home", "room", "device", "device_id", "field"},
+			nil,
+		),
+	}
+}
+
+func (dc *deviceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dc.desc
+}
+
+func (dc *deviceCollector) Collect(ch chan<- prometheus.Metric) {
+	values, err := dc.store.Datas().LatestValues()
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSMDCC001"}).Errorf("%s", err.Error())
+		return
+	}
+
+	for _, value := range values {
+		ch <- prometheus.MustNewConstMetric(dc.desc, prometheus.GaugeValue, value.Value, value.Home, value.Room, value.Device, value.DeviceID, value.Field)
+	}
+}
+
+// RegisterDeviceMetrics enable the exporter of latest device values
+func RegisterDeviceMetrics(store Store) {
+	prometheus.MustRegister(newDeviceCollector(store))
+}
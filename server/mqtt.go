@@ -0,0 +1,118 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ItsJimi/casa/config"
+	"github.com/ItsJimi/casa/logger"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttRetryInterval define delay between connection attempts to broker
+const mqttRetryInterval = 10 * time.Second
+
+// mqttBridge publish device datas to an MQTT broker and turn set messages into actions
+type mqttBridge struct {
+	client mqtt.Client
+	conf   config.MQTT
+}
+
+// StartMQTT connect server to MQTT broker, retrying in background until broker is reachable
+func (s *Server) StartMQTT(conf config.MQTT) {
+	bridge := &mqttBridge{
+		conf: conf,
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(conf.Broker).
+		SetClientID(conf.ClientID).
+		SetUsername(conf.Username).
+		SetPassword(conf.Password).
+		SetAutoReconnect(true).
+		SetWill(bridge.topic("status"), "offline", conf.QoS, true).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			logger.WithFields(logger.Fields{}).Infof("Connected to MQTT broker %s", conf.Broker)
+			client.Publish(bridge.topic("status"), conf.QoS, true, "online")
+			token := client.Subscribe(bridge.topic("+", "+", "+", "+", "set"), conf.QoS, s.mqttSetHandler(bridge))
+			if token.Wait() && token.Error() != nil {
+				logger.WithFields(logger.Fields{"code": "CSMSM001"}).Errorf("%s", token.Error().Error())
+			}
+			go s.publishDiscovery()
+		}).
+		SetConnectionLostHandler(func(client mqtt.Client, err error) {
+			logger.WithFields(logger.Fields{"code": "CSMSM002"}).Warnf("MQTT connection lost: %s", err.Error())
+		})
+	bridge.client = mqtt.NewClient(opts)
+	s.mqtt = bridge
+
+	go func() {
+		for {
+			token := bridge.client.Connect()
+			if token.Wait() && token.Error() == nil {
+				return
+			}
+			logger.WithFields(logger.Fields{"code": "CSMSM003"}).Errorf("%s", token.Error().Error())
+			time.Sleep(mqttRetryInterval)
+		}
+	}()
+}
+
+// topic join levels after topic prefix
+func (bridge *mqttBridge) topic(levels ...string) string {
+	return strings.Join(append([]string{bridge.conf.TopicPrefix}, levels...), "/")
+}
+
+// publishData publish value of data event to <prefix>/<home>/<room>/<device>/<field>
+func (bridge *mqttBridge) publishData(event Event, fieldType string) {
+	data, ok := event.Data.(Datas)
+	if !ok {
+		return
+	}
+	topic := bridge.topic(event.HomeID, event.RoomID, event.DeviceID, data.Field)
+	bridge.client.Publish(topic, bridge.conf.QoS, bridge.conf.Retain, dataValue(data, fieldType))
+}
+
+// mqttSetHandler call action of device when a message is received on <prefix>/<home>/<room>/<device>/<action>/set
+func (s *Server) mqttSetHandler(bridge *mqttBridge) mqtt.MessageHandler {
+	return func(client mqtt.Client, message mqtt.Message) {
+		levels := strings.Split(strings.TrimPrefix(message.Topic(), bridge.conf.TopicPrefix+"/"), "/")
+		if len(levels) != 5 {
+			return
+		}
+		homeID, roomID, deviceID, call := levels[0], levels[1], levels[2], levels[3]
+
+		device, err := s.db.Devices().ByID(deviceID)
+		if err != nil || device.RoomID != roomID {
+			logger.WithFields(logger.Fields{"code": "CSMMSH001", "topic": message.Topic()}).Warnf("Device can't be found")
+			return
+		}
+		room, err := s.db.Rooms().ByID(roomID)
+		if err != nil || room.HomeID != homeID {
+			logger.WithFields(logger.Fields{"code": "CSMMSH002", "topic": message.Topic()}).Warnf("Room can't be found")
+			return
+		}
+
+		err = s.sendDeviceAction(device, call, string(message.Payload()), "mqtt")
+		if err != nil {
+			logger.WithFields(logger.Fields{"code": "CSMMSH003", "topic": message.Topic()}).Errorf("%s", err.Error())
+		}
+	}
+}
+
+// dataValue format value of data according to type of its field
+func dataValue(data Datas, fieldType string) string {
+	switch fieldType {
+	case "string":
+		return data.ValueStr
+	case "bool":
+		return strconv.FormatBool(data.ValueBool)
+	case "int":
+		return strconv.FormatFloat(data.ValueNbr, 'f', -1, 64)
+	}
+	if data.ValueStr != "" {
+		return data.ValueStr
+	}
+	return strconv.FormatFloat(data.ValueNbr, 'f', -1, 64)
+}
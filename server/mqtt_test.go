@@ -0,0 +1,219 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ItsJimi/casa/config"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	broker "github.com/mochi-co/mqtt/server"
+	"github.com/mochi-co/mqtt/server/listeners"
+)
+
+// fakeGateway keep messages sent to gateway
+type fakeGateway struct {
+	messages chan []byte
+}
+
+func (g fakeGateway) SetAddr(addr string) {}
+
+func (g fakeGateway) Send(message []byte) error {
+	g.messages <- message
+	return nil
+}
+
+func (g fakeGateway) Fetch(path string) ([]byte, error) {
+	return nil, errGatewayOffline
+}
+
+// freeAddress return a local address nothing listens on
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startTestBroker start an embedded MQTT broker on a random port and return its address
+func startTestBroker(t *testing.T) string {
+	t.Helper()
+	address := freeAddress(t)
+
+	b := broker.New()
+	err := b.AddListener(listeners.NewTCP("test", address), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Serve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return address
+}
+
+// startDropProxy forward connections to target until drop is called, which cut them without MQTT disconnect
+func startDropProxy(t *testing.T, target string) (string, func()) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mutex sync.Mutex
+	conns := []net.Conn{}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", target)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			mutex.Lock()
+			conns = append(conns, conn, upstream)
+			mutex.Unlock()
+			go io.Copy(upstream, conn)
+			go io.Copy(conn, upstream)
+		}
+	}()
+
+	drop := func() {
+		l.Close()
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+	t.Cleanup(drop)
+	return l.Addr().String(), drop
+}
+
+// subscribeTest connect a client to broker and return messages received on filter
+func subscribeTest(t *testing.T, address string, clientID string, filter string) <-chan mqtt.Message {
+	t.Helper()
+	messages := make(chan mqtt.Message, 100)
+	client := mqtt.NewClient(mqtt.NewClientOptions().AddBroker("tcp://" + address).SetClientID(clientID))
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	t.Cleanup(func() { client.Disconnect(0) })
+	token := client.Subscribe(filter, 1, func(client mqtt.Client, message mqtt.Message) {
+		messages <- message
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	return messages
+}
+
+// waitMessage return next message of topic, ignoring others
+func waitMessage(t *testing.T, messages <-chan mqtt.Message, topic string) mqtt.Message {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case message := <-messages:
+			if message.Topic() == topic {
+				return message
+			}
+		case <-timeout:
+			t.Fatalf("no message on %s", topic)
+		}
+	}
+}
+
+func TestMQTTBridge(t *testing.T) {
+	address := startTestBroker(t)
+	proxy, drop := startDropProxy(t, address)
+
+	store := &fakeStore{
+		rooms:   []Room{{ID: "kitchen", HomeID: "home"}},
+		devices: []Device{{ID: "lamp", RoomID: "kitchen", PhysicalID: "0x01", Plugin: "zigbee", Config: "{}"}},
+	}
+	gateway := fakeGateway{messages: make(chan []byte, 10)}
+	s := NewServer(store, gateway)
+
+	messages := subscribeTest(t, address, "observer", "casa/#")
+	s.StartMQTT(config.MQTT{
+		Broker:      "tcp://" + proxy,
+		ClientID:    "casa-test",
+		TopicPrefix: "casa",
+		QoS:         1,
+	})
+	t.Cleanup(func() { s.mqtt.client.Disconnect(0) })
+
+	status := waitMessage(t, messages, "casa/status")
+	if string(status.Payload()) != "online" {
+		t.Fatalf("status = %s, want online", status.Payload())
+	}
+
+	t.Run("publish datas", func(t *testing.T) {
+		s.SaveNewDatas([]Datas{{DeviceID: "0x01", Field: "temperature", ValueNbr: 21.5}})
+
+		message := waitMessage(t, messages, "casa/home/kitchen/lamp/temperature")
+		if string(message.Payload()) != "21.5" {
+			t.Errorf("payload = %s, want 21.5", message.Payload())
+		}
+	})
+
+	t.Run("set to action", func(t *testing.T) {
+		client := mqtt.NewClient(mqtt.NewClientOptions().AddBroker("tcp://" + address).SetClientID("tool"))
+		if token := client.Connect(); token.Wait() && token.Error() != nil {
+			t.Fatal(token.Error())
+		}
+		defer client.Disconnect(0)
+		// room of device doesn't match, it's ignored
+		client.Publish("casa/home/bedroom/lamp/toggle/set", 1, false, "false").Wait()
+		// bridge subscribes to set topics just after announcing it is online, so publishing is retried
+		var raw []byte
+		timeout := time.After(5 * time.Second)
+		for raw == nil {
+			client.Publish("casa/home/kitchen/lamp/toggle/set", 1, false, "true").Wait()
+			select {
+			case raw = <-gateway.messages:
+			case <-time.After(200 * time.Millisecond):
+			case <-timeout:
+				t.Fatal("no action sent to gateway")
+			}
+		}
+
+		var message WebsocketMessage
+		var action ActionMessage
+		if err := json.Unmarshal(raw, &message); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(message.Body, &action); err != nil {
+			t.Fatal(err)
+		}
+		want := ActionMessage{PhysicalID: "0x01", Plugin: "zigbee", Call: "toggle", Config: "{}", Params: "true"}
+		if message.Action != "callAction" || action != want {
+			t.Errorf("sent %s %+v, want callAction %+v", message.Action, action, want)
+		}
+	})
+
+	t.Run("will", func(t *testing.T) {
+		drop()
+
+		status := waitMessage(t, messages, "casa/status")
+		if string(status.Payload()) != "offline" {
+			t.Fatalf("status = %s, want offline", status.Payload())
+		}
+
+		retained := waitMessage(t, subscribeTest(t, address, "late", "casa/status"), "casa/status")
+		if string(retained.Payload()) != "offline" || !retained.Retained() {
+			t.Errorf("retained status = %s (retained %t), want offline", retained.Payload(), retained.Retained())
+		}
+	})
+}
@@ -0,0 +1,486 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ItsJimi/casa/config"
+	"github.com/ItsJimi/casa/logger"
+	"github.com/ItsJimi/casa/utils"
+	"github.com/labstack/echo"
+)
+
+// notificationAction is the automation action target sending a notification instead of calling a device,
+// its call is the user notified, every member of home when empty, and its value is the message
+const notificationAction = "notification"
+
+// NotificationChannel define a way to deliver notifications to users
+type NotificationChannel interface {
+	// Name identify channel in delivery results and metrics
+	Name() string
+	// Enabled tell if preference of user ask for channel
+	Enabled(preference NotificationPreference) bool
+	// Push tell if channel interrupts user, push channels are muted during quiet hours
+	Push() bool
+	Send(notification Notification, user User, preference NotificationPreference) error
+}
+
+type notificationPreferenceReq struct {
+	App        *bool
+	Email      *bool
+	HTTPURL    *string `json:"httpUrl"`
+	HTTPFormat *string `json:"httpFormat"`
+	QuietStart *string
+	QuietEnd   *string
+	Timezone   *string
+}
+
+type notificationDelivery struct {
+	Channel string `json:"channel"`
+	Status  string `json:"status"` // sent, failed
+	Error   string `json:"error,omitempty"`
+}
+
+// StartNotifications build notification channels from configuration, in-app channel is always enabled
+func (s *Server) StartNotifications(conf config.Notifications) {
+	s.notifiers = []NotificationChannel{
+		appChannel{hub: s.hub},
+		newHTTPChannel(conf.HTTPTimeout),
+	}
+	if conf.SMTP.Host != "" {
+		s.notifiers = append(s.notifiers, smtpChannel{conf: conf.SMTP})
+	}
+	s.lowBattery = conf.LowBattery
+}
+
+// notifyAutomation send message of automation to user, or to every member of its home when userID is empty
+func (s *Server) notifyAutomation(auto Automation, userID string, message string) {
+	if userID == "" {
+		s.notifyMembers(auto.HomeID, auto.Name, message, "automation")
+		return
+	}
+	if _, err := s.db.Permissions().Get(userID, "home", auto.HomeID); err != nil {
+		logger.WithFields(logger.Fields{"code": "CSNNA002"}).Warnf("User %s isn't a member of home %s", userID, auto.HomeID)
+		return
+	}
+
+	s.notify(Notification{
+		UserID:  userID,
+		HomeID:  auto.HomeID,
+		Title:   auto.Name,
+		Message: message,
+		Source:  "automation",
+	}, false)
+}
+
+// notifyMembers send message to every member of home
+func (s *Server) notifyMembers(homeID string, title string, message string, source string) {
+	members, err := s.db.Permissions().Members("home", homeID)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSNNM001"}).Errorf("%s", err.Error())
+		return
+	}
+	for _, member := range members {
+		s.notify(Notification{
+			UserID:  member.User.ID,
+			HomeID:  homeID,
+			Title:   title,
+			Message: message,
+			Source:  source,
+		}, false)
+	}
+}
+
+// notifyGatewayOffline tell members of homes linked to a gateway that their devices can't be reached
+func (s *Server) notifyGatewayOffline() {
+	gateways, err := s.db.Gateways().ListLinked()
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSNNGO001"}).Errorf("%s", err.Error())
+		return
+	}
+	for _, gateway := range gateways {
+		name := gateway.Name
+		if name == "" {
+			name = "Gateway"
+		}
+		s.notifyMembers(gateway.HomeID, "Gateway offline", name+" is offline, devices can't be reached", "gateway")
+	}
+}
+
+// batteryLow tell if data is a battery level crossing the low battery threshold, so members are notified once
+func (s *Server) batteryLow(device Device, data Datas) bool {
+	if s.lowBattery <= 0 || !strings.EqualFold(data.Field, "battery") || data.ValueNbr > s.lowBattery {
+		return false
+	}
+	previous, err := s.db.Datas().Latest(device.ID, data.Field)
+	if err == sql.ErrNoRows {
+		return true
+	}
+	return err == nil && previous.ValueNbr > s.lowBattery
+}
+
+// notifyLowBattery tell members of home of device that its battery must be changed
+func (s *Server) notifyLowBattery(device Device, level float64) {
+	room, err := s.db.Rooms().ByID(device.RoomID)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSNNLB001"}).Errorf("%s", err.Error())
+		return
+	}
+	s.notifyMembers(room.HomeID, "Low battery", fmt.Sprintf("%s battery is at %g%%", device.Name, level), "battery")
+}
+
+// notifyMember tell user of permission that its access to home, room or device changed
+func (s *Server) notifyMember(homeID string, action string, permission Permission) {
+	var name string
+	switch permission.Type {
+	case "home":
+		home, err := s.db.Homes().ByID(permission.TypeID)
+		if err != nil {
+			logger.WithFields(logger.Fields{"code": "CSNNME001"}).Errorf("%s", err.Error())
+			return
+		}
+		name = home.Name
+	case "room":
+		room, err := s.db.Rooms().ByID(permission.TypeID)
+		if err != nil {
+			logger.WithFields(logger.Fields{"code": "CSNNME002"}).Errorf("%s", err.Error())
+			return
+		}
+		name = room.Name
+	case "device":
+		device, err := s.db.Devices().ByID(permission.TypeID)
+		if err != nil {
+			logger.WithFields(logger.Fields{"code": "CSNNME003"}).Errorf("%s", err.Error())
+			return
+		}
+		name = device.Name
+	}
+
+	message := "Your rights on " + permission.Type + " " + name + " have been updated"
+	switch action {
+	case "added":
+		message = "You have been added to " + permission.Type + " " + name
+	case "removed":
+		message = "You have been removed from " + permission.Type + " " + name
+	}
+	s.notify(Notification{
+		UserID:  permission.UserID,
+		HomeID:  homeID,
+		Title:   "Membership",
+		Message: message,
+		Source:  "member",
+	}, false)
+}
+
+// notify deliver notification through channels asked by preference of its user, push channels are skipped
+// during quiet hours unless force is true
+func (s *Server) notify(notification Notification, force bool) []notificationDelivery {
+	deliveries := []notificationDelivery{}
+	if notification.ID == "" {
+		notification.ID = utils.NewULID()
+	}
+	if notification.CreatedAt == "" {
+		notification.CreatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+
+	user, err := s.db.Users().ByID(notification.UserID)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSNN001"}).Errorf("%s", err.Error())
+		return deliveries
+	}
+	preference, err := s.notificationPreference(notification.UserID, notification.HomeID)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSNN002"}).Errorf("%s", err.Error())
+		return deliveries
+	}
+	quiet := !force && preference.quiet(time.Now())
+
+	// history is kept whatever channels are enabled, in-app channel only delivers it live
+	if err := s.db.Notifications().Create(notification); err != nil {
+		logger.WithFields(logger.Fields{"code": "CSNN004"}).Errorf("%s", err.Error())
+	}
+
+	for _, channel := range s.notifiers {
+		if !channel.Enabled(preference) || (quiet && channel.Push()) {
+			continue
+		}
+		delivery := notificationDelivery{
+			Channel: channel.Name(),
+			Status:  "sent",
+		}
+		if err := channel.Send(notification, user, preference); err != nil {
+			logger.WithFields(logger.Fields{"code": "CSNN003", "channel": channel.Name()}).Errorf("%s", err.Error())
+			delivery.Status, delivery.Error = "failed", err.Error()
+		}
+		notificationsSent.WithLabelValues(delivery.Channel, delivery.Status).Inc()
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries
+}
+
+// notificationPreference return preference of user in home, defaults when user never saved one
+func (s *Server) notificationPreference(userID string, homeID string) (NotificationPreference, error) {
+	preference, err := s.db.NotificationPreferences().Get(userID, homeID)
+	if err == sql.ErrNoRows {
+		return NotificationPreference{
+			UserID:     userID,
+			HomeID:     homeID,
+			App:        true,
+			HTTPFormat: "text",
+		}, nil
+	}
+	return preference, err
+}
+
+// quiet tell if t is in quiet hours of preference
+func (p NotificationPreference) quiet(t time.Time) bool {
+	if p.QuietStart == "" || p.QuietEnd == "" || p.QuietStart == p.QuietEnd {
+		return false
+	}
+	if location, err := time.LoadLocation(p.Timezone); err == nil && p.Timezone != "" {
+		t = t.In(location)
+	}
+	// HH:MM strings compare like times of day
+	now := t.Format("15:04")
+	if p.QuietStart < p.QuietEnd {
+		return now >= p.QuietStart && now < p.QuietEnd
+	}
+	return now >= p.QuietStart || now < p.QuietEnd
+}
+
+// validNotificationPreference check formats of preference
+func validNotificationPreference(preference NotificationPreference) error {
+	if preference.HTTPURL != "" {
+		if err := validWebhookURL(preference.HTTPURL); err != nil {
+			return err
+		}
+	}
+	if preference.HTTPFormat != "text" && preference.HTTPFormat != "json" {
+		return errors.New("httpFormat must be text or json")
+	}
+	for _, hour := range []string{preference.QuietStart, preference.QuietEnd} {
+		if _, err := time.Parse("15:04", hour); hour != "" && err != nil {
+			return errors.New("Quiet hours must be formatted HH:MM")
+		}
+	}
+	if _, err := time.LoadLocation(preference.Timezone); err != nil {
+		return errors.New("Unknown timezone")
+	}
+	return nil
+}
+
+// GetNotificationPreference route get how user is notified for home
+func (s *Server) GetNotificationPreference(c echo.Context) error {
+	user := c.Get("user").(User)
+	preference, err := s.notificationPreference(user.ID, c.Param("homeId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSNGNP001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSNGNP001",
+			Message: "Preference can't be retrieved",
+		})
+	}
+
+	return c.JSON(http.StatusOK, DataReponse{
+		Data: preference,
+	})
+}
+
+// UpdateNotificationPreference route change how user is notified for home
+func (s *Server) UpdateNotificationPreference(c echo.Context) error {
+	req := new(notificationPreferenceReq)
+	if err := c.Bind(req); err != nil {
+		logger.WithFields(logger.Fields{"code": "CSNUNP001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSNUNP001",
+			Message: "Wrong parameters",
+		})
+	}
+
+	user := c.Get("user").(User)
+	preference, err := s.notificationPreference(user.ID, c.Param("homeId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSNUNP002"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSNUNP002",
+			Message: "Preference can't be retrieved",
+		})
+	}
+
+	if req.App != nil {
+		preference.App = *req.App
+	}
+	if req.Email != nil {
+		preference.Email = *req.Email
+	}
+	if req.HTTPURL != nil {
+		preference.HTTPURL = *req.HTTPURL
+	}
+	if req.HTTPFormat != nil {
+		preference.HTTPFormat = *req.HTTPFormat
+	}
+	if req.QuietStart != nil {
+		preference.QuietStart = *req.QuietStart
+	}
+	if req.QuietEnd != nil {
+		preference.QuietEnd = *req.QuietEnd
+	}
+	if req.Timezone != nil {
+		preference.Timezone = *req.Timezone
+	}
+
+	if err := validNotificationPreference(preference); err != nil {
+		logger.WithFields(logger.Fields{"code": "CSNUNP003"}).Warnf("%s", err.Error())
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSNUNP003",
+			Message: err.Error(),
+		})
+	}
+
+	err = s.db.NotificationPreferences().Set(preference)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSNUNP004"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSNUNP004",
+			Message: "Preference can't be updated",
+		})
+	}
+
+	return c.JSON(http.StatusOK, MessageResponse{
+		Message: "Preference updated",
+	})
+}
+
+// TestNotification route send a test notification to user through its channels, even during quiet hours
+func (s *Server) TestNotification(c echo.Context) error {
+	user := c.Get("user").(User)
+	deliveries := s.notify(Notification{
+		UserID:  user.ID,
+		HomeID:  c.Param("homeId"),
+		Title:   "Casa",
+		Message: "Test notification",
+		Source:  "test",
+	}, true)
+
+	return c.JSON(http.StatusOK, DataReponse{
+		Data: deliveries,
+	})
+}
+
+type notificationsRes struct {
+	Data   []Notification `json:"data"`
+	Unread int            `json:"unread"`
+}
+
+// GetNotifications route get last notifications of user with its count of unread ones, only unread ones with ?unread=true
+func (s *Server) GetNotifications(c echo.Context) error {
+	limit := 50
+	if c.QueryParam("limit") != "" {
+		value, err := strconv.Atoi(c.QueryParam("limit"))
+		if err != nil || value < 1 || value > 500 {
+			logger.WithFields(logger.Fields{"code": "CSNGN001"}).Warnf("Wrong limit %s", c.QueryParam("limit"))
+			return c.JSON(http.StatusBadRequest, ErrorResponse{
+				Code:    "CSNGN001",
+				Message: "Limit must be between 1 and 500",
+			})
+		}
+		limit = value
+	}
+
+	user := c.Get("user").(User)
+	notifications, err := s.db.Notifications().ListForUser(user.ID, c.QueryParam("unread") == "true", limit)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSNGN002"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSNGN002",
+			Message: "Notifications can't be retrieved",
+		})
+	}
+	unread, err := s.db.Notifications().CountUnread(user.ID)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSNGN003"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSNGN003",
+			Message: "Notifications can't be retrieved",
+		})
+	}
+
+	return c.JSON(http.StatusOK, notificationsRes{
+		Data:   notifications,
+		Unread: unread,
+	})
+}
+
+// ReadNotification route mark a notification of user as read
+func (s *Server) ReadNotification(c echo.Context) error {
+	user := c.Get("user").(User)
+	_, err := s.db.Notifications().GetForUser(user.ID, c.Param("notificationId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSNRN001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    "CSNRN001",
+			Message: "Notification not found",
+		})
+	}
+
+	err = s.db.Notifications().MarkRead(user.ID, c.Param("notificationId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSNRN002"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSNRN002",
+			Message: "Notification can't be updated",
+		})
+	}
+
+	return c.JSON(http.StatusOK, MessageResponse{
+		Message: "Notification read",
+	})
+}
+
+// ReadNotifications route mark every notification of user as read
+func (s *Server) ReadNotifications(c echo.Context) error {
+	user := c.Get("user").(User)
+	err := s.db.Notifications().MarkRead(user.ID, "")
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSNRNS001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSNRNS001",
+			Message: "Notifications can't be updated",
+		})
+	}
+
+	return c.JSON(http.StatusOK, MessageResponse{
+		Message: "Notifications read",
+	})
+}
+
+// DeleteNotification route delete a notification of user
+func (s *Server) DeleteNotification(c echo.Context) error {
+	user := c.Get("user").(User)
+	_, err := s.db.Notifications().GetForUser(user.ID, c.Param("notificationId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSNDN001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    "CSNDN001",
+			Message: "Notification not found",
+		})
+	}
+
+	err = s.db.Notifications().Delete(user.ID, c.Param("notificationId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSNDN002"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSNDN002",
+			Message: "Notification can't be deleted",
+		})
+	}
+
+	return c.JSON(http.StatusOK, MessageResponse{
+		Message: "Notification deleted",
+	})
+}
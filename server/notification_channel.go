@@ -0,0 +1,181 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ItsJimi/casa/config"
+)
+
+// appChannel push notifications to websocket clients of user
+type appChannel struct {
+	hub *hub
+}
+
+func (ch appChannel) Name() string { return "app" }
+
+func (ch appChannel) Enabled(preference NotificationPreference) bool { return preference.App }
+
+// Push is false, in-app notifications are shown even during quiet hours
+func (ch appChannel) Push() bool { return false }
+
+func (ch appChannel) Send(notification Notification, user User, preference NotificationPreference) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	message := WebsocketMessage{
+		Action: "notification",
+		Body:   body,
+	}
+	for _, client := range ch.hub.list() {
+		if client.user.ID != user.ID {
+			continue
+		}
+		if err := client.send(message); err == errSlowClient {
+			wsClientsEvicted.Inc()
+			ch.hub.unregister(client)
+		}
+	}
+	return nil
+}
+
+// httpChannel post notifications to url of user, as text with a Title header like ntfy or as json like Gotify
+type httpChannel struct {
+	client *http.Client
+}
+
+func newHTTPChannel(timeout time.Duration) httpChannel {
+	return httpChannel{
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (ch httpChannel) Name() string { return "http" }
+
+func (ch httpChannel) Enabled(preference NotificationPreference) bool {
+	return preference.HTTPURL != ""
+}
+
+func (ch httpChannel) Push() bool { return true }
+
+func (ch httpChannel) Send(notification Notification, user User, preference NotificationPreference) error {
+	var req *http.Request
+	var err error
+	if preference.HTTPFormat == "json" {
+		body, _ := json.Marshal(map[string]interface{}{
+			"title":    notification.Title,
+			"message":  notification.Message,
+			"priority": 5,
+		})
+		req, err = http.NewRequest(http.MethodPost, preference.HTTPURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req, err = http.NewRequest(http.MethodPost, preference.HTTPURL, strings.NewReader(notification.Message))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		req.Header.Set("Title", mime.QEncoding.Encode("utf-8", notification.Title))
+	}
+	req.Header.Set("User-Agent", "Casa-Notification")
+
+	resp, err := ch.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s", preference.HTTPURL, resp.Status)
+	}
+	return nil
+}
+
+// smtpChannel email notifications to address of user
+type smtpChannel struct {
+	conf config.SMTP
+}
+
+func (ch smtpChannel) Name() string { return "email" }
+
+func (ch smtpChannel) Enabled(preference NotificationPreference) bool { return preference.Email }
+
+func (ch smtpChannel) Push() bool { return true }
+
+func (ch smtpChannel) Send(notification Notification, user User, preference NotificationPreference) error {
+	addr := net.JoinHostPort(ch.conf.Host, strconv.Itoa(ch.conf.Port))
+	var auth smtp.Auth
+	if ch.conf.Username != "" {
+		auth = smtp.PlainAuth("", ch.conf.Username, ch.conf.Password, ch.conf.Host)
+	}
+	message := ch.message(notification, user)
+	if !ch.conf.TLS {
+		// STARTTLS is used when server offers it, plain auth is refused on unencrypted connections except to localhost
+		return smtp.SendMail(addr, auth, ch.conf.From, []string{user.Email}, message)
+	}
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: ch.conf.Host})
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, ch.conf.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(ch.conf.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(user.Email); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// message return email with headers, lines end with CRLF
+func (ch smtpChannel) message(notification Notification, user User) []byte {
+	headers := []string{
+		"From: " + ch.conf.From,
+		"To: " + user.Email,
+		"Subject: " + mime.QEncoding.Encode("utf-8", notification.Title),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: <" + notification.ID + "@casa>",
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+	}
+	body := strings.Replace(notification.Message, "\r\n", "\n", -1)
+	body = strings.Replace(body, "\n", "\r\n", -1)
+	return []byte(strings.Join(headers, "\r\n") + "\r\n\r\n" + body + "\r\n")
+}
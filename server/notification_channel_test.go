@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ItsJimi/casa/config"
+)
+
+// smtpSession is what a client sent to the fake SMTP server
+type smtpSession struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// startSMTPServer accept one SMTP session on a random port, offering AUTH PLAIN without STARTTLS
+func startSMTPServer(t *testing.T) (string, int, <-chan smtpSession) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	sessions := make(chan smtpSession, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		r := bufio.NewReader(conn)
+		reply := func(lines ...string) {
+			conn.Write([]byte(strings.Join(lines, "\r\n") + "\r\n"))
+		}
+		session := smtpSession{}
+		reply("220 localhost ESMTP test")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch verb {
+			case "EHLO", "HELO":
+				reply("250-localhost", "250 AUTH PLAIN")
+			case "AUTH":
+				credentials, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "AUTH PLAIN "))
+				session.auth = string(credentials)
+				reply("235 2.7.0 Authenticated")
+			case "MAIL":
+				session.from = strings.TrimPrefix(line, "MAIL FROM:")
+				reply("250 OK")
+			case "RCPT":
+				session.to = append(session.to, strings.TrimPrefix(line, "RCPT TO:"))
+				reply("250 OK")
+			case "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var data strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(strings.TrimPrefix(line, "."))
+				}
+				session.data = data.String()
+				reply("250 OK")
+			case "QUIT":
+				reply("221 Bye")
+				sessions <- session
+				return
+			default:
+				reply("502 Not implemented")
+			}
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	return host, portNumber, sessions
+}
+
+func TestSMTPChannelSend(t *testing.T) {
+	host, port, sessions := startSMTPServer(t)
+	channel := smtpChannel{conf: config.SMTP{
+		Host:     host,
+		Port:     port,
+		Username: "casa",
+		Password: "secret",
+		From:     "casa@example.com",
+	}}
+
+	err := channel.Send(Notification{
+		ID:      "01EXAMPLE",
+		Title:   "Température",
+		Message: "Kitchen is at 31°C\n.hidden dot\nOpen a window",
+	}, User{Email: "user@example.com"}, NotificationPreference{Email: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var session smtpSession
+	select {
+	case session = <-sessions:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no SMTP session")
+	}
+
+	if session.auth != "\x00casa\x00secret" {
+		t.Errorf("auth = %q, want plain auth of casa", session.auth)
+	}
+	if session.from != "<casa@example.com>" {
+		t.Errorf("MAIL FROM = %s", session.from)
+	}
+	if len(session.to) != 1 || session.to[0] != "<user@example.com>" {
+		t.Errorf("RCPT TO = %v", session.to)
+	}
+
+	parts := strings.SplitN(session.data, "\r\n\r\n", 2)
+	if len(parts) != 2 {
+		t.Fatalf("no headers in %q", session.data)
+	}
+	for _, header := range []string{
+		"From: casa@example.com",
+		"To: user@example.com",
+		"Subject: =?utf-8?q?Temp=C3=A9rature?=",
+		"Message-ID: <01EXAMPLE@casa>",
+		"Content-Type: text/plain; charset=utf-8",
+	} {
+		if !strings.Contains(parts[0]+"\r\n", header+"\r\n") {
+			t.Errorf("header %s missing in\n%s", header, parts[0])
+		}
+	}
+	if want := "Kitchen is at 31°C\r\n.hidden dot\r\nOpen a window\r\n"; parts[1] != want {
+		t.Errorf("body = %q, want %q", parts[1], want)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeChannel record notifications it sends
+type fakeChannel struct {
+	name string
+	push bool
+	sent *[]string
+}
+
+func (ch fakeChannel) Name() string { return ch.name }
+
+func (ch fakeChannel) Enabled(preference NotificationPreference) bool { return true }
+
+func (ch fakeChannel) Push() bool { return ch.push }
+
+func (ch fakeChannel) Send(notification Notification, user User, preference NotificationPreference) error {
+	*ch.sent = append(*ch.sent, ch.name)
+	return nil
+}
+
+func TestNotificationPreferenceQuiet(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2020, 1, 2, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name       string
+		start, end string
+		timezone   string
+		t          time.Time
+		quiet      bool
+	}{
+		{"no quiet hours", "", "", "", at(3, 0), false},
+		{"same start and end", "22:00", "22:00", "", at(22, 0), false},
+		{"in day range", "13:00", "14:00", "UTC", at(13, 30), true},
+		{"at day range end", "13:00", "14:00", "UTC", at(14, 0), false},
+		{"before overnight range", "22:00", "07:00", "UTC", at(21, 59), false},
+		{"in overnight range evening", "22:00", "07:00", "UTC", at(22, 0), true},
+		{"in overnight range morning", "22:00", "07:00", "UTC", at(6, 59), true},
+		{"after overnight range", "22:00", "07:00", "UTC", at(7, 0), false},
+		{"in range of timezone", "22:00", "07:00", "Europe/Paris", at(21, 30), true},
+		{"out of range of timezone", "22:00", "07:00", "Europe/Paris", at(6, 30), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			preference := NotificationPreference{QuietStart: test.start, QuietEnd: test.end, Timezone: test.timezone}
+			if quiet := preference.quiet(test.t); quiet != test.quiet {
+				t.Errorf("quiet = %t, want %t", quiet, test.quiet)
+			}
+		})
+	}
+}
+
+func TestNotifyQuietHours(t *testing.T) {
+	now := time.Now().UTC()
+	// quiet hours around or away from now, in UTC so the test doesn't depend on timezone of server
+	around := [2]string{now.Add(-time.Hour).Format("15:04"), now.Add(time.Hour).Format("15:04")}
+	away := [2]string{now.Add(time.Hour).Format("15:04"), now.Add(2 * time.Hour).Format("15:04")}
+
+	tests := []struct {
+		name  string
+		quiet [2]string
+		force bool
+		sent  []string
+	}{
+		{"out of quiet hours", away, false, []string{"app", "push"}},
+		{"in quiet hours", around, false, []string{"app"}},
+		{"forced in quiet hours", around, true, []string{"app", "push"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &fakeStore{
+				users: []User{{ID: "user", Email: "user@example.com"}},
+				preferences: []NotificationPreference{
+					{UserID: "user", HomeID: "home", App: true, QuietStart: test.quiet[0], QuietEnd: test.quiet[1], Timezone: "UTC"},
+				},
+			}
+			sent := []string{}
+			s := NewServer(store, nil)
+			s.notifiers = []NotificationChannel{
+				fakeChannel{name: "app", sent: &sent},
+				fakeChannel{name: "push", push: true, sent: &sent},
+			}
+
+			deliveries := s.notify(Notification{UserID: "user", HomeID: "home", Title: "Test", Message: "Test"}, test.force)
+
+			if len(sent) != len(test.sent) || len(deliveries) != len(test.sent) {
+				t.Fatalf("sent through %v, want %v", sent, test.sent)
+			}
+			for i := range sent {
+				if sent[i] != test.sent[i] || deliveries[i].Channel != test.sent[i] || deliveries[i].Status != "sent" {
+					t.Errorf("sent through %v with %+v, want %v", sent, deliveries, test.sent)
+				}
+			}
+			// history is kept even when push channels are muted
+			if len(store.notifications) != 1 {
+				t.Errorf("%d notifications kept, want 1", len(store.notifications))
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ItsJimi/casa/config"
+	"github.com/ItsJimi/casa/logger"
+	"github.com/labstack/echo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// oauthCodeLifetime define how long an authorization code can be exchanged
+	oauthCodeLifetime = 10 * time.Minute
+	// oauthTokenLifetime define how long an access token given to an OAuth client is valid
+	oauthTokenLifetime = time.Hour
+)
+
+var (
+	errOAuthRedirectURI = errors.New("Redirect uri doesn't match authorization request")
+	errOAuthClient      = errors.New("Grant was given to another client")
+)
+
+var oauthLoginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Casa</title></head>
+<body>
+<h1>Link your Casa account</h1>
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<form method="post">
+<input type="hidden" name="response_type" value="code">
+<input type="hidden" name="client_id" value="{{.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+<input type="hidden" name="state" value="{{.State}}">
+<p><input type="email" name="email" placeholder="Email" required></p>
+<p><input type="password" name="password" placeholder="Password" required></p>
+<p><button type="submit">Link</button></p>
+</form>
+</body>
+</html>
+`))
+
+type oauthLogin struct {
+	ClientID    string
+	RedirectURI string
+	State       string
+	Error       string
+}
+
+type oauthTokenRes struct {
+	TokenType    string `json:"token_type"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// oauthErrorRes is an error of token endpoint, formatted as OAuth clients expect
+type oauthErrorRes struct {
+	Error string `json:"error"`
+}
+
+// oauthClient return configured client with id and redirect uri
+func (s *Server) oauthClient(id string, redirectURI string) (config.OAuthClient, bool) {
+	for _, client := range s.oauthClients {
+		if client.ID != id {
+			continue
+		}
+		for _, uri := range client.RedirectURIs {
+			if uri == redirectURI {
+				return client, true
+			}
+		}
+	}
+	return config.OAuthClient{}, false
+}
+
+// renderOAuthLogin answer login form as html
+func renderOAuthLogin(c echo.Context, status int, login oauthLogin) error {
+	var page bytes.Buffer
+	if err := oauthLoginPage.Execute(&page, login); err != nil {
+		return err
+	}
+	return c.HTMLBlob(status, page.Bytes())
+}
+
+// OAuthAuthorize route display login form linking casa account to an OAuth client
+func (s *Server) OAuthAuthorize(c echo.Context) error {
+	login := oauthLogin{
+		ClientID:    c.QueryParam("client_id"),
+		RedirectURI: c.QueryParam("redirect_uri"),
+		State:       c.QueryParam("state"),
+	}
+	if _, ok := s.oauthClient(login.ClientID, login.RedirectURI); !ok || c.QueryParam("response_type") != "code" {
+		logger.WithFields(logger.Fields{"code": "CSOOA001"}).Warnf("Unknown OAuth client or redirect uri")
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSOOA001",
+			Message: "Unknown client or redirect uri",
+		})
+	}
+
+	return renderOAuthLogin(c, http.StatusOK, login)
+}
+
+// OAuthAuthorizeSubmit route check user credentials and redirect to OAuth client with an authorization code
+func (s *Server) OAuthAuthorizeSubmit(c echo.Context) error {
+	login := oauthLogin{
+		ClientID:    c.FormValue("client_id"),
+		RedirectURI: c.FormValue("redirect_uri"),
+		State:       c.FormValue("state"),
+	}
+	if _, ok := s.oauthClient(login.ClientID, login.RedirectURI); !ok || c.FormValue("response_type") != "code" {
+		logger.WithFields(logger.Fields{"code": "CSOOAS001"}).Warnf("Unknown OAuth client or redirect uri")
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSOOAS001",
+			Message: "Unknown client or redirect uri",
+		})
+	}
+	redirect, err := url.Parse(login.RedirectURI)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSOOAS002"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSOOAS002",
+			Message: "Wrong redirect uri",
+		})
+	}
+
+	user, err := s.db.Users().ByEmail(c.FormValue("email"))
+	if err == nil {
+		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(c.FormValue("password")))
+	}
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSOOAS003"}).Warnf("%s", err.Error())
+		login.Error = "Email and password doesn't match"
+		return renderOAuthLogin(c, http.StatusUnauthorized, login)
+	}
+
+	code, err := newSecret()
+	if err == nil {
+		err = s.db.OAuthGrants().Create(OAuthGrant{
+			ID:          code,
+			Type:        "code",
+			ClientID:    login.ClientID,
+			UserID:      user.ID,
+			RedirectURI: login.RedirectURI,
+		}, time.Now().Add(oauthCodeLifetime))
+	}
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSOOAS004"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSOOAS004",
+			Message: "Authorization code can't be created",
+		})
+	}
+
+	query := redirect.Query()
+	query.Set("code", code)
+	if login.State != "" {
+		query.Set("state", login.State)
+	}
+	redirect.RawQuery = query.Encode()
+	return c.Redirect(http.StatusFound, redirect.String())
+}
+
+// OAuthToken route exchange an authorization code or a refresh token for an access token
+func (s *Server) OAuthToken(c echo.Context) error {
+	clientID, clientSecret, ok := c.Request().BasicAuth()
+	if !ok {
+		clientID = c.FormValue("client_id")
+		clientSecret = c.FormValue("client_secret")
+	}
+
+	var client config.OAuthClient
+	for _, conf := range s.oauthClients {
+		if conf.ID == clientID && subtle.ConstantTimeCompare([]byte(conf.Secret), []byte(clientSecret)) == 1 {
+			client = conf
+		}
+	}
+	if client.ID == "" {
+		logger.WithFields(logger.Fields{"code": "CSOOT001"}).Warnf("Wrong OAuth client credentials")
+		return c.JSON(http.StatusUnauthorized, oauthErrorRes{Error: "invalid_client"})
+	}
+
+	var grant OAuthGrant
+	var err error
+	switch c.FormValue("grant_type") {
+	case "authorization_code":
+		grant, err = s.db.OAuthGrants().ByID(c.FormValue("code"), "code")
+		if err == nil && grant.RedirectURI != c.FormValue("redirect_uri") {
+			err = errOAuthRedirectURI
+		}
+		// a code can be exchanged only once
+		s.db.OAuthGrants().Delete(c.FormValue("code"))
+	case "refresh_token":
+		grant, err = s.db.OAuthGrants().ByID(c.FormValue("refresh_token"), "refresh")
+	default:
+		logger.WithFields(logger.Fields{"code": "CSOOT002"}).Warnf("Unsupported grant type")
+		return c.JSON(http.StatusBadRequest, oauthErrorRes{Error: "unsupported_grant_type"})
+	}
+	if err == nil && grant.ClientID != client.ID {
+		err = errOAuthClient
+	}
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSOOT003"}).Warnf("%s", err.Error())
+		return c.JSON(http.StatusBadRequest, oauthErrorRes{Error: "invalid_grant"})
+	}
+
+	res := oauthTokenRes{
+		TokenType: "Bearer",
+		ExpiresIn: int(oauthTokenLifetime.Seconds()),
+	}
+	res.AccessToken, err = newSecret()
+	if err == nil {
+		err = s.db.Tokens().CreateWithExpiry(Token{
+			ID:        res.AccessToken,
+			UserID:    grant.UserID,
+			Type:      "oauth",
+			IP:        c.RealIP(),
+			UserAgent: c.Request().UserAgent(),
+		}, time.Now().Add(oauthTokenLifetime))
+	}
+	if err == nil && grant.Type == "code" {
+		res.RefreshToken, err = newSecret()
+		if err == nil {
+			err = s.db.OAuthGrants().Create(OAuthGrant{
+				ID:       res.RefreshToken,
+				Type:     "refresh",
+				ClientID: client.ID,
+				UserID:   grant.UserID,
+			}, time.Time{})
+		}
+	}
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSOOT004"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, oauthErrorRes{Error: "server_error"})
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, res)
+}
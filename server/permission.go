@@ -1,68 +1,94 @@
 package server
 
 import (
+	"database/sql"
 	"net/http"
+	"strings"
 
 	"github.com/ItsJimi/casa/logger"
 	"github.com/labstack/echo"
 )
 
-// hasPermission
-func hasPermission(next echo.HandlerFunc, permissionType string, read, write, manage, admin bool) echo.HandlerFunc {
+// rightCodes are the codes hasPermission answers when a right is missing
+var rightCodes = map[string]string{
+	"read":   "CSPHP003",
+	"write":  "CSPHP004",
+	"manage": "CSPHP005",
+	"admin":  "CSPHP006",
+}
+
+// missingRight return the first right asked which permission lacks, empty when it has them all, admin grants every right
+func missingRight(permission Permission, read, write, manage, admin bool) string {
+	switch {
+	case permission.Admin:
+		return ""
+	case read && !permission.Read:
+		return "read"
+	case write && !permission.Write:
+		return "write"
+	case manage && !permission.Manage:
+		return "manage"
+	case admin:
+		return "admin"
+	}
+	return ""
+}
+
+// hasPermission answer 404 when user has no permission on element, 403 when rights or token scope are missing
+// and 500 when permission can't be read
+func (s *Server) hasPermission(next echo.HandlerFunc, permissionType string, read, write, manage, admin bool) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		reqUser := c.Get("user").(User)
 
-		row := DB.QueryRowx(`
-			SELECT * FROM permissions WHERE user_id=$1 AND type=$2 AND type_id=$3
-		`, reqUser.ID, permissionType, c.Param(permissionType+"Id"))
-
-		if row == nil {
-			logger.WithFields(logger.Fields{"code": "CSPHP001", "userId": reqUser.ID, "type": permissionType, "typeId": c.Param(permissionType + "Id")}).Warnf("Unauthorized")
-			return c.JSON(http.StatusUnauthorized, ErrorResponse{
-				Code:    "CSPHP001",
-				Message: "Unauthorized",
+		permission, err := s.db.Permissions().Get(reqUser.ID, permissionType, c.Param(permissionType+"Id"))
+		if err == sql.ErrNoRows {
+			logger.WithFields(logger.Fields{"code": "CSPHP002", "userId": reqUser.ID, "type": permissionType, "typeId": c.Param(permissionType + "Id")}).Warnf("%s", err.Error())
+			return c.JSON(http.StatusNotFound, ErrorResponse{
+				Code:    "CSPHP002",
+				Message: strings.Title(permissionType) + " not found",
 			})
 		}
-
-		var permission Permission
-		err := row.StructScan(&permission)
 		if err != nil {
-			logger.WithFields(logger.Fields{"code": "CSPHP002", "userId": reqUser.ID, "type": permissionType, "typeId": c.Param(permissionType + "Id")}).Errorf("%s", err.Error())
-			return c.JSON(http.StatusUnauthorized, ErrorResponse{
-				Code:    "CSPHP002",
-				Message: "Unauthorized",
+			logger.WithFields(logger.Fields{"code": "CSPHP008", "userId": reqUser.ID, "type": permissionType, "typeId": c.Param(permissionType + "Id")}).Errorf("%s", err.Error())
+			return c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Code:    "CSPHP008",
+				Message: "Permission can't be checked",
 			})
 		}
 
-		if read == true && permission.Read == false && permission.Admin != true {
-			logger.WithFields(logger.Fields{"code": "CSPHP003", "userId": reqUser.ID, "type": permissionType, "typeId": c.Param(permissionType + "Id")}).Warnf("Unauthorized")
-			return c.JSON(http.StatusUnauthorized, ErrorResponse{
-				Code:    "CSPHP003",
-				Message: "Unauthorized",
-			})
-		}
-		if write == true && permission.Write == false && permission.Admin != true {
-			logger.WithFields(logger.Fields{"code": "CSPHP004", "userId": reqUser.ID, "type": permissionType, "typeId": c.Param(permissionType + "Id")}).Warnf("Unauthorized")
-			return c.JSON(http.StatusUnauthorized, ErrorResponse{
-				Code:    "CSPHP004",
-				Message: "Unauthorized",
-			})
-		}
-		if manage == true && permission.Manage == false && permission.Admin != true {
-			logger.WithFields(logger.Fields{"code": "CSPHP005", "userId": reqUser.ID, "type": permissionType, "typeId": c.Param(permissionType + "Id")}).Warnf("Unauthorized")
-			return c.JSON(http.StatusUnauthorized, ErrorResponse{
-				Code:    "CSPHP005",
-				Message: "Unauthorized",
+		if right := missingRight(permission, read, write, manage, admin); right != "" {
+			code := rightCodes[right]
+			logger.WithFields(logger.Fields{"code": code, "userId": reqUser.ID, "type": permissionType, "typeId": c.Param(permissionType + "Id")}).Warnf("Unauthorized")
+			return c.JSON(http.StatusForbidden, ErrorResponse{
+				Code:    code,
+				Message: "Forbidden",
 			})
 		}
-		if admin == true && permission.Admin == false {
-			logger.WithFields(logger.Fields{"code": "CSPHP006", "userId": reqUser.ID, "type": permissionType, "typeId": c.Param(permissionType + "Id")}).Warnf("Unauthorized")
-			return c.JSON(http.StatusUnauthorized, ErrorResponse{
-				Code:    "CSPHP006",
-				Message: "Unauthorized",
+
+		if token, ok := c.Get("token").(Token); ok && (!token.allows(read, write, manage, admin) || !s.inTokenScope(token, c)) {
+			logger.WithFields(logger.Fields{"code": "CSPHP007", "userId": reqUser.ID, "tokenId": token.ID, "type": permissionType, "typeId": c.Param(permissionType + "Id")}).Warnf("Token scope")
+			return c.JSON(http.StatusForbidden, ErrorResponse{
+				Code:    "CSPHP007",
+				Message: "Forbidden",
 			})
 		}
 
 		return next(c)
 	}
 }
+
+// clientHasPermission check permission of websocket client user like hasPermission do for routes
+func (s *Server) clientHasPermission(client *wsClient, permissionType string, typeID string, read, write, manage, admin bool) bool {
+	permission, err := s.db.Permissions().Get(client.user.ID, permissionType, typeID)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSPCHP001", "userId": client.user.ID, "type": permissionType, "typeId": typeID}).Errorf("%s", err.Error())
+		return false
+	}
+
+	if missingRight(permission, read, write, manage, admin) != "" {
+		logger.WithFields(logger.Fields{"code": "CSPCHP002", "userId": client.user.ID, "type": permissionType, "typeId": typeID}).Warnf("Unauthorized")
+		return false
+	}
+
+	return true
+}
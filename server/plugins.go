@@ -7,6 +7,6 @@ import (
 )
 
 // GetPlugins route get list of home plugins
-func GetPlugins(c echo.Context) error {
-	return c.JSON(http.StatusOK, Configs)
+func (s *Server) GetPlugins(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.configs)
 }
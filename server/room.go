@@ -15,7 +15,7 @@ type addRoomReq struct {
 }
 
 // AddRoom route create and add user to an room
-func AddRoom(c echo.Context) error {
+func (s *Server) AddRoom(c echo.Context) error {
 	req := new(addRoomReq)
 	if err := c.Bind(req); err != nil {
 		logger.WithFields(logger.Fields{"code": "CSRAR001"}).Errorf("%s", err.Error())
@@ -35,7 +35,13 @@ func AddRoom(c echo.Context) error {
 
 	user := c.Get("user").(User)
 
-	row, err := DB.Query("INSERT INTO rooms (id, name, home_id, creator_id) VALUES (generate_ulid(), $1, $2, $3) RETURNING id;", req.Name, c.Param("homeId"), user.ID)
+	newRoom := Room{
+		ID:        utils.NewULID(),
+		Name:      req.Name,
+		HomeID:    c.Param("homeId"),
+		CreatorID: user.ID,
+	}
+	err := s.db.Rooms().Create(newRoom)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSRAR003"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusBadRequest, ErrorResponse{
@@ -43,27 +49,18 @@ func AddRoom(c echo.Context) error {
 			Message: "Room can't be created",
 		})
 	}
-	var roomID string
-	row.Next()
-	err = row.Scan(&roomID)
-	if err != nil {
-		logger.WithFields(logger.Fields{"code": "CSRAR004"}).Errorf("%s", err.Error())
-		return c.JSON(http.StatusBadRequest, ErrorResponse{
-			Code:    "CSRAR004",
-			Message: "Room can't be created",
-		})
-	}
 
 	newPermission := Permission{
+		ID:     utils.NewULID(),
 		UserID: user.ID,
 		Type:   "room",
-		TypeID: roomID,
+		TypeID: newRoom.ID,
 		Read:   true,
 		Write:  true,
 		Manage: true,
 		Admin:  true,
 	}
-	_, err = DB.NamedExec("INSERT INTO permissions (id, user_id, type, type_id, read, write, manage, admin) VALUES (generate_ulid(), :user_id, :type, :type_id, :read, :write, :manage, :admin)", newPermission)
+	err = s.db.Permissions().Create(newPermission)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSRAR005"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -73,12 +70,12 @@ func AddRoom(c echo.Context) error {
 	}
 
 	return c.JSON(http.StatusCreated, MessageResponse{
-		Message: roomID,
+		Message: newRoom.ID,
 	})
 }
 
 // UpdateRoom route update room
-func UpdateRoom(c echo.Context) error {
+func (s *Server) UpdateRoom(c echo.Context) error {
 	req := new(addRoomReq)
 	if err := c.Bind(req); err != nil {
 		logger.WithFields(logger.Fields{"code": "CSRUR001"}).Errorf("%s", err.Error())
@@ -95,8 +92,7 @@ func UpdateRoom(c echo.Context) error {
 
 	user := c.Get("user").(User)
 
-	var permission Permission
-	err := DB.Get(&permission, "SELECT * FROM permissions WHERE user_id=$1 AND type=$2 AND type_id=$3", user.ID, "room", c.Param("roomId"))
+	permission, err := s.db.Permissions().Get(user.ID, "room", c.Param("roomId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSRUR003"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusNotFound, ErrorResponse{
@@ -113,7 +109,10 @@ func UpdateRoom(c echo.Context) error {
 		})
 	}
 
-	_, err = DB.Exec("UPDATE rooms SET Name=$1 WHERE id=$2", req.Name, c.Param("roomId"))
+	err = s.db.Rooms().Update(Room{
+		ID:   c.Param("roomId"),
+		Name: req.Name,
+	})
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSRUR005"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -128,11 +127,10 @@ func UpdateRoom(c echo.Context) error {
 }
 
 // DeleteRoom route delete room
-func DeleteRoom(c echo.Context) error {
+func (s *Server) DeleteRoom(c echo.Context) error {
 	user := c.Get("user").(User)
 
-	var permission Permission
-	err := DB.Get(&permission, "SELECT * FROM permissions WHERE user_id=$1 AND type=$2 AND type_id=$3", user.ID, "room", c.Param("roomId"))
+	permission, err := s.db.Permissions().Get(user.ID, "room", c.Param("roomId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSRDR001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusNotFound, ErrorResponse{
@@ -149,7 +147,7 @@ func DeleteRoom(c echo.Context) error {
 		})
 	}
 
-	_, err = DB.Exec("DELETE FROM rooms WHERE id=$1", c.Param("roomId"))
+	err = s.db.Rooms().Delete(c.Param("roomId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSRDR003"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -157,7 +155,7 @@ func DeleteRoom(c echo.Context) error {
 			Message: "Room can't be deleted",
 		})
 	}
-	_, err = DB.Exec("DELETE FROM permissions WHERE type=$1 AND type_id=$2", "room", c.Param("roomId"))
+	err = s.db.Permissions().DeleteAll("room", c.Param("roomId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSRDR004"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -171,16 +169,6 @@ func DeleteRoom(c echo.Context) error {
 	})
 }
 
-type permissionRoom struct {
-	Permission
-	User
-	RoomID        string `db:"r_id"`
-	RoomName      string `db:"r_name"`
-	RoomHomeID    string `db:"r_homeid"`
-	RoomCreatedAt string `db:"r_createdat"`
-	RoomUpdatedAt string `db:"r_updatedat"`
-}
-
 type roomRes struct {
 	ID        string `json:"id"`
 	Name      string `json:"name"`
@@ -195,16 +183,10 @@ type roomRes struct {
 }
 
 // GetRooms route get list of user rooms
-func GetRooms(c echo.Context) error {
+func (s *Server) GetRooms(c echo.Context) error {
 	user := c.Get("user").(User)
 
-	rows, err := DB.Queryx(`
-		SELECT permissions.*, users.*,
-		rooms.id as r_id,	rooms.name AS r_name, rooms.home_id AS r_homeid, rooms.created_at AS r_createdat, rooms.updated_at AS r_updatedat FROM permissions
-		JOIN rooms ON permissions.type_id = rooms.id
-		JOIN users ON rooms.creator_id = users.id
-		WHERE permissions.type=$1 AND permissions.user_id=$2 AND rooms.home_id=$3 AND (permissions.read=true OR permissions.admin=true)
-	`, "room", user.ID, c.Param("homeId"))
+	permissions, err := s.db.Rooms().ListForUser(user.ID, c.Param("homeId"))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSRGRS001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -212,20 +194,9 @@ func GetRooms(c echo.Context) error {
 			Message: "Rooms can't be retrieved",
 		})
 	}
-	defer rows.Close()
 
 	rooms := []roomRes{}
-	for rows.Next() {
-		var permission permissionRoom
-		err := rows.StructScan(&permission)
-		if err != nil {
-			logger.WithFields(logger.Fields{"code": "CSRGRS002"}).Errorf("%s", err.Error())
-			return c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Code:    "CSRGRS002",
-				Message: "Rooms can't be retrieved",
-			})
-		}
-
+	for _, permission := range permissions {
 		rooms = append(rooms, roomRes{
 			ID:        permission.RoomID,
 			Name:      permission.RoomName,
@@ -246,20 +217,12 @@ func GetRooms(c echo.Context) error {
 }
 
 // GetRoom route get specific room with id
-func GetRoom(c echo.Context) error {
+func (s *Server) GetRoom(c echo.Context) error {
 	user := c.Get("user").(User)
 
-	var permission permissionRoom
-	err := DB.QueryRowx(`
-		SELECT permissions.*, users.*,
-		rooms.id as r_id,	rooms.name AS r_name, rooms.home_id AS r_homeid, rooms.created_at AS r_createdat, rooms.updated_at AS r_updatedat FROM permissions
-		JOIN rooms ON permissions.type_id = rooms.id
-		JOIN users ON rooms.creator_id = users.id
-		WHERE permissions.type=$1 AND permissions.type_id=$2 AND permissions.user_id=$3
-	`, "room", c.Param("roomId"), user.ID).StructScan(&permission)
-
+	permission, err := s.db.Rooms().GetForUser(user.ID, c.Param("roomId"))
 	if err != nil {
-		logger.WithFields(logger.Fields{"code": "CSRGR001"}).Errorf("QueryRowx: Select error")
+		logger.WithFields(logger.Fields{"code": "CSRGR001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusNotFound, ErrorResponse{
 			Code:    "CSRGR001",
 			Message: "Room can't be found",
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"github.com/ItsJimi/casa/config"
+	"github.com/getcasa/sdk"
+)
+
+// Server hold dependencies shared by routes, gateway readers and automations
+type Server struct {
+	db      Store
+	gateway GatewayTransport
+	// origins allowed to open client websocket
+	origins []string
+	// trustedProxies are addresses or networks whose forwarded client address is believed
+	trustedProxies []string
+
+	// configs define plugins configuration sent by gateway
+	configs []sdk.Configuration
+	// queues keep datas of direct triggers until next automations loop
+	queues           []Datas
+	automationStates []automationState
+	hub              *hub
+	broker           *eventBroker
+	hookLimiter      *rateLimiter
+	// mqtt is nil when MQTT bridge is disabled
+	mqtt *mqttBridge
+	// homekit is nil when HomeKit bridges are disabled
+	homekit *homekitBridges
+	// hue is nil when Hue bridge emulation is disabled
+	hue *hueBridge
+	// notifiers deliver notifications to users
+	notifiers []NotificationChannel
+	// lowBattery is the battery percentage notifying members of home, 0 disables it
+	lowBattery float64
+	// oauthClients can link users accounts, like voice assistants
+	oauthClients []config.OAuthClient
+}
+
+// NewServer return a server using store for storage and gateway to reach the gateway
+func NewServer(store Store, gateway GatewayTransport) *Server {
+	return &Server{
+		db:      store,
+		gateway: gateway,
+		hub:     newHub(),
+		broker:  newEventBroker(),
+		// shared by hook tokens and client addresses
+		hookLimiter: newRateLimiter(hookRateLimit, hookRateWindow),
+	}
+}
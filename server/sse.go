@@ -0,0 +1,206 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ItsJimi/casa/logger"
+	"github.com/labstack/echo"
+)
+
+// eventBufferSize define how many events are kept to resume streams with Last-Event-ID
+const eventBufferSize = 1000
+
+// sseKeepAlive define interval of comments sent to keep streams open through proxies, token of stream is checked again at the same time
+var sseKeepAlive = 30 * time.Second
+
+// sseSubscriberBuffer define how many events can wait for a stream before it's closed
+const sseSubscriberBuffer = 64
+
+type sseSubscriber struct {
+	events chan Event
+	// tokenID is the token stream was opened with, revoked is set when events is closed because it was deleted
+	tokenID string
+	revoked bool
+}
+
+// eventBroker keep last events and dispatch new ones to streams
+type eventBroker struct {
+	mutex       sync.Mutex
+	buffer      []Event
+	subscribers map[*sseSubscriber]bool
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		subscribers: map[*sseSubscriber]bool{},
+	}
+}
+
+// add keep event and send it to streams, closing streams which are too slow
+func (b *eventBroker) add(event Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.buffer = append(b.buffer, event)
+	if len(b.buffer) > eventBufferSize {
+		b.buffer = b.buffer[len(b.buffer)-eventBufferSize:]
+	}
+
+	for sub := range b.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// subscribe return a new stream of tokenID and buffered events after lastEventID, all of them when lastEventID is no longer buffered
+func (b *eventBroker) subscribe(lastEventID string, tokenID string) (*sseSubscriber, []Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	sub := &sseSubscriber{
+		events:  make(chan Event, sseSubscriberBuffer),
+		tokenID: tokenID,
+	}
+	b.subscribers[sub] = true
+
+	missed := []Event{}
+	if lastEventID == "" {
+		return sub, missed
+	}
+	// ULIDs aren't ordered within a millisecond, so events are replayed from position of lastEventID
+	start := 0
+	for i, event := range b.buffer {
+		if event.ID == lastEventID {
+			start = i + 1
+			break
+		}
+	}
+	missed = append(missed, b.buffer[start:]...)
+	return sub, missed
+}
+
+// dropToken close streams opened with tokenID
+func (b *eventBroker) dropToken(tokenID string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for sub := range b.subscribers {
+		if sub.tokenID == tokenID {
+			sub.revoked = true
+			delete(b.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+func (b *eventBroker) unsubscribe(sub *sseSubscriber) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.subscribers[sub] {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// GetHomeEvents route stream events of home with server-sent events
+func (s *Server) GetHomeEvents(c echo.Context) error {
+	user := c.Get("user").(User)
+	token := c.Get("token").(Token)
+	homeID := c.Param("homeId")
+	deviceID := c.QueryParam("deviceId")
+	types := map[string]bool{}
+	for _, typ := range strings.Split(c.QueryParam("type"), ",") {
+		if typ != "" {
+			types[typ] = true
+		}
+	}
+	permissions := newPermissionCache()
+
+	match := func(event Event) bool {
+		if event.HomeID != homeID {
+			return false
+		}
+		if len(types) > 0 && !types[event.Type] {
+			return false
+		}
+		if deviceID != "" && event.DeviceID != deviceID {
+			return false
+		}
+		return s.allowed(user.ID, permissions, event)
+	}
+
+	sub, missed := s.broker.subscribe(c.Request().Header.Get("Last-Event-ID"), token.ID)
+	defer s.broker.unsubscribe(sub)
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	for _, event := range missed {
+		if match(event) {
+			if err := writeSSE(res, event); err != nil {
+				return nil
+			}
+		}
+	}
+	res.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case event, ok := <-sub.events:
+			if !ok && sub.revoked {
+				logger.WithFields(logger.Fields{"code": "CSSGHE003", "userId": user.ID}).Warnf("Token revoked, closing events stream")
+				return nil
+			}
+			if !ok {
+				logger.WithFields(logger.Fields{"code": "CSSGHE001", "userId": user.ID}).Warnf("Events stream too slow, closing it")
+				return nil
+			}
+			if !match(event) {
+				continue
+			}
+			if err := writeSSE(res, event); err != nil {
+				return nil
+			}
+			res.Flush()
+		case <-keepAlive.C:
+			if err := s.tokenValid(token.ID); err != nil {
+				logger.WithFields(logger.Fields{"code": "CSSGHE002", "userId": user.ID}).Warnf("%s", err.Error())
+				return nil
+			}
+			if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
+
+// writeSSE write event in server-sent events format
+func writeSSE(res *echo.Response, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(res, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
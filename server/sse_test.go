@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ItsJimi/casa/config"
+)
+
+func TestEventBrokerSubscribe(t *testing.T) {
+	// ids within a millisecond aren't ordered, so buffer order isn't id order
+	buffer := []Event{{ID: "01C"}, {ID: "01A"}, {ID: "01D"}, {ID: "01B"}}
+	ids := func(events []Event) []string {
+		list := []string{}
+		for _, event := range events {
+			list = append(list, event.ID)
+		}
+		return list
+	}
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		missed      []string
+	}{
+		{"no last event", "", []string{}},
+		{"first event", "01C", []string{"01A", "01D", "01B"}},
+		{"event before greater ids", "01A", []string{"01D", "01B"}},
+		{"last event", "01B", []string{}},
+		{"event no longer buffered", "00Z", []string{"01C", "01A", "01D", "01B"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newEventBroker()
+			for _, event := range buffer {
+				b.add(event)
+			}
+			sub, missed := b.subscribe(test.lastEventID, "token")
+			defer b.unsubscribe(sub)
+
+			if got := ids(missed); !reflect.DeepEqual(got, test.missed) {
+				t.Errorf("missed %v, want %v", got, test.missed)
+			}
+		})
+	}
+}
+
+func TestHomeEventsTokenRevoked(t *testing.T) {
+	keepAlive := sseKeepAlive
+	sseKeepAlive = 20 * time.Millisecond
+	defer func() { sseKeepAlive = keepAlive }()
+
+	store := newRoutesFixture()
+	s := NewServer(store, nil)
+	server := httptest.NewServer(s.Router(config.Configuration{}))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/v1/homes/home/events", nil)
+	req.Header.Set("Authorization", "Bearer member-token")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", res.StatusCode)
+	}
+
+	closed := make(chan bool)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		keepAlives := 0
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), ": keep-alive") {
+				keepAlives++
+			}
+		}
+		closed <- keepAlives > 0
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	store.Tokens().Delete("member-token")
+
+	select {
+	case keptAlive := <-closed:
+		if !keptAlive {
+			t.Error("stream was closed before its token was revoked")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream is still open after its token was revoked")
+	}
+}
+
+func TestGatewayEventsOfLinkedHomes(t *testing.T) {
+	store := newRoutesFixture()
+	store.homes = append(store.homes, Home{ID: "other", Name: "Other", CreatorID: "stranger"})
+	store.permissions = append(store.permissions, Permission{UserID: "stranger", Type: "home", TypeID: "other", Read: true})
+	store.gateways = []Gateway{{ID: "gateway", HomeID: "home"}, {ID: "unlinked"}}
+	s := NewServer(store, nil)
+
+	s.gatewayConnected(true)
+	defer setGatewayOnline(false)
+
+	events := s.broker.buffer
+	if len(events) != 1 || events[0].Type != "gateway" || events[0].HomeID != "home" {
+		t.Fatalf("published %+v, want a gateway event of home", events)
+	}
+	for _, test := range []struct {
+		userID  string
+		allowed bool
+	}{{"member", true}, {"stranger", false}} {
+		if allowed := s.allowed(test.userID, newPermissionCache(), events[0]); allowed != test.allowed {
+			t.Errorf("%s allowed = %t, want %t", test.userID, allowed, test.allowed)
+		}
+	}
+	if s.allowed("member", newPermissionCache(), Event{Type: "gateway"}) {
+		t.Error("event outside homes is allowed")
+	}
+}
+
+func TestHomeEventsAPIKeyDeleted(t *testing.T) {
+	store := newRoutesFixture()
+	store.tokens = append(store.tokens, Token{
+		ID: "home-key", UserID: "owner", Type: apiKeyType, Read: true, ScopeType: "home", ScopeID: "home",
+		SecretHash: hashAPIKeySecret("secret"), ExpireAt: time.Now().Add(time.Hour).Format(time.RFC3339),
+	})
+	s := NewServer(store, nil)
+	server := httptest.NewServer(s.Router(config.Configuration{}))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/v1/homes/home/events", nil)
+	req.Header.Set("Authorization", "Bearer home-key.secret")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", res.StatusCode)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+		}
+		close(closed)
+	}()
+
+	// stream is closed by deletion, long before its token would be checked again at keep-alive
+	req, _ = http.NewRequest(http.MethodDelete, server.URL+"/v1/apikeys/home-key", nil)
+	req.Header.Set("Authorization", "Bearer owner-token")
+	deleted, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted.Body.Close()
+	if deleted.StatusCode != http.StatusOK {
+		t.Fatalf("delete status = %d, want 200", deleted.StatusCode)
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream is still open after its API key was deleted")
+	}
+}
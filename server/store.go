@@ -0,0 +1,268 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/ItsJimi/casa/migrations"
+)
+
+// Store define the storage backend of casa
+type Store interface {
+	Users() UserStore
+	Tokens() TokenStore
+	Homes() HomeStore
+	Rooms() RoomStore
+	Gateways() GatewayStore
+	Plugins() PluginStore
+	Devices() DeviceStore
+	Permissions() PermissionStore
+	Automations() AutomationStore
+	Datas() DatasStore
+	Logs() LogStore
+	Webhooks() WebhookStore
+	AutomationHooks() AutomationHookStore
+	OAuthGrants() OAuthGrantStore
+	HomeKit() HomeKitStore
+	HueDevices() HueDeviceStore
+	Aliases() AliasStore
+	NotificationPreferences() NotificationPreferenceStore
+	Notifications() NotificationStore
+
+	Ping(ctx context.Context) error
+	Close() error
+
+	// Migrate apply schema migrations until version target, 0 means latest
+	Migrate(target int) error
+	// Rollback revert the last steps applied schema migrations
+	Rollback(steps int) error
+	SchemaVersion() (int, error)
+	SchemaStatus() ([]migrations.State, error)
+}
+
+// Rows define a cursor on rows returned by a store
+type Rows interface {
+	Next() bool
+	StructScan(dest interface{}) error
+	Err() error
+	Close() error
+}
+
+// UserStore define access to users
+type UserStore interface {
+	Create(user User) error
+	ByID(id string) (User, error)
+	ByEmail(email string) (User, error)
+	UpdateProfil(id string, firstname string, lastname string) error
+	UpdateEmail(id string, email string) error
+	UpdatePassword(id string, password string) error
+}
+
+// TokenStore define access to users tokens
+type TokenStore interface {
+	Create(token Token) error
+	Delete(id string) error
+	// CreateWithExpiry create token expiring at expireAt instead of in a month
+	CreateWithExpiry(token Token, expireAt time.Time) error
+	// CreateAPIKey create token with its rights, scope and secret hash, expiring at expireAt
+	CreateAPIKey(token Token, expireAt time.Time) error
+	// ByIDWithUser return token with its owner
+	ByIDWithUser(id string) (Token, User, error)
+	ListForUser(userID string, tokenType string) ([]Token, error)
+}
+
+// HomeStore define access to homes
+type HomeStore interface {
+	Create(home Home) error
+	ByID(id string) (Home, error)
+	// Update change non empty name, address and wifi ssid of home
+	Update(home Home) error
+	Delete(id string) error
+	ListForUser(userID string) ([]PermissionHome, error)
+	GetForUser(userID string, homeID string) (PermissionHome, error)
+}
+
+// RoomStore define access to rooms
+type RoomStore interface {
+	Create(room Room) error
+	ByID(id string) (Room, error)
+	Update(room Room) error
+	Delete(id string) error
+	// ListForUser return rooms of home readable by user
+	ListForUser(userID string, homeID string) ([]PermissionRoom, error)
+	GetForUser(userID string, roomID string) (PermissionRoom, error)
+}
+
+// GatewayStore define access to gateways
+type GatewayStore interface {
+	Create(gateway Gateway) error
+	ByID(id string) (Gateway, error)
+	// Update change non empty name of gateway
+	Update(gateway Gateway) error
+	Link(id string, userID string, homeID string) error
+	Delete(id string) error
+	GetForUser(userID string, gatewayID string) (PermissionGateway, error)
+	// ListLinked return gateways linked to a home
+	ListLinked() ([]Gateway, error)
+}
+
+// PluginStore define access to gateways plugins configuration
+type PluginStore interface {
+	// Save create plugin or update config of plugin with the same name on gateway
+	Save(plugin Plugin) error
+	ByName(gatewayID string, name string) (Plugin, error)
+}
+
+// DeviceStore define access to devices
+type DeviceStore interface {
+	Create(device Device) error
+	ByID(id string) (Device, error)
+	ByPhysicalID(gatewayID string, physicalID string) (Device, error)
+	ByPhysicalIDs(physicalIDs []string) ([]Device, error)
+	// ListAllForUser return devices of every room user can read
+	ListAllForUser(userID string) ([]PermissionDevice, error)
+	All() ([]Device, error)
+	// KnownPhysicalIDs return physicalIDs already added in home
+	KnownPhysicalIDs(homeID string, physicalIDs []string) ([]string, error)
+	// Update change non empty name, room and icon of device and return it
+	Update(device Device) (Device, error)
+	Delete(id string) error
+	// ListForUser return devices of room readable by user
+	ListForUser(userID string, roomID string) ([]PermissionDevice, error)
+	GetForUser(userID string, roomID string, deviceID string) (PermissionDevice, error)
+}
+
+// PermissionStore define access to users permissions
+type PermissionStore interface {
+	Create(permission Permission) error
+	Get(userID string, typ string, typeID string) (Permission, error)
+	// Update change rights of permission with same user, type and type id
+	Update(permission Permission) error
+	Delete(userID string, typ string, typeID string) error
+	DeleteAll(typ string, typeID string) error
+	// Members return permissions with their user on typ typeID
+	Members(typ string, typeID string) ([]PermissionMember, error)
+}
+
+// AutomationStore define access to automations
+type AutomationStore interface {
+	Create(automation Automation) error
+	// Update change non empty name and non nil lists of automation
+	Update(automation Automation) error
+	Delete(creatorID string, id string) error
+	All() ([]Automation, error)
+	ByID(id string) (Automation, error)
+	ListForHome(homeID string) ([]AutomationDetail, error)
+	GetForHome(homeID string, id string) (AutomationDetail, error)
+}
+
+// DatasStore define access to datas received from devices
+type DatasStore interface {
+	Create(data Datas) error
+	// Latest return last data of device field, of any field when field is empty
+	Latest(deviceID string, field string) (Datas, error)
+	ListForDevice(homeID string, roomID string, deviceID string, field string) ([]Datas, error)
+	// ExportDevice return a cursor of device datas, of all fields when field is empty
+	ExportDevice(homeID string, roomID string, deviceID string, field string) (Rows, error)
+	// ExportHome return a cursor of home datas of devices user can read, of all fields when field is empty
+	ExportHome(homeID string, userID string, field string) (Rows, error)
+	// LatestValues return value_nbr of the latest data of each device field, ordered like Latest, fields whose
+	// latest data has no number are skipped. Create always store a number, 0 for string and bool datas
+	LatestValues() ([]DeviceValue, error)
+}
+
+// LogStore define access to devices and automations logs
+type LogStore interface {
+	Create(log Logs) error
+	ListForDevice(homeID string, roomID string, deviceID string) ([]Logs, error)
+	ListForAutomation(homeID string, automationID string) ([]Logs, error)
+}
+
+// WebhookStore define access to home webhooks and their deliveries
+type WebhookStore interface {
+	Create(webhook Webhook) error
+	ByID(id string) (Webhook, error)
+	// Update change non empty url, secret and events, and active of webhook
+	Update(webhook Webhook) error
+	Delete(homeID string, id string) error
+	ListForHome(homeID string) ([]Webhook, error)
+	GetForHome(homeID string, id string) (Webhook, error)
+	// Active return enabled webhooks of home
+	Active(homeID string) ([]Webhook, error)
+
+	CreateDelivery(delivery WebhookDelivery) error
+	// UpdateDelivery save status, attempts, response and next attempt of delivery
+	UpdateDelivery(delivery WebhookDelivery) error
+	// PendingDeliveries return at most limit deliveries whose next attempt is due at now, oldest first
+	PendingDeliveries(now time.Time, limit int) ([]WebhookDelivery, error)
+	// DeleteDeliveriesBefore remove deliveries done, succeeded or failed, before time
+	DeleteDeliveriesBefore(before time.Time) error
+	// Deliveries return last deliveries of webhook, newest first
+	Deliveries(webhookID string, limit int) ([]WebhookDelivery, error)
+}
+
+// AutomationHookStore define access to incoming webhooks firing automations
+type AutomationHookStore interface {
+	Create(hook AutomationHook) error
+	ByToken(token string) (AutomationHook, error)
+	Delete(automationID string, id string) error
+	ListForAutomation(automationID string) ([]AutomationHook, error)
+	// Fired save that hook was just called
+	Fired(id string) error
+}
+
+// HomeKitStore define access to pairings and keys of homes HomeKit bridges
+type HomeKitStore interface {
+	Get(homeID string, key string) ([]byte, error)
+	// Set create or replace value of key
+	Set(homeID string, key string, value []byte) error
+	Delete(homeID string, key string) error
+	Keys(homeID string) ([]string, error)
+}
+
+// NotificationPreferenceStore define access to notification preferences of members
+type NotificationPreferenceStore interface {
+	Get(userID string, homeID string) (NotificationPreference, error)
+	// Set create or replace preference of user in home
+	Set(preference NotificationPreference) error
+}
+
+// NotificationStore define access to notifications received by users
+type NotificationStore interface {
+	Create(notification Notification) error
+	// ListForUser return last notifications of user first, only unread ones when unread is true
+	ListForUser(userID string, unread bool, limit int) ([]Notification, error)
+	GetForUser(userID string, id string) (Notification, error)
+	CountUnread(userID string) (int, error)
+	// MarkRead mark notification of user as read, every unread notification of user when id is empty
+	MarkRead(userID string, id string) error
+	Delete(userID string, id string) error
+}
+
+// AliasStore define access to aliases of rooms and devices
+type AliasStore interface {
+	Create(alias Alias) error
+	Delete(homeID string, id string) error
+	ListForHome(homeID string) ([]Alias, error)
+	GetForHome(homeID string, id string) (Alias, error)
+}
+
+// HueDeviceStore define access to devices exposed by the emulated Hue bridge
+type HueDeviceStore interface {
+	// Set expose device or change its calls, keeping its light id
+	Set(device HueDevice) error
+	Delete(homeID string, deviceID string) error
+	ListForHome(homeID string) ([]HueDevice, error)
+	GetForHome(homeID string, deviceID string) (HueDevice, error)
+	All() ([]HueDevice, error)
+	ByLightID(lightID int) (HueDevice, error)
+}
+
+// OAuthGrantStore define access to OAuth authorization codes and refresh tokens
+type OAuthGrantStore interface {
+	// Create save grant, expireAt zero value never expire
+	Create(grant OAuthGrant, expireAt time.Time) error
+	// ByID return grant of type when it isn't expired
+	ByID(id string, grantType string) (OAuthGrant, error)
+	Delete(id string) error
+}
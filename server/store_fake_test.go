@@ -0,0 +1,391 @@
+package server
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// fakeStore is an in memory Store for routes tests, calling a store or method it doesn't implement panics
+type fakeStore struct {
+	Store
+	users       []User
+	tokens      []Token
+	homes       []Home
+	rooms       []Room
+	devices     []Device
+	permissions []Permission
+	gateways    []Gateway
+	// permissionErr make permission lookups fail, like an unreachable database
+	permissionErr error
+
+	// mutex protect tokens and what handlers running in background write
+	mutex         sync.Mutex
+	datas         []Datas
+	logs          []Logs
+	preferences   []NotificationPreference
+	notifications []Notification
+}
+
+func (f *fakeStore) Tokens() TokenStore               { return fakeTokenStore{f: f} }
+func (f *fakeStore) Homes() HomeStore                 { return fakeHomeStore{f: f} }
+func (f *fakeStore) Rooms() RoomStore                 { return fakeRoomStore{f: f} }
+func (f *fakeStore) Devices() DeviceStore             { return fakeDeviceStore{f: f} }
+func (f *fakeStore) Permissions() PermissionStore     { return fakePermissionStore{f: f} }
+func (f *fakeStore) Datas() DatasStore                { return fakeDatasStore{f: f} }
+func (f *fakeStore) Logs() LogStore                   { return fakeLogStore{f: f} }
+func (f *fakeStore) Automations() AutomationStore     { return fakeAutomationStore{} }
+func (f *fakeStore) Webhooks() WebhookStore           { return fakeWebhookStore{} }
+func (f *fakeStore) Gateways() GatewayStore           { return fakeGatewayStore{f: f} }
+func (f *fakeStore) Users() UserStore                 { return fakeUserStore{f: f} }
+func (f *fakeStore) Notifications() NotificationStore { return fakeNotificationStore{f: f} }
+func (f *fakeStore) NotificationPreferences() NotificationPreferenceStore {
+	return fakeNotificationPreferenceStore{f: f}
+}
+
+func (f *fakeStore) user(id string) (User, error) {
+	for _, user := range f.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return User{}, sql.ErrNoRows
+}
+
+type fakeUserStore struct {
+	UserStore
+	f *fakeStore
+}
+
+func (s fakeUserStore) ByID(id string) (User, error) {
+	return s.f.user(id)
+}
+
+type fakeTokenStore struct {
+	TokenStore
+	f *fakeStore
+}
+
+func (s fakeTokenStore) ByIDWithUser(id string) (Token, User, error) {
+	s.f.mutex.Lock()
+	defer s.f.mutex.Unlock()
+	for _, token := range s.f.tokens {
+		if token.ID == id {
+			user, err := s.f.user(token.UserID)
+			return token, user, err
+		}
+	}
+	return Token{}, User{}, sql.ErrNoRows
+}
+
+func (s fakeTokenStore) CreateAPIKey(token Token, expireAt time.Time) error {
+	s.f.mutex.Lock()
+	defer s.f.mutex.Unlock()
+	token.ExpireAt = expireAt.Format(time.RFC3339)
+	s.f.tokens = append(s.f.tokens, token)
+	return nil
+}
+
+func (s fakeTokenStore) ListForUser(userID string, tokenType string) ([]Token, error) {
+	s.f.mutex.Lock()
+	defer s.f.mutex.Unlock()
+	tokens := []Token{}
+	for _, token := range s.f.tokens {
+		if token.UserID == userID && token.Type == tokenType {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+func (s fakeTokenStore) Delete(id string) error {
+	s.f.mutex.Lock()
+	defer s.f.mutex.Unlock()
+	for i, token := range s.f.tokens {
+		if token.ID == id {
+			s.f.tokens = append(s.f.tokens[:i], s.f.tokens[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+type fakeGatewayStore struct {
+	GatewayStore
+	f *fakeStore
+}
+
+func (s fakeGatewayStore) ListLinked() ([]Gateway, error) {
+	gateways := []Gateway{}
+	for _, gateway := range s.f.gateways {
+		if gateway.HomeID != "" {
+			gateways = append(gateways, gateway)
+		}
+	}
+	return gateways, nil
+}
+
+type fakePermissionStore struct {
+	PermissionStore
+	f *fakeStore
+}
+
+func (s fakePermissionStore) Get(userID string, typ string, typeID string) (Permission, error) {
+	if s.f.permissionErr != nil {
+		return Permission{}, s.f.permissionErr
+	}
+	for _, permission := range s.f.permissions {
+		if permission.UserID == userID && permission.Type == typ && permission.TypeID == typeID {
+			return permission, nil
+		}
+	}
+	return Permission{}, sql.ErrNoRows
+}
+
+func (s fakePermissionStore) Members(typ string, typeID string) ([]PermissionMember, error) {
+	members := []PermissionMember{}
+	for _, permission := range s.f.permissions {
+		if permission.Type == typ && permission.TypeID == typeID {
+			user, _ := s.f.user(permission.UserID)
+			members = append(members, PermissionMember{Permission: permission, User: user})
+		}
+	}
+	return members, nil
+}
+
+type fakeHomeStore struct {
+	HomeStore
+	f *fakeStore
+}
+
+func (s fakeHomeStore) ByID(id string) (Home, error) {
+	for _, home := range s.f.homes {
+		if home.ID == id {
+			return home, nil
+		}
+	}
+	return Home{}, sql.ErrNoRows
+}
+
+func (s fakeHomeStore) Update(home Home) error {
+	for i := range s.f.homes {
+		if s.f.homes[i].ID == home.ID {
+			if home.Name != "" {
+				s.f.homes[i].Name = home.Name
+			}
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (s fakeHomeStore) GetForUser(userID string, homeID string) (PermissionHome, error) {
+	home, err := s.ByID(homeID)
+	if err != nil {
+		return PermissionHome{}, err
+	}
+	permission, err := s.f.Permissions().Get(userID, "home", homeID)
+	if err != nil {
+		return PermissionHome{}, err
+	}
+	creator, _ := s.f.user(home.CreatorID)
+	return PermissionHome{
+		Permission:    permission,
+		User:          creator,
+		HomeID:        home.ID,
+		HomeName:      home.Name,
+		HomeAddress:   home.Address,
+		HomeCreatedAt: home.CreatedAt,
+	}, nil
+}
+
+type fakeRoomStore struct {
+	RoomStore
+	f *fakeStore
+}
+
+func (s fakeRoomStore) ByID(id string) (Room, error) {
+	for _, room := range s.f.rooms {
+		if room.ID == id {
+			return room, nil
+		}
+	}
+	return Room{}, sql.ErrNoRows
+}
+
+func (s fakeRoomStore) GetForUser(userID string, roomID string) (PermissionRoom, error) {
+	room, err := s.ByID(roomID)
+	if err != nil {
+		return PermissionRoom{}, err
+	}
+	permission, err := s.f.Permissions().Get(userID, "room", roomID)
+	if err != nil || !permission.Read {
+		return PermissionRoom{}, sql.ErrNoRows
+	}
+	creator, _ := s.f.user(room.CreatorID)
+	return PermissionRoom{
+		Permission:    permission,
+		User:          creator,
+		RoomID:        room.ID,
+		RoomName:      room.Name,
+		RoomHomeID:    room.HomeID,
+		RoomCreatedAt: room.CreatedAt,
+		RoomUpdatedAt: room.UpdatedAt,
+	}, nil
+}
+
+type fakeDeviceStore struct {
+	DeviceStore
+	f *fakeStore
+}
+
+func (s fakeDeviceStore) ByID(id string) (Device, error) {
+	for _, device := range s.f.devices {
+		if device.ID == id {
+			return device, nil
+		}
+	}
+	return Device{}, sql.ErrNoRows
+}
+
+func (s fakeDeviceStore) ByPhysicalIDs(physicalIDs []string) ([]Device, error) {
+	devices := []Device{}
+	for _, device := range s.f.devices {
+		for _, physicalID := range physicalIDs {
+			if device.PhysicalID == physicalID {
+				devices = append(devices, device)
+			}
+		}
+	}
+	return devices, nil
+}
+
+func (s fakeDeviceStore) ListAllForUser(userID string) ([]PermissionDevice, error) {
+	permissions := []PermissionDevice{}
+	for _, device := range s.f.devices {
+		permission, err := s.GetForUser(userID, device.RoomID, device.ID)
+		if err == nil {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions, nil
+}
+
+func (s fakeDeviceStore) GetForUser(userID string, roomID string, deviceID string) (PermissionDevice, error) {
+	device, err := s.ByID(deviceID)
+	if err != nil || device.RoomID != roomID {
+		return PermissionDevice{}, sql.ErrNoRows
+	}
+	permission, err := s.f.Permissions().Get(userID, "device", deviceID)
+	if err != nil || !permission.Read {
+		return PermissionDevice{}, sql.ErrNoRows
+	}
+	creator, _ := s.f.user(device.CreatorID)
+	return PermissionDevice{
+		Permission:         permission,
+		User:               creator,
+		DeviceID:           device.ID,
+		DeviceName:         device.Name,
+		DeviceIcon:         device.Icon,
+		DeviceRoomID:       device.RoomID,
+		DevicePlugin:       device.Plugin,
+		DeviceGatewayID:    device.GatewayID,
+		DevicePhysicalID:   device.PhysicalID,
+		DevicePhysicalName: device.PhysicalName,
+		DeviceConfig:       device.Config,
+		DeviceCreatedAt:    device.CreatedAt,
+		DeviceUpdatedAt:    device.UpdatedAt,
+	}, nil
+}
+
+type fakeDatasStore struct {
+	DatasStore
+	f *fakeStore
+}
+
+func (s fakeDatasStore) Create(data Datas) error {
+	s.f.mutex.Lock()
+	defer s.f.mutex.Unlock()
+	s.f.datas = append(s.f.datas, data)
+	return nil
+}
+
+func (s fakeDatasStore) Latest(deviceID string, field string) (Datas, error) {
+	s.f.mutex.Lock()
+	defer s.f.mutex.Unlock()
+	for i := len(s.f.datas) - 1; i >= 0; i-- {
+		data := s.f.datas[i]
+		if data.DeviceID == deviceID && (field == "" || data.Field == field) {
+			return data, nil
+		}
+	}
+	return Datas{}, sql.ErrNoRows
+}
+
+type fakeLogStore struct {
+	LogStore
+	f *fakeStore
+}
+
+func (s fakeLogStore) Create(log Logs) error {
+	s.f.mutex.Lock()
+	defer s.f.mutex.Unlock()
+	s.f.logs = append(s.f.logs, log)
+	return nil
+}
+
+// fakeWebhookStore has no webhooks
+type fakeWebhookStore struct {
+	WebhookStore
+}
+
+func (s fakeWebhookStore) Active(homeID string) ([]Webhook, error) {
+	return nil, nil
+}
+
+func (s fakeWebhookStore) ListForHome(homeID string) ([]Webhook, error) {
+	return []Webhook{}, nil
+}
+
+func (s fakeWebhookStore) GetForHome(homeID string, id string) (Webhook, error) {
+	return Webhook{}, sql.ErrNoRows
+}
+
+// fakeAutomationStore has no automations
+type fakeAutomationStore struct {
+	AutomationStore
+}
+
+func (s fakeAutomationStore) ListForHome(homeID string) ([]AutomationDetail, error) {
+	return []AutomationDetail{}, nil
+}
+
+func (s fakeAutomationStore) GetForHome(homeID string, id string) (AutomationDetail, error) {
+	return AutomationDetail{}, sql.ErrNoRows
+}
+
+type fakeNotificationPreferenceStore struct {
+	NotificationPreferenceStore
+	f *fakeStore
+}
+
+func (s fakeNotificationPreferenceStore) Get(userID string, homeID string) (NotificationPreference, error) {
+	for _, preference := range s.f.preferences {
+		if preference.UserID == userID && preference.HomeID == homeID {
+			return preference, nil
+		}
+	}
+	return NotificationPreference{}, sql.ErrNoRows
+}
+
+type fakeNotificationStore struct {
+	NotificationStore
+	f *fakeStore
+}
+
+func (s fakeNotificationStore) Create(notification Notification) error {
+	s.f.mutex.Lock()
+	defer s.f.mutex.Unlock()
+	s.f.notifications = append(s.f.notifications, notification)
+	return nil
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"database/sql"
+
+	"github.com/ItsJimi/casa/config"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type postgresDialect struct{}
+
+func (postgresDialect) array(list []string) interface{} {
+	return pq.Array(list)
+}
+
+func (postgresDialect) scanArray(list *[]string) interface{} {
+	return pq.Array(list)
+}
+
+// latestValuesQuery walk distinct device fields through datas_device_field index instead of sorting all datas
+func (postgresDialect) latestValuesQuery() string {
+	return `
+		WITH RECURSIVE fields AS (
+			(SELECT device_id, field FROM datas ORDER BY device_id, field LIMIT 1)
+			UNION ALL
+			SELECT next.device_id, next.field FROM fields, LATERAL (
+				SELECT later.device_id, later.field FROM datas AS later
+				WHERE (later.device_id, later.field) > (fields.device_id, fields.field)
+				ORDER BY later.device_id, later.field LIMIT 1
+			) AS next
+		)
+		SELECT homes.name, rooms.name, devices.name, devices.id, fields.field, last.value_nbr
+		FROM fields
+		CROSS JOIN LATERAL (
+			SELECT datas.value_nbr FROM datas
+			WHERE datas.device_id = fields.device_id AND datas.field = fields.field
+			ORDER BY datas.created_at DESC, datas.id DESC LIMIT 1
+		) AS last
+		JOIN devices ON fields.device_id = devices.id
+		JOIN rooms ON devices.room_id = rooms.id
+		JOIN homes ON rooms.home_id = homes.id
+		WHERE last.value_nbr IS NOT NULL
+	`
+}
+
+// openPostgres connect to postgres database of conf
+func openPostgres(conf config.Database) (Store, error) {
+	db, err := sql.Open(instrumentedDriverName, conf.DSN(conf.Name))
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStore{db: sqlx.NewDb(db, "postgres"), dialect: postgresDialect{}}, nil
+}
+
+// createPostgresDatabase create database of conf if it doesn't exist
+func createPostgresDatabase(conf config.Database) error {
+	db, err := sqlx.Open("postgres", conf.DSN(""))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("CREATE database " + pq.QuoteIdentifier(conf.Name))
+	return err
+}
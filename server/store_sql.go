@@ -0,0 +1,970 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ItsJimi/casa/migrations"
+	"github.com/ItsJimi/casa/utils"
+	"github.com/jmoiron/sqlx"
+)
+
+// dialect define what differ between sql backends
+type dialect interface {
+	// array wrap a list to be written in an array column
+	array(list []string) interface{}
+	// scanArray wrap a list to be read from an array column
+	scanArray(list *[]string) interface{}
+	// latestValuesQuery select home, room, device, device id, field and value of last numeric datas
+	latestValuesQuery() string
+}
+
+// sqlStore implement Store with queries shared by sql backends, written with ? placeholders
+type sqlStore struct {
+	db      *sqlx.DB
+	dialect dialect
+}
+
+func (s *sqlStore) get(dest interface{}, query string, args ...interface{}) error {
+	return s.db.Get(dest, s.db.Rebind(query), args...)
+}
+
+func (s *sqlStore) selectx(dest interface{}, query string, args ...interface{}) error {
+	return s.db.Select(dest, s.db.Rebind(query), args...)
+}
+
+func (s *sqlStore) queryx(query string, args ...interface{}) (*sqlx.Rows, error) {
+	return s.db.Queryx(s.db.Rebind(query), args...)
+}
+
+func (s *sqlStore) exec(query string, args ...interface{}) error {
+	_, err := s.db.Exec(s.db.Rebind(query), args...)
+	return err
+}
+
+// in expand list arguments of query, list must not be empty
+func (s *sqlStore) in(query string, args ...interface{}) (string, []interface{}, error) {
+	query, args, err := sqlx.In(query, args...)
+	if err != nil {
+		return "", nil, err
+	}
+	return s.db.Rebind(query), args, nil
+}
+
+func (s *sqlStore) Users() UserStore                     { return userStore{s} }
+func (s *sqlStore) Tokens() TokenStore                   { return tokenStore{s} }
+func (s *sqlStore) Homes() HomeStore                     { return homeStore{s} }
+func (s *sqlStore) Rooms() RoomStore                     { return roomStore{s} }
+func (s *sqlStore) Gateways() GatewayStore               { return gatewayStore{s} }
+func (s *sqlStore) Plugins() PluginStore                 { return pluginStore{s} }
+func (s *sqlStore) Devices() DeviceStore                 { return deviceStore{s} }
+func (s *sqlStore) Permissions() PermissionStore         { return permissionStore{s} }
+func (s *sqlStore) Automations() AutomationStore         { return automationStore{s} }
+func (s *sqlStore) Datas() DatasStore                    { return datasStore{s} }
+func (s *sqlStore) Logs() LogStore                       { return logStore{s} }
+func (s *sqlStore) Webhooks() WebhookStore               { return webhookStore{s} }
+func (s *sqlStore) AutomationHooks() AutomationHookStore { return automationHookStore{s} }
+func (s *sqlStore) OAuthGrants() OAuthGrantStore         { return oauthGrantStore{s} }
+func (s *sqlStore) HomeKit() HomeKitStore                { return homekitStore{s} }
+func (s *sqlStore) HueDevices() HueDeviceStore           { return hueDeviceStore{s} }
+func (s *sqlStore) Aliases() AliasStore                  { return aliasStore{s} }
+func (s *sqlStore) NotificationPreferences() NotificationPreferenceStore {
+	return notificationPreferenceStore{s}
+}
+func (s *sqlStore) Notifications() NotificationStore { return notificationStore{s} }
+
+func (s *sqlStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqlStore) Migrate(target int) error {
+	return migrations.Up(s.db, target)
+}
+
+func (s *sqlStore) Rollback(steps int) error {
+	return migrations.Down(s.db, steps)
+}
+
+func (s *sqlStore) SchemaVersion() (int, error) {
+	return migrations.Current(s.db)
+}
+
+func (s *sqlStore) SchemaStatus() ([]migrations.State, error) {
+	return migrations.Status(s.db)
+}
+
+type userStore struct{ *sqlStore }
+
+func (s userStore) Create(user User) error {
+	return s.exec("INSERT INTO users (id, email, password, firstname, lastname, birthdate) VALUES (?, ?, ?, ?, ?, ?)",
+		user.ID, user.Email, user.Password, user.Firstname, user.Lastname, user.Birthdate)
+}
+
+func (s userStore) ByID(id string) (User, error) {
+	var user User
+	err := s.get(&user, "SELECT * FROM users WHERE id=?", id)
+	return user, err
+}
+
+func (s userStore) ByEmail(email string) (User, error) {
+	var user User
+	err := s.get(&user, "SELECT * FROM users WHERE email=?", email)
+	return user, err
+}
+
+func (s userStore) UpdateProfil(id string, firstname string, lastname string) error {
+	return s.exec("UPDATE users SET firstname=?, lastname=? WHERE id=?", firstname, lastname, id)
+}
+
+func (s userStore) UpdateEmail(id string, email string) error {
+	return s.exec("UPDATE users SET email=? WHERE id=?", email, id)
+}
+
+func (s userStore) UpdatePassword(id string, password string) error {
+	return s.exec("UPDATE users SET password=? WHERE id=?", password, id)
+}
+
+type tokenStore struct{ *sqlStore }
+
+func (s tokenStore) Create(token Token) error {
+	return s.exec("INSERT INTO tokens (id, user_id, type, ip, user_agent) VALUES (?, ?, ?, ?, ?)",
+		token.ID, token.UserID, token.Type, token.IP, token.UserAgent)
+}
+
+func (s tokenStore) CreateWithExpiry(token Token, expireAt time.Time) error {
+	return s.exec("INSERT INTO tokens (id, user_id, type, ip, user_agent, expire_at) VALUES (?, ?, ?, ?, ?, ?)",
+		token.ID, token.UserID, token.Type, token.IP, token.UserAgent, expireAt.UTC())
+}
+
+func (s tokenStore) Delete(id string) error {
+	return s.exec("DELETE FROM tokens WHERE id=?", id)
+}
+
+func (s tokenStore) CreateAPIKey(token Token, expireAt time.Time) error {
+	return s.exec(`INSERT INTO tokens (id, user_id, type, ip, user_agent, read, write, manage, admin, expire_at, name, scope_type, scope_id, secret_hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		token.ID, token.UserID, token.Type, token.IP, token.UserAgent, token.Read, token.Write, token.Manage, token.Admin, expireAt.UTC(),
+		token.Name, token.ScopeType, token.ScopeID, token.SecretHash)
+}
+
+func (s tokenStore) ByIDWithUser(id string) (Token, User, error) {
+	var token struct {
+		User
+		Type       string `db:"t_type"`
+		Read       bool   `db:"t_read"`
+		Write      bool   `db:"t_write"`
+		Manage     bool   `db:"t_manage"`
+		Admin      bool   `db:"t_admin"`
+		ExpireAt   string `db:"expire_at"`
+		ScopeType  string `db:"scope_type"`
+		ScopeID    string `db:"scope_id"`
+		SecretHash string `db:"secret_hash"`
+	}
+	err := s.get(&token, `
+		SELECT users.*, tokens.type AS t_type, tokens.read AS t_read, tokens.write AS t_write, tokens.manage AS t_manage, tokens.admin AS t_admin,
+		tokens.expire_at, tokens.scope_type, tokens.scope_id, tokens.secret_hash
+		FROM tokens JOIN users ON tokens.user_id = users.id WHERE tokens.id=?`, id)
+	return Token{
+		ID:         id,
+		UserID:     token.User.ID,
+		Type:       token.Type,
+		Read:       token.Read,
+		Write:      token.Write,
+		Manage:     token.Manage,
+		Admin:      token.Admin,
+		ExpireAt:   token.ExpireAt,
+		ScopeType:  token.ScopeType,
+		ScopeID:    token.ScopeID,
+		SecretHash: token.SecretHash,
+	}, token.User, err
+}
+
+func (s tokenStore) ListForUser(userID string, tokenType string) ([]Token, error) {
+	tokens := []Token{}
+	err := s.selectx(&tokens, "SELECT * FROM tokens WHERE user_id=? AND type=? ORDER BY created_at", userID, tokenType)
+	return tokens, err
+}
+
+type homeStore struct{ *sqlStore }
+
+const permissionHomeSelect = `
+	SELECT permissions.*, users.*, homes.id as h_id, homes.name AS h_name, homes.address AS h_address, homes.created_at AS h_createdat FROM permissions
+	JOIN homes ON permissions.type_id = homes.id
+	JOIN users ON homes.creator_id = users.id
+`
+
+func (s homeStore) Create(home Home) error {
+	return s.exec("INSERT INTO homes (id, name, address, creator_id) VALUES (?, ?, ?, ?)",
+		home.ID, home.Name, home.Address, home.CreatorID)
+}
+
+func (s homeStore) ByID(id string) (Home, error) {
+	var home Home
+	err := s.get(&home, `
+		SELECT id, name, COALESCE(address, '') AS address, COALESCE(wifi_ssid, '') AS wifi_ssid, created_at, updated_at, creator_id
+		FROM homes WHERE id=?
+	`, id)
+	return home, err
+}
+
+func (s homeStore) Update(home Home) error {
+	return s.exec("UPDATE homes SET name=COALESCE(?, name), address=COALESCE(?, address), wifi_ssid=COALESCE(?, wifi_ssid) WHERE id=?",
+		utils.NewNullString(home.Name), utils.NewNullString(home.Address), utils.NewNullString(home.WifiSSID), home.ID)
+}
+
+func (s homeStore) Delete(id string) error {
+	return s.exec("DELETE FROM homes WHERE id=?", id)
+}
+
+func (s homeStore) ListForUser(userID string) ([]PermissionHome, error) {
+	homes := []PermissionHome{}
+	err := s.selectx(&homes, permissionHomeSelect+"WHERE permissions.type=? AND permissions.user_id=?", "home", userID)
+	return homes, err
+}
+
+func (s homeStore) GetForUser(userID string, homeID string) (PermissionHome, error) {
+	var home PermissionHome
+	err := s.get(&home, permissionHomeSelect+"WHERE permissions.type=? AND permissions.type_id=? AND permissions.user_id=?", "home", homeID, userID)
+	return home, err
+}
+
+type roomStore struct{ *sqlStore }
+
+const permissionRoomSelect = `
+	SELECT permissions.*, users.*,
+	rooms.id as r_id, rooms.name AS r_name, rooms.home_id AS r_homeid, rooms.created_at AS r_createdat, rooms.updated_at AS r_updatedat FROM permissions
+	JOIN rooms ON permissions.type_id = rooms.id
+	JOIN users ON rooms.creator_id = users.id
+`
+
+func (s roomStore) Create(room Room) error {
+	return s.exec("INSERT INTO rooms (id, name, home_id, creator_id) VALUES (?, ?, ?, ?)",
+		room.ID, room.Name, room.HomeID, room.CreatorID)
+}
+
+func (s roomStore) ByID(id string) (Room, error) {
+	var room Room
+	err := s.get(&room, `
+		SELECT id, COALESCE(name, '') AS name, COALESCE(icon, '') AS icon, home_id, created_at, updated_at, creator_id
+		FROM rooms WHERE id=?
+	`, id)
+	return room, err
+}
+
+func (s roomStore) Update(room Room) error {
+	return s.exec("UPDATE rooms SET name=? WHERE id=?", room.Name, room.ID)
+}
+
+func (s roomStore) Delete(id string) error {
+	return s.exec("DELETE FROM rooms WHERE id=?", id)
+}
+
+func (s roomStore) ListForUser(userID string, homeID string) ([]PermissionRoom, error) {
+	rooms := []PermissionRoom{}
+	err := s.selectx(&rooms, permissionRoomSelect+"WHERE permissions.type=? AND permissions.user_id=? AND rooms.home_id=? AND (permissions.read=true OR permissions.admin=true)",
+		"room", userID, homeID)
+	return rooms, err
+}
+
+func (s roomStore) GetForUser(userID string, roomID string) (PermissionRoom, error) {
+	var room PermissionRoom
+	err := s.get(&room, permissionRoomSelect+"WHERE permissions.type=? AND permissions.type_id=? AND permissions.user_id=?", "room", roomID, userID)
+	return room, err
+}
+
+type gatewayStore struct{ *sqlStore }
+
+func (s gatewayStore) Create(gateway Gateway) error {
+	return s.exec("INSERT INTO gateways (id, model) VALUES (?, ?)", gateway.ID, gateway.Model)
+}
+
+func (s gatewayStore) ByID(id string) (Gateway, error) {
+	var gateway Gateway
+	err := s.get(&gateway, `
+		SELECT id, COALESCE(home_id, '') AS home_id, COALESCE(name, '') AS name, COALESCE(model, '') AS model, created_at, updated_at, COALESCE(creator_id, '') AS creator_id
+		FROM gateways WHERE id=?
+	`, id)
+	return gateway, err
+}
+
+func (s gatewayStore) Update(gateway Gateway) error {
+	return s.exec("UPDATE gateways SET name=COALESCE(?, name) WHERE id=?", utils.NewNullString(gateway.Name), gateway.ID)
+}
+
+func (s gatewayStore) Link(id string, userID string, homeID string) error {
+	return s.exec("UPDATE gateways SET creator_id=?, home_id=? WHERE id=?", userID, homeID, id)
+}
+
+func (s gatewayStore) Delete(id string) error {
+	return s.exec("DELETE FROM gateways WHERE id=?", id)
+}
+
+func (s gatewayStore) ListLinked() ([]Gateway, error) {
+	gateways := []Gateway{}
+	err := s.selectx(&gateways, `
+		SELECT id, home_id, COALESCE(name, '') AS name, COALESCE(model, '') AS model, created_at, updated_at, COALESCE(creator_id, '') AS creator_id
+		FROM gateways WHERE home_id IS NOT NULL`)
+	return gateways, err
+}
+
+func (s gatewayStore) GetForUser(userID string, gatewayID string) (PermissionGateway, error) {
+	var gateway PermissionGateway
+	err := s.get(&gateway, `
+		SELECT permissions.*, users.*,
+		gateways.id as g_id, gateways.name AS g_name, gateways.home_id AS g_homeid, gateways.model AS g_model, gateways.created_at AS g_createdat, gateways.updated_at AS g_updatedat FROM permissions
+		JOIN gateways ON permissions.type_id = gateways.home_id
+		JOIN users ON gateways.creator_id = users.id
+		WHERE permissions.type=? AND gateways.id=? AND permissions.user_id=?
+	`, "home", gatewayID, userID)
+	return gateway, err
+}
+
+type pluginStore struct{ *sqlStore }
+
+func (s pluginStore) Save(plugin Plugin) error {
+	existing, err := s.ByName(plugin.GatewayID, plugin.Name)
+	if err == nil {
+		return s.exec("UPDATE plugins SET config=? WHERE id=?", plugin.Config, existing.ID)
+	}
+	return s.exec("INSERT INTO plugins (id, gateway_id, name, config) VALUES (?, ?, ?, ?)",
+		plugin.ID, plugin.GatewayID, plugin.Name, plugin.Config)
+}
+
+func (s pluginStore) ByName(gatewayID string, name string) (Plugin, error) {
+	var plugin Plugin
+	err := s.get(&plugin, "SELECT * FROM plugins WHERE gateway_id=? AND name=?", gatewayID, name)
+	return plugin, err
+}
+
+type deviceStore struct{ *sqlStore }
+
+const permissionDeviceSelect = `
+	SELECT permissions.*, users.*,
+	devices.id as d_id, devices.name AS d_name, devices.icon AS d_icon, devices.room_id AS d_roomid, devices.gateway_id AS d_gatewayid, devices.physical_id AS d_physicalid, devices.physical_name AS d_physicalname, devices.config AS d_config, devices.plugin AS d_plugin, devices.created_at AS d_createdat, devices.updated_at AS d_updatedat FROM permissions
+	JOIN devices ON permissions.type_id = devices.id
+	JOIN users ON devices.creator_id = users.id
+`
+
+func (s deviceStore) Create(device Device) error {
+	return s.exec("INSERT INTO devices (id, name, icon, room_id, gateway_id, physical_id, physical_name, config, plugin, creator_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		device.ID, device.Name, device.Icon, device.RoomID, device.GatewayID, device.PhysicalID, device.PhysicalName, device.Config, device.Plugin, device.CreatorID)
+}
+
+func (s deviceStore) ByID(id string) (Device, error) {
+	var device Device
+	err := s.get(&device, "SELECT * FROM devices WHERE id=?", id)
+	return device, err
+}
+
+func (s deviceStore) All() ([]Device, error) {
+	devices := []Device{}
+	err := s.selectx(&devices, "SELECT * FROM devices ORDER BY created_at")
+	return devices, err
+}
+
+func (s deviceStore) ByPhysicalID(gatewayID string, physicalID string) (Device, error) {
+	var device Device
+	err := s.get(&device, "SELECT * FROM devices WHERE physical_id=? AND gateway_id=?", physicalID, gatewayID)
+	return device, err
+}
+
+func (s deviceStore) ByPhysicalIDs(physicalIDs []string) ([]Device, error) {
+	devices := []Device{}
+	if len(physicalIDs) == 0 {
+		return devices, nil
+	}
+	query, args, err := s.in("SELECT * FROM devices WHERE physical_id IN (?)", physicalIDs)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.Select(&devices, query, args...)
+	return devices, err
+}
+
+func (s deviceStore) KnownPhysicalIDs(homeID string, physicalIDs []string) ([]string, error) {
+	known := []string{}
+	if len(physicalIDs) == 0 {
+		return known, nil
+	}
+	query, args, err := s.in(`
+		SELECT physical_id
+		FROM devices
+		JOIN gateways ON devices.gateway_id = gateways.id
+		WHERE physical_id IN (?) AND gateways.home_id = ?
+	`, physicalIDs, homeID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.Select(&known, query, args...)
+	return known, err
+}
+
+func (s deviceStore) Update(device Device) (Device, error) {
+	err := s.exec("UPDATE devices SET name=COALESCE(?, name), room_id=COALESCE(?, room_id), icon=COALESCE(?, icon) WHERE id=?",
+		utils.NewNullString(device.Name), utils.NewNullString(device.RoomID), utils.NewNullString(device.Icon), device.ID)
+	if err != nil {
+		return Device{}, err
+	}
+	return s.ByID(device.ID)
+}
+
+func (s deviceStore) Delete(id string) error {
+	return s.exec("DELETE FROM devices WHERE id=?", id)
+}
+
+func (s deviceStore) ListForUser(userID string, roomID string) ([]PermissionDevice, error) {
+	devices := []PermissionDevice{}
+	err := s.selectx(&devices, permissionDeviceSelect+"WHERE permissions.type=? AND permissions.user_id=? AND devices.room_id=? AND (permissions.read=true OR permissions.admin=true)",
+		"device", userID, roomID)
+	return devices, err
+}
+
+func (s deviceStore) ListAllForUser(userID string) ([]PermissionDevice, error) {
+	devices := []PermissionDevice{}
+	err := s.selectx(&devices, permissionDeviceSelect+"WHERE permissions.type=? AND permissions.user_id=? AND (permissions.read=true OR permissions.admin=true) ORDER BY devices.created_at",
+		"device", userID)
+	return devices, err
+}
+
+func (s deviceStore) GetForUser(userID string, roomID string, deviceID string) (PermissionDevice, error) {
+	var device PermissionDevice
+	err := s.get(&device, permissionDeviceSelect+"WHERE permissions.type=? AND permissions.type_id=? AND permissions.user_id=? AND devices.room_id=?",
+		"device", deviceID, userID, roomID)
+	return device, err
+}
+
+type permissionStore struct{ *sqlStore }
+
+func (s permissionStore) Create(permission Permission) error {
+	return s.exec("INSERT INTO permissions (id, user_id, type, type_id, read, write, manage, admin) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		permission.ID, permission.UserID, permission.Type, permission.TypeID, permission.Read, permission.Write, permission.Manage, permission.Admin)
+}
+
+func (s permissionStore) Get(userID string, typ string, typeID string) (Permission, error) {
+	var permission Permission
+	err := s.get(&permission, "SELECT * FROM permissions WHERE user_id=? AND type=? AND type_id=?", userID, typ, typeID)
+	return permission, err
+}
+
+func (s permissionStore) Update(permission Permission) error {
+	return s.exec("UPDATE permissions SET read=?, write=?, manage=?, admin=? WHERE user_id=? AND type=? AND type_id=?",
+		permission.Read, permission.Write, permission.Manage, permission.Admin, permission.UserID, permission.Type, permission.TypeID)
+}
+
+func (s permissionStore) Delete(userID string, typ string, typeID string) error {
+	return s.exec("DELETE FROM permissions WHERE user_id=? AND type=? AND type_id=?", userID, typ, typeID)
+}
+
+func (s permissionStore) DeleteAll(typ string, typeID string) error {
+	return s.exec("DELETE FROM permissions WHERE type=? AND type_id=?", typ, typeID)
+}
+
+func (s permissionStore) Members(typ string, typeID string) ([]PermissionMember, error) {
+	members := []PermissionMember{}
+	err := s.selectx(&members, `
+		SELECT * FROM permissions
+		JOIN users ON permissions.user_id = users.id
+		WHERE permissions.type=? AND permissions.type_id=?
+	`, typ, typeID)
+	return members, err
+}
+
+type automationStore struct{ *sqlStore }
+
+const automationColumns = `automations.id, automations.home_id, COALESCE(automations.name, ''), automations."trigger", automations.trigger_key, automations.trigger_value, automations.trigger_operator,
+	automations.action, automations.action_call, automations.action_value, COALESCE(automations.status, true), automations.created_at, automations.updated_at, automations.creator_id`
+
+func (s automationStore) scan(rows *sqlx.Rows, automation *Automation, dest ...interface{}) error {
+	return rows.Scan(append([]interface{}{&automation.ID, &automation.HomeID, &automation.Name,
+		s.dialect.scanArray(&automation.Trigger), s.dialect.scanArray(&automation.TriggerKey), s.dialect.scanArray(&automation.TriggerValue), s.dialect.scanArray(&automation.TriggerOperator),
+		s.dialect.scanArray(&automation.Action), s.dialect.scanArray(&automation.ActionCall), s.dialect.scanArray(&automation.ActionValue),
+		&automation.Status, &automation.CreatedAt, &automation.UpdatedAt, &automation.CreatorID}, dest...)...)
+}
+
+func (s automationStore) Create(automation Automation) error {
+	return s.exec(`INSERT INTO automations (id, name, "trigger", trigger_key, trigger_operator, trigger_value, action, action_call, action_value, status, creator_id, home_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		automation.ID, automation.Name, s.dialect.array(automation.Trigger), s.dialect.array(automation.TriggerKey), s.dialect.array(automation.TriggerOperator), s.dialect.array(automation.TriggerValue),
+		s.dialect.array(automation.Action), s.dialect.array(automation.ActionCall), s.dialect.array(automation.ActionValue), automation.Status, automation.CreatorID, automation.HomeID)
+}
+
+func (s automationStore) Update(automation Automation) error {
+	return s.exec(`UPDATE automations SET
+		name = COALESCE(?, name),
+		"trigger" = COALESCE(?, "trigger"),
+		trigger_key = COALESCE(?, trigger_key),
+		trigger_operator = COALESCE(?, trigger_operator),
+		trigger_value = COALESCE(?, trigger_value),
+		action = COALESCE(?, action),
+		action_call = COALESCE(?, action_call),
+		action_value = COALESCE(?, action_value)
+		WHERE id=?`,
+		utils.NewNullString(automation.Name), s.dialect.array(automation.Trigger), s.dialect.array(automation.TriggerKey), s.dialect.array(automation.TriggerOperator), s.dialect.array(automation.TriggerValue),
+		s.dialect.array(automation.Action), s.dialect.array(automation.ActionCall), s.dialect.array(automation.ActionValue), automation.ID)
+}
+
+func (s automationStore) Delete(creatorID string, id string) error {
+	return s.exec("DELETE FROM automations WHERE creator_id=? AND id=?", creatorID, id)
+}
+
+func (s automationStore) All() ([]Automation, error) {
+	return s.list("")
+}
+
+func (s automationStore) ByID(id string) (Automation, error) {
+	automations, err := s.list("WHERE automations.id=?", id)
+	if err != nil {
+		return Automation{}, err
+	}
+	if len(automations) == 0 {
+		return Automation{}, sql.ErrNoRows
+	}
+	return automations[0], nil
+}
+
+func (s automationStore) list(where string, args ...interface{}) ([]Automation, error) {
+	rows, err := s.queryx("SELECT "+automationColumns+" FROM automations "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	automations := []Automation{}
+	for rows.Next() {
+		var automation Automation
+		if err := s.scan(rows, &automation); err != nil {
+			return nil, err
+		}
+		automations = append(automations, automation)
+	}
+	return automations, rows.Err()
+}
+
+func (s automationStore) details(where string, args ...interface{}) ([]AutomationDetail, error) {
+	rows, err := s.queryx(`
+		SELECT `+automationColumns+`,
+		users.id, users.firstname, users.lastname, users.email, users.password, users.birthdate, users.created_at, users.updated_at
+		FROM automations
+		JOIN users ON automations.creator_id = users.id
+		`+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	automations := []AutomationDetail{}
+	deviceIDs := []string{}
+	for rows.Next() {
+		automation := AutomationDetail{Triggers: []Device{}, Actions: []Device{}}
+		user := &automation.Creator
+		err := s.scan(rows, &automation.Automation, &user.ID, &user.Firstname, &user.Lastname, &user.Email, &user.Password, &user.Birthdate, &user.CreatedAt, &user.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		automations = append(automations, automation)
+		deviceIDs = append(deviceIDs, automation.Trigger...)
+		deviceIDs = append(deviceIDs, automation.Action...)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(deviceIDs) == 0 {
+		return automations, nil
+	}
+
+	query, args, err := s.in("SELECT * FROM devices WHERE id IN (?)", deviceIDs)
+	if err != nil {
+		return nil, err
+	}
+	var devices []Device
+	if err := s.db.Select(&devices, query, args...); err != nil {
+		return nil, err
+	}
+	devicesByID := map[string]Device{}
+	for _, device := range devices {
+		devicesByID[device.ID] = device
+	}
+
+	// Keep devices in the order of trigger and action lists
+	for i := range automations {
+		for _, id := range automations[i].Trigger {
+			if device, ok := devicesByID[id]; ok {
+				automations[i].Triggers = append(automations[i].Triggers, device)
+			}
+		}
+		for _, id := range automations[i].Action {
+			if device, ok := devicesByID[id]; ok {
+				automations[i].Actions = append(automations[i].Actions, device)
+			}
+		}
+	}
+	return automations, nil
+}
+
+func (s automationStore) ListForHome(homeID string) ([]AutomationDetail, error) {
+	return s.details("WHERE automations.home_id=?", homeID)
+}
+
+func (s automationStore) GetForHome(homeID string, id string) (AutomationDetail, error) {
+	automations, err := s.details("WHERE automations.home_id=? AND automations.id=?", homeID, id)
+	if err != nil {
+		return AutomationDetail{}, err
+	}
+	if len(automations) == 0 {
+		return AutomationDetail{}, sql.ErrNoRows
+	}
+	return automations[0], nil
+}
+
+type datasStore struct{ *sqlStore }
+
+const exportDatasSelect = `
+	SELECT datas.id, datas.device_id, datas.field, datas.value_nbr, datas.value_str, datas.value_bool, datas.created_at FROM datas
+	JOIN devices ON datas.device_id = devices.id
+	JOIN rooms ON devices.room_id = rooms.id
+`
+
+func (s datasStore) Create(data Datas) error {
+	return s.exec("INSERT INTO datas (id, device_id, field, value_nbr, value_str, value_bool) VALUES (?, ?, ?, ?, ?, ?)",
+		data.ID, data.DeviceID, data.Field, data.ValueNbr, data.ValueStr, data.ValueBool)
+}
+
+func (s datasStore) Latest(deviceID string, field string) (Datas, error) {
+	var data Datas
+	err := s.get(&data, "SELECT * FROM datas WHERE device_id=? AND (?='' OR field=?) ORDER BY created_at DESC, id DESC LIMIT 1", deviceID, field, field)
+	return data, err
+}
+
+func (s datasStore) ListForDevice(homeID string, roomID string, deviceID string, field string) ([]Datas, error) {
+	datas := []Datas{}
+	err := s.selectx(&datas, `
+		SELECT datas.* FROM datas
+		JOIN devices ON datas.device_id = devices.id
+		JOIN rooms ON devices.room_id = rooms.id
+		WHERE rooms.home_id=? AND devices.room_id=? AND datas.device_id=? AND datas.field=?
+		ORDER BY datas.created_at DESC
+	`, homeID, roomID, deviceID, field)
+	return datas, err
+}
+
+func (s datasStore) ExportDevice(homeID string, roomID string, deviceID string, field string) (Rows, error) {
+	return s.queryx(exportDatasSelect+"WHERE rooms.home_id=? AND devices.room_id=? AND datas.device_id=? AND (?='' OR datas.field=?) ORDER BY datas.created_at",
+		homeID, roomID, deviceID, field, field)
+}
+
+func (s datasStore) ExportHome(homeID string, userID string, field string) (Rows, error) {
+	return s.queryx(exportDatasSelect+`
+		JOIN permissions ON permissions.type='device' AND permissions.type_id=datas.device_id AND permissions.user_id=?
+		WHERE rooms.home_id=? AND (permissions.read=true OR permissions.admin=true) AND (?='' OR datas.field=?) ORDER BY datas.created_at`,
+		userID, homeID, field, field)
+}
+
+func (s datasStore) LatestValues() ([]DeviceValue, error) {
+	rows, err := s.queryx(s.dialect.latestValuesQuery())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []DeviceValue{}
+	for rows.Next() {
+		var value DeviceValue
+		var home, room, device sql.NullString
+		if err := rows.Scan(&home, &room, &device, &value.DeviceID, &value.Field, &value.Value); err != nil {
+			return nil, err
+		}
+		value.Home = home.String
+		value.Room = room.String
+		value.Device = device.String
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+type logStore struct{ *sqlStore }
+
+func (s logStore) Create(log Logs) error {
+	return s.exec("INSERT INTO logs (id, type, type_id, value) VALUES (?, ?, ?, ?)", log.ID, log.Type, log.TypeID, log.Value)
+}
+
+func (s logStore) ListForDevice(homeID string, roomID string, deviceID string) ([]Logs, error) {
+	logs := []Logs{}
+	err := s.selectx(&logs, `
+		SELECT logs.* FROM logs
+		JOIN devices ON logs.type_id = devices.id
+		JOIN rooms ON devices.room_id = rooms.id
+		WHERE rooms.home_id=? AND devices.room_id=? AND logs.type_id=? AND logs.type='device'
+		ORDER BY logs.created_at DESC
+	`, homeID, roomID, deviceID)
+	return logs, err
+}
+
+func (s logStore) ListForAutomation(homeID string, automationID string) ([]Logs, error) {
+	logs := []Logs{}
+	err := s.selectx(&logs, `
+		SELECT logs.* FROM logs
+		JOIN automations ON logs.type_id = automations.id
+		WHERE automations.home_id=? AND logs.type_id=? AND logs.type='automation'
+		ORDER BY logs.created_at DESC
+	`, homeID, automationID)
+	return logs, err
+}
+
+type webhookStore struct{ *sqlStore }
+
+func (s webhookStore) Create(webhook Webhook) error {
+	return s.exec("INSERT INTO webhooks (id, home_id, url, secret, events, active, creator_id) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		webhook.ID, webhook.HomeID, webhook.URL, webhook.Secret, webhook.Events, webhook.Active, webhook.CreatorID)
+}
+
+func (s webhookStore) ByID(id string) (Webhook, error) {
+	var webhook Webhook
+	err := s.get(&webhook, "SELECT * FROM webhooks WHERE id=?", id)
+	return webhook, err
+}
+
+func (s webhookStore) Update(webhook Webhook) error {
+	return s.exec("UPDATE webhooks SET url=COALESCE(?, url), secret=COALESCE(?, secret), events=COALESCE(?, events), active=? WHERE id=? AND home_id=?",
+		utils.NewNullString(webhook.URL), utils.NewNullString(webhook.Secret), utils.NewNullString(webhook.Events), webhook.Active, webhook.ID, webhook.HomeID)
+}
+
+func (s webhookStore) Delete(homeID string, id string) error {
+	return s.exec("DELETE FROM webhooks WHERE home_id=? AND id=?", homeID, id)
+}
+
+func (s webhookStore) ListForHome(homeID string) ([]Webhook, error) {
+	webhooks := []Webhook{}
+	err := s.selectx(&webhooks, "SELECT * FROM webhooks WHERE home_id=? ORDER BY created_at", homeID)
+	return webhooks, err
+}
+
+func (s webhookStore) GetForHome(homeID string, id string) (Webhook, error) {
+	var webhook Webhook
+	err := s.get(&webhook, "SELECT * FROM webhooks WHERE home_id=? AND id=?", homeID, id)
+	return webhook, err
+}
+
+func (s webhookStore) Active(homeID string) ([]Webhook, error) {
+	webhooks := []Webhook{}
+	err := s.selectx(&webhooks, "SELECT * FROM webhooks WHERE active=? AND home_id=?", true, homeID)
+	return webhooks, err
+}
+
+func (s webhookStore) CreateDelivery(delivery WebhookDelivery) error {
+	return s.exec("INSERT INTO webhook_deliveries (id, webhook_id, event_id, event_type, payload, status, next_attempt_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		delivery.ID, delivery.WebhookID, delivery.EventID, delivery.EventType, delivery.Payload, delivery.Status, delivery.NextAttemptAt)
+}
+
+func (s webhookStore) UpdateDelivery(delivery WebhookDelivery) error {
+	return s.exec("UPDATE webhook_deliveries SET status=?, attempts=?, response_code=?, error=?, next_attempt_at=? WHERE id=?",
+		delivery.Status, delivery.Attempts, delivery.ResponseCode, delivery.Error, delivery.NextAttemptAt, delivery.ID)
+}
+
+func (s webhookStore) PendingDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	err := s.selectx(&deliveries, "SELECT * FROM webhook_deliveries WHERE status='pending' AND next_attempt_at<=? ORDER BY next_attempt_at LIMIT ?", now.UTC(), limit)
+	return deliveries, err
+}
+
+func (s webhookStore) DeleteDeliveriesBefore(before time.Time) error {
+	return s.exec("DELETE FROM webhook_deliveries WHERE status!='pending' AND updated_at<?", before.UTC())
+}
+
+func (s webhookStore) Deliveries(webhookID string, limit int) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	err := s.selectx(&deliveries, "SELECT * FROM webhook_deliveries WHERE webhook_id=? ORDER BY id DESC LIMIT ?", webhookID, limit)
+	return deliveries, err
+}
+
+type automationHookStore struct{ *sqlStore }
+
+func (s automationHookStore) Create(hook AutomationHook) error {
+	return s.exec("INSERT INTO automation_hooks (id, home_id, automation_id, token, creator_id) VALUES (?, ?, ?, ?, ?)",
+		hook.ID, hook.HomeID, hook.AutomationID, hook.Token, hook.CreatorID)
+}
+
+func (s automationHookStore) ByToken(token string) (AutomationHook, error) {
+	var hook AutomationHook
+	err := s.get(&hook, "SELECT * FROM automation_hooks WHERE token=?", token)
+	return hook, err
+}
+
+func (s automationHookStore) Delete(automationID string, id string) error {
+	return s.exec("DELETE FROM automation_hooks WHERE automation_id=? AND id=?", automationID, id)
+}
+
+func (s automationHookStore) ListForAutomation(automationID string) ([]AutomationHook, error) {
+	hooks := []AutomationHook{}
+	err := s.selectx(&hooks, "SELECT * FROM automation_hooks WHERE automation_id=? ORDER BY created_at", automationID)
+	return hooks, err
+}
+
+func (s automationHookStore) Fired(id string) error {
+	return s.exec("UPDATE automation_hooks SET last_fired_at=? WHERE id=?", time.Now().UTC(), id)
+}
+
+type homekitStore struct{ *sqlStore }
+
+func (s homekitStore) Get(homeID string, key string) ([]byte, error) {
+	var value []byte
+	err := s.get(&value, "SELECT value FROM homekit_store WHERE home_id=? AND key=?", homeID, key)
+	return value, err
+}
+
+func (s homekitStore) Set(homeID string, key string, value []byte) error {
+	return s.exec("INSERT INTO homekit_store (home_id, key, value) VALUES (?, ?, ?) ON CONFLICT (home_id, key) DO UPDATE SET value=excluded.value",
+		homeID, key, value)
+}
+
+func (s homekitStore) Delete(homeID string, key string) error {
+	return s.exec("DELETE FROM homekit_store WHERE home_id=? AND key=?", homeID, key)
+}
+
+func (s homekitStore) Keys(homeID string) ([]string, error) {
+	keys := []string{}
+	err := s.selectx(&keys, "SELECT key FROM homekit_store WHERE home_id=? ORDER BY key", homeID)
+	return keys, err
+}
+
+type notificationPreferenceStore struct{ *sqlStore }
+
+func (s notificationPreferenceStore) Get(userID string, homeID string) (NotificationPreference, error) {
+	var preference NotificationPreference
+	err := s.get(&preference, "SELECT * FROM notification_preferences WHERE user_id=? AND home_id=?", userID, homeID)
+	return preference, err
+}
+
+func (s notificationPreferenceStore) Set(preference NotificationPreference) error {
+	return s.exec(`INSERT INTO notification_preferences (user_id, home_id, app, email, http_url, http_format, quiet_start, quiet_end, timezone)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, home_id) DO UPDATE SET app=excluded.app, email=excluded.email, http_url=excluded.http_url, http_format=excluded.http_format,
+		quiet_start=excluded.quiet_start, quiet_end=excluded.quiet_end, timezone=excluded.timezone`,
+		preference.UserID, preference.HomeID, preference.App, preference.Email, preference.HTTPURL, preference.HTTPFormat,
+		preference.QuietStart, preference.QuietEnd, preference.Timezone)
+}
+
+type notificationStore struct{ *sqlStore }
+
+func (s notificationStore) Create(notification Notification) error {
+	return s.exec("INSERT INTO notifications (id, user_id, home_id, title, message, source, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		notification.ID, notification.UserID, notification.HomeID, notification.Title, notification.Message, notification.Source, notification.CreatedAt)
+}
+
+func (s notificationStore) ListForUser(userID string, unread bool, limit int) ([]Notification, error) {
+	notifications := []Notification{}
+	query := "SELECT * FROM notifications WHERE user_id=?"
+	if unread {
+		query += " AND read_at IS NULL"
+	}
+	// ids are ULIDs, sorted like creation dates to the millisecond
+	err := s.selectx(&notifications, query+" ORDER BY id DESC LIMIT ?", userID, limit)
+	return notifications, err
+}
+
+func (s notificationStore) GetForUser(userID string, id string) (Notification, error) {
+	var notification Notification
+	err := s.get(&notification, "SELECT * FROM notifications WHERE user_id=? AND id=?", userID, id)
+	return notification, err
+}
+
+func (s notificationStore) CountUnread(userID string) (int, error) {
+	var count int
+	err := s.get(&count, "SELECT COUNT(*) FROM notifications WHERE user_id=? AND read_at IS NULL", userID)
+	return count, err
+}
+
+func (s notificationStore) MarkRead(userID string, id string) error {
+	if id == "" {
+		return s.exec("UPDATE notifications SET read_at=CURRENT_TIMESTAMP WHERE user_id=? AND read_at IS NULL", userID)
+	}
+	return s.exec("UPDATE notifications SET read_at=COALESCE(read_at, CURRENT_TIMESTAMP) WHERE user_id=? AND id=?", userID, id)
+}
+
+func (s notificationStore) Delete(userID string, id string) error {
+	return s.exec("DELETE FROM notifications WHERE user_id=? AND id=?", userID, id)
+}
+
+type aliasStore struct{ *sqlStore }
+
+func (s aliasStore) Create(alias Alias) error {
+	return s.exec("INSERT INTO aliases (id, home_id, name, type, type_id, creator_id) VALUES (?, ?, ?, ?, ?, ?)",
+		alias.ID, alias.HomeID, alias.Name, alias.Type, alias.TypeID, alias.CreatorID)
+}
+
+func (s aliasStore) Delete(homeID string, id string) error {
+	return s.exec("DELETE FROM aliases WHERE home_id=? AND id=?", homeID, id)
+}
+
+func (s aliasStore) ListForHome(homeID string) ([]Alias, error) {
+	aliases := []Alias{}
+	err := s.selectx(&aliases, "SELECT * FROM aliases WHERE home_id=? ORDER BY name", homeID)
+	return aliases, err
+}
+
+func (s aliasStore) GetForHome(homeID string, id string) (Alias, error) {
+	var alias Alias
+	err := s.get(&alias, "SELECT * FROM aliases WHERE home_id=? AND id=?", homeID, id)
+	return alias, err
+}
+
+type hueDeviceStore struct{ *sqlStore }
+
+func (s hueDeviceStore) Set(device HueDevice) error {
+	return s.exec(`INSERT INTO hue_devices (device_id, home_id, light_id, on_call, off_call, brightness_call, creator_id)
+		VALUES (?, ?, (SELECT COALESCE(MAX(light_id), 0) + 1 FROM hue_devices), ?, ?, ?, ?)
+		ON CONFLICT (device_id) DO UPDATE SET on_call=excluded.on_call, off_call=excluded.off_call, brightness_call=excluded.brightness_call`,
+		device.DeviceID, device.HomeID, device.OnCall, device.OffCall, device.BrightnessCall, device.CreatorID)
+}
+
+func (s hueDeviceStore) Delete(homeID string, deviceID string) error {
+	return s.exec("DELETE FROM hue_devices WHERE home_id=? AND device_id=?", homeID, deviceID)
+}
+
+func (s hueDeviceStore) ListForHome(homeID string) ([]HueDevice, error) {
+	devices := []HueDevice{}
+	err := s.selectx(&devices, "SELECT * FROM hue_devices WHERE home_id=? ORDER BY light_id", homeID)
+	return devices, err
+}
+
+func (s hueDeviceStore) GetForHome(homeID string, deviceID string) (HueDevice, error) {
+	var device HueDevice
+	err := s.get(&device, "SELECT * FROM hue_devices WHERE home_id=? AND device_id=?", homeID, deviceID)
+	return device, err
+}
+
+func (s hueDeviceStore) All() ([]HueDevice, error) {
+	devices := []HueDevice{}
+	err := s.selectx(&devices, "SELECT * FROM hue_devices ORDER BY light_id")
+	return devices, err
+}
+
+func (s hueDeviceStore) ByLightID(lightID int) (HueDevice, error) {
+	var device HueDevice
+	err := s.get(&device, "SELECT * FROM hue_devices WHERE light_id=?", lightID)
+	return device, err
+}
+
+type oauthGrantStore struct{ *sqlStore }
+
+func (s oauthGrantStore) Create(grant OAuthGrant, expireAt time.Time) error {
+	var expire interface{}
+	if !expireAt.IsZero() {
+		expire = expireAt.UTC()
+	}
+	return s.exec("INSERT INTO oauth_grants (id, type, client_id, user_id, redirect_uri, expire_at) VALUES (?, ?, ?, ?, ?, ?)",
+		grant.ID, grant.Type, grant.ClientID, grant.UserID, grant.RedirectURI, expire)
+}
+
+func (s oauthGrantStore) ByID(id string, grantType string) (OAuthGrant, error) {
+	var grant OAuthGrant
+	err := s.get(&grant, "SELECT * FROM oauth_grants WHERE id=? AND type=? AND (expire_at IS NULL OR expire_at > ?)", id, grantType, time.Now().UTC())
+	return grant, err
+}
+
+func (s oauthGrantStore) Delete(id string) error {
+	return s.exec("DELETE FROM oauth_grants WHERE id=?", id)
+}
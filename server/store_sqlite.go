@@ -0,0 +1,95 @@
+package server
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/url"
+
+	"github.com/ItsJimi/casa/config"
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+)
+
+// instrumentedSQLiteDriverName is the sqlite driver wrapped to observe query durations
+const instrumentedSQLiteDriverName = "casa-sqlite3"
+
+func init() {
+	sql.Register(instrumentedSQLiteDriverName, &instrumentedDriver{driver: &sqlite3.SQLiteDriver{}})
+}
+
+// jsonArray store a list as JSON text since sqlite has no array type
+type jsonArray []string
+
+func (a jsonArray) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal([]string(a))
+	return string(b), err
+}
+
+func (a *jsonArray) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), a)
+	case []byte:
+		return json.Unmarshal(v, a)
+	}
+	return errors.New("jsonArray: unsupported type")
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) array(list []string) interface{} {
+	return jsonArray(list)
+}
+
+func (sqliteDialect) scanArray(list *[]string) interface{} {
+	return (*jsonArray)(list)
+}
+
+// latestValuesQuery walk distinct device fields through datas_device_field index instead of scanning all datas
+func (sqliteDialect) latestValuesQuery() string {
+	return `
+		WITH RECURSIVE fields (device_id, field) AS (
+			SELECT * FROM (SELECT device_id, field FROM datas ORDER BY device_id, field LIMIT 1)
+			UNION ALL
+			SELECT next.device_id, next.field FROM fields
+			JOIN datas AS next ON next.rowid = (
+				SELECT later.rowid FROM datas AS later
+				WHERE (later.device_id, later.field) > (fields.device_id, fields.field)
+				ORDER BY later.device_id, later.field LIMIT 1
+			)
+		)
+		SELECT homes.name, rooms.name, devices.name, devices.id, fields.field, datas.value_nbr
+		FROM fields
+		JOIN datas ON datas.rowid = (
+			SELECT last.rowid FROM datas AS last
+			WHERE last.device_id = fields.device_id AND last.field = fields.field
+			ORDER BY last.created_at DESC, last.id DESC LIMIT 1
+		)
+		JOIN devices ON fields.device_id = devices.id
+		JOIN rooms ON devices.room_id = rooms.id
+		JOIN homes ON rooms.home_id = homes.id
+		WHERE datas.value_nbr IS NOT NULL
+	`
+}
+
+// openSQLite open sqlite file of conf, created when missing
+func openSQLite(conf config.Database) (Store, error) {
+	params := url.Values{}
+	params.Set("_foreign_keys", "1")
+	params.Set("_busy_timeout", "5000")
+	params.Set("_journal_mode", "WAL")
+
+	db, err := sql.Open(instrumentedSQLiteDriverName, "file:"+conf.Path+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStore{db: sqlx.NewDb(db, "sqlite3"), dialect: sqliteDialect{}}, nil
+}
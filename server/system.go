@@ -2,18 +2,20 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/ItsJimi/casa/config"
 	"github.com/ItsJimi/casa/logger"
+	"github.com/ItsJimi/casa/utils"
 	"github.com/getcasa/sdk"
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo"
-	"github.com/lib/pq"
 )
 
 // WebsocketMessage struct to format received message
@@ -31,18 +33,6 @@ type ActionMessage struct {
 	Params     string
 }
 
-// GatewayAddr define the gateway connected
-var GatewayAddr string
-
-// GatewayConn define the websocket connected between casa server and gateway
-var GatewayConn *websocket.Conn
-
-// ClientsConn define array of websocket client connextion
-var ClientsConn []*websocket.Conn
-var queues []Datas
-
-// Configs define plugins configuration
-var Configs []sdk.Configuration
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true
@@ -50,46 +40,145 @@ var upgrader = websocket.Upgrader{
 }
 
 // InitGatewayConnection create websocket connection
-func InitGatewayConnection(con echo.Context) error {
-	var err error
-	GatewayConn, err = upgrader.Upgrade(con.Response(), con.Request(), nil)
+func (s *Server) InitGatewayConnection(con echo.Context) error {
+	ws, ok := s.gateway.(*websocketGateway)
+	if !ok {
+		logger.WithFields(logger.Fields{"code": "CSDIGC001"}).Warnf("Gateway transport isn't websocket")
+		return con.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    "CSDIGC001",
+			Message: "Gateways connect with MQTT",
+		})
+	}
+
+	wsConn, err := upgrader.Upgrade(con.Response(), con.Request(), nil)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSDIC001"}).Errorf("%s", err.Error())
 		return err
 	}
+	ws.Attach(wsConn)
+	s.gatewayConnected(true)
 
-	go GatewayReader(GatewayConn)
+	go s.GatewayReader(wsConn)
 
 	return nil
 }
 
+// clientAuthTimeout define how long a client has to send its auth message
+const clientAuthTimeout = 10 * time.Second
+
 // InitClientConnection create websocket connection
-func InitClientConnection(con echo.Context) error {
-	wsConn, err := upgrader.Upgrade(con.Response(), con.Request(), nil)
+func (s *Server) InitClientConnection(con echo.Context) error {
+	clientUpgrader := websocket.Upgrader{
+		CheckOrigin: s.checkOrigin,
+	}
+	wsConn, err := clientUpgrader.Upgrade(con.Response(), con.Request(), nil)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSDIC001"}).Errorf("%s", err.Error())
 		return err
 	}
 
-	ClientsConn = append(ClientsConn, wsConn)
+	key := clientToken(con)
+	if key == "" {
+		key, err = readAuthMessage(wsConn)
+		if err != nil {
+			logger.WithFields(logger.Fields{"code": "CSDIC002"}).Warnf("%s", err.Error())
+			rejectClient(wsConn, "CSDIC002", "Missing token")
+			return nil
+		}
+	}
+
+	if ok, _ := s.IsAuthenticated(key, con); !ok {
+		logger.WithFields(logger.Fields{"code": "CSDIC003"}).Warnf("Unauthorized")
+		rejectClient(wsConn, "CSDIC003", "Unauthorized")
+		return nil
+	}
 
-	go ClientReader(wsConn)
+	client := newWSClient(wsConn, con.Get("user").(User), key)
+	s.hub.register(client)
+
+	err = client.send(WebsocketMessage{
+		Action: "authenticated",
+		Body:   []byte(client.user.ID),
+	})
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSDIC004"}).Errorf("%s", err.Error())
+	}
+
+	go s.ClientReader(client)
 
 	return nil
 }
 
+// clientToken return token given in Authorization header or token query param
+func clientToken(c echo.Context) string {
+	auth := strings.Split(c.Request().Header.Get("Authorization"), " ")
+	if len(auth) == 2 && auth[0] == "Bearer" {
+		return auth[1]
+	}
+	return c.QueryParam("token")
+}
+
+// readAuthMessage return token of first message when it's an auth message
+func readAuthMessage(WSConn *websocket.Conn) (string, error) {
+	var wm WebsocketMessage
+
+	WSConn.SetReadDeadline(time.Now().Add(clientAuthTimeout))
+	defer WSConn.SetReadDeadline(time.Time{})
+
+	_, message, err := WSConn.ReadMessage()
+	if err != nil {
+		return "", err
+	}
+	err = json.Unmarshal(message, &wm)
+	if err != nil {
+		return "", err
+	}
+	if wm.Action != "auth" || len(wm.Body) == 0 {
+		return "", errors.New("First message isn't auth")
+	}
+	return string(wm.Body), nil
+}
+
+// rejectClient send an error then close websocket
+func rejectClient(WSConn *websocket.Conn, code string, message string) {
+	body, _ := json.Marshal(ErrorResponse{
+		Code:    code,
+		Message: message,
+	})
+	marshMessage, _ := json.Marshal(WebsocketMessage{
+		Action: "error",
+		Body:   body,
+	})
+	WSConn.SetWriteDeadline(time.Now().Add(clientWriteWait))
+	WebsocketWriteMessage(WSConn, marshMessage)
+	WSConn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, message))
+	WSConn.Close()
+}
+
+// checkOrigin allow browsers origins allowed by CORS configuration
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range s.origins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
 // GatewayReader receive and read message in WS connection
-func GatewayReader(WSConn *websocket.Conn) {
+func (s *Server) GatewayReader(WSConn *websocket.Conn) {
 	for {
 		var wm WebsocketMessage
 
 		_, message, err := WSConn.ReadMessage()
 		if err != nil {
 			logger.WithFields(logger.Fields{"code": "CSDGR001"}).Errorf("%s", err.Error())
-			if strings.Contains(err.Error(), "close 1006") {
-				return
-			}
-			continue
+			s.gatewayConnected(false)
+			return
 		}
 		err = json.Unmarshal(message, &wm)
 		if err != nil {
@@ -99,31 +188,54 @@ func GatewayReader(WSConn *websocket.Conn) {
 
 		logger.WithFields(logger.Fields{}).Debugf("recv: %s", message)
 
-		switch wm.Action {
-		case "newConnection":
-			GatewayAddr = string(wm.Body)
-			GetConfigFromGateway(GatewayAddr)
-		case "newData":
-			go func(data []byte) {
-				var datas []Datas
-				json.Unmarshal(data, &datas)
-				SaveNewDatas(datas)
-			}(wm.Body)
-		default:
-			continue
-		}
+		s.handleGatewayMessage(wm)
+	}
+}
+
+// gatewayConnected save and publish to homes linked to gateway that it's online or offline
+func (s *Server) gatewayConnected(online bool) {
+	setGatewayOnline(online)
+	gateways, err := s.db.Gateways().ListLinked()
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSSGC001"}).Errorf("%s", err.Error())
+	}
+	for _, gateway := range gateways {
+		s.publish(Event{Type: "gateway", HomeID: gateway.HomeID, Data: GatewayEvent{Online: online}})
+	}
+	if !online {
+		go s.notifyGatewayOffline()
+	}
+}
+
+// handleGatewayMessage do what gateway asked, whatever transport it's connected with
+func (s *Server) handleGatewayMessage(wm WebsocketMessage) {
+	switch wm.Action {
+	case "newConnection":
+		s.gateway.SetAddr(string(wm.Body))
+		s.GetConfigFromGateway()
+	case "newData":
+		go func(data []byte) {
+			var datas []Datas
+			json.Unmarshal(data, &datas)
+			s.SaveNewDatas(datas)
+		}(wm.Body)
+	case "configs":
+		s.addConfigs(wm.Body)
 	}
 }
 
 // ClientReader receive and read message in WS connection
-func ClientReader(WSConn *websocket.Conn) {
+func (s *Server) ClientReader(client *wsClient) {
 	for {
 		var wm WebsocketMessage
 
-		_, message, err := WSConn.ReadMessage()
+		_, message, err := client.conn.ReadMessage()
 		if err != nil {
-			logger.WithFields(logger.Fields{"code": "CSDCR001"}).Errorf("%s", err.Error())
-			continue
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				logger.WithFields(logger.Fields{"code": "CSDCR001"}).Errorf("%s", err.Error())
+			}
+			s.hub.unregister(client)
+			return
 		}
 		err = json.Unmarshal(message, &wm)
 		if err != nil {
@@ -133,11 +245,20 @@ func ClientReader(WSConn *websocket.Conn) {
 
 		logger.WithFields(logger.Fields{}).Debugf("recv: %s", message)
 
+		if _, err := s.userFromToken(client.token); err != nil {
+			logger.WithFields(logger.Fields{"code": "CSDCR009"}).Warnf("%s", err.Error())
+			s.dropClient(client, "CSDCR009", "Token expired or revoked")
+			return
+		}
+
 		switch wm.Action {
 		case "getLog":
 			var deviceID = wm.Body
-			var data Datas
-			err := DB.Get(&data, "SELECT * FROM datas WHERE device_id=$1", deviceID)
+			if !s.clientHasPermission(client, "device", string(deviceID), true, false, false, false) {
+				s.sendClientError(client, "CSDCR010", "Unauthorized")
+				continue
+			}
+			data, err := s.db.Datas().Latest(string(deviceID), "")
 			if err != nil {
 				logger.WithFields(logger.Fields{"code": "CSDCR003"}).Errorf("%s", err.Error())
 				continue
@@ -145,22 +266,41 @@ func ClientReader(WSConn *websocket.Conn) {
 
 			marshalData, _ := json.Marshal(data)
 
-			message := WebsocketMessage{
+			logger.WithFields(logger.Fields{}).Debugf("Data sent to app")
+			err = client.send(WebsocketMessage{
 				Action: "getLog",
 				Body:   marshalData,
+			})
+			if err != nil {
+				logger.WithFields(logger.Fields{"code": "CSDGR005"}).Errorf("%s", err.Error())
+				continue
 			}
 
-			marshMessage, _ := json.Marshal(message)
-			if err != nil {
-				logger.WithFields(logger.Fields{"code": "CSDGR004"}).Errorf("%s", err.Error())
-				break
+		case "subscribe", "unsubscribe":
+			var sub Subscription
+			err := json.Unmarshal(wm.Body, &sub)
+			if err != nil || (sub.Type != "home" && sub.Type != "room" && sub.Type != "device") || sub.ID == "" {
+				s.sendClientError(client, "CSDCR006", "Wrong subscription")
+				continue
 			}
-			logger.WithFields(logger.Fields{}).Debugf("Data sent to app")
-			err = WebsocketWriteMessage(WSConn, marshMessage)
-			if err != nil {
-				logger.WithFields(logger.Fields{"code": "CSDGR005"}).Errorf("%s", err.Error())
+			if wm.Action == "unsubscribe" {
+				client.unsubscribe(sub)
+				continue
+			}
+			if !s.clientHasPermission(client, sub.Type, sub.ID, true, false, false, false) {
+				s.sendClientError(client, "CSDCR007", "Unauthorized")
 				continue
 			}
+			client.subscribe(sub)
+
+			marshalSub, _ := json.Marshal(sub)
+			err = client.send(WebsocketMessage{
+				Action: "subscribed",
+				Body:   marshalSub,
+			})
+			if err != nil {
+				logger.WithFields(logger.Fields{"code": "CSDCR008"}).Errorf("%s", err.Error())
+			}
 
 		default:
 			continue
@@ -168,6 +308,21 @@ func ClientReader(WSConn *websocket.Conn) {
 	}
 }
 
+// sendClientError send an error message to client
+func (s *Server) sendClientError(client *wsClient, code string, message string) {
+	body, _ := json.Marshal(ErrorResponse{
+		Code:    code,
+		Message: message,
+	})
+	err := client.send(WebsocketMessage{
+		Action: "error",
+		Body:   body,
+	})
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSDSCE001"}).Errorf("%s", err.Error())
+	}
+}
+
 // WebsocketWriteMessage send a message in WS connection
 func WebsocketWriteMessage(WSConn *websocket.Conn, message []byte) error {
 	err := WSConn.WriteMessage(websocket.TextMessage, message)
@@ -179,46 +334,46 @@ func WebsocketWriteMessage(WSConn *websocket.Conn, message []byte) error {
 }
 
 // GetConfigFromGateway get config from gateway
-func GetConfigFromGateway(addr string) {
-	var tmpConfigs []sdk.Configuration
-
-	resp, err := http.Get("http://" + addr + "/v1/configs")
+func (s *Server) GetConfigFromGateway() {
+	body, err := s.gateway.Fetch("/v1/configs")
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSSGCFG001"}).Errorf("%s", err.Error())
 		return
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		logger.WithFields(logger.Fields{"code": "CSSGCFG002"}).Errorf("%s", err.Error())
-		return
-	}
+	s.addConfigs(body)
+}
+
+// addConfigs add plugins configurations which are not known yet
+func (s *Server) addConfigs(body []byte) {
+	var tmpConfigs []sdk.Configuration
 
-	err = json.Unmarshal(body, &tmpConfigs)
+	err := json.Unmarshal(body, &tmpConfigs)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSSGCFG003"}).Errorf("%s", err.Error())
 		return
 	}
 
-	if len(Configs) == 0 {
-		Configs = tmpConfigs
+	if len(s.configs) == 0 {
+		s.configs = tmpConfigs
 	} else {
 		for _, tmpConf := range tmpConfigs {
-			if configFromPlugin(Configs, tmpConf.Name).Name == "" {
-				Configs = append(Configs, tmpConf)
+			if configFromPlugin(s.configs, tmpConf.Name).Name == "" {
+				s.configs = append(s.configs, tmpConf)
 			}
 		}
 	}
+	go s.publishDiscovery()
+	go s.reloadHomeKit("")
 }
 
 // GetDiscoveredDevices return an array of futur discover
-func GetDiscoveredDevices(c echo.Context) error {
+func (s *Server) GetDiscoveredDevices(c echo.Context) error {
 	var discovered []sdk.DiscoveredDevice
 	logger.WithFields(logger.Fields{}).Debugf("Discover devices")
 	plugin := c.Param("plugin")
 
-	resp, err := http.Get("http://" + GatewayAddr + "/v1/discover/" + plugin)
+	body, err := s.gateway.Fetch("/v1/discover/" + plugin)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSSGDDG001"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusBadRequest, ErrorResponse{
@@ -226,16 +381,6 @@ func GetDiscoveredDevices(c echo.Context) error {
 			Message: err.Error(),
 		})
 	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		logger.WithFields(logger.Fields{"code": "CSSGDDG002"}).Errorf("%s", err.Error())
-		return c.JSON(http.StatusBadRequest, ErrorResponse{
-			Code:    "CSSGDDG002",
-			Message: err.Error(),
-		})
-	}
 
 	err = json.Unmarshal(body, &discovered)
 	if err != nil {
@@ -251,12 +396,7 @@ func GetDiscoveredDevices(c echo.Context) error {
 		arrayPhysicalID = append(arrayPhysicalID, disco.PhysicalID)
 	}
 
-	rows, err := DB.Queryx(`
-		SELECT physical_id
-		FROM devices
-		JOIN gateways ON devices.gateway_id = gateways.id
-		WHERE physical_id = ANY($1) AND gateways.home_id = $2
-	`, "{"+strings.Join(arrayPhysicalID, ",")+"}", c.Param("homeId"))
+	knownIDs, err := s.db.Devices().KnownPhysicalIDs(c.Param("homeId"), arrayPhysicalID)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSSGDDG004"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -264,21 +404,10 @@ func GetDiscoveredDevices(c echo.Context) error {
 			Message: err.Error(),
 		})
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var device Device
-		err := rows.StructScan(&device)
-		if err != nil {
-			logger.WithFields(logger.Fields{"code": "CSSGDDG005"}).Errorf("%s", err.Error())
-			return c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Code:    "CSSGDDG005",
-				Message: err.Error(),
-			})
-		}
 
+	for _, physicalID := range knownIDs {
 		for ind, disco := range discovered {
-			if disco.PhysicalID == device.PhysicalID {
+			if disco.PhysicalID == physicalID {
 				discovered = append(discovered[:ind], discovered[ind+1:]...)
 				continue
 			}
@@ -289,8 +418,13 @@ func GetDiscoveredDevices(c echo.Context) error {
 }
 
 // SaveNewDatas save receive datas from gateway in DB
-func SaveNewDatas(datas []Datas) {
-	var devices []Device
+func (s *Server) SaveNewDatas(datas []Datas) {
+	start := time.Now()
+	defer func() {
+		datasSaveDuration.Observe(time.Since(start).Seconds())
+	}()
+	datasReceived.Add(float64(len(datas)))
+
 	var arrayID []string
 
 	for _, data := range datas {
@@ -299,36 +433,38 @@ func SaveNewDatas(datas []Datas) {
 		}
 	}
 
-	rows, err := DB.Queryx(`SELECT id, physical_name, physical_id, plugin FROM devices WHERE physical_id = ANY($1)`, "{"+strings.Join(arrayID, ",")+"}")
+	devices, err := s.db.Devices().ByPhysicalIDs(arrayID)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSDSND001"}).Errorf("%s", err.Error())
 		return
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var device Device
-		err := rows.StructScan(&device)
-		if err != nil {
-			logger.WithFields(logger.Fields{"code": "CSDSND002"}).Errorf("%s", err.Error())
-		}
-		devices = append(devices, device)
-	}
 
 	for _, data := range datas {
 		device := findDeviceFromID(devices, data.DeviceID)
 		if device != nil {
 			data.DeviceID = device.ID
 
-			if FindFieldFromName(sdk.FindDevicesFromName(configFromPlugin(Configs, device.Plugin).Devices, device.PhysicalName).Triggers, data.Field).Direct {
-				queues = append(queues, data)
+			field := FindFieldFromName(sdk.FindDevicesFromName(configFromPlugin(s.configs, device.Plugin).Devices, device.PhysicalName).Triggers, data.Field)
+			if field.Direct {
+				s.queues = append(s.queues, data)
+			}
+			if s.batteryLow(*device, data) {
+				go s.notifyLowBattery(*device, data.ValueNbr)
 			}
-			_, err = DB.Exec("INSERT INTO datas (id, device_id, field, value_nbr, value_str, value_bool) VALUES ($1, $2, $3, $4, $5, $6)",
-				data.ID, data.DeviceID, data.Field, data.ValueNbr, data.ValueStr, data.ValueBool)
+			err = s.db.Datas().Create(data)
 			if err != nil {
+				datasSaved.WithLabelValues("error").Inc()
 				logger.WithFields(logger.Fields{"code": "CSDSND003"}).Errorf("%s", err.Error())
 				continue
 			}
+			datasSaved.WithLabelValues("ok").Inc()
+			event := s.publishDeviceEvent("data", *device, data)
+			if s.mqtt != nil {
+				s.mqtt.publishData(event, field.Type)
+			}
+			if s.homekit != nil {
+				s.updateHomeKit(data)
+			}
 		}
 	}
 }
@@ -356,25 +492,21 @@ type automationState struct {
 	Active bool
 }
 
-var listAutomations []automationState
-
 // Automations loop on automations to do actions
-func Automations() {
-	for range time.Tick(200 * time.Millisecond) {
-		rows, err := DB.Queryx("SELECT * FROM automations")
+func (s *Server) Automations(conf config.Automation) {
+	automationsInterval = conf.Interval
+	for range time.Tick(conf.Interval) {
+		atomic.StoreInt64(&automationsHeartbeat, time.Now().UnixNano())
+		automations, err := s.db.Automations().All()
 		if err != nil {
 			fmt.Println(err)
 			continue
 		}
-		defer rows.Close()
-		for rows.Next() {
-			var auto Automation
-			err := rows.Scan(&auto.ID, &auto.HomeID, &auto.Name, pq.Array(&auto.Trigger), pq.Array(&auto.TriggerKey), pq.Array(&auto.TriggerValue), pq.Array(&auto.TriggerOperator), pq.Array(&auto.Action), pq.Array(&auto.ActionCall), pq.Array(&auto.ActionValue), &auto.Status, &auto.CreatedAt, &auto.UpdatedAt, &auto.CreatorID)
-			if err != nil {
-				continue
-			}
-			if findAutomationFromID(listAutomations, auto.ID) == -1 {
-				listAutomations = append(listAutomations, automationState{
+		for _, auto := range automations {
+			evaluationStart := time.Now()
+			automationEvaluations.Inc()
+			if findAutomationFromID(s.automationStates, auto.ID) == -1 {
+				s.automationStates = append(s.automationStates, automationState{
 					ID:     auto.ID,
 					Active: false,
 				})
@@ -382,12 +514,11 @@ func Automations() {
 			var conditions []string
 
 			for i := 0; i < len(auto.Trigger); i++ {
-				var device Device
-				err = DB.Get(&device, `SELECT * FROM devices WHERE id = $1`, auto.Trigger[i])
-				field := FindFieldFromName(sdk.FindDevicesFromName(configFromPlugin(Configs, device.Plugin).Devices, device.PhysicalName).Triggers, auto.TriggerKey[i])
+				device, _ := s.db.Devices().ByID(auto.Trigger[i])
+				field := FindFieldFromName(sdk.FindDevicesFromName(configFromPlugin(s.configs, device.Plugin).Devices, device.PhysicalName).Triggers, auto.TriggerKey[i])
 
 				if field.Direct {
-					queue := FindDataFromID(queues, device.ID)
+					queue := FindDataFromID(s.queues, device.ID)
 					if queue.DeviceID == device.ID {
 						switch field.Type {
 						case "string":
@@ -406,52 +537,9 @@ func Automations() {
 						}
 					}
 				} else if device.ID == auto.Trigger[i] {
-					var data Datas
-					err = DB.Get(&data, `SELECT * FROM datas WHERE device_id = $1 AND field = $2 ORDER BY created_at DESC`, device.ID, auto.TriggerKey[i])
-					switch field.Type {
-					case "string":
-						if data.ValueStr == auto.TriggerValue[i] {
-							conditions = append(conditions, "1")
-						}
-					case "int":
-						firstchar := string(auto.TriggerValue[i][0])
-						secondchar := string(auto.TriggerValue[i][1])
-						value, err := strconv.ParseFloat(string(auto.TriggerValue[i][1:]), 64)
-						if err == nil {
-							switch firstchar {
-							case ">":
-								if secondchar == "=" && data.ValueNbr >= value {
-									conditions = append(conditions, "1")
-									break
-								}
-								if data.ValueNbr > value {
-									conditions = append(conditions, "1")
-								}
-							case "<":
-								if secondchar == "=" && data.ValueNbr <= value {
-									conditions = append(conditions, "1")
-									break
-								}
-								if data.ValueNbr < value {
-									conditions = append(conditions, "1")
-								}
-							case "=":
-								if data.ValueNbr == value {
-									conditions = append(conditions, "1")
-								}
-							case "!":
-								if secondchar == "=" && data.ValueNbr != value {
-									conditions = append(conditions, "1")
-								}
-							default:
-							}
-						}
-					case "bool":
-						triggerValueBool, err := strconv.ParseBool(auto.TriggerValue[i])
-						if err == nil && data.ValueBool == triggerValueBool {
-							conditions = append(conditions, "1")
-						}
-					default:
+					data, _ := s.db.Datas().Latest(device.ID, auto.TriggerKey[i])
+					if matchData(field.Type, data, auto.TriggerValue[i]) {
+						conditions = append(conditions, "1")
 					}
 				}
 				if len(conditions) == 0 {
@@ -465,59 +553,146 @@ func Automations() {
 				}
 			}
 
-			stateAuto := findAutomationFromID(listAutomations, auto.ID)
+			stateAuto := findAutomationFromID(s.automationStates, auto.ID)
+			automationEvaluationDuration.Observe(time.Since(evaluationStart).Seconds())
 			if !checkConditionOperator(conditions) {
-				listAutomations[stateAuto].Active = false
+				s.automationStates[stateAuto].Active = false
 				continue
 			}
-			if stateAuto != -1 && listAutomations[stateAuto].Active {
+			if stateAuto != -1 && s.automationStates[stateAuto].Active {
 				continue
 			}
+			if s.runAutomation(auto, nil) {
+				s.automationStates[stateAuto].Active = true
+			}
+		}
+		s.queues = nil
+	}
 
-			for i := 0; i < len(auto.Action); i++ {
-				var device Device
-				err = DB.Get(&device, `SELECT * FROM devices WHERE id = $1`, auto.Action[i])
-				if err == nil {
-
-					act := ActionMessage{
-						PhysicalID: device.PhysicalID,
-						Plugin:     device.Plugin,
-						Call:       auto.ActionCall[i],
-						Config:     device.Config,
-						Params:     auto.ActionValue[i],
-					}
+	go s.Automations(conf)
+}
 
-					marshAct, _ := json.Marshal(act)
+// runAutomation send actions of automation with vars replaced in params, it return true when an action was called
+func (s *Server) runAutomation(auto Automation, vars map[string]string) bool {
+	called := false
+	automationRuns.Inc()
 
-					message := WebsocketMessage{
-						Action: "callAction",
-						Body:   marshAct,
-					}
+	for i := 0; i < len(auto.Action); i++ {
+		if auto.Action[i] == notificationAction {
+			called = true
+			go s.notifyAutomation(auto, auto.ActionCall[i], replaceVariables(auto.ActionValue[i], vars))
+			continue
+		}
+		device, err := s.db.Devices().ByID(auto.Action[i])
+		if err == nil {
+
+			act := ActionMessage{
+				PhysicalID: device.PhysicalID,
+				Plugin:     device.Plugin,
+				Call:       auto.ActionCall[i],
+				Config:     device.Config,
+				Params:     replaceVariables(auto.ActionValue[i], vars),
+			}
 
-					listAutomations[stateAuto].Active = true
+			marshAct, _ := json.Marshal(act)
 
-					marshMessage, _ := json.Marshal(message)
-					if err != nil {
-						logger.WithFields(logger.Fields{"code": "CSSA001"}).Errorf("%s", err.Error())
-						break
-					}
-					logger.WithFields(logger.Fields{}).Debugf("Action sent to gateway")
-					err = WebsocketWriteMessage(GatewayConn, marshMessage)
-					if err != nil {
-						logger.WithFields(logger.Fields{"code": "CSSA002"}).Errorf("%s", err.Error())
-						continue
-					}
-				}
+			message := WebsocketMessage{
+				Action: "callAction",
+				Body:   marshAct,
+			}
+
+			called = true
+
+			marshMessage, _ := json.Marshal(message)
+			if err != nil {
+				logger.WithFields(logger.Fields{"code": "CSSA001"}).Errorf("%s", err.Error())
+				break
+			}
+			logger.WithFields(logger.Fields{}).Debugf("Action sent to gateway")
+			actionEvent := ActionEvent{
+				Call:         act.Call,
+				Params:       act.Params,
+				Status:       "sent",
+				Source:       "automation",
+				AutomationID: auto.ID,
 			}
-			_, err = DB.Exec("INSERT INTO logs (id, type, type_id, value) VALUES (generate_ulid(), $1, $2, $3)", "automation", auto.ID, "")
+			err = s.gateway.Send(marshMessage)
 			if err != nil {
-				logger.WithFields(logger.Fields{"code": "CSSA003"}).Errorf("%s", err.Error())
+				actionSendFailures.WithLabelValues("automation").Inc()
+				logger.WithFields(logger.Fields{"code": "CSSA002"}).Errorf("%s", err.Error())
+				actionEvent.Status = "failed"
+				s.publishDeviceEvent("action", device, actionEvent)
+				continue
 			}
+			actionsSent.WithLabelValues("automation").Inc()
+			s.publishDeviceEvent("action", device, actionEvent)
 		}
-		queues = nil
 	}
+	err := s.db.Logs().Create(Logs{
+		ID:     utils.NewULID(),
+		Type:   "automation",
+		TypeID: auto.ID,
+	})
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSSA003"}).Errorf("%s", err.Error())
+	}
+	s.publish(Event{
+		Type:   "automation",
+		HomeID: auto.HomeID,
+		Data: AutomationEvent{
+			ID:   auto.ID,
+			Name: auto.Name,
+		},
+	})
+	return called
+}
 
-	go Automations()
+// matchData tell if data of a field with fieldType match trigger value of an automation
+func matchData(fieldType string, data Datas, trigger string) bool {
+	switch fieldType {
+	case "string":
+		if data.ValueStr == trigger {
+			return true
+		}
+	case "int":
+		firstchar := string(trigger[0])
+		secondchar := string(trigger[1])
+		value, err := strconv.ParseFloat(string(trigger[1:]), 64)
+		if err == nil {
+			switch firstchar {
+			case ">":
+				if secondchar == "=" && data.ValueNbr >= value {
+					return true
+				}
+				if data.ValueNbr > value {
+					return true
+				}
+			case "<":
+				if secondchar == "=" && data.ValueNbr <= value {
+					return true
+				}
+				if data.ValueNbr < value {
+					return true
+				}
+			case "=":
+				if data.ValueNbr == value {
+					return true
+				}
+			case "!":
+				if secondchar == "=" && data.ValueNbr != value {
+					return true
+				}
+			default:
+			}
+		}
+	case "bool":
+		triggerValueBool, err := strconv.ParseBool(trigger)
+		if err == nil && data.ValueBool == triggerValueBool {
+			return true
+		}
+	default:
+	}
+	return false
 }
 
 func checkConditionOperator(conditions []string) bool {
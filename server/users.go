@@ -12,7 +12,7 @@ import (
 )
 
 // GetUser route get user by id
-func GetUser(c echo.Context) error {
+func (s *Server) GetUser(c echo.Context) error {
 	reqUser := c.Get("user").(User)
 
 	if c.Param("userId") == "me" || c.Param("userId") == reqUser.ID {
@@ -35,7 +35,7 @@ type updateUserProfilReq struct {
 }
 
 // UpdateUserProfil route update user profil
-func UpdateUserProfil(c echo.Context) error {
+func (s *Server) UpdateUserProfil(c echo.Context) error {
 	req := new(updateUserProfilReq)
 	if err := c.Bind(req); err != nil {
 		logger.WithFields(logger.Fields{"code": "CSUUUP001"}).Errorf("%s", err.Error())
@@ -63,11 +63,7 @@ func UpdateUserProfil(c echo.Context) error {
 		})
 	}
 
-	_, err := DB.Exec(`
-		UPDATE users
-		SET firstname=$1, lastname=$2
-		WHERE id=$3
-	`, req.Firstname, req.Lastname, c.Param("userId"))
+	err := s.db.Users().UpdateProfil(c.Param("userId"), req.Firstname, req.Lastname)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSUUUP004"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -86,7 +82,7 @@ type updateUserEmailReq struct {
 }
 
 // UpdateUserEmail route update user email
-func UpdateUserEmail(c echo.Context) error {
+func (s *Server) UpdateUserEmail(c echo.Context) error {
 	req := new(updateUserEmailReq)
 	if err := c.Bind(req); err != nil {
 		logger.WithFields(logger.Fields{"code": "CSUUUE001"}).Errorf("%s", err.Error())
@@ -114,11 +110,7 @@ func UpdateUserEmail(c echo.Context) error {
 		})
 	}
 
-	_, err := DB.Exec(`
-		UPDATE users
-		SET email=$1
-		WHERE id=$2
-	`, req.Email, c.Param("userId"))
+	err := s.db.Users().UpdateEmail(c.Param("userId"), req.Email)
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSUUUE004"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -139,7 +131,7 @@ type updateUserPasswordReq struct {
 }
 
 // UpdateUserPassword route update user password
-func UpdateUserPassword(c echo.Context) error {
+func (s *Server) UpdateUserPassword(c echo.Context) error {
 	req := new(updateUserPasswordReq)
 	if err := c.Bind(req); err != nil {
 		logger.WithFields(logger.Fields{"code": "CSUUUPA001"}).Errorf("%s", err.Error())
@@ -192,11 +184,7 @@ func UpdateUserPassword(c echo.Context) error {
 		})
 	}
 
-	_, err = DB.Exec(`
-		UPDATE users
-		SET password=$1
-		WHERE id=$2
-	`, hashedPassword, c.Param("userId"))
+	err = s.db.Users().UpdatePassword(c.Param("userId"), string(hashedPassword))
 	if err != nil {
 		logger.WithFields(logger.Fields{"code": "CSUUUPA007"}).Errorf("%s", err.Error())
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -1,10 +1,15 @@
 package server
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"time"
 
+	"github.com/ItsJimi/casa/config"
+	"github.com/ItsJimi/casa/logger"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // MessageResponse define json response for API
@@ -24,11 +29,59 @@ type DataReponse struct {
 }
 
 // Start start echo server
-func Start(port string) {
+func (s *Server) Start(conf config.Configuration) {
+	ExportDir = conf.Export.Dir
+	if conf.Metrics.Token == "" {
+		logger.WithFields(logger.Fields{"code": "CSWSS002"}).Warnf("Metrics aren't served, set metrics.token to scrape them")
+	}
+	if conf.Metrics.DeviceValues {
+		RegisterDeviceMetrics(s.db)
+	}
+	if !conf.Automation.Enabled {
+		disableReadinessCheck("automations")
+	}
+	if gs, ok := s.gateway.(gatewayServer); ok {
+		if err := gs.Serve(s); err != nil {
+			logger.WithFields(logger.Fields{"code": "CSWSS001"}).Fatalf("%s", err.Error())
+		}
+	}
+	s.StartNotifications(conf.Notifications)
+	if conf.MQTT.Enabled {
+		s.StartMQTT(conf.MQTT)
+	}
+	if conf.HomeKit.Enabled {
+		s.StartHomeKit(conf.HomeKit)
+	}
+	if conf.Hue.Enabled {
+		s.StartHue(conf.Hue)
+	}
+
+	e := s.Router(conf)
+	go s.CheckClientsTokens(time.Minute)
+	go s.hookLimiter.EvictWindows(hookRateWindow)
+	go s.DeliverWebhooks(time.Second)
+	go s.PurgeWebhookDeliveries(time.Hour)
+	go PurgeExportJobs(time.Hour)
+
+	if conf.Server.TLS.Enabled {
+		e.Logger.Fatal(e.StartTLS(conf.Server.Address, conf.Server.TLS.CertFile, conf.Server.TLS.KeyFile))
+	}
+	e.Logger.Fatal(e.Start(conf.Server.Address))
+}
+
+// Router build echo instance with all routes of the API
+func (s *Server) Router(conf config.Configuration) *echo.Echo {
+	s.origins = conf.Server.CORSOrigins
+	s.trustedProxies = conf.Server.TrustedProxies
+	s.oauthClients = conf.OAuth.Clients
+
 	e := echo.New()
 
 	e.Pre(middleware.RemoveTrailingSlash())
-	e.Use(middleware.CORS())
+	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins: conf.Server.CORSOrigins,
+	}))
+	e.Use(metricsMiddleware)
 
 	e.GET("/", func(c echo.Context) error {
 		return c.JSON(http.StatusOK, MessageResponse{
@@ -42,163 +95,282 @@ func Start(port string) {
 		})
 	})
 
+	e.GET("/healthz", s.Healthz)
+	e.GET("/readyz", s.Readyz)
+
+	// Metrics are only served to scrapers knowing token
+	if conf.Metrics.Token != "" {
+		e.GET("/metrics", echo.WrapHandler(promhttp.Handler()), middleware.KeyAuth(func(key string, c echo.Context) (bool, error) {
+			return subtle.ConstantTimeCompare([]byte(key), []byte(conf.Metrics.Token)) == 1, nil
+		}))
+	}
+
 	// V1
 	v1 := e.Group("/v1")
 
 	// Signup
-	v1.POST("/signup", SignUp)
+	v1.POST("/signup", s.SignUp)
 
 	// Signin
-	v1.POST("/signin", SignIn)
+	v1.POST("/signin", s.SignIn)
 
 	// Link Gateway
-	v1.POST("/gateway", AddGateway)
-	v1.POST("/gateway/:gatewayId/plugins", AddPlugin)
-	v1.GET("/gateway/:gatewayId/plugins/:pluginName", GetPlugin)
+	v1.POST("/gateway", s.AddGateway)
+	v1.POST("/gateway/:gatewayId/plugins", s.AddPlugin)
+	v1.GET("/gateway/:gatewayId/plugins/:pluginName", s.GetPlugin)
 
 	// WS
-	v1.GET("/ws", InitGatewayConnection)
-	v1.GET("/ws/client", InitClientConnection)
+	v1.GET("/ws", s.InitGatewayConnection)
+	v1.GET("/ws/client", s.InitClientConnection)
+
+	// Incoming webhooks, authenticated by their token
+	v1.POST("/hooks/:token", s.FireAutomationHook)
+
+	// OAuth account linking of voice assistants
+	v1.GET("/oauth/authorize", s.OAuthAuthorize)
+	v1.POST("/oauth/authorize", s.OAuthAuthorizeSubmit)
+	v1.POST("/oauth/token", s.OAuthToken)
+
+	// Alexa directives carry their token in body
+	v1.POST("/assistants/alexa", s.AlexaFulfillment)
 
 	// Check authorization
-	v1.Use(middleware.KeyAuth(IsAuthenticated))
+	v1.Use(middleware.KeyAuth(s.IsAuthenticated))
 
 	// Signout
-	v1.POST("/signout", SignOut)
+	v1.POST("/signout", s.SignOut)
+
+	// Voice assistants
+	v1.POST("/assistants/google", s.GoogleFulfillment)
 
 	// Homes
-	v1.POST("/homes", AddHome)
-	v1.PUT("/homes/:homeId", UpdateHome, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", false, false, true, false)
+	v1.POST("/homes", s.AddHome)
+	v1.PUT("/homes/:homeId", s.UpdateHome, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
 	})
-	v1.DELETE("/homes/:homeId", DeleteHome, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", false, false, false, true)
+	v1.DELETE("/homes/:homeId", s.DeleteHome, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, false, true)
 	})
-	v1.GET("/homes", GetHomes)
-	v1.GET("/homes/:homeId", GetHome, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", true, false, false, false)
+	v1.GET("/homes", s.GetHomes)
+	v1.GET("/homes/:homeId", s.GetHome, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", true, false, false, false)
 	})
 
 	// Homes Members
-	v1.GET("/homes/:homeId/members", GetMembers, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", true, false, false, false)
+	v1.GET("/homes/:homeId/members", s.GetMembers, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", true, false, false, false)
 	})
-	v1.POST("/homes/:homeId/members", AddMember, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", false, false, true, false)
+	v1.POST("/homes/:homeId/members", s.AddMember, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
 	})
-	v1.DELETE("/homes/:homeId/members/:userId", RemoveMember, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", false, false, true, false)
+	v1.DELETE("/homes/:homeId/members/:userId", s.RemoveMember, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
 	})
-	v1.PUT("/homes/:homeId/members/:userId", EditMember, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", false, false, false, true)
+	v1.PUT("/homes/:homeId/members/:userId", s.EditMember, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, false, true)
+	})
+
+	// Events
+	v1.GET("/homes/:homeId/events", s.GetHomeEvents, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", true, false, false, false)
+	})
+
+	// Webhooks
+	v1.POST("/homes/:homeId/webhooks", s.AddWebhook, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
+	})
+	v1.PUT("/homes/:homeId/webhooks/:webhookId", s.UpdateWebhook, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
+	})
+	v1.DELETE("/homes/:homeId/webhooks/:webhookId", s.DeleteWebhook, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
+	})
+	v1.GET("/homes/:homeId/webhooks", s.GetWebhooks, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
+	})
+	v1.GET("/homes/:homeId/webhooks/:webhookId", s.GetWebhook, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
+	})
+	v1.GET("/homes/:homeId/webhooks/:webhookId/deliveries", s.GetWebhookDeliveries, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
+	})
+
+	// Notifications
+	v1.GET("/homes/:homeId/notifications/preferences", s.GetNotificationPreference, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", true, false, false, false)
+	})
+	v1.PUT("/homes/:homeId/notifications/preferences", s.UpdateNotificationPreference, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", true, false, false, false)
+	})
+	v1.POST("/homes/:homeId/notifications/test", s.TestNotification, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", true, false, false, false)
+	})
+
+	// Text commands
+	v1.POST("/homes/:homeId/command", s.Command, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", true, false, false, false)
+	})
+	v1.GET("/homes/:homeId/aliases", s.GetAliases, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", true, false, false, false)
+	})
+	v1.POST("/homes/:homeId/aliases", s.AddAlias, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
+	})
+	v1.DELETE("/homes/:homeId/aliases/:aliasId", s.DeleteAlias, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
+	})
+
+	// Devices exposed by emulated Hue bridge
+	v1.GET("/homes/:homeId/hue/devices", s.GetHueDevices, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
+	})
+	v1.PUT("/homes/:homeId/hue/devices/:deviceId", s.SetHueDevice, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
+	})
+	v1.DELETE("/homes/:homeId/hue/devices/:deviceId", s.DeleteHueDevice, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
 	})
 
 	// Rooms
-	v1.POST("/homes/:homeId/rooms", AddRoom, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", false, false, true, false)
+	v1.POST("/homes/:homeId/rooms", s.AddRoom, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
 	})
-	v1.PUT("/homes/:homeId/rooms/:roomId", UpdateRoom, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "room", false, false, true, false)
+	v1.PUT("/homes/:homeId/rooms/:roomId", s.UpdateRoom, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "room", false, false, true, false)
 	})
-	v1.DELETE("/homes/:homeId/rooms/:roomId", DeleteRoom, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "room", false, false, false, true)
+	v1.DELETE("/homes/:homeId/rooms/:roomId", s.DeleteRoom, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "room", false, false, false, true)
 	})
-	v1.GET("/homes/:homeId/rooms", GetRooms, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", true, false, false, false)
+	v1.GET("/homes/:homeId/rooms", s.GetRooms, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", true, false, false, false)
 	})
-	v1.GET("/homes/:homeId/rooms/:roomId", GetRoom, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "room", true, false, false, false)
+	v1.GET("/homes/:homeId/rooms/:roomId", s.GetRoom, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "room", true, false, false, false)
 	})
 
 	// Rooms Members
-	v1.GET("/homes/:homeId/rooms/:roomId/members", GetRoomMembers, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "room", true, false, false, false)
+	v1.GET("/homes/:homeId/rooms/:roomId/members", s.GetRoomMembers, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "room", true, false, false, false)
 	})
-	v1.PUT("/homes/:homeId/rooms/:roomId/members/:userId", EditRoomMember, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "room", false, false, false, true)
+	v1.PUT("/homes/:homeId/rooms/:roomId/members/:userId", s.EditRoomMember, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "room", false, false, false, true)
 	})
 
 	// Devices
-	v1.POST("/homes/:homeId/rooms/:roomId/devices", AddDevice, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "room", false, false, true, false)
+	v1.POST("/homes/:homeId/rooms/:roomId/devices", s.AddDevice, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "room", false, false, true, false)
 	})
-	v1.PUT("/homes/:homeId/rooms/:roomId/devices/:deviceId", UpdateDevice, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "device", false, false, true, false)
+	v1.PUT("/homes/:homeId/rooms/:roomId/devices/:deviceId", s.UpdateDevice, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "device", false, false, true, false)
 	})
-	v1.DELETE("/homes/:homeId/rooms/:roomId/devices/:deviceId", DeleteDevice, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "device", false, false, false, true)
+	v1.DELETE("/homes/:homeId/rooms/:roomId/devices/:deviceId", s.DeleteDevice, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "device", false, false, false, true)
 	})
-	v1.GET("/homes/:homeId/rooms/:roomId/devices", GetDevices, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "room", true, false, false, false)
+	v1.GET("/homes/:homeId/rooms/:roomId/devices", s.GetDevices, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "room", true, false, false, false)
 	})
-	v1.GET("/homes/:homeId/rooms/:roomId/devices/:deviceId", GetDevice, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "device", true, false, false, false)
+	v1.GET("/homes/:homeId/rooms/:roomId/devices/:deviceId", s.GetDevice, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "device", true, false, false, false)
 	})
-	v1.GET("/homes/:homeId/rooms/:roomId/devices/:deviceId/logs", GetLogsDevice, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "device", true, false, false, false)
+	v1.GET("/homes/:homeId/rooms/:roomId/devices/:deviceId/logs", s.GetLogsDevice, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "device", true, false, false, false)
 	})
-	v1.GET("/homes/:homeId/rooms/:roomId/devices/:deviceId/datas", GetDatasDevice, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "device", true, false, false, false)
+	v1.GET("/homes/:homeId/rooms/:roomId/devices/:deviceId/datas", s.GetDatasDevice, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "device", true, false, false, false)
 	})
-	v1.POST("/homes/:homeId/rooms/:roomId/devices/:deviceId/actions", CallAction, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "device", false, true, false, false)
+	v1.GET("/homes/:homeId/rooms/:roomId/devices/:deviceId/datas/export", s.ExportDatasDevice, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "device", true, false, false, false)
+	})
+	v1.POST("/homes/:homeId/rooms/:roomId/devices/:deviceId/actions", s.CallAction, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "device", false, true, false, false)
 	})
 
 	// Devices Members
-	v1.GET("/homes/:homeId/rooms/:roomId/devices/:deviceId/members", GetDeviceMembers, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "device", true, false, false, false)
+	v1.GET("/homes/:homeId/rooms/:roomId/devices/:deviceId/members", s.GetDeviceMembers, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "device", true, false, false, false)
 	})
-	v1.PUT("/homes/:homeId/rooms/:roomId/devices/:deviceId/members/:userId", EditDeviceMember, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "device", false, false, false, true)
+	v1.PUT("/homes/:homeId/rooms/:roomId/devices/:deviceId/members/:userId", s.EditDeviceMember, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "device", false, false, false, true)
 	})
 
 	// Automations
-	v1.POST("/homes/:homeId/automations", AddAutomation, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", false, true, false, false)
+	v1.POST("/homes/:homeId/automations", s.AddAutomation, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, true, false, false)
+	})
+	v1.PUT("/homes/:homeId/automations/:automationId", s.UpdateAutomation, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, true, false, false)
+	})
+	v1.DELETE("/homes/:homeId/automations/:automationId", s.DeleteAutomation, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
+	})
+	v1.GET("/homes/:homeId/automations", s.GetAutomations, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", true, false, false, false)
+	})
+	v1.GET("/homes/:homeId/automations/:automationId", s.GetAutomation, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", true, false, false, false)
 	})
-	v1.PUT("/homes/:homeId/automations/:automationId", UpdateAutomation, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", false, true, false, false)
+	v1.GET("/homes/:homeId/automations/:automationId/logs", s.GetLogsAutomation, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", true, false, false, false)
 	})
-	v1.DELETE("/homes/:homeId/automations/:automationId", DeleteAutomation, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", false, false, true, false)
+	v1.POST("/homes/:homeId/automations/:automationId/hooks", s.AddAutomationHook, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
 	})
-	v1.GET("/homes/:homeId/automations", GetAutomations, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", true, false, false, false)
+	v1.GET("/homes/:homeId/automations/:automationId/hooks", s.GetAutomationHooks, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
 	})
-	v1.GET("/homes/:homeId/automations/:automationId", GetAutomation, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", true, false, false, false)
+	v1.DELETE("/homes/:homeId/automations/:automationId/hooks/:hookId", s.DeleteAutomationHook, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
 	})
-	v1.GET("/homes/:homeId/automations/:automationId/logs", GetLogsAutomation, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", true, false, false, false)
+
+	// Exports
+	v1.POST("/homes/:homeId/exports", s.AddHomeExport, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", true, false, false, false)
+	})
+	v1.GET("/homes/:homeId/exports/:exportId", s.GetHomeExport, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", true, false, false, false)
+	})
+	v1.GET("/homes/:homeId/exports/:exportId/download", s.DownloadHomeExport, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", true, false, false, false)
 	})
 
 	// Plugins
-	v1.GET("/homes/:homeId/plugins", GetPlugins, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", false, false, true, false)
+	v1.GET("/homes/:homeId/plugins", s.GetPlugins, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
 	})
 
 	// Gateways
-	v1.POST("/homes/:homeId/gateways/link", LinkGateway, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", false, false, true, false)
+	v1.POST("/homes/:homeId/gateways/link", s.LinkGateway, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
 	})
-	v1.PUT("/homes/:homeId/gateways/:gatewayId", UpdateGateway, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", false, false, true, false)
+	v1.PUT("/homes/:homeId/gateways/:gatewayId", s.UpdateGateway, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
 	})
-	v1.DELETE("/homes/:homeId/gateways/:gatewayId", DeleteGateway, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", false, false, true, false)
+	v1.DELETE("/homes/:homeId/gateways/:gatewayId", s.DeleteGateway, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
 	})
-	v1.GET("/homes/:homeId/gateways/discover/:plugin", GetDiscoveredDevices, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", false, false, true, false)
+	v1.GET("/homes/:homeId/gateways/discover/:plugin", s.GetDiscoveredDevices, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", false, false, true, false)
 	})
-	v1.GET("/homes/:homeId/gateways/:gatewayId", GetGateway, func(next echo.HandlerFunc) echo.HandlerFunc {
-		return hasPermission(next, "home", true, false, false, false)
+	v1.GET("/homes/:homeId/gateways/:gatewayId", s.GetGateway, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return s.hasPermission(next, "home", true, false, false, false)
 	})
 
+	// API keys, only managed with a session
+	v1.GET("/apikeys", s.GetAPIKeys)
+	v1.POST("/apikeys", s.AddAPIKey)
+	v1.DELETE("/apikeys/:tokenId", s.DeleteAPIKey)
+
+	// Notifications history of user
+	v1.GET("/notifications", s.GetNotifications)
+	v1.PUT("/notifications/read", s.ReadNotifications)
+	v1.PUT("/notifications/:notificationId/read", s.ReadNotification)
+	v1.DELETE("/notifications/:notificationId", s.DeleteNotification)
+
 	// Users
-	v1.GET("/users/:userId", GetUser)
-	v1.PUT("/users/:userId", UpdateUserProfil)
-	v1.PUT("/users/:userId/email", UpdateUserEmail)
-	v1.PUT("/users/:userId/password", UpdateUserPassword)
+	v1.GET("/users/:userId", s.GetUser)
+	v1.PUT("/users/:userId", s.UpdateUserProfil)
+	v1.PUT("/users/:userId/email", s.UpdateUserEmail)
+	v1.PUT("/users/:userId/password", s.UpdateUserPassword)
 
-	e.Logger.Fatal(e.Start(":" + port))
+	return e
 }
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ItsJimi/casa/config"
+)
+
+// newRoutesFixture return a store with a home owned by owner, where member can only read and stranger has nothing
+func newRoutesFixture() *fakeStore {
+	expireAt := time.Now().Add(time.Hour).Format(time.RFC3339)
+	all := func(userID, typ, typeID string) Permission {
+		return Permission{UserID: userID, Type: typ, TypeID: typeID, Read: true, Write: true, Manage: true, Admin: true}
+	}
+	read := func(userID, typ, typeID string) Permission {
+		return Permission{UserID: userID, Type: typ, TypeID: typeID, Read: true}
+	}
+
+	return &fakeStore{
+		users: []User{
+			{ID: "owner", Firstname: "Owner"},
+			{ID: "member", Firstname: "Member"},
+			{ID: "stranger", Firstname: "Stranger"},
+		},
+		// signin tokens have every right, permissions limit them
+		tokens: []Token{
+			{ID: "owner-token", UserID: "owner", Type: "signin", Read: true, Write: true, Manage: true, Admin: true, ExpireAt: expireAt},
+			{ID: "member-token", UserID: "member", Type: "signin", Read: true, Write: true, Manage: true, Admin: true, ExpireAt: expireAt},
+			{ID: "stranger-token", UserID: "stranger", Type: "signin", Read: true, Write: true, Manage: true, Admin: true, ExpireAt: expireAt},
+			{ID: "expired-token", UserID: "owner", Type: "signin", Read: true, Write: true, Manage: true, Admin: true, ExpireAt: time.Now().Add(-time.Hour).Format(time.RFC3339)},
+			{ID: "kitchen-key", UserID: "owner", Type: apiKeyType, Read: true, ScopeType: "room", ScopeID: "kitchen", SecretHash: hashAPIKeySecret("secret"), ExpireAt: expireAt},
+		},
+		homes: []Home{{ID: "home", Name: "Home", CreatorID: "owner"}},
+		rooms: []Room{
+			{ID: "kitchen", Name: "Kitchen", HomeID: "home", CreatorID: "owner"},
+			{ID: "bedroom", Name: "Bedroom", HomeID: "home", CreatorID: "owner"},
+		},
+		devices: []Device{{ID: "lamp", Name: "Lamp", RoomID: "bedroom", CreatorID: "owner"}},
+		permissions: []Permission{
+			all("owner", "home", "home"), all("owner", "room", "kitchen"), all("owner", "room", "bedroom"), all("owner", "device", "lamp"),
+			read("member", "home", "home"), read("member", "room", "bedroom"), read("member", "device", "lamp"),
+		},
+	}
+}
+
+func TestRoutesPermissions(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		body   string
+		status int
+		code   string
+	}{
+		{"unknown token", "GET", "/v1/homes/home", "unknown", "", http.StatusUnauthorized, ""},
+		{"expired token", "GET", "/v1/homes/home", "expired-token", "", http.StatusUnauthorized, ""},
+		{"api key outside homes", "GET", "/v1/homes", "kitchen-key.secret", "", http.StatusUnauthorized, ""},
+		{"api key wrong secret", "GET", "/v1/homes/home/rooms/kitchen", "kitchen-key.wrong", "", http.StatusUnauthorized, ""},
+
+		{"owner get home", "GET", "/v1/homes/home", "owner-token", "", http.StatusOK, ""},
+		{"owner update home", "PUT", "/v1/homes/home", "owner-token", `{"name":"House"}`, http.StatusOK, ""},
+		{"owner get room", "GET", "/v1/homes/home/rooms/bedroom", "owner-token", "", http.StatusOK, ""},
+		{"owner get device", "GET", "/v1/homes/home/rooms/bedroom/devices/lamp", "owner-token", "", http.StatusOK, ""},
+		{"member get home", "GET", "/v1/homes/home", "member-token", "", http.StatusOK, ""},
+		{"member get device", "GET", "/v1/homes/home/rooms/bedroom/devices/lamp", "member-token", "", http.StatusOK, ""},
+		{"api key get its room", "GET", "/v1/homes/home/rooms/kitchen", "kitchen-key.secret", "", http.StatusOK, ""},
+
+		{"member update home", "PUT", "/v1/homes/home", "member-token", `{"name":"House"}`, http.StatusForbidden, "CSPHP005"},
+		{"member delete home", "DELETE", "/v1/homes/home", "member-token", "", http.StatusForbidden, "CSPHP006"},
+		{"member delete room", "DELETE", "/v1/homes/home/rooms/bedroom", "member-token", "", http.StatusForbidden, "CSPHP006"},
+		{"member update device", "PUT", "/v1/homes/home/rooms/bedroom/devices/lamp", "member-token", `{"name":"Light"}`, http.StatusForbidden, "CSPHP005"},
+		{"api key out of scope", "GET", "/v1/homes/home/rooms/bedroom", "kitchen-key.secret", "", http.StatusForbidden, "CSPHP007"},
+		{"api key device out of scope", "GET", "/v1/homes/home/rooms/bedroom/devices/lamp", "kitchen-key.secret", "", http.StatusForbidden, "CSPHP007"},
+		{"api key without write", "PUT", "/v1/homes/home/rooms/kitchen", "kitchen-key.secret", `{"name":"Cooking"}`, http.StatusForbidden, "CSPHP007"},
+
+		{"stranger get home", "GET", "/v1/homes/home", "stranger-token", "", http.StatusNotFound, "CSPHP002"},
+		{"stranger get room", "GET", "/v1/homes/home/rooms/bedroom", "stranger-token", "", http.StatusNotFound, "CSPHP002"},
+		{"stranger get device", "GET", "/v1/homes/home/rooms/bedroom/devices/lamp", "stranger-token", "", http.StatusNotFound, "CSPHP002"},
+		{"member get room without permission", "GET", "/v1/homes/home/rooms/kitchen", "member-token", "", http.StatusNotFound, "CSPHP002"},
+		{"unknown home", "GET", "/v1/homes/unknown", "owner-token", "", http.StatusNotFound, "CSPHP002"},
+		{"unknown room", "GET", "/v1/homes/home/rooms/unknown", "owner-token", "", http.StatusNotFound, "CSPHP002"},
+		{"unknown device", "GET", "/v1/homes/home/rooms/bedroom/devices/unknown", "owner-token", "", http.StatusNotFound, "CSPHP002"},
+		{"device of another room", "GET", "/v1/homes/home/rooms/kitchen/devices/lamp", "owner-token", "", http.StatusNotFound, "CSDGD001"},
+
+		{"member list automations", "GET", "/v1/homes/home/automations", "member-token", "", http.StatusOK, ""},
+		{"member add automation", "POST", "/v1/homes/home/automations", "member-token", "{}", http.StatusForbidden, "CSPHP004"},
+		{"member delete automation", "DELETE", "/v1/homes/home/automations/auto", "member-token", "", http.StatusForbidden, "CSPHP005"},
+		{"member list automation hooks", "GET", "/v1/homes/home/automations/auto/hooks", "member-token", "", http.StatusForbidden, "CSPHP005"},
+		{"owner list hooks of unknown automation", "GET", "/v1/homes/home/automations/unknown/hooks", "owner-token", "", http.StatusNotFound, "CSAHGAH001"},
+		{"stranger list automations", "GET", "/v1/homes/home/automations", "stranger-token", "", http.StatusNotFound, "CSPHP002"},
+		{"api key list automations out of scope", "GET", "/v1/homes/home/automations", "kitchen-key.secret", "", http.StatusForbidden, "CSPHP007"},
+
+		{"owner list webhooks", "GET", "/v1/homes/home/webhooks", "owner-token", "", http.StatusOK, ""},
+		{"owner get unknown webhook", "GET", "/v1/homes/home/webhooks/unknown", "owner-token", "", http.StatusNotFound, "CSWGW001"},
+		{"member list webhooks", "GET", "/v1/homes/home/webhooks", "member-token", "", http.StatusForbidden, "CSPHP005"},
+		{"member delete webhook", "DELETE", "/v1/homes/home/webhooks/hook", "member-token", "", http.StatusForbidden, "CSPHP005"},
+		{"stranger add webhook", "POST", "/v1/homes/home/webhooks", "stranger-token", "{}", http.StatusNotFound, "CSPHP002"},
+
+		{"owner get its export", "GET", "/v1/homes/home/exports/owner-export", "owner-token", "", http.StatusOK, ""},
+		{"member get export of owner", "GET", "/v1/homes/home/exports/owner-export", "member-token", "", http.StatusNotFound, "CSEGHE001"},
+		{"member download export of owner", "GET", "/v1/homes/home/exports/owner-export/download", "member-token", "", http.StatusNotFound, "CSEDHE001"},
+		{"stranger add export", "POST", "/v1/homes/home/exports", "stranger-token", "{}", http.StatusNotFound, "CSPHP002"},
+		{"stranger get export", "GET", "/v1/homes/home/exports/owner-export", "stranger-token", "", http.StatusNotFound, "CSPHP002"},
+
+		{"member list members", "GET", "/v1/homes/home/members", "member-token", "", http.StatusOK, ""},
+		{"member add member", "POST", "/v1/homes/home/members", "member-token", `{"email":"stranger@casa.test"}`, http.StatusForbidden, "CSPHP005"},
+		{"member remove member", "DELETE", "/v1/homes/home/members/owner", "member-token", "", http.StatusForbidden, "CSPHP005"},
+		{"member edit member", "PUT", "/v1/homes/home/members/owner", "member-token", `{"read":true}`, http.StatusForbidden, "CSPHP006"},
+		{"stranger list members", "GET", "/v1/homes/home/members", "stranger-token", "", http.StatusNotFound, "CSPHP002"},
+
+		{"owner list api keys", "GET", "/v1/apikeys", "owner-token", "", http.StatusOK, ""},
+		{"member add read api key", "POST", "/v1/apikeys", "member-token", `{"name":"grafana","type":"home","typeId":"home","read":true}`, http.StatusCreated, ""},
+		{"member add api key with more rights", "POST", "/v1/apikeys", "member-token", `{"name":"grafana","type":"home","typeId":"home","write":true}`, http.StatusBadRequest, "CSAKAAK004"},
+		{"member add api key without permission", "POST", "/v1/apikeys", "member-token", `{"name":"grafana","type":"room","typeId":"kitchen","read":true}`, http.StatusBadRequest, "CSAKAAK004"},
+		{"stranger delete api key of owner", "DELETE", "/v1/apikeys/kitchen-key", "stranger-token", "", http.StatusNotFound, "CSAKDAK001"},
+		{"api key list api keys", "GET", "/v1/apikeys", "kitchen-key.secret", "", http.StatusUnauthorized, ""},
+	}
+
+	exportJobsMutex.Lock()
+	exportJobs = map[string]*ExportJob{"owner-export": {ID: "owner-export", HomeID: "home", Status: "running", userID: "owner"}}
+	exportJobsMutex.Unlock()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewServer(newRoutesFixture(), nil)
+			e := s.Router(config.Configuration{})
+
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			req.Header.Set("Authorization", "Bearer "+test.key)
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != test.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, test.status, rec.Body.String())
+			}
+			if test.code == "" {
+				return
+			}
+			var res ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Code != test.code {
+				t.Errorf("code = %s, want %s", res.Code, test.code)
+			}
+		})
+	}
+}
+
+func TestRoutesPermissionError(t *testing.T) {
+	store := newRoutesFixture()
+	store.permissionErr = errors.New("database is unreachable")
+	e := NewServer(store, nil).Router(config.Configuration{})
+
+	req := httptest.NewRequest("GET", "/v1/homes/home", nil)
+	req.Header.Set("Authorization", "Bearer owner-token")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	var res ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusInternalServerError || res.Code != "CSPHP008" {
+		t.Errorf("answered %d %s, want %d CSPHP008", rec.Code, res.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestRoutesMetrics(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		key    string
+		status int
+	}{
+		{"not configured", "", "", http.StatusNotFound},
+		{"no token", "scrape", "", http.StatusBadRequest},
+		{"wrong token", "scrape", "wrong", http.StatusUnauthorized},
+		{"token", "scrape", "scrape", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := config.Configuration{Metrics: config.Metrics{Token: test.token}}
+			e := NewServer(newRoutesFixture(), nil).Router(conf)
+
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			if test.key != "" {
+				req.Header.Set("Authorization", "Bearer "+test.key)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != test.status {
+				t.Errorf("status = %d, want %d", rec.Code, test.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,513 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ItsJimi/casa/logger"
+	"github.com/ItsJimi/casa/utils"
+	"github.com/labstack/echo"
+)
+
+// webhookEventTypes define event types a webhook can subscribe to
+var webhookEventTypes = map[string]bool{
+	"data":       true,
+	"action":     true,
+	"automation": true,
+	"gateway":    true,
+	"member":     true,
+}
+
+// webhookBackoff define delay before each retry of a failed delivery
+var webhookBackoff = []time.Duration{
+	10 * time.Second,
+	30 * time.Second,
+	2 * time.Minute,
+	10 * time.Minute,
+	30 * time.Minute,
+}
+
+const (
+	// webhookDeliveriesLimit define how many deliveries are returned by delivery log
+	webhookDeliveriesLimit = 100
+	// webhookDeliveriesBatch define how many due deliveries are attempted each tick
+	webhookDeliveriesBatch = 100
+	// webhookDeliveriesRetention define how long done deliveries are kept in delivery log
+	webhookDeliveriesRetention = 30 * 24 * time.Hour
+)
+
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+type webhookReq struct {
+	URL    string
+	Secret string
+	Events []string
+	Active *bool
+}
+
+type webhookRes struct {
+	ID        string   `json:"id"`
+	HomeID    string   `json:"homeId"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Active    bool     `json:"active"`
+	CreatedAt string   `json:"createdAt"`
+	UpdatedAt string   `json:"updatedAt"`
+	CreatorID string   `json:"creatorId"`
+	Secret    string   `json:"secret,omitempty"`
+}
+
+func newWebhookRes(webhook Webhook) webhookRes {
+	events := []string{}
+	if webhook.Events != "" {
+		events = strings.Split(webhook.Events, ",")
+	}
+	return webhookRes{
+		ID:        webhook.ID,
+		HomeID:    webhook.HomeID,
+		URL:       webhook.URL,
+		Events:    events,
+		Active:    webhook.Active,
+		CreatedAt: webhook.CreatedAt,
+		UpdatedAt: webhook.UpdatedAt,
+		CreatorID: webhook.CreatorID,
+	}
+}
+
+// validWebhookURL check that webhook target is an absolute http(s) url
+func validWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("URL must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// joinWebhookEvents check event types and join them for database
+func joinWebhookEvents(events []string) (string, error) {
+	for _, event := range events {
+		if !webhookEventTypes[event] {
+			return "", fmt.Errorf("Unknown event type %s", event)
+		}
+	}
+	return strings.Join(events, ","), nil
+}
+
+// newSecret return 32 random bytes encoded in hex
+func newSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// AddWebhook route create a webhook on home
+func (s *Server) AddWebhook(c echo.Context) error {
+	req := new(webhookReq)
+	if err := c.Bind(req); err != nil {
+		logger.WithFields(logger.Fields{"code": "CSWAW001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSWAW001",
+			Message: "Wrong parameters",
+		})
+	}
+
+	if err := validWebhookURL(req.URL); err != nil {
+		logger.WithFields(logger.Fields{"code": "CSWAW002"}).Warnf("%s", err.Error())
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSWAW002",
+			Message: "URL must be an absolute http or https URL",
+		})
+	}
+
+	events, err := joinWebhookEvents(req.Events)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSWAW003"}).Warnf("%s", err.Error())
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSWAW003",
+			Message: err.Error(),
+		})
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		secret, err = newSecret()
+		if err != nil {
+			logger.WithFields(logger.Fields{"code": "CSWAW004"}).Errorf("%s", err.Error())
+			return c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Code:    "CSWAW004",
+				Message: "Webhook can't be created",
+			})
+		}
+	}
+
+	active := true
+	if req.Active != nil {
+		active = *req.Active
+	}
+
+	user := c.Get("user").(User)
+	webhook := Webhook{
+		ID:        utils.NewULID(),
+		HomeID:    c.Param("homeId"),
+		URL:       req.URL,
+		Secret:    secret,
+		Events:    events,
+		Active:    active,
+		CreatorID: user.ID,
+	}
+	err = s.db.Webhooks().Create(webhook)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSWAW005"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSWAW005",
+			Message: "Webhook can't be created",
+		})
+	}
+
+	webhook, err = s.db.Webhooks().ByID(webhook.ID)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSWAW006"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSWAW006",
+			Message: "Webhook can't be retrieved",
+		})
+	}
+
+	// Secret is only returned once, on creation
+	res := newWebhookRes(webhook)
+	res.Secret = webhook.Secret
+	return c.JSON(http.StatusCreated, DataReponse{
+		Data: res,
+	})
+}
+
+// UpdateWebhook route update webhook of home
+func (s *Server) UpdateWebhook(c echo.Context) error {
+	req := new(webhookReq)
+	if err := c.Bind(req); err != nil {
+		logger.WithFields(logger.Fields{"code": "CSWUW001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Code:    "CSWUW001",
+			Message: "Wrong parameters",
+		})
+	}
+
+	webhook, err := s.db.Webhooks().GetForHome(c.Param("homeId"), c.Param("webhookId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSWUW002"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    "CSWUW002",
+			Message: "Webhook not found",
+		})
+	}
+
+	if req.URL != "" {
+		if err := validWebhookURL(req.URL); err != nil {
+			logger.WithFields(logger.Fields{"code": "CSWUW003"}).Warnf("%s", err.Error())
+			return c.JSON(http.StatusBadRequest, ErrorResponse{
+				Code:    "CSWUW003",
+				Message: "URL must be an absolute http or https URL",
+			})
+		}
+		webhook.URL = req.URL
+	}
+
+	if req.Events != nil {
+		events, err := joinWebhookEvents(req.Events)
+		if err != nil {
+			logger.WithFields(logger.Fields{"code": "CSWUW004"}).Warnf("%s", err.Error())
+			return c.JSON(http.StatusBadRequest, ErrorResponse{
+				Code:    "CSWUW004",
+				Message: err.Error(),
+			})
+		}
+		webhook.Events = events
+	}
+
+	if req.Secret != "" {
+		webhook.Secret = req.Secret
+	}
+	if req.Active != nil {
+		webhook.Active = *req.Active
+	}
+
+	err = s.db.Webhooks().Update(webhook)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSWUW005"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSWUW005",
+			Message: "Webhook can't be updated",
+		})
+	}
+
+	return c.JSON(http.StatusOK, MessageResponse{
+		Message: "Webhook updated",
+	})
+}
+
+// DeleteWebhook route delete webhook of home
+func (s *Server) DeleteWebhook(c echo.Context) error {
+	_, err := s.db.Webhooks().GetForHome(c.Param("homeId"), c.Param("webhookId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSWDW001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    "CSWDW001",
+			Message: "Webhook not found",
+		})
+	}
+
+	err = s.db.Webhooks().Delete(c.Param("homeId"), c.Param("webhookId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSWDW002"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSWDW002",
+			Message: "Webhook can't be deleted",
+		})
+	}
+
+	return c.JSON(http.StatusOK, MessageResponse{
+		Message: "Webhook deleted",
+	})
+}
+
+// GetWebhooks route get list of home webhooks
+func (s *Server) GetWebhooks(c echo.Context) error {
+	webhooks, err := s.db.Webhooks().ListForHome(c.Param("homeId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSWGWS001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSWGWS001",
+			Message: "Webhooks can't be retrieved",
+		})
+	}
+
+	res := []webhookRes{}
+	for _, webhook := range webhooks {
+		res = append(res, newWebhookRes(webhook))
+	}
+
+	return c.JSON(http.StatusOK, DataReponse{
+		Data: res,
+	})
+}
+
+// GetWebhook route get specific webhook with id
+func (s *Server) GetWebhook(c echo.Context) error {
+	webhook, err := s.db.Webhooks().GetForHome(c.Param("homeId"), c.Param("webhookId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSWGW001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    "CSWGW001",
+			Message: "Webhook not found",
+		})
+	}
+
+	return c.JSON(http.StatusOK, DataReponse{
+		Data: newWebhookRes(webhook),
+	})
+}
+
+// GetWebhookDeliveries route get last deliveries of webhook
+func (s *Server) GetWebhookDeliveries(c echo.Context) error {
+	webhook, err := s.db.Webhooks().GetForHome(c.Param("homeId"), c.Param("webhookId"))
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSWGWD001"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Code:    "CSWGWD001",
+			Message: "Webhook not found",
+		})
+	}
+
+	deliveries, err := s.db.Webhooks().Deliveries(webhook.ID, webhookDeliveriesLimit)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSWGWD002"}).Errorf("%s", err.Error())
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Code:    "CSWGWD002",
+			Message: "Deliveries can't be retrieved",
+		})
+	}
+
+	return c.JSON(http.StatusOK, DataReponse{
+		Data: deliveries,
+	})
+}
+
+// subscribedTo tell if webhook wants event type
+func (webhook Webhook) subscribedTo(typ string) bool {
+	if webhook.Events == "" {
+		return true
+	}
+	for _, event := range strings.Split(webhook.Events, ",") {
+		if event == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// queueWebhooks save a pending delivery of event for each webhook of its home wanting it
+func (s *Server) queueWebhooks(event Event) {
+	if event.HomeID == "" {
+		return
+	}
+	webhooks, err := s.db.Webhooks().Active(event.HomeID)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSWQW001"}).Errorf("%s", err.Error())
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSWQW002"}).Errorf("%s", err.Error())
+		return
+	}
+
+	// Webhooks only receive events their creator can read
+	permissions := newPermissionCache()
+	for _, webhook := range webhooks {
+		if !webhook.subscribedTo(event.Type) || !s.allowed(webhook.CreatorID, permissions, event) {
+			continue
+		}
+		err := s.db.Webhooks().CreateDelivery(WebhookDelivery{
+			ID:            utils.NewULID(),
+			WebhookID:     webhook.ID,
+			EventID:       event.ID,
+			EventType:     event.Type,
+			Payload:       string(payload),
+			Status:        "pending",
+			NextAttemptAt: time.Now().UTC(),
+		})
+		if err != nil {
+			logger.WithFields(logger.Fields{"code": "CSWQW003", "webhookId": webhook.ID}).Errorf("%s", err.Error())
+		}
+	}
+}
+
+// DeliverWebhooks send pending deliveries which are due, every interval
+func (s *Server) DeliverWebhooks(interval time.Duration) {
+	var mutex sync.Mutex
+	inFlight := map[string]bool{}
+
+	for range time.Tick(interval) {
+		deliveries, err := s.db.Webhooks().PendingDeliveries(time.Now(), webhookDeliveriesBatch)
+		if err != nil {
+			logger.WithFields(logger.Fields{"code": "CSWDWS001"}).Errorf("%s", err.Error())
+			continue
+		}
+
+		for _, delivery := range deliveries {
+			mutex.Lock()
+			if inFlight[delivery.ID] {
+				mutex.Unlock()
+				continue
+			}
+			inFlight[delivery.ID] = true
+			mutex.Unlock()
+
+			go func(delivery WebhookDelivery) {
+				s.attemptDelivery(delivery)
+				mutex.Lock()
+				delete(inFlight, delivery.ID)
+				mutex.Unlock()
+			}(delivery)
+		}
+	}
+}
+
+// PurgeWebhookDeliveries remove deliveries done for longer than webhookDeliveriesRetention, every interval
+func (s *Server) PurgeWebhookDeliveries(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		err := s.db.Webhooks().DeleteDeliveriesBefore(now.Add(-webhookDeliveriesRetention))
+		if err != nil {
+			logger.WithFields(logger.Fields{"code": "CSWPWD001"}).Errorf("%s", err.Error())
+		}
+	}
+}
+
+// attemptDelivery post delivery payload and save result, scheduling a retry on failure
+func (s *Server) attemptDelivery(delivery WebhookDelivery) {
+	webhook, err := s.db.Webhooks().ByID(delivery.WebhookID)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSWAD001", "deliveryId": delivery.ID}).Errorf("%s", err.Error())
+		return
+	}
+
+	delivery.Attempts++
+	delivery.ResponseCode, err = postWebhook(webhook, delivery)
+	if err == nil {
+		delivery.Status = "success"
+		delivery.Error = ""
+	} else {
+		logger.WithFields(logger.Fields{"code": "CSWAD002", "webhookId": webhook.ID, "deliveryId": delivery.ID}).Warnf("%s", err.Error())
+		delivery.Error = err.Error()
+		if delivery.Attempts > len(webhookBackoff) {
+			delivery.Status = "failed"
+		} else {
+			delivery.NextAttemptAt = time.Now().UTC().Add(webhookBackoff[delivery.Attempts-1])
+		}
+	}
+
+	err = s.db.Webhooks().UpdateDelivery(delivery)
+	if err != nil {
+		logger.WithFields(logger.Fields{"code": "CSWAD003", "deliveryId": delivery.ID}).Errorf("%s", err.Error())
+	}
+}
+
+// webhookSignature return hex HMAC-SHA256 of timestamp and payload joined by a dot, so a captured delivery can't be replayed later
+func webhookSignature(secret string, timestamp string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// postWebhook send payload signed with webhook secret and return response status code
+func postWebhook(webhook Webhook, delivery WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("User-Agent", "casa-webhook")
+	req.Header.Set("X-Casa-Event", delivery.EventType)
+	req.Header.Set("X-Casa-Delivery", delivery.ID)
+	req.Header.Set("X-Casa-Timestamp", timestamp)
+	req.Header.Set("X-Casa-Signature", "sha256="+webhookSignature(webhook.Secret, timestamp, delivery.Payload))
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64*1024))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("Webhook answered with status %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"crypto/hmac"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPostWebhookSignature(t *testing.T) {
+	type received struct {
+		header http.Header
+		body   string
+	}
+	requests := make(chan received, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- received{header: r.Header, body: string(body)}
+	}))
+	defer server.Close()
+
+	delivery := WebhookDelivery{ID: "delivery", EventType: "data", Payload: `{"type":"data"}`}
+	code, err := postWebhook(Webhook{URL: server.URL, Secret: "secret"}, delivery)
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("postWebhook returned %d, %v", code, err)
+	}
+
+	req := <-requests
+	timestamp := req.header.Get("X-Casa-Timestamp")
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(sent, 0)) > time.Minute {
+		t.Errorf("X-Casa-Timestamp = %q, want current unix time", timestamp)
+	}
+	want := "sha256=" + webhookSignature("secret", timestamp, req.body)
+	if signature := req.header.Get("X-Casa-Signature"); !hmac.Equal([]byte(signature), []byte(want)) {
+		t.Errorf("X-Casa-Signature = %s, want %s", signature, want)
+	}
+	// a captured body replayed with another timestamp doesn't match signature
+	if req.header.Get("X-Casa-Signature") == "sha256="+webhookSignature("secret", "0", req.body) {
+		t.Error("signature doesn't depend on timestamp")
+	}
+	if req.body != delivery.Payload || req.header.Get("X-Casa-Event") != "data" || req.header.Get("X-Casa-Delivery") != "delivery" {
+		t.Errorf("received %s with headers %v", req.body, req.header)
+	}
+}
@@ -0,0 +1,114 @@
+package simulator
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/ItsJimi/casa/server"
+	"github.com/getcasa/sdk"
+)
+
+// Scenario define what the simulated gateway expose and send
+type Scenario struct {
+	// Configs are served on /v1/configs
+	Configs []sdk.Configuration `json:"configs"`
+	// Discovered are served on /v1/discover/:plugin, by plugin name
+	Discovered map[string][]sdk.DiscoveredDevice `json:"discovered"`
+	// Steps are played in order once connected
+	Steps []Step `json:"steps"`
+	// Loop replay steps until gateway is closed
+	Loop bool `json:"loop"`
+}
+
+// Step define datas sent after waiting a delay
+type Step struct {
+	Wait  string         `json:"wait"` // 500ms, 2s...
+	Datas []server.Datas `json:"datas"`
+}
+
+// Delay return parsed wait of step
+func (step Step) Delay() (time.Duration, error) {
+	if step.Wait == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(step.Wait)
+}
+
+// LoadScenario read a json scenario from file
+func LoadScenario(file string) (Scenario, error) {
+	var scenario Scenario
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return scenario, err
+	}
+
+	err = json.Unmarshal(content, &scenario)
+	if err != nil {
+		return scenario, err
+	}
+
+	for _, step := range scenario.Steps {
+		if _, err := step.Delay(); err != nil {
+			return scenario, err
+		}
+	}
+
+	return scenario, nil
+}
+
+// DefaultScenario return a lamp and a temperature sensor of a fake plugin
+func DefaultScenario() Scenario {
+	return Scenario{
+		Configs: []sdk.Configuration{
+			{
+				Name:        "simulator",
+				Version:     "0.1.0",
+				Author:      "casa",
+				Description: "Simulated devices",
+				Discover:    true,
+				Devices: []sdk.Device{
+					{
+						Name:           "lamp",
+						Description:    "Simulated lamp",
+						DefaultTrigger: "on",
+						DefaultAction:  "toggle",
+						Triggers: []sdk.Trigger{
+							{Name: "on", Description: "Lamp is on", Type: "bool"},
+						},
+						Actions: []string{"toggle", "brightness"},
+					},
+					{
+						Name:           "sensor",
+						Description:    "Simulated temperature sensor with a button",
+						DefaultTrigger: "temperature",
+						Triggers: []sdk.Trigger{
+							{Name: "temperature", Description: "Temperature in celsius", Type: "int"},
+							{Name: "click", Description: "Button pressed", Direct: true, Type: "string", Possibilities: []string{"single", "double"}},
+						},
+						Actions: []string{},
+					},
+				},
+				Actions: []sdk.Action{
+					{Name: "toggle", Description: "Toggle lamp"},
+					{Name: "brightness", Description: "Set lamp brightness", Fields: []sdk.Field{
+						{Name: "level", Type: "int", Min: 0, Max: 100},
+					}},
+				},
+			},
+		},
+		Discovered: map[string][]sdk.DiscoveredDevice{
+			"simulator": {
+				{Name: "Simulated lamp", PhysicalID: "sim-lamp-1", PhysicalName: "lamp", Plugin: "simulator"},
+				{Name: "Simulated sensor", PhysicalID: "sim-sensor-1", PhysicalName: "sensor", Plugin: "simulator"},
+			},
+		},
+		Steps: []Step{
+			{Wait: "5s", Datas: []server.Datas{{DeviceID: "sim-sensor-1", Field: "temperature", ValueNbr: 21}}},
+			{Wait: "5s", Datas: []server.Datas{{DeviceID: "sim-sensor-1", Field: "temperature", ValueNbr: 23}}},
+			{Wait: "5s", Datas: []server.Datas{{DeviceID: "sim-sensor-1", Field: "click", ValueStr: "single"}}},
+		},
+		Loop: true,
+	}
+}
@@ -0,0 +1,343 @@
+// Package simulator fake a casa gateway to develop and test without hardware
+package simulator
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ItsJimi/casa/logger"
+	"github.com/ItsJimi/casa/server"
+	"github.com/ItsJimi/casa/utils"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo"
+)
+
+// DefaultTopicPrefix is the prefix of gateway topics on casa MQTT broker when gateway.mqtt.topic_prefix isn't changed
+const DefaultTopicPrefix = "casa/gateway"
+
+// Gateway is a simulated gateway connected to a casa server
+type Gateway struct {
+	// ServerURL is the websocket route of casa server (ws://localhost:4353/v1/ws)
+	// or its MQTT broker (tcp://localhost:1883)
+	ServerURL string
+	// Address is where gateway http routes listen (:4354, :0 for a random port)
+	Address  string
+	Scenario Scenario
+	// TopicPrefix is the prefix of gateway topics on MQTT broker, like gateway.mqtt.topic_prefix of server
+	TopicPrefix string
+
+	mutex    sync.Mutex
+	conn     *websocket.Conn
+	client   mqtt.Client
+	http     *http.Server
+	addr     string
+	actions  []server.ActionMessage
+	received chan server.ActionMessage
+	done     chan struct{}
+}
+
+// New return a simulated gateway, call Start to connect it
+func New(serverURL string, address string, scenario Scenario) *Gateway {
+	return &Gateway{
+		ServerURL:   serverURL,
+		Address:     address,
+		Scenario:    scenario,
+		TopicPrefix: DefaultTopicPrefix,
+		received:    make(chan server.ActionMessage, 100),
+		done:        make(chan struct{}),
+	}
+}
+
+// Start serve gateway http routes, connect to server and announce gateway
+func (g *Gateway) Start() error {
+	listener, err := net.Listen("tcp", g.Address)
+	if err != nil {
+		return err
+	}
+	g.addr = announcedAddr(listener.Addr().(*net.TCPAddr))
+	g.http = &http.Server{Handler: g.router()}
+	go g.http.Serve(listener)
+
+	if g.isMQTT() {
+		return g.startMQTT()
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(g.ServerURL, nil)
+	if err != nil {
+		g.http.Close()
+		return err
+	}
+	g.mutex.Lock()
+	g.conn = conn
+	g.mutex.Unlock()
+
+	go g.reader(conn)
+
+	return g.write(server.WebsocketMessage{
+		Action: "newConnection",
+		Body:   []byte(g.addr),
+	})
+}
+
+func (g *Gateway) isMQTT() bool {
+	return strings.HasPrefix(g.ServerURL, "tcp://") || strings.HasPrefix(g.ServerURL, "ssl://")
+}
+
+// startMQTT connect to casa broker, announce gateway and its configs, then listen actions
+func (g *Gateway) startMQTT() error {
+	opts := mqtt.NewClientOptions().
+		AddBroker(g.ServerURL).
+		SetClientID("casa-simulator-"+utils.NewULID()).
+		SetWill(g.TopicPrefix+"/status", "offline", 1, true)
+	if u, err := url.Parse(g.ServerURL); err == nil && u.User != nil {
+		password, _ := u.User.Password()
+		opts.SetUsername(u.User.Username()).SetPassword(password)
+	}
+
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		g.http.Close()
+		return token.Error()
+	}
+	token := client.Subscribe(g.TopicPrefix+"/callAction", 1, func(_ mqtt.Client, message mqtt.Message) {
+		g.receive(message.Payload())
+	})
+	if token.Wait() && token.Error() != nil {
+		client.Disconnect(250)
+		g.http.Close()
+		return token.Error()
+	}
+	g.mutex.Lock()
+	g.client = client
+	g.mutex.Unlock()
+
+	configs, err := json.Marshal(g.Scenario.Configs)
+	if err != nil {
+		return err
+	}
+	for _, message := range []server.WebsocketMessage{
+		{Action: "status", Body: []byte("online")},
+		{Action: "configs", Body: configs},
+		{Action: "newConnection", Body: []byte(g.addr)},
+	} {
+		if err := g.write(message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Addr return http address announced to server
+func (g *Gateway) Addr() string {
+	return g.addr
+}
+
+// Play send scenario steps, until end of steps or Close when scenario loop
+func (g *Gateway) Play() error {
+	for {
+		for _, step := range g.Scenario.Steps {
+			delay, err := step.Delay()
+			if err != nil {
+				return err
+			}
+			select {
+			case <-g.done:
+				return nil
+			case <-time.After(delay):
+			}
+			if err := g.Send(step.Datas); err != nil {
+				return err
+			}
+		}
+		if !g.Scenario.Loop || len(g.Scenario.Steps) == 0 {
+			return nil
+		}
+	}
+}
+
+// Send send datas to server like a plugin would, DeviceID is the physical id of device
+func (g *Gateway) Send(datas []server.Datas) error {
+	toSend := make([]server.Datas, len(datas))
+	for i, data := range datas {
+		if data.ID == "" {
+			data.ID = utils.NewULID()
+		}
+		toSend[i] = data
+	}
+
+	body, err := json.Marshal(toSend)
+	if err != nil {
+		return err
+	}
+
+	return g.write(server.WebsocketMessage{
+		Action: "newData",
+		Body:   body,
+	})
+}
+
+// Actions return callAction received since start
+func (g *Gateway) Actions() []server.ActionMessage {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return append([]server.ActionMessage{}, g.actions...)
+}
+
+// WaitAction return next callAction received or an error after timeout
+func (g *Gateway) WaitAction(timeout time.Duration) (server.ActionMessage, error) {
+	select {
+	case action := <-g.received:
+		return action, nil
+	case <-time.After(timeout):
+		return server.ActionMessage{}, errors.New("No action received")
+	}
+}
+
+// Close disconnect gateway and stop its http routes
+func (g *Gateway) Close() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	select {
+	case <-g.done:
+		return nil
+	default:
+		close(g.done)
+	}
+
+	if g.client != nil {
+		g.client.Publish(g.TopicPrefix+"/status", 1, true, "offline").Wait()
+		g.client.Disconnect(250)
+	}
+	if g.conn != nil {
+		g.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		g.conn.Close()
+	}
+	if g.http != nil {
+		return g.http.Close()
+	}
+	return nil
+}
+
+func (g *Gateway) write(message server.WebsocketMessage) error {
+	marshMessage, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.client != nil {
+		token := g.client.Publish(g.TopicPrefix+"/"+message.Action, 1, message.Action == "status", message.Body)
+		token.Wait()
+		return token.Error()
+	}
+	if g.conn == nil {
+		return errors.New("Gateway isn't connected")
+	}
+	return server.WebsocketWriteMessage(g.conn, marshMessage)
+}
+
+func (g *Gateway) reader(conn *websocket.Conn) {
+	for {
+		var wm server.WebsocketMessage
+
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			select {
+			case <-g.done:
+			default:
+				logger.WithFields(logger.Fields{}).Errorf("Simulated gateway disconnected: %s", err.Error())
+			}
+			return
+		}
+		if err := json.Unmarshal(message, &wm); err != nil {
+			logger.WithFields(logger.Fields{}).Errorf("%s", err.Error())
+			continue
+		}
+
+		switch wm.Action {
+		case "callAction":
+			g.receive(wm.Body)
+		default:
+			continue
+		}
+	}
+}
+
+// receive keep action sent by server
+func (g *Gateway) receive(body []byte) {
+	var action server.ActionMessage
+	if err := json.Unmarshal(body, &action); err != nil {
+		logger.WithFields(logger.Fields{}).Errorf("%s", err.Error())
+		return
+	}
+	logger.WithFields(logger.Fields{}).Infof("Action %s called on %s (%s) with %q", action.Call, action.PhysicalID, action.Plugin, action.Params)
+
+	g.mutex.Lock()
+	g.actions = append(g.actions, action)
+	g.mutex.Unlock()
+	select {
+	case g.received <- action:
+	default:
+	}
+}
+
+func (g *Gateway) router() *echo.Echo {
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+
+	e.GET("/v1/configs", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, g.Scenario.Configs)
+	})
+
+	e.GET("/v1/discover/:plugin", func(c echo.Context) error {
+		discovered := g.Scenario.Discovered[c.Param("plugin")]
+		if discovered == nil {
+			return c.JSON(http.StatusOK, []struct{}{})
+		}
+		return c.JSON(http.StatusOK, discovered)
+	})
+
+	e.GET("/v1/actions", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, g.Actions())
+	})
+
+	e.POST("/v1/datas", func(c echo.Context) error {
+		var datas []server.Datas
+		if err := c.Bind(&datas); err != nil {
+			return c.JSON(http.StatusBadRequest, server.ErrorResponse{
+				Code:    "SIMD001",
+				Message: "Wrong parameters",
+			})
+		}
+		if err := g.Send(datas); err != nil {
+			return c.JSON(http.StatusInternalServerError, server.ErrorResponse{
+				Code:    "SIMD002",
+				Message: err.Error(),
+			})
+		}
+		return c.JSON(http.StatusOK, server.MessageResponse{
+			Message: "Datas sent",
+		})
+	})
+
+	return e
+}
+
+// announcedAddr return address reachable by server, localhost when listening on all interfaces
+func announcedAddr(addr *net.TCPAddr) string {
+	if addr.IP == nil || addr.IP.IsUnspecified() {
+		return net.JoinHostPort("localhost", strconv.Itoa(addr.Port))
+	}
+	return addr.String()
+}
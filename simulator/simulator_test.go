@@ -0,0 +1,207 @@
+package simulator
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ItsJimi/casa/logger"
+	"github.com/ItsJimi/casa/server"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/getcasa/sdk"
+	"github.com/gorilla/websocket"
+	broker "github.com/mochi-co/mqtt/server"
+	"github.com/mochi-co/mqtt/server/listeners"
+)
+
+func TestMain(m *testing.M) {
+	err := logger.NewLogger(logger.Configuration{
+		EnableConsole: true,
+		ConsoleLevel:  logger.Fatal,
+	})
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeServer is a casa websocket route keeping messages of the gateway connected to it
+type fakeServer struct {
+	messages chan server.WebsocketMessage
+	conns    chan *websocket.Conn
+}
+
+func startFakeServer(t *testing.T) (*fakeServer, string) {
+	t.Helper()
+	fs := &fakeServer{
+		messages: make(chan server.WebsocketMessage, 10),
+		conns:    make(chan *websocket.Conn, 1),
+	}
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		fs.conns <- conn
+		for {
+			var wm server.WebsocketMessage
+			if err := conn.ReadJSON(&wm); err != nil {
+				return
+			}
+			fs.messages <- wm
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return fs, "ws" + strings.TrimPrefix(ts.URL, "http") + "/v1/ws"
+}
+
+// waitMessage return next message of gateway
+func waitMessage(t *testing.T, messages <-chan server.WebsocketMessage) server.WebsocketMessage {
+	t.Helper()
+	select {
+	case message := <-messages:
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message from gateway")
+	}
+	return server.WebsocketMessage{}
+}
+
+// checkNewData check message is newData of datas, with ids set by gateway
+func checkNewData(t *testing.T, message server.WebsocketMessage, datas []server.Datas) {
+	t.Helper()
+	if message.Action != "newData" {
+		t.Fatalf("action = %s, want newData", message.Action)
+	}
+	var sent []server.Datas
+	if err := json.Unmarshal(message.Body, &sent); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != len(datas) {
+		t.Fatalf("sent %d datas, want %d", len(sent), len(datas))
+	}
+	for i := range sent {
+		if sent[i].ID == "" {
+			t.Errorf("data %d has no id", i)
+		}
+		sent[i].ID = ""
+	}
+	if !reflect.DeepEqual(sent, datas) {
+		t.Errorf("sent %+v, want %+v", sent, datas)
+	}
+}
+
+func TestGatewayWebsocket(t *testing.T) {
+	fs, url := startFakeServer(t)
+	g := New(url, "127.0.0.1:0", DefaultScenario())
+	if err := g.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	message := waitMessage(t, fs.messages)
+	if message.Action != "newConnection" || string(message.Body) != g.Addr() {
+		t.Fatalf("first message = %s %s, want newConnection %s", message.Action, message.Body, g.Addr())
+	}
+
+	// server fetch configs from address announced by gateway
+	res, err := http.Get("http://" + g.Addr() + "/v1/configs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var configs []sdk.Configuration
+	if err := json.NewDecoder(res.Body).Decode(&configs); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(configs, DefaultScenario().Configs) {
+		t.Errorf("configs = %+v, want scenario configs", configs)
+	}
+
+	datas := []server.Datas{{DeviceID: "sim-lamp", Field: "on", ValueBool: true}}
+	if err := g.Send(datas); err != nil {
+		t.Fatal(err)
+	}
+	checkNewData(t, waitMessage(t, fs.messages), datas)
+
+	conn := <-fs.conns
+	action := server.ActionMessage{PhysicalID: "sim-lamp", Plugin: "simulator", Call: "toggle"}
+	body, _ := json.Marshal(action)
+	if err := conn.WriteJSON(server.WebsocketMessage{Action: "callAction", Body: body}); err != nil {
+		t.Fatal(err)
+	}
+	received, err := g.WaitAction(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received != action || len(g.Actions()) != 1 {
+		t.Errorf("received %+v (%d actions), want %+v", received, len(g.Actions()), action)
+	}
+}
+
+func TestGatewayMQTT(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+	b := broker.New()
+	if err := b.AddListener(listeners.NewTCP("test", address), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	messages := make(chan server.WebsocketMessage, 10)
+	observer := mqtt.NewClient(mqtt.NewClientOptions().AddBroker("tcp://" + address).SetClientID("observer"))
+	if token := observer.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	defer observer.Disconnect(0)
+	token := observer.Subscribe("home/gateway/#", 1, func(_ mqtt.Client, message mqtt.Message) {
+		messages <- server.WebsocketMessage{Action: strings.TrimPrefix(message.Topic(), "home/gateway/"), Body: message.Payload()}
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+
+	g := New("tcp://"+address, "127.0.0.1:0", DefaultScenario())
+	g.TopicPrefix = "home/gateway"
+	if err := g.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	for _, want := range []string{"status", "configs", "newConnection"} {
+		message := waitMessage(t, messages)
+		if message.Action != want {
+			t.Fatalf("action = %s, want %s", message.Action, want)
+		}
+		if want == "newConnection" && string(message.Body) != g.Addr() {
+			t.Errorf("newConnection = %s, want %s", message.Body, g.Addr())
+		}
+	}
+
+	datas := []server.Datas{{DeviceID: "sim-sensor", Field: "temperature", ValueNbr: 21.5}}
+	if err := g.Send(datas); err != nil {
+		t.Fatal(err)
+	}
+	checkNewData(t, waitMessage(t, messages), datas)
+
+	action := server.ActionMessage{PhysicalID: "sim-lamp", Plugin: "simulator", Call: "toggle"}
+	body, _ := json.Marshal(action)
+	observer.Publish("home/gateway/callAction", 1, false, body).Wait()
+	if received, err := g.WaitAction(5 * time.Second); err != nil || received != action {
+		t.Errorf("received %+v (%v), want %+v", received, err, action)
+	}
+}
@@ -1,12 +1,15 @@
 package utils
 
 import (
+	"crypto/rand"
 	"database/sql"
 	"errors"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo"
+	"github.com/oklog/ulid/v2"
 )
 
 //MissingFields verify if fields are missing
@@ -36,3 +39,10 @@ func NewNullString(s string) sql.NullString {
 		Valid:  true,
 	}
 }
+
+//NewULID generate a new ulid as string
+// Entropy comes from crypto/rand because ids are also used as tokens, so ULIDs are ordered by millisecond
+// but not within a millisecond: don't rely on their order to find what came after an id
+func NewULID() string {
+	return ulid.MustNew(ulid.Timestamp(time.Now()), rand.Reader).String()
+}